@@ -0,0 +1,411 @@
+// Package google obtains Google access and identity tokens outside of the
+// GCP metadata server: from service account keys, from workload identity
+// federation (external account) configurations and by impersonating a
+// service account through the IAM Credentials API.
+package google
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// CloudPlatformScope grants access to all Google Cloud APIs. It is the
+// default scope and the scope used for tokens that only call IAM.
+const CloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// IAMCredentialsURL is the base URL of the IAM Credentials API. It is
+// overridden in tests.
+var IAMCredentialsURL = "https://iamcredentials.googleapis.com"
+
+const (
+	defaultTokenURI = "https://oauth2.googleapis.com/token"
+	jwtBearerGrant  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	tokenExchange   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	maxResponse     = 1 << 20
+	assertionTTL    = time.Hour
+)
+
+// impersonationURL extracts the service account from an external account's
+// service_account_impersonation_url.
+var impersonationURL = regexp.MustCompile(`/serviceAccounts/([^/:]+):generateAccessToken$`)
+
+// Credentials is a parsed Google credentials JSON file of type
+// service_account or external_account. Unknown fields are ignored so key
+// files can be used exactly as downloaded.
+type Credentials struct {
+	Type string `json:"type"`
+
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+
+	Audience                       string            `json:"audience"`
+	SubjectTokenType               string            `json:"subject_token_type"`
+	TokenURL                       string            `json:"token_url"`
+	ServiceAccountImpersonationURL string            `json:"service_account_impersonation_url"`
+	ClientID                       string            `json:"client_id"`
+	ClientSecret                   string            `json:"client_secret"`
+	WorkforcePoolUserProject       string            `json:"workforce_pool_user_project"`
+	CredentialSource               *CredentialSource `json:"credential_source"`
+
+	key crypto.Signer
+}
+
+// CredentialSource locates the subject token of an external account.
+type CredentialSource struct {
+	File          string            `json:"file"`
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers"`
+	EnvironmentID string            `json:"environment_id"`
+	Executable    json.RawMessage   `json:"executable"`
+	Format        struct {
+		Type                  string `json:"type"`
+		SubjectTokenFieldName string `json:"subject_token_field_name"`
+	} `json:"format"`
+}
+
+// ParseCredentials parses and validates a credentials JSON document.
+func ParseCredentials(data string) (*Credentials, error) {
+	var c Credentials
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("invalid credentials JSON: %w", err)
+	}
+	switch c.Type {
+	case "service_account":
+		if c.ClientEmail == "" || c.PrivateKey == "" {
+			return nil, fmt.Errorf("service account credentials require client_email and private_key")
+		}
+		key, err := authplugins.ParsePrivateKey(c.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service account private_key: %w", err)
+		}
+		c.key = key
+		if c.TokenURI == "" {
+			c.TokenURI = defaultTokenURI
+		}
+	case "external_account":
+		if c.Audience == "" || c.SubjectTokenType == "" || c.TokenURL == "" || c.CredentialSource == nil {
+			return nil, fmt.Errorf("external account credentials require audience, subject_token_type, token_url and credential_source")
+		}
+		src := c.CredentialSource
+		if src.EnvironmentID != "" || len(src.Executable) > 0 {
+			return nil, fmt.Errorf("only file and url credential sources are supported")
+		}
+		if (src.File == "") == (src.URL == "") {
+			return nil, fmt.Errorf("credential_source requires exactly one of file or url")
+		}
+		switch src.Format.Type {
+		case "", "text":
+		case "json":
+			if src.Format.SubjectTokenFieldName == "" {
+				return nil, fmt.Errorf("json credential_source format requires subject_token_field_name")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported credential_source format %q", src.Format.Type)
+		}
+		if c.ServiceAccountImpersonationURL != "" && !impersonationURL.MatchString(c.ServiceAccountImpersonationURL) {
+			return nil, fmt.Errorf("invalid service_account_impersonation_url")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported credentials type %q", c.Type)
+	}
+	return &c, nil
+}
+
+// AccessToken returns an OAuth2 access token with scopes.
+func (c *Credentials) AccessToken(ctx context.Context, client *http.Client, scopes []string) (authplugins.Token, error) {
+	if c.Type == "service_account" {
+		assertion, err := c.assertion(map[string]interface{}{"scope": strings.Join(scopes, " ")})
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		form := url.Values{"grant_type": {jwtBearerGrant}, "assertion": {assertion}}
+		tr, err := authplugins.RequestToken(ctx, client, c.TokenURI, form, "", "")
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		return tr.Token(time.Now()), nil
+	}
+	if c.ServiceAccountImpersonationURL == "" {
+		return c.federatedToken(ctx, client, scopes)
+	}
+	fed, err := c.federatedToken(ctx, client, []string{CloudPlatformScope})
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	return generateAccessToken(ctx, client, c.ServiceAccountImpersonationURL, fed.Value, scopes)
+}
+
+// IDToken returns an OpenID Connect identity token for audience. External
+// accounts need a service_account_impersonation_url because federated
+// tokens cannot be exchanged for identity tokens directly.
+func (c *Credentials) IDToken(ctx context.Context, client *http.Client, audience string) (authplugins.Token, error) {
+	if c.Type == "service_account" {
+		assertion, err := c.assertion(map[string]interface{}{"target_audience": audience})
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		form := url.Values{"grant_type": {jwtBearerGrant}, "assertion": {assertion}}
+		var out struct {
+			IDToken string `json:"id_token"`
+		}
+		if err := do(ctx, client, http.MethodPost, c.TokenURI, "application/x-www-form-urlencoded", "", strings.NewReader(form.Encode()), &out); err != nil {
+			return authplugins.Token{}, err
+		}
+		if out.IDToken == "" {
+			return authplugins.Token{}, fmt.Errorf("empty id_token")
+		}
+		return authplugins.Token{Value: out.IDToken, Expiry: idTokenExpiry(out.IDToken)}, nil
+	}
+	m := impersonationURL.FindStringSubmatch(c.ServiceAccountImpersonationURL)
+	if m == nil {
+		return authplugins.Token{}, fmt.Errorf("external account credentials need service_account_impersonation_url to mint identity tokens")
+	}
+	sa, err := url.PathUnescape(m[1])
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	fed, err := c.federatedToken(ctx, client, []string{CloudPlatformScope})
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	return GenerateIDToken(ctx, client, fed.Value, sa, audience)
+}
+
+// assertion signs a self-issued JWT for the service account's token URI.
+func (c *Credentials) assertion(extra map[string]interface{}) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": c.ClientEmail,
+		"sub": c.ClientEmail,
+		"aud": c.TokenURI,
+		"iat": now.Unix(),
+		"exp": now.Add(assertionTTL).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return authplugins.SignJWT("RS256", c.key, c.PrivateKeyID, claims)
+}
+
+// federatedToken exchanges the external account's subject token at its STS
+// token_url.
+func (c *Credentials) federatedToken(ctx context.Context, client *http.Client, scopes []string) (authplugins.Token, error) {
+	subject, err := c.subjectToken(ctx, client)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	form := url.Values{
+		"grant_type":           {tokenExchange},
+		"audience":             {c.Audience},
+		"scope":                {strings.Join(scopes, " ")},
+		"requested_token_type": {accessTokenType},
+		"subject_token":        {subject},
+		"subject_token_type":   {c.SubjectTokenType},
+	}
+	if c.WorkforcePoolUserProject != "" && c.ClientID == "" {
+		opts, _ := json.Marshal(map[string]string{"userProject": c.WorkforcePoolUserProject})
+		form.Set("options", string(opts))
+	}
+	tr, err := authplugins.RequestToken(ctx, client, c.TokenURL, form, c.ClientID, c.ClientSecret)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	return tr.Token(time.Now()), nil
+}
+
+// subjectToken reads the external account's subject token from its file or
+// URL credential source.
+func (c *Credentials) subjectToken(ctx context.Context, client *http.Client) (string, error) {
+	src := c.CredentialSource
+	var data []byte
+	if src.File != "" {
+		b, err := os.ReadFile(src.File)
+		if err != nil {
+			return "", err
+		}
+		data = b
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+		if err != nil {
+			return "", err
+		}
+		for k, v := range src.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("credential source returned %s", resp.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxResponse)); err != nil {
+			return "", err
+		}
+	}
+	token := strings.TrimSpace(string(data))
+	if src.Format.Type == "json" {
+		var m map[string]interface{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return "", fmt.Errorf("credential source is not JSON: %w", err)
+		}
+		token, _ = m[src.Format.SubjectTokenFieldName].(string)
+	}
+	if token == "" {
+		return "", fmt.Errorf("credential source returned an empty subject token")
+	}
+	return token, nil
+}
+
+// GenerateAccessToken exchanges base, an access token with permission to
+// impersonate serviceAccount, for an access token of that service account.
+func GenerateAccessToken(ctx context.Context, client *http.Client, base, serviceAccount string, scopes []string) (authplugins.Token, error) {
+	return generateAccessToken(ctx, client, iamURL(serviceAccount, "generateAccessToken"), base, scopes)
+}
+
+func generateAccessToken(ctx context.Context, client *http.Client, endpoint, base string, scopes []string) (authplugins.Token, error) {
+	body, _ := json.Marshal(map[string]interface{}{"scope": scopes})
+	var out struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := do(ctx, client, http.MethodPost, endpoint, "application/json", base, strings.NewReader(string(body)), &out); err != nil {
+		return authplugins.Token{}, err
+	}
+	if out.AccessToken == "" {
+		return authplugins.Token{}, fmt.Errorf("empty accessToken")
+	}
+	return authplugins.Token{Value: out.AccessToken, Expiry: out.ExpireTime}, nil
+}
+
+// GenerateIDToken exchanges base, an access token with permission to
+// impersonate serviceAccount, for an identity token of that service account.
+func GenerateIDToken(ctx context.Context, client *http.Client, base, serviceAccount, audience string) (authplugins.Token, error) {
+	body, _ := json.Marshal(map[string]interface{}{"audience": audience, "includeEmail": true})
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := do(ctx, client, http.MethodPost, iamURL(serviceAccount, "generateIdToken"), "application/json", base, strings.NewReader(string(body)), &out); err != nil {
+		return authplugins.Token{}, err
+	}
+	if out.Token == "" {
+		return authplugins.Token{}, fmt.Errorf("empty identity token")
+	}
+	return authplugins.Token{Value: out.Token, Expiry: idTokenExpiry(out.Token)}, nil
+}
+
+// MetadataAccessToken fetches an access token for the default service
+// account from the metadata server at host, optionally narrowed to scopes.
+func MetadataAccessToken(ctx context.Context, client *http.Client, host string, scopes []string) (authplugins.Token, error) {
+	u := host + "/computeMetadata/v1/instance/service-accounts/default/token"
+	if len(scopes) > 0 {
+		u += "?scopes=" + url.QueryEscape(strings.Join(scopes, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+		return authplugins.Token{}, fmt.Errorf("status %s: %s", resp.Status, body)
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return authplugins.Token{}, err
+	}
+	return authplugins.Token{Value: tr.AccessToken, Expiry: time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)}, nil
+}
+
+func iamURL(serviceAccount, method string) string {
+	return fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:%s", IAMCredentialsURL, url.PathEscape(serviceAccount), method)
+}
+
+// do sends a request and decodes a JSON response into out, reporting
+// Google API errors with their message.
+func do(ctx context.Context, client *http.Client, method, endpoint, contentType, bearer string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Google APIs nest a message under error; OAuth endpoints use an
+		// error code string with error_description.
+		var e struct {
+			Error       json.RawMessage `json:"error"`
+			Description string          `json:"error_description"`
+		}
+		if json.Unmarshal(data, &e) == nil {
+			var api struct {
+				Message string `json:"message"`
+			}
+			var code string
+			msg := e.Description
+			if json.Unmarshal(e.Error, &api) == nil && api.Message != "" {
+				msg = api.Message
+			} else if json.Unmarshal(e.Error, &code) == nil && code != "" {
+				msg = strings.TrimSpace(code + " " + msg)
+			}
+			if msg != "" {
+				return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, msg)
+			}
+		}
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// idTokenExpiry reads the exp claim of an identity token, assuming a short
+// lifetime when it cannot be read.
+func idTokenExpiry(tok string) time.Time {
+	parts := strings.Split(tok, ".")
+	if len(parts) == 3 {
+		if data, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			var c struct {
+				Exp int64 `json:"exp"`
+			}
+			if json.Unmarshal(data, &c) == nil && c.Exp > 0 {
+				return time.Unix(c.Exp, 0)
+			}
+		}
+	}
+	return time.Now().Add(authplugins.DefaultTokenLifetime)
+}
@@ -0,0 +1,263 @@
+package google
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serviceAccountJSON(t *testing.T, tokenURI string) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	b, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "proj",
+		"client_email":   "sa@proj.iam.gserviceaccount.com",
+		"private_key_id": "kid1",
+		"private_key":    string(pemKey),
+		"token_uri":      tokenURI,
+	})
+	return string(b), key
+}
+
+// verifyAssertion checks the assertion's RS256 signature and returns its
+// header and claims.
+func verifyAssertion(t *testing.T, key *rsa.PrivateKey, jwt string) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed assertion %q", jwt)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Fatalf("bad assertion signature: %v", err)
+	}
+	decode := func(s string) map[string]interface{} {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		m := map[string]interface{}{}
+		json.Unmarshal(b, &m)
+		return m
+	}
+	return decode(parts[0]), decode(parts[1])
+}
+
+func idToken(exp time.Time) string {
+	claims, _ := json.Marshal(map[string]interface{}{"exp": exp.Unix()})
+	return "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+}
+
+func TestServiceAccountTokens(t *testing.T) {
+	var key *rsa.PrivateKey
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != jwtBearerGrant {
+			t.Errorf("unexpected grant %q", r.Form.Get("grant_type"))
+		}
+		header, claims := verifyAssertion(t, key, r.Form.Get("assertion"))
+		if header["kid"] != "kid1" || claims["iss"] != "sa@proj.iam.gserviceaccount.com" || claims["aud"] != "http://"+r.Host+"/token" {
+			t.Errorf("unexpected assertion %v %v", header, claims)
+		}
+		if aud, ok := claims["target_audience"]; ok {
+			fmt.Fprintf(w, `{"id_token":%q}`, idToken(exp))
+			if aud != "https://svc.example" {
+				t.Errorf("unexpected target_audience %v", aud)
+			}
+			return
+		}
+		if claims["scope"] != "s1 s2" {
+			t.Errorf("unexpected scope %v", claims["scope"])
+		}
+		fmt.Fprint(w, `{"access_token":"at","expires_in":3600}`)
+	}))
+	defer srv.Close()
+	data, k := serviceAccountJSON(t, srv.URL+"/token")
+	key = k
+	creds, err := ParseCredentials(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := creds.AccessToken(context.Background(), srv.Client(), []string{"s1", "s2"})
+	if err != nil || tok.Value != "at" {
+		t.Fatalf("unexpected access token %v %v", tok, err)
+	}
+	tok, err = creds.IDToken(context.Background(), srv.Client(), "https://svc.example")
+	if err != nil || !tok.Expiry.Equal(exp) {
+		t.Fatalf("unexpected id token %v %v", tok, err)
+	}
+}
+
+func TestExternalAccountImpersonation(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	os.WriteFile(tokenFile, []byte(`{"id_token":"subject"}`), 0o600)
+
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/sts":
+			r.ParseForm()
+			if r.Form.Get("subject_token") != "subject" || r.Form.Get("audience") != "//iam.googleapis.com/pool" ||
+				r.Form.Get("subject_token_type") != "urn:ietf:params:oauth:token-type:jwt" || r.Form.Get("scope") != CloudPlatformScope {
+				t.Errorf("unexpected exchange %v", r.Form)
+			}
+			fmt.Fprint(w, `{"access_token":"federated","expires_in":3600}`)
+		case "/v1/projects/-/serviceAccounts/sa@proj.iam.gserviceaccount.com:generateAccessToken":
+			var body struct {
+				Scope []string `json:"scope"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if r.Header.Get("Authorization") != "Bearer federated" || strings.Join(body.Scope, ",") != "s1" {
+				t.Errorf("unexpected impersonation %q %v", r.Header.Get("Authorization"), body.Scope)
+			}
+			fmt.Fprintf(w, `{"accessToken":"impersonated","expireTime":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case "/v1/projects/-/serviceAccounts/sa@proj.iam.gserviceaccount.com:generateIdToken":
+			if r.Header.Get("Authorization") != "Bearer federated" {
+				t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+			}
+			fmt.Fprintf(w, `{"token":%q}`, idToken(time.Now().Add(time.Hour)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	old := IAMCredentialsURL
+	IAMCredentialsURL = srv.URL
+	defer func() { IAMCredentialsURL = old }()
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"type":                              "external_account",
+		"audience":                          "//iam.googleapis.com/pool",
+		"subject_token_type":                "urn:ietf:params:oauth:token-type:jwt",
+		"token_url":                         srv.URL + "/sts",
+		"service_account_impersonation_url": srv.URL + "/v1/projects/-/serviceAccounts/sa@proj.iam.gserviceaccount.com:generateAccessToken",
+		"credential_source": map[string]interface{}{
+			"file":   tokenFile,
+			"format": map[string]string{"type": "json", "subject_token_field_name": "id_token"},
+		},
+	})
+	creds, err := ParseCredentials(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := creds.AccessToken(context.Background(), srv.Client(), []string{"s1"})
+	if err != nil || tok.Value != "impersonated" {
+		t.Fatalf("unexpected access token %v %v", tok, err)
+	}
+	if _, err := creds.IDToken(context.Background(), srv.Client(), "aud"); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 4 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestExternalAccountURLSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subject":
+			if r.Header.Get("Metadata") != "True" {
+				t.Errorf("missing source header")
+			}
+			fmt.Fprint(w, "subject\n")
+		case "/sts":
+			r.ParseForm()
+			if r.Form.Get("subject_token") != "subject" || r.Form.Get("scope") != "s1" {
+				t.Errorf("unexpected exchange %v", r.Form)
+			}
+			if u, p, _ := r.BasicAuth(); u != "client" || p != "secret" {
+				t.Errorf("unexpected client auth %q", u)
+			}
+			fmt.Fprint(w, `{"access_token":"federated","expires_in":3600}`)
+		}
+	}))
+	defer srv.Close()
+	b, _ := json.Marshal(map[string]interface{}{
+		"type":               "external_account",
+		"audience":           "aud",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:id_token",
+		"token_url":          srv.URL + "/sts",
+		"client_id":          "client",
+		"client_secret":      "secret",
+		"credential_source":  map[string]interface{}{"url": srv.URL + "/subject", "headers": map[string]string{"Metadata": "True"}},
+	})
+	creds, err := ParseCredentials(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := creds.AccessToken(context.Background(), srv.Client(), []string{"s1"})
+	if err != nil || tok.Value != "federated" {
+		t.Fatalf("unexpected token %v %v", tok, err)
+	}
+	if _, err := creds.IDToken(context.Background(), srv.Client(), "aud"); err == nil {
+		t.Fatal("expected identity tokens to require impersonation")
+	}
+}
+
+func TestParseCredentialsErrors(t *testing.T) {
+	source := map[string]interface{}{"file": "/tmp/token"}
+	ext := func(k string, v interface{}) string {
+		m := map[string]interface{}{
+			"type":               "external_account",
+			"audience":           "aud",
+			"subject_token_type": "jwt",
+			"token_url":          "https://sts.example",
+			"credential_source":  source,
+		}
+		m[k] = v
+		b, _ := json.Marshal(m)
+		return string(b)
+	}
+	cases := []string{
+		"not json",
+		`{"type":"authorized_user"}`,
+		`{"type":"service_account","client_email":"sa"}`,
+		`{"type":"service_account","client_email":"sa","private_key":"bogus"}`,
+		ext("audience", ""),
+		ext("credential_source", map[string]interface{}{"environment_id": "aws1"}),
+		ext("credential_source", map[string]interface{}{"file": "a", "url": "b"}),
+		ext("credential_source", map[string]interface{}{"file": "a", "format": map[string]string{"type": "json"}}),
+		ext("credential_source", map[string]interface{}{"file": "a", "format": map[string]string{"type": "xml"}}),
+		ext("service_account_impersonation_url", "https://iam.example/other"),
+	}
+	for i, c := range cases {
+		if _, err := ParseCredentials(c); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestGoogleAPIErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"code":403,"message":"Permission iam.serviceAccounts.getAccessToken denied"}}`)
+	}))
+	defer srv.Close()
+	old := IAMCredentialsURL
+	IAMCredentialsURL = srv.URL
+	defer func() { IAMCredentialsURL = old }()
+	_, err := GenerateAccessToken(context.Background(), srv.Client(), "base", "sa@proj", nil)
+	if err == nil || !strings.Contains(err.Error(), "getAccessToken denied") {
+		t.Fatalf("expected API error message, got %v", err)
+	}
+}
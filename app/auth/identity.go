@@ -0,0 +1,129 @@
+package authplugins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IdentityTemplate builds a caller ID from token claims. Templates contain
+// literal text and claim references such as "{{.repository}}@{{.ref}}".
+// References may address nested claims with dots; keys that themselves contain
+// dots, like "kubernetes.io", are matched before being split further.
+type IdentityTemplate struct {
+	literals []string
+	claims   []string
+}
+
+// ParseIdentityTemplate compiles a template string. It returns an error when
+// the template is malformed or references no claims.
+func ParseIdentityTemplate(s string) (*IdentityTemplate, error) {
+	t := &IdentityTemplate{}
+	rest := s
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			if strings.Contains(rest, "}}") {
+				return nil, fmt.Errorf("identity template %q has unmatched }}", s)
+			}
+			t.literals = append(t.literals, rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("identity template %q has unterminated {{", s)
+		}
+		lit := rest[:start]
+		if strings.Contains(lit, "}}") {
+			return nil, fmt.Errorf("identity template %q has unmatched }}", s)
+		}
+		ref := strings.TrimSpace(rest[start+2 : start+end])
+		if !strings.HasPrefix(ref, ".") || len(ref) == 1 || strings.ContainsAny(ref, " {}") {
+			return nil, fmt.Errorf("identity template %q has invalid claim reference %q", s, ref)
+		}
+		t.literals = append(t.literals, lit)
+		t.claims = append(t.claims, ref[1:])
+		rest = rest[start+end+2:]
+	}
+	if len(t.claims) == 0 {
+		return nil, fmt.Errorf("identity template %q references no claims", s)
+	}
+	return t, nil
+}
+
+// IdentityTemplateForClaim returns a template that uses a single claim as the
+// caller ID.
+func IdentityTemplateForClaim(claim string) (*IdentityTemplate, error) {
+	return ParseIdentityTemplate("{{." + claim + "}}")
+}
+
+// ParseIdentityParams handles the identity_claim and identity_template params
+// shared by token based plugins. It returns nil when neither is set so callers
+// fall back to their default identity.
+func ParseIdentityParams(claim, template string) (*IdentityTemplate, error) {
+	switch {
+	case claim != "" && template != "":
+		return nil, fmt.Errorf("identity_claim and identity_template are mutually exclusive")
+	case claim != "":
+		return IdentityTemplateForClaim(claim)
+	case template != "":
+		return ParseIdentityTemplate(template)
+	}
+	return nil, nil
+}
+
+// Execute renders the caller ID. It returns false when a referenced claim is
+// missing, empty or not a string, number or boolean.
+func (t *IdentityTemplate) Execute(claims map[string]interface{}) (string, bool) {
+	var b strings.Builder
+	for i, name := range t.claims {
+		b.WriteString(t.literals[i])
+		v, ok := LookupClaim(claims, name)
+		if !ok {
+			return "", false
+		}
+		var s string
+		switch val := v.(type) {
+		case string:
+			s = val
+		case float64:
+			s = strconv.FormatFloat(val, 'f', -1, 64)
+		case bool:
+			s = strconv.FormatBool(val)
+		default:
+			return "", false
+		}
+		if s == "" {
+			return "", false
+		}
+		b.WriteString(s)
+	}
+	b.WriteString(t.literals[len(t.literals)-1])
+	return b.String(), true
+}
+
+// LookupClaim resolves a dotted claim path against decoded token claims. At
+// each level the longest key matching a prefix of the remaining path wins.
+func LookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	segs := strings.Split(path, ".")
+	var cur interface{} = claims
+	for len(segs) > 0 {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for n := len(segs); n > 0; n-- {
+			if v, ok := m[strings.Join(segs[:n], ".")]; ok {
+				cur = v
+				segs = segs[n:]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -0,0 +1,66 @@
+package authplugins
+
+import "testing"
+
+func TestIdentityTemplateExecute(t *testing.T) {
+	claims := map[string]interface{}{
+		"repository": "org/repo",
+		"ref":        "refs/heads/main",
+		"run":        float64(42),
+		"admin":      true,
+		"kubernetes.io": map[string]interface{}{
+			"namespace":      "team",
+			"serviceaccount": map[string]interface{}{"name": "builder"},
+		},
+		"empty":  "",
+		"groups": []interface{}{"a"},
+	}
+	cases := []struct {
+		tmpl string
+		want string
+		ok   bool
+	}{
+		{"{{.repository}}@{{.ref}}", "org/repo@refs/heads/main", true},
+		{"run-{{ .run }}", "run-42", true},
+		{"{{.admin}}", "true", true},
+		{"system:serviceaccount:{{.kubernetes.io.namespace}}:{{.kubernetes.io.serviceaccount.name}}", "system:serviceaccount:team:builder", true},
+		{"{{.missing}}", "", false},
+		{"{{.empty}}", "", false},
+		{"{{.groups}}", "", false},
+		{"{{.repository.name}}", "", false},
+	}
+	for _, c := range cases {
+		tmpl, err := ParseIdentityTemplate(c.tmpl)
+		if err != nil {
+			t.Fatalf("%s: %v", c.tmpl, err)
+		}
+		got, ok := tmpl.Execute(claims)
+		if ok != c.ok || got != c.want {
+			t.Fatalf("%s: expected %q/%v, got %q/%v", c.tmpl, c.want, c.ok, got, ok)
+		}
+	}
+}
+
+func TestParseIdentityTemplateErrors(t *testing.T) {
+	for _, s := range []string{"", "static", "{{.a", "a}}", "{{a}}", "{{.}}", "{{.a b}}", "x}}{{.a}}"} {
+		if _, err := ParseIdentityTemplate(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestParseIdentityParams(t *testing.T) {
+	if tmpl, err := ParseIdentityParams("", ""); err != nil || tmpl != nil {
+		t.Fatalf("expected nil template, got %v %v", tmpl, err)
+	}
+	tmpl, err := ParseIdentityParams("email", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := tmpl.Execute(map[string]interface{}{"email": "a@example.com"}); !ok || id != "a@example.com" {
+		t.Fatalf("unexpected id %q", id)
+	}
+	if _, err := ParseIdentityParams("email", "{{.sub}}"); err == nil {
+		t.Fatal("expected mutually exclusive error")
+	}
+}
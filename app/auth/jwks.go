@@ -0,0 +1,402 @@
+package authplugins
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKSHTTPClient fetches JWKS documents and OIDC discovery metadata. It can be
+// swapped in tests.
+var JWKSHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// JWKSDefaultTTL is how long fetched keys are cached when the endpoint does not
+// send Cache-Control or Expires headers.
+var JWKSDefaultTTL = time.Hour
+
+// JWKSMinRefresh limits how often a key set is refetched, whether because a
+// token references an unknown kid or because the endpoint is failing.
+var JWKSMinRefresh = 30 * time.Second
+
+// JWKSFetchTimeout bounds a single JWKS or discovery fetch. Fetches are shared
+// by every request waiting on the set, so they are detached from the
+// cancellation of the request that triggered them.
+var JWKSFetchTimeout = 10 * time.Second
+
+// maxJWKSBody bounds how much of a JWKS or discovery response is read.
+const maxJWKSBody = 1 << 20
+
+// ErrKeyNotFound is returned when no key in the set matches the requested kid.
+var ErrKeyNotFound = errors.New("key not found")
+
+// JWK is a public key published in a JSON Web Key Set.
+type JWK struct {
+	KeyID     string
+	Algorithm string
+	Key       crypto.PublicKey
+}
+
+// JWKS caches the keys published at a JSON Web Key Set endpoint. Keys are
+// refreshed when the cache expires or when a token references an unknown kid,
+// and the last good set keeps being served while the endpoint is unavailable.
+type JWKS struct {
+	URL string
+
+	fetchMu     sync.Mutex
+	mu          sync.RWMutex
+	keys        []JWK
+	expiry      time.Time
+	lastAttempt time.Time
+	lastErr     error
+}
+
+var jwksSets = struct {
+	sync.Mutex
+	m map[string]*JWKS
+}{m: make(map[string]*JWKS)}
+
+// GetJWKS returns the shared key set for url. Sets are kept across
+// configuration reloads so cached keys are not refetched unnecessarily.
+func GetJWKS(url string) *JWKS {
+	jwksSets.Lock()
+	defer jwksSets.Unlock()
+	s, ok := jwksSets.m[url]
+	if !ok {
+		s = &JWKS{URL: url}
+		jwksSets.m[url] = s
+	}
+	return s
+}
+
+// Lookup returns the keys matching kid. When kid is empty every key in the set
+// is returned. An unknown kid triggers a rate limited refetch so rotated keys
+// are picked up without waiting for the cache to expire.
+func (j *JWKS) Lookup(ctx context.Context, kid string) ([]JWK, error) {
+	j.mu.RLock()
+	keys, fresh := j.keys, j.keys != nil && time.Now().Before(j.expiry)
+	j.mu.RUnlock()
+
+	var err error
+	if !fresh {
+		if keys, err = j.refresh(ctx, false); err != nil {
+			return nil, err
+		}
+	}
+	if found := matchKID(keys, kid); len(found) > 0 {
+		return found, nil
+	}
+	if kid == "" {
+		return nil, ErrKeyNotFound
+	}
+	if keys, err = j.refresh(ctx, true); err != nil {
+		return nil, err
+	}
+	if found := matchKID(keys, kid); len(found) > 0 {
+		return found, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func matchKID(keys []JWK, kid string) []JWK {
+	if kid == "" {
+		return keys
+	}
+	var found []JWK
+	for _, k := range keys {
+		if k.KeyID == kid {
+			found = append(found, k)
+		}
+	}
+	return found
+}
+
+// refresh fetches the key set unless another goroutine already refreshed it
+// or the last attempt happened less than JWKSMinRefresh ago. On failure the
+// previously cached keys are returned when available.
+func (j *JWKS) refresh(ctx context.Context, force bool) ([]JWK, error) {
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+
+	j.mu.RLock()
+	keys, expiry, last, lastErr := j.keys, j.expiry, j.lastAttempt, j.lastErr
+	j.mu.RUnlock()
+
+	now := time.Now()
+	if !force && keys != nil && now.Before(expiry) {
+		return keys, nil
+	}
+	if !last.IsZero() && now.Sub(last) < JWKSMinRefresh {
+		if keys != nil {
+			return keys, nil
+		}
+		return nil, lastErr
+	}
+
+	fctx, cancel := detachedFetchContext(ctx)
+	newKeys, exp, err := fetchJWKS(fctx, j.URL)
+	if err != nil && fctx.Err() != nil {
+		err = fmt.Errorf("jwks fetch from %s timed out", j.URL)
+	}
+	cancel()
+	j.mu.Lock()
+	j.lastAttempt = now
+	j.lastErr = err
+	if err == nil {
+		j.keys = newKeys
+		j.expiry = exp
+	}
+	j.mu.Unlock()
+	if err != nil {
+		if keys != nil {
+			Logger().Warn("jwks refresh failed; using last known keys", "url", j.URL, "error", err)
+			return keys, nil
+		}
+		return nil, err
+	}
+	return newKeys, nil
+}
+
+// detachedFetchContext keeps the values of ctx but not its cancellation, so a
+// caller that goes away does not fail a fetch other callers are waiting on.
+func detachedFetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), JWKSFetchTimeout)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func fetchJWKS(ctx context.Context, url string) ([]JWK, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := JWKSHTTPClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("jwks fetch failed: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBody))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var data struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, time.Time{}, err
+	}
+	keys := make([]JWK, 0, len(data.Keys))
+	for _, k := range data.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, JWK{KeyID: k.Kid, Algorithm: k.Alg, Key: pub})
+	}
+	return keys, cacheExpiry(resp.Header, time.Now()), nil
+}
+
+// cacheExpiry derives how long a response may be cached from its
+// Cache-Control and Expires headers.
+func cacheExpiry(h http.Header, now time.Time) time.Time {
+	if cc := h.Get("Cache-Control"); cc != "" {
+		for _, d := range strings.Split(cc, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			switch {
+			case d == "no-store" || d == "no-cache":
+				return now
+			case strings.HasPrefix(d, "max-age="):
+				if secs, err := strconv.Atoi(strings.TrimPrefix(d, "max-age=")); err == nil && secs >= 0 {
+					return now.Add(time.Duration(secs) * time.Second)
+				}
+			}
+		}
+	}
+	if e := h.Get("Expires"); e != "" {
+		if t, err := http.ParseTime(e); err == nil {
+			return t
+		}
+	}
+	return now.Add(JWKSDefaultTTL)
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeB64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeB64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid rsa key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeB64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("invalid ec key")
+		}
+		// Validate the point via the uncompressed encoding.
+		raw := append([]byte{4}, append(x, y...)...)
+		pub, err := ecdsa.ParseUncompressedPublicKey(curve, raw)
+		if err != nil {
+			return nil, err
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// oidcIssuer holds the discovery state of one issuer. Its lock serialises
+// discovery for that issuer only.
+type oidcIssuer struct {
+	mu      sync.Mutex
+	set     *JWKS
+	attempt time.Time
+}
+
+var oidcSets = struct {
+	sync.Mutex
+	m map[string]*oidcIssuer
+}{m: make(map[string]*oidcIssuer)}
+
+// DiscoverJWKS resolves the key set advertised by an OpenID Connect issuer via
+// its /.well-known/openid-configuration document. Successful lookups are
+// cached and failed ones are retried at most once per JWKSMinRefresh.
+func DiscoverJWKS(ctx context.Context, issuer string) (*JWKS, error) {
+	oidcSets.Lock()
+	e, ok := oidcSets.m[issuer]
+	if !ok {
+		e = &oidcIssuer{}
+		oidcSets.m[issuer] = e
+	}
+	oidcSets.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.set != nil {
+		return e.set, nil
+	}
+	if !e.attempt.IsZero() && time.Since(e.attempt) < JWKSMinRefresh {
+		return nil, fmt.Errorf("oidc discovery for %s recently failed", issuer)
+	}
+	e.attempt = time.Now()
+
+	fctx, cancel := detachedFetchContext(ctx)
+	defer cancel()
+	uri, err := discoverJWKSURI(fctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	e.set = GetJWKS(uri)
+	return e.set, nil
+}
+
+func discoverJWKSURI(ctx context.Context, issuer string) (string, error) {
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := JWKSHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc discovery failed: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBody))
+	if err != nil {
+		return "", err
+	}
+	var meta struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return "", err
+	}
+	if meta.Issuer != issuer {
+		return "", fmt.Errorf("oidc discovery issuer mismatch: %s", meta.Issuer)
+	}
+	if meta.JWKSURI == "" {
+		return "", fmt.Errorf("oidc discovery missing jwks_uri")
+	}
+	return meta.JWKSURI, nil
+}
@@ -0,0 +1,296 @@
+package authplugins
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func resetJWKS(t *testing.T) {
+	t.Helper()
+	jwksSets.Lock()
+	jwksSets.m = make(map[string]*JWKS)
+	jwksSets.Unlock()
+	oidcSets.Lock()
+	oidcSets.m = make(map[string]*oidcIssuer)
+	oidcSets.Unlock()
+	oldMin := JWKSMinRefresh
+	oldClient := JWKSHTTPClient
+	oldTimeout := JWKSFetchTimeout
+	t.Cleanup(func() {
+		JWKSMinRefresh = oldMin
+		JWKSHTTPClient = oldClient
+		JWKSFetchTimeout = oldTimeout
+	})
+}
+
+func TestJWKSLookupAndCache(t *testing.T) {
+	resetJWKS(t)
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{rsaJWK("k1", &key.PublicKey)}})
+	}))
+	defer ts.Close()
+	JWKSHTTPClient = ts.Client()
+
+	set := GetJWKS(ts.URL)
+	if GetJWKS(ts.URL) != set {
+		t.Fatal("expected shared key set")
+	}
+	keys, err := set.Lookup(context.Background(), "k1")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("unexpected lookup result %v %v", keys, err)
+	}
+	if _, ok := keys[0].Key.(*rsa.PublicKey); !ok {
+		t.Fatalf("expected rsa key, got %T", keys[0].Key)
+	}
+	if _, err := set.Lookup(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected cached keys, got %d fetches", hits)
+	}
+	if set.expiry.Before(time.Now().Add(9 * time.Minute)) {
+		t.Fatalf("expected max-age to be honored, got %v", set.expiry)
+	}
+}
+
+func TestJWKSUnknownKIDRefetchRateLimited(t *testing.T) {
+	resetJWKS(t)
+	k1, _ := rsa.GenerateKey(rand.Reader, 1024)
+	k2, _ := rsa.GenerateKey(rand.Reader, 1024)
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		keys := []interface{}{rsaJWK("k1", &k1.PublicKey)}
+		if n > 1 {
+			keys = append(keys, rsaJWK("k2", &k2.PublicKey))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer ts.Close()
+	JWKSHTTPClient = ts.Client()
+	JWKSMinRefresh = 0
+
+	set := GetJWKS(ts.URL)
+	if _, err := set.Lookup(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Lookup(context.Background(), "k2"); err != nil {
+		t.Fatalf("expected rotated key to be found: %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("expected refetch on unknown kid, got %d fetches", hits)
+	}
+
+	JWKSMinRefresh = time.Hour
+	if _, err := set.Lookup(context.Background(), "missing"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("expected refetch to be rate limited, got %d fetches", hits)
+	}
+}
+
+func TestJWKSFallsBackToLastGoodSet(t *testing.T) {
+	resetJWKS(t)
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	var fail atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{rsaJWK("k1", &key.PublicKey)}})
+	}))
+	defer ts.Close()
+	JWKSHTTPClient = ts.Client()
+	JWKSMinRefresh = 0
+
+	set := GetJWKS(ts.URL)
+	if _, err := set.Lookup(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+	fail.Store(true)
+	if _, err := set.Lookup(context.Background(), "k1"); err != nil {
+		t.Fatalf("expected stale keys to be used: %v", err)
+	}
+
+	empty := GetJWKS(ts.URL + "/other")
+	if _, err := empty.Lookup(context.Background(), "k1"); err == nil {
+		t.Fatal("expected error without cached keys")
+	}
+}
+
+func TestJWKSParsesKeyTypes(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecBytes, _ := ec.PublicKey.Bytes()
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	cases := []jsonWebKey{
+		{Kty: "EC", Crv: "P-256", X: base64.RawURLEncoding.EncodeToString(ecBytes[1:33]), Y: base64.RawURLEncoding.EncodeToString(ecBytes[33:])},
+		{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPub)},
+	}
+	for _, c := range cases {
+		if _, err := c.publicKey(); err != nil {
+			t.Fatalf("%s: unexpected error %v", c.Kty, err)
+		}
+	}
+	bad := []jsonWebKey{
+		{Kty: "oct"},
+		{Kty: "RSA", N: "!!", E: "AQAB"},
+		{Kty: "EC", Crv: "P-192"},
+		{Kty: "EC", Crv: "P-256", X: "AA", Y: "AA"},
+		{Kty: "OKP", Crv: "X25519"},
+		{Kty: "OKP", Crv: "Ed25519", X: "AA"},
+	}
+	for _, c := range bad {
+		if _, err := c.publicKey(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Now()
+	if got := cacheExpiry(http.Header{"Cache-Control": {"max-age=60"}}, now); !got.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected max-age expiry %v", got)
+	}
+	if got := cacheExpiry(http.Header{"Cache-Control": {"no-store"}}, now); !got.Equal(now) {
+		t.Fatalf("unexpected no-store expiry %v", got)
+	}
+	exp := now.Add(2 * time.Hour).UTC().Truncate(time.Second)
+	if got := cacheExpiry(http.Header{"Expires": {exp.Format(http.TimeFormat)}}, now); !got.Equal(exp) {
+		t.Fatalf("unexpected Expires expiry %v", got)
+	}
+	if got := cacheExpiry(http.Header{}, now); !got.Equal(now.Add(JWKSDefaultTTL)) {
+		t.Fatalf("unexpected default expiry %v", got)
+	}
+}
+
+func TestDiscoverJWKS(t *testing.T) {
+	resetJWKS(t)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"jwks_uri":%q}`, ts.URL, ts.URL+"/keys")
+		case "/bad/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":"https://evil.example.com","jwks_uri":"x"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	JWKSHTTPClient = ts.Client()
+
+	set, err := DiscoverJWKS(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.URL != ts.URL+"/keys" {
+		t.Fatalf("unexpected jwks url %s", set.URL)
+	}
+	if again, _ := DiscoverJWKS(context.Background(), ts.URL); again != set {
+		t.Fatal("expected discovery result to be cached")
+	}
+	if _, err := DiscoverJWKS(context.Background(), ts.URL+"/bad"); err == nil {
+		t.Fatal("expected issuer mismatch error")
+	}
+	if _, err := DiscoverJWKS(context.Background(), ts.URL+"/bad"); err == nil {
+		t.Fatal("expected rate limited discovery error")
+	}
+}
+
+func TestJWKSRefreshIgnoresCallerCancellation(t *testing.T) {
+	resetJWKS(t)
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{rsaJWK("k1", &key.PublicKey)}})
+	}))
+	defer ts.Close()
+	JWKSHTTPClient = ts.Client()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetJWKS(ts.URL).Lookup(ctx, "k1"); err != nil {
+		t.Fatalf("expected fetch to outlive the caller, got %v", err)
+	}
+}
+
+func TestJWKSRefreshTimeoutNotContextError(t *testing.T) {
+	resetJWKS(t)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	JWKSHTTPClient = ts.Client()
+	JWKSFetchTimeout = 50 * time.Millisecond
+
+	set := GetJWKS(ts.URL)
+	if _, err := set.Lookup(context.Background(), "k1"); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	set.mu.RLock()
+	lastErr := set.lastErr
+	set.mu.RUnlock()
+	if lastErr == nil || errors.Is(lastErr, context.DeadlineExceeded) {
+		t.Fatalf("expected a non-context error to be recorded, got %v", lastErr)
+	}
+}
+
+func TestDiscoverJWKSDoesNotBlockOtherIssuers(t *testing.T) {
+	resetJWKS(t)
+	release := make(chan struct{})
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow/.well-known/openid-configuration":
+			<-release
+			http.NotFound(w, r)
+		case "/fast/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"jwks_uri":%q}`, ts.URL+"/fast", ts.URL+"/keys")
+		}
+	}))
+	defer ts.Close()
+	JWKSHTTPClient = ts.Client()
+
+	done := make(chan struct{})
+	go func() {
+		DiscoverJWKS(context.Background(), ts.URL+"/slow")
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := DiscoverJWKS(context.Background(), ts.URL+"/fast"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,126 @@
+package authplugins
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePrivateKey parses a PEM private key in PKCS#8, PKCS#1 or SEC 1 form.
+func ParsePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	var priv interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+	return signer, nil
+}
+
+// ValidSigningAlg reports whether SignJWT supports alg.
+func ValidSigningAlg(alg string) bool {
+	switch alg {
+	case "RS256", "PS256", "ES256", "ES384", "EdDSA":
+		return true
+	}
+	return false
+}
+
+// SignJWT returns a compact JWS of claims signed by key with alg. kid is
+// included in the header when set.
+func SignJWT(alg string, key crypto.Signer, kid string, claims map[string]interface{}) (string, error) {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := signJWS(alg, key, []byte(signing))
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func signJWS(alg string, key crypto.Signer, msg []byte) ([]byte, error) {
+	switch alg {
+	case "RS256":
+		if _, ok := key.Public().(*rsa.PublicKey); !ok {
+			break
+		}
+		sum := sha256.Sum256(msg)
+		return key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case "PS256":
+		if _, ok := key.Public().(*rsa.PublicKey); !ok {
+			break
+		}
+		sum := sha256.Sum256(msg)
+		return key.Sign(rand.Reader, sum[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	case "ES256", "ES384":
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			break
+		}
+		var sum []byte
+		switch {
+		case alg == "ES256" && priv.Curve == elliptic.P256():
+			s := sha256.Sum256(msg)
+			sum = s[:]
+		case alg == "ES384" && priv.Curve == elliptic.P384():
+			s := sha512.Sum384(msg)
+			sum = s[:]
+		default:
+			return nil, fmt.Errorf("key curve does not match %s", alg)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, sum)
+		if err != nil {
+			return nil, err
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		r.FillBytes(out[:size])
+		s.FillBytes(out[size:])
+		return out, nil
+	case "EdDSA":
+		if _, ok := key.Public().(ed25519.PublicKey); !ok {
+			break
+		}
+		return key.Sign(rand.Reader, msg, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported alg %s", alg)
+	}
+	return nil, fmt.Errorf("key type %T does not match %s", key, alg)
+}
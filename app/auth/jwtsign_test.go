@@ -0,0 +1,94 @@
+package authplugins
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func pemKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pkcs1 := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rk)}))
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(ek)
+	sec1 := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	_, edk, _ := ed25519.GenerateKey(rand.Reader)
+	for _, s := range []string{pkcs1, sec1, pemKey(t, edk)} {
+		if _, err := ParsePrivateKey(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := ParsePrivateKey("nope"); err == nil {
+		t.Fatal("expected error for non-PEM key")
+	}
+}
+
+func TestSignJWT(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edk, _ := ed25519.GenerateKey(rand.Reader)
+
+	tok, err := SignJWT("RS256", rk, "k1", map[string]interface{}{"sub": "me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(tok, ".")
+	hb, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	var h map[string]string
+	json.Unmarshal(hb, &h)
+	if h["alg"] != "RS256" || h["kid"] != "k1" {
+		t.Fatalf("unexpected header %v", h)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if err := rsa.VerifyPKCS1v15(&rk.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Fatalf("RS256 signature invalid: %v", err)
+	}
+
+	tok, err = SignJWT("ES256", ek, "", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts = strings.Split(tok, ".")
+	sum = sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	sig, _ = base64.RawURLEncoding.DecodeString(parts[2])
+	if len(sig) != 64 || !ecdsa.Verify(&ek.PublicKey, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Fatal("ES256 signature invalid")
+	}
+
+	if _, err := SignJWT("EdDSA", edk, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignJWT("PS256", rk, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignJWT("ES384", ek, "", nil); err == nil {
+		t.Fatal("expected curve mismatch")
+	}
+	if _, err := SignJWT("RS256", ek, "", nil); err == nil {
+		t.Fatal("expected key type mismatch")
+	}
+	if _, err := SignJWT("HS256", rk, "", nil); err == nil {
+		t.Fatal("expected unsupported alg")
+	}
+}
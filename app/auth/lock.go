@@ -0,0 +1,71 @@
+package authplugins
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Locker serializes work, such as refreshing a rotating credential, that must
+// not run concurrently for the same key.
+type Locker interface {
+	// Lock blocks until key is held or ctx is done and returns a function
+	// that releases it. Implementations shared across instances release the
+	// lock after ttl when the holder never does.
+	Lock(ctx context.Context, key string, ttl time.Duration) (func(), error)
+}
+
+// MemoryLocker is a process-local Locker.
+type MemoryLocker struct {
+	mu sync.Mutex
+	m  map[string]chan struct{}
+}
+
+// NewMemoryLocker returns an in-memory locker.
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{m: make(map[string]chan struct{})}
+}
+
+// Lock implements Locker. The ttl is ignored because a process cannot lose
+// a lock it holds without exiting.
+func (l *MemoryLocker) Lock(ctx context.Context, key string, _ time.Duration) (func(), error) {
+	l.mu.Lock()
+	ch, ok := l.m[key]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		l.m[key] = ch
+	}
+	l.mu.Unlock()
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var (
+	lockerMu sync.RWMutex
+	locker   Locker = NewMemoryLocker()
+)
+
+// SetLocker sets the locker shared by plugins and returns the previous one. A
+// nil locker restores a fresh in-memory locker.
+func SetLocker(l Locker) Locker {
+	if l == nil {
+		l = NewMemoryLocker()
+	}
+	lockerMu.Lock()
+	defer lockerMu.Unlock()
+	prev := locker
+	locker = l
+	return prev
+}
+
+// Lock acquires key from the shared locker.
+func Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	lockerMu.RLock()
+	l := locker
+	lockerMu.RUnlock()
+	return l.Lock(ctx, key, ttl)
+}
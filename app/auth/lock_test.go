@@ -0,0 +1,48 @@
+package authplugins
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	l := NewMemoryLocker()
+	unlock, err := l.Lock(context.Background(), "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(ctx, "a", time.Minute); err == nil {
+		t.Fatal("expected held lock to block until ctx is done")
+	}
+	other, err := l.Lock(context.Background(), "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+	unlock()
+	again, err := l.Lock(context.Background(), "a", time.Minute)
+	if err != nil {
+		t.Fatalf("expected released lock to be acquired: %v", err)
+	}
+	again()
+}
+
+func TestSetLocker(t *testing.T) {
+	l := NewMemoryLocker()
+	prev := SetLocker(l)
+	defer SetLocker(prev)
+	unlock, err := Lock(context.Background(), "k", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.m) != 1 {
+		t.Fatal("expected shared locker to be used")
+	}
+	unlock()
+	if SetLocker(nil) != l {
+		t.Fatal("expected previous locker to be returned")
+	}
+}
@@ -0,0 +1,78 @@
+package authplugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTokenLifetime is assumed when a token response omits expires_in.
+const DefaultTokenLifetime = 5 * time.Minute
+
+// maxTokenResponse bounds how much of a token endpoint response is read.
+const maxTokenResponse = 1 << 20
+
+// TokenResponse is a successful OAuth2 token endpoint response.
+type TokenResponse struct {
+	AccessToken     string      `json:"access_token"`
+	TokenType       string      `json:"token_type"`
+	RefreshToken    string      `json:"refresh_token"`
+	IssuedTokenType string      `json:"issued_token_type"`
+	ExpiresIn       json.Number `json:"expires_in"`
+}
+
+// Token returns the access token with its expiry measured from now.
+func (t *TokenResponse) Token(now time.Time) Token {
+	lifetime := DefaultTokenLifetime
+	if secs, err := t.ExpiresIn.Int64(); err == nil && secs > 0 {
+		lifetime = time.Duration(secs) * time.Second
+	}
+	return Token{Value: t.AccessToken, Expiry: now.Add(lifetime)}
+}
+
+// RequestToken posts form to an OAuth2 token endpoint. When user is set the
+// client authenticates with HTTP Basic as RFC 6749 section 2.3.1 describes.
+// Error responses are reported with their OAuth2 error code.
+func RequestToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values, user, pass string) (*TokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if user != "" {
+		req.SetBasicAuth(url.QueryEscape(user), url.QueryEscape(pass))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, e.Error, e.Description)
+		}
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	var tr TokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("empty access token")
+	}
+	return &tr, nil
+}
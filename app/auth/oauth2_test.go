@@ -0,0 +1,53 @@
+package authplugins
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if user, pass, ok := r.BasicAuth(); !ok || user != "id%3A1" || pass != "s" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"bad secret"}`))
+			return
+		}
+		switch r.PostForm.Get("grant_type") {
+		case "ok":
+			w.Write([]byte(`{"access_token":"at","refresh_token":"rt","expires_in":60}`))
+		case "empty":
+			w.Write([]byte(`{"token_type":"Bearer"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	tr, err := RequestToken(ctx, ts.Client(), ts.URL, url.Values{"grant_type": {"ok"}}, "id:1", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if tok := tr.Token(now); tok.Value != "at" || !tok.Expiry.Equal(now.Add(time.Minute)) || tr.RefreshToken != "rt" {
+		t.Fatalf("unexpected token %+v", tr)
+	}
+	if _, err := RequestToken(ctx, ts.Client(), ts.URL, url.Values{"grant_type": {"ok"}}, "id:1", "wrong"); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Fatalf("expected oauth error code, got %v", err)
+	}
+	if _, err := RequestToken(ctx, ts.Client(), ts.URL, url.Values{"grant_type": {"empty"}}, "id:1", "s"); err == nil {
+		t.Fatal("expected empty access token error")
+	}
+	if _, err := RequestToken(ctx, ts.Client(), ts.URL, url.Values{"grant_type": {"x"}}, "id:1", "s"); err == nil {
+		t.Fatal("expected status error")
+	}
+	if tok := (&TokenResponse{AccessToken: "a"}).Token(now); !tok.Expiry.Equal(now.Add(DefaultTokenLifetime)) {
+		t.Fatalf("expected default lifetime, got %v", tok.Expiry)
+	}
+}
@@ -0,0 +1,233 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func sha256Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func argon2Hash(key string) string {
+	salt := []byte("0123456789abcdef")
+	sum := argon2.IDKey([]byte(key), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum))
+}
+
+func writeKeys(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secrets.ClearCache()
+}
+
+func newKeyRequest(key string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	return r
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys(t, path, fmt.Sprintf(`
+- id: ci-bot
+  hash: %s
+- id: partner
+  prefix: partner-
+  hash: '%s'
+- id: old
+  hash: %s
+  expires: 2020-01-01T00:00:00Z
+- id: revoked
+  hash: %s
+  disabled: true
+- id: future
+  hash: %s
+  expires: %s
+`, sha256Hash("ci-key"), argon2Hash("partner-key"), sha256Hash("old-key"), sha256Hash("revoked-key"),
+		sha256Hash("future-key"), time.Now().Add(time.Hour).UTC().Format(time.RFC3339)))
+
+	p := &APIKeyAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"keys": "file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key string
+		id  string
+		ok  bool
+	}{
+		{"ci-key", "ci-bot", true},
+		{"partner-key", "partner", true},
+		{"future-key", "future", true},
+		{"old-key", "", false},
+		{"revoked-key", "", false},
+		{"unknown", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := newKeyRequest(tt.key)
+		if got := p.Authenticate(context.Background(), r, cfg); got != tt.ok {
+			t.Fatalf("%q: expected %v, got %v", tt.key, tt.ok, got)
+		}
+		id, ok := p.Identify(r, cfg)
+		if ok != tt.ok || id != tt.id {
+			t.Fatalf("%q: unexpected identity %q/%v", tt.key, id, ok)
+		}
+	}
+
+	r := newKeyRequest("ci-key")
+	p.StripAuth(r, cfg)
+	if r.Header.Get("X-API-Key") != "" {
+		t.Fatal("expected header to be stripped")
+	}
+}
+
+func TestAPIKeyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys(t, path, fmt.Sprintf("- id: a\n  hash: %s\n", sha256Hash("key-a")))
+
+	p := &APIKeyAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"keys": "file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Authenticate(context.Background(), newKeyRequest("key-a"), cfg) {
+		t.Fatal("expected key-a to authenticate")
+	}
+
+	writeKeys(t, path, fmt.Sprintf("- id: a\n  hash: %s\n  disabled: true\n- id: b\n  hash: %s\n", sha256Hash("key-a"), sha256Hash("key-b")))
+	if p.Authenticate(context.Background(), newKeyRequest("key-a"), cfg) {
+		t.Fatal("expected disabled key to fail after reload")
+	}
+	if id, ok := p.Identify(newKeyRequest("key-b"), cfg); !ok || id != "b" {
+		t.Fatalf("expected new key to identify as b, got %q/%v", id, ok)
+	}
+
+	writeKeys(t, path, "not: [valid")
+	if p.Authenticate(context.Background(), newKeyRequest("key-b"), cfg) {
+		t.Fatal("expected malformed key file to fail closed")
+	}
+}
+
+func TestAPIKeyHeaderPrefix(t *testing.T) {
+	t.Setenv("API_KEYS", fmt.Sprintf(`[{"id": "svc", "hash": "%s"}]`, sha256Hash("secret")))
+	secrets.ClearCache()
+	p := &APIKeyAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"keys": "env:API_KEYS", "header": "Authorization", "prefix": "ApiKey "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newKeyRequest("")
+	r.Header.Set("Authorization", "ApiKey secret")
+	if id, ok := p.Identify(r, cfg); !ok || id != "svc" {
+		t.Fatalf("unexpected identity %q/%v", id, ok)
+	}
+	r.Header.Set("Authorization", "Bearer secret")
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected wrong prefix to fail")
+	}
+}
+
+func TestAPIKeyParseParams(t *testing.T) {
+	p := &APIKeyAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected error for missing keys")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"keys": "nope"}); err == nil {
+		t.Fatal("expected error for invalid secret ref")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"keys": "env:K", "extra": 1}); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+	if p.Authenticate(context.Background(), newKeyRequest("x"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+	if rp := p.RequiredParams(); len(rp) != 1 || rp[0] != "keys" {
+		t.Fatalf("unexpected required params: %v", rp)
+	}
+	if op := p.OptionalParams(); len(op) != 2 {
+		t.Fatalf("unexpected optional params: %v", op)
+	}
+}
+
+func TestParseKeySetErrors(t *testing.T) {
+	good := sha256Hash("k")
+	cases := map[string]string{
+		"missing id":     fmt.Sprintf("- hash: %s\n", good),
+		"duplicate hash": fmt.Sprintf("- id: a\n  hash: %s\n- id: b\n  hash: %s\n", good, good),
+		"bad sha":        "- id: a\n  hash: sha256:zz\n",
+		"unknown format": "- id: a\n  hash: md5:abc\n",
+		"bad argon":      "- id: a\n  hash: $argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA\n",
+		"argon version":  "- id: a\n  hash: $argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA\n",
+		"unknown field":  fmt.Sprintf("- id: a\n  hash: %s\n  owner: me\n", good),
+		"argon prefix":   fmt.Sprintf("- id: a\n  hash: '%s'\n", argon2Hash("k")),
+		"prefix overlap": fmt.Sprintf("- id: a\n  prefix: ab\n  hash: '%s'\n- id: b\n  prefix: abc\n  hash: '%s'\n", argon2Hash("k"), argon2Hash("j")),
+	}
+	for name, data := range cases {
+		if _, err := parseKeySet([]byte(data)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestArgon2VerifiedCache(t *testing.T) {
+	set, err := parseKeySet([]byte(fmt.Sprintf("- id: a\n  prefix: ak_\n  hash: '%s'\n", argon2Hash("ak_pw"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if e, err := set.lookup(ctx, "ak_pw"); err != nil || e == nil || e.ID != "a" {
+		t.Fatalf("expected argon2 key to match, got %v", err)
+	}
+	if len(set.verified) != 1 {
+		t.Fatalf("expected verified key to be cached, got %d", len(set.verified))
+	}
+	if e, _ := set.lookup(ctx, "ak_wrong"); e != nil {
+		t.Fatal("expected wrong key to fail")
+	}
+}
+
+func TestArgon2SelectsByPrefix(t *testing.T) {
+	set, err := parseKeySet([]byte(fmt.Sprintf("- id: a\n  prefix: ak_\n  hash: '%s'\n", argon2Hash("ak_pw"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Occupy every verification slot: unknown prefixes must be rejected
+	// without waiting for one, known prefixes wait for a slot.
+	for i := 0; i < cap(argonSlots); i++ {
+		argonSlots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(argonSlots); i++ {
+			<-argonSlots
+		}
+	}()
+	if e, err := set.lookup(context.Background(), "zz_pw"); e != nil || err != nil {
+		t.Fatalf("expected unknown prefix to be rejected, got %v %v", e, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := set.lookup(ctx, "ak_other"); err == nil {
+		t.Fatal("expected verification to wait for a free slot")
+	}
+}
@@ -0,0 +1,140 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// inParams configures the api_key plugin. Keys references a secret holding a
+// YAML list of hashed keys, for example a file: reference to a key file.
+type inParams struct {
+	Keys   string `json:"keys"`
+	Header string `json:"header"`
+	Prefix string `json:"prefix"`
+
+	state *keyState
+}
+
+// keyState caches the parsed key file. The secret is re-read on every request
+// through the secrets cache and only re-parsed when its contents change, so
+// reload() and -secret-refresh pick up edits without restarting.
+type keyState struct {
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+	set    *keySet
+}
+
+// APIKeyAuth authenticates callers with hashed API keys and identifies them by
+// the id of the matching key.
+type APIKeyAuth struct{}
+
+func (a *APIKeyAuth) Name() string             { return "api_key" }
+func (a *APIKeyAuth) RequiredParams() []string { return []string{"keys"} }
+func (a *APIKeyAuth) OptionalParams() []string { return []string{"header", "prefix"} }
+
+func (a *APIKeyAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[inParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if p.Keys == "" {
+		return nil, fmt.Errorf("missing keys")
+	}
+	if err := secrets.ValidateSecret(p.Keys); err != nil {
+		return nil, err
+	}
+	if p.Header == "" {
+		p.Header = "X-API-Key"
+	}
+	p.state = &keyState{}
+	return p, nil
+}
+
+func (a *APIKeyAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return a.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason checks the presented key and explains failures.
+func (a *APIKeyAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	_, err := a.match(ctx, r, p)
+	return err
+}
+
+// Identify returns the id of the key presented by the caller.
+func (a *APIKeyAuth) Identify(r *http.Request, p interface{}) (string, bool) {
+	id, err := a.match(r.Context(), r, p)
+	return id, err == nil
+}
+
+// StripAuth removes the API key header from the request.
+func (a *APIKeyAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+}
+
+func (a *APIKeyAuth) match(ctx context.Context, r *http.Request, p interface{}) (string, error) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	header := r.Header.Get(cfg.Header)
+	if header == "" {
+		return "", authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
+	if cfg.Prefix != "" && !strings.HasPrefix(header, cfg.Prefix) {
+		return "", authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
+	}
+	key := strings.TrimPrefix(header, cfg.Prefix)
+	if key == "" {
+		return "", authplugins.Fail(authplugins.ReasonMissingCredential, "empty API key")
+	}
+	set, err := cfg.state.load(ctx, cfg.Keys)
+	if err != nil {
+		authplugins.Logger().Error("api_key: failed to load keys", "error", err)
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	e, err := set.lookup(ctx, key)
+	if err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	if e == nil {
+		return "", authplugins.Fail(authplugins.ReasonInvalidCredential, "unknown API key")
+	}
+	if !e.active(time.Now()) {
+		return "", authplugins.Fail(authplugins.ReasonExpired, "API key %s is not active", e.ID)
+	}
+	return e.ID, nil
+}
+
+func (s *keyState) load(ctx context.Context, ref string) (*keySet, error) {
+	raw, err := secrets.LoadSecret(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(raw))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.set != nil && digest == s.digest {
+		return s.set, nil
+	}
+	set, err := parseKeySet([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	s.digest = digest
+	s.set = set
+	return set, nil
+}
+
+func init() { authplugins.RegisterIncoming(&APIKeyAuth{}) }
@@ -0,0 +1,196 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"gopkg.in/yaml.v3"
+)
+
+// maxVerified bounds the cache of keys that already passed argon2
+// verification.
+const maxVerified = 10000
+
+// argonSlots bounds how many argon2 derivations run at once so a burst of
+// requests cannot exhaust memory with the cost parameters from the key file.
+var argonSlots = make(chan struct{}, 4)
+
+// keyEntry is a single record in the key file.
+type keyEntry struct {
+	ID       string    `yaml:"id"`
+	Prefix   string    `yaml:"prefix"`
+	Hash     string    `yaml:"hash"`
+	Expires  time.Time `yaml:"expires"`
+	Disabled bool      `yaml:"disabled"`
+
+	sha   []byte
+	argon *argonHash
+}
+
+type argonHash struct {
+	variant string
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	sum     []byte
+}
+
+// keySet is a parsed key file. Argon2 entries are selected by the public
+// prefix of the presented key so at most one derivation runs per request, and
+// keys that verified are remembered by their SHA-256 digest so it only runs
+// once per key.
+type keySet struct {
+	entries []*keyEntry
+	argon   []*keyEntry
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]*keyEntry
+}
+
+func parseKeySet(data []byte) (*keySet, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var entries []*keyEntry
+	if err := dec.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	seen := make(map[string]bool)
+	var argon []*keyEntry
+	for i, e := range entries {
+		if e == nil || e.ID == "" {
+			return nil, fmt.Errorf("key %d: missing id", i)
+		}
+		if seen[e.Hash] {
+			return nil, fmt.Errorf("key %s: duplicate hash", e.ID)
+		}
+		seen[e.Hash] = true
+		if err := e.parseHash(); err != nil {
+			return nil, fmt.Errorf("key %s: %w", e.ID, err)
+		}
+		if e.argon == nil {
+			continue
+		}
+		if e.Prefix == "" {
+			return nil, fmt.Errorf("key %s: argon2 hashes require a prefix", e.ID)
+		}
+		for _, o := range argon {
+			if strings.HasPrefix(e.Prefix, o.Prefix) || strings.HasPrefix(o.Prefix, e.Prefix) {
+				return nil, fmt.Errorf("key %s: prefix overlaps key %s", e.ID, o.ID)
+			}
+		}
+		argon = append(argon, e)
+	}
+	return &keySet{entries: entries, argon: argon, verified: make(map[[sha256.Size]byte]*keyEntry)}, nil
+}
+
+// parseHash accepts "sha256:<hex>" or an argon2 PHC string such as
+// "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>".
+func (e *keyEntry) parseHash() error {
+	if rest, ok := strings.CutPrefix(e.Hash, "sha256:"); ok {
+		sum, err := hex.DecodeString(rest)
+		if err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("invalid sha256 hash")
+		}
+		e.sha = sum
+		return nil
+	}
+	parts := strings.Split(e.Hash, "$")
+	if len(parts) != 6 || parts[0] != "" || (parts[1] != "argon2id" && parts[1] != "argon2i") {
+		return fmt.Errorf("unsupported hash format")
+	}
+	if parts[2] != "v=19" {
+		return fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	h := &argonHash{variant: parts[1]}
+	var threads uint32
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &threads); err != nil {
+		return fmt.Errorf("invalid argon2 parameters")
+	}
+	if h.memory == 0 || h.time == 0 || threads == 0 || threads > 255 {
+		return fmt.Errorf("invalid argon2 parameters")
+	}
+	h.threads = uint8(threads)
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return fmt.Errorf("invalid argon2 salt")
+	}
+	if h.sum, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.sum) == 0 {
+		return fmt.Errorf("invalid argon2 hash")
+	}
+	e.argon = h
+	return nil
+}
+
+func (h *argonHash) verify(key []byte) bool {
+	var sum []byte
+	if h.variant == "argon2i" {
+		sum = argon2.Key(key, h.salt, h.time, h.memory, h.threads, uint32(len(h.sum)))
+	} else {
+		sum = argon2.IDKey(key, h.salt, h.time, h.memory, h.threads, uint32(len(h.sum)))
+	}
+	return subtle.ConstantTimeCompare(sum, h.sum) == 1
+}
+
+// lookup returns the entry matching key regardless of its expiry or disabled
+// state. It returns an error only when ctx ends while waiting to verify.
+func (s *keySet) lookup(ctx context.Context, key string) (*keyEntry, error) {
+	digest := sha256.Sum256([]byte(key))
+	var match *keyEntry
+	for _, e := range s.entries {
+		if e.sha != nil && subtle.ConstantTimeCompare(digest[:], e.sha) == 1 {
+			match = e
+		}
+	}
+	if match != nil {
+		return match, nil
+	}
+
+	s.mu.Lock()
+	e, ok := s.verified[digest]
+	s.mu.Unlock()
+	if ok {
+		return e, nil
+	}
+	e = nil
+	for _, a := range s.argon {
+		if strings.HasPrefix(key, a.Prefix) {
+			e = a
+			break
+		}
+	}
+	if e == nil {
+		return nil, nil
+	}
+	select {
+	case argonSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ok = e.argon.verify([]byte(key))
+	<-argonSlots
+	if !ok {
+		return nil, nil
+	}
+	s.mu.Lock()
+	if len(s.verified) >= maxVerified {
+		s.verified = make(map[[sha256.Size]byte]*keyEntry)
+	}
+	s.verified[digest] = e
+	s.mu.Unlock()
+	return e, nil
+}
+
+// active reports whether the entry may currently be used.
+func (e *keyEntry) active(now time.Time) bool {
+	return !e.Disabled && (e.Expires.IsZero() || now.Before(e.Expires))
+}
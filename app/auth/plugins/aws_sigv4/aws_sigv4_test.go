@@ -0,0 +1,433 @@
+package awssigv4
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const (
+	testAKID   = "AKIDEXAMPLE"
+	testSecret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// signHeader signs r in the header form the way AWS SDKs do.
+func signHeader(t *testing.T, r *http.Request, secret string, at time.Time, body string, payloadHeader bool) {
+	t.Helper()
+	amzDate := at.UTC().Format(amzDateFormat)
+	r.Header.Set("X-Amz-Date", amzDate)
+	payload := hashHex([]byte(body))
+	signed := []string{"host", "x-amz-date"}
+	if payloadHeader {
+		r.Header.Set("X-Amz-Content-Sha256", payload)
+		signed = []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	}
+	scope := amzDate[:8] + "/us-east-1/service/aws4_request"
+	uris := canonicalURIs(r.URL)
+	canonical, ok := canonicalRequest(r, uris[len(uris)-1], canonicalQuery(r.URL.Query(), ""), signed, payload)
+	if !ok {
+		t.Fatal("failed to build canonical request")
+	}
+	sig := signature(secret, amzDate, scope, canonical)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, testAKID, scope, strings.Join(signed, ";"), sig))
+}
+
+// presign adds SigV4 query parameters to r.
+func presign(t *testing.T, r *http.Request, secret string, at time.Time, expires int) {
+	t.Helper()
+	amzDate := at.UTC().Format(amzDateFormat)
+	scope := amzDate[:8] + "/us-east-1/service/aws4_request"
+	q := r.URL.Query()
+	q.Set("X-Amz-Algorithm", algorithm)
+	q.Set("X-Amz-Credential", testAKID+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", fmt.Sprint(expires))
+	q.Set("X-Amz-SignedHeaders", "host")
+	r.URL.RawQuery = q.Encode()
+	canonical, ok := canonicalRequest(r, "/"+strings.TrimPrefix(r.URL.EscapedPath(), "/"), canonicalQuery(q, ""), []string{"host"}, unsignedPayload)
+	if !ok {
+		t.Fatal("failed to build canonical request")
+	}
+	q.Set("X-Amz-Signature", signature(secret, amzDate, scope, canonical))
+	r.URL.RawQuery = q.Encode()
+}
+
+func newPlugin(t *testing.T, extra map[string]interface{}) (*AWSSigV4Auth, interface{}) {
+	t.Helper()
+	t.Setenv("AWS_SECRET", testSecret)
+	secrets.ClearCache()
+	params := map[string]interface{}{
+		"credentials": []interface{}{
+			map[string]interface{}{"access_key_id": testAKID, "secret_access_key": "env:AWS_SECRET", "name": "billing"},
+		},
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	p := &AWSSigV4Auth{}
+	cfg, err := p.ParseParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, cfg
+}
+
+func TestSigV4Vector(t *testing.T) {
+	// get-vanilla from the AWS SigV4 test suite.
+	r := httptest.NewRequest(http.MethodGet, "http://example.amazonaws.com/", nil)
+	r.Header.Set("X-Amz-Date", "20150830T123600Z")
+	canonical, ok := canonicalRequest(r, "/", "", []string{"host", "x-amz-date"}, hashHex(nil))
+	if !ok {
+		t.Fatal("canonical request failed")
+	}
+	got := signature(testSecret, "20150830T123600Z", "20150830/us-east-1/service/aws4_request", canonical)
+	if got != "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31" {
+		t.Fatalf("unexpected signature %s", got)
+	}
+}
+
+func TestSigV4HeaderAuth(t *testing.T) {
+	p, cfg := newPlugin(t, nil)
+	body := `{"a":1}`
+	r := httptest.NewRequest(http.MethodPost, "http://svc.internal/path/a%20b?x=2&a=1", strings.NewReader(body))
+	signHeader(t, r, testSecret, time.Now(), body, true)
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected valid signature to authenticate")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "billing" {
+		t.Fatalf("unexpected identity %q", id)
+	}
+
+	// Computed payload hash without X-Amz-Content-Sha256.
+	r = httptest.NewRequest(http.MethodPost, "http://svc.internal/", strings.NewReader(body))
+	signHeader(t, r, testSecret, time.Now(), body, false)
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected signature over computed payload hash to authenticate")
+	}
+
+	// Body tampering is detected.
+	r = httptest.NewRequest(http.MethodPost, "http://svc.internal/", strings.NewReader(`{"a":2}`))
+	signHeader(t, r, testSecret, time.Now(), body, true)
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected tampered body to fail")
+	}
+
+	// Wrong secret.
+	r = httptest.NewRequest(http.MethodGet, "http://svc.internal/", nil)
+	signHeader(t, r, "other", time.Now(), "", false)
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected wrong secret to fail")
+	}
+
+	// Outside the skew window.
+	r = httptest.NewRequest(http.MethodGet, "http://svc.internal/", nil)
+	signHeader(t, r, testSecret, time.Now().Add(-20*time.Minute), "", false)
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected stale signature to fail")
+	}
+
+	p.StripAuth(r, cfg)
+	if r.Header.Get("Authorization") != "" || r.Header.Get("X-Amz-Date") != "" {
+		t.Fatal("expected SigV4 headers to be stripped")
+	}
+}
+
+func TestSigV4UnsignedPayload(t *testing.T) {
+	p, cfg := newPlugin(t, nil)
+	r := httptest.NewRequest(http.MethodPut, "http://svc.internal/obj", strings.NewReader("data"))
+	amzDate := time.Now().UTC().Format(amzDateFormat)
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	scope := amzDate[:8] + "/us-east-1/service/aws4_request"
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonical, _ := canonicalRequest(r, "/obj", "", signed, unsignedPayload)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, testAKID, scope, strings.Join(signed, ";"), signature(testSecret, amzDate, scope, canonical)))
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected unsigned payload with body to be rejected by default")
+	}
+
+	p, cfg = newPlugin(t, map[string]interface{}{"allow_unsigned_payload": true})
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected unsigned payload to be accepted when allowed")
+	}
+}
+
+func TestSigV4Presigned(t *testing.T) {
+	p, cfg := newPlugin(t, map[string]interface{}{"region": "us-east-1", "service": "service"})
+	r := httptest.NewRequest(http.MethodGet, "http://svc.internal/report?id=7&X-Amz-Target=Svc.Op", nil)
+	presign(t, r, testSecret, time.Now(), 300)
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected presigned URL to authenticate")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "billing" {
+		t.Fatalf("unexpected identity %q", id)
+	}
+	p.StripAuth(r, cfg)
+	if r.URL.RawQuery != "X-Amz-Target=Svc.Op&id=7" {
+		t.Fatalf("expected only presigned params stripped, got %s", r.URL.RawQuery)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://svc.internal/report", nil)
+	presign(t, r, testSecret, time.Now().Add(-10*time.Minute), 300)
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected expired presigned URL to fail")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://svc.internal/report", nil)
+	presign(t, r, testSecret, time.Now(), 700000)
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected presigned URL beyond max_expires to fail")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://svc.internal/report", nil)
+	presign(t, r, testSecret, time.Now(), 300)
+	q := r.URL.Query()
+	q.Set("id", "8")
+	r.URL.RawQuery = q.Encode()
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected modified query to fail")
+	}
+}
+
+func TestSigV4ScopeAndIdentity(t *testing.T) {
+	p, cfg := newPlugin(t, map[string]interface{}{"region": "eu-west-1"})
+	r := httptest.NewRequest(http.MethodGet, "http://svc.internal/", nil)
+	signHeader(t, r, testSecret, time.Now(), "", false)
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected region mismatch to fail")
+	}
+
+	t.Setenv("AWS_SECRET2", "s2")
+	cfg2, err := p.ParseParams(map[string]interface{}{
+		"credentials": []interface{}{
+			map[string]interface{}{"access_key_id": testAKID, "secret_access_key": "env:AWS_SECRET"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := p.Identify(r, cfg2); !ok || id != testAKID {
+		t.Fatalf("expected access key ID identity, got %q", id)
+	}
+
+	unknown := httptest.NewRequest(http.MethodGet, "http://svc.internal/", nil)
+	unknown.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), testAKID, "AKIDOTHER", 1))
+	unknown.Header.Set("X-Amz-Date", r.Header.Get("X-Amz-Date"))
+	if _, ok := p.Identify(unknown, cfg2); ok {
+		t.Fatal("expected unknown access key to have no identity")
+	}
+}
+
+func TestSigV4ParseParams(t *testing.T) {
+	p := &AWSSigV4Auth{}
+	bad := []map[string]interface{}{
+		{},
+		{"credentials": []interface{}{map[string]interface{}{"access_key_id": "A"}}},
+		{"credentials": []interface{}{map[string]interface{}{"access_key_id": "A", "secret_access_key": "nope"}}},
+		{"credentials": []interface{}{
+			map[string]interface{}{"access_key_id": "A", "secret_access_key": "env:X"},
+			map[string]interface{}{"access_key_id": "A", "secret_access_key": "env:Y"},
+		}},
+		{"credentials": []interface{}{map[string]interface{}{"access_key_id": "A", "secret_access_key": "env:X", "extra": 1}}},
+		{"credentials": []interface{}{map[string]interface{}{"access_key_id": "A", "secret_access_key": "env:X"}}, "tolerance": -1},
+	}
+	for i, m := range bad {
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"credentials": []interface{}{map[string]interface{}{"access_key_id": "A", "secret_access_key": "env:X"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*inParams); c.Tolerance != 900 || c.MaxExpires != 604800 {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+	if p.Authenticate(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
+
+func TestParseSignatureRejectsMalformed(t *testing.T) {
+	now := time.Now().UTC().Format(amzDateFormat)
+	day := now[:8]
+	cases := []string{
+		"AWS4-HMAC-SHA256 Credential=A/" + day + "/r/s/aws4_request, SignedHeaders=host, Signature=",
+		"AWS4-HMAC-SHA256 Credential=A/" + day + "/r/s, SignedHeaders=host, Signature=ab",
+		"AWS4-HMAC-SHA256 Credential=A/19990101/r/s/aws4_request, SignedHeaders=host, Signature=ab",
+		"AWS4-HMAC-SHA256 Credential=A/" + day + "/r/s/aws4_request, SignedHeaders=x-amz-date, Signature=ab",
+		"AWS4-HMAC-SHA256 Credential=A/" + day + "/r/s/aws4_request, SignedHeaders=x-amz-date;host, Signature=ab",
+	}
+	for i, auth := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", auth)
+		r.Header.Set("X-Amz-Date", now)
+		if _, ok := parseSignature(r); ok {
+			t.Fatalf("case %d: expected parse failure", i)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"X-Amz-Algorithm": {"AWS4-HMAC-SHA1"}}.Encode(), nil)
+	if _, ok := parseSignature(r); ok {
+		t.Fatal("expected unsupported algorithm to fail")
+	}
+}
+
+func newOutgoing(t *testing.T, extra map[string]interface{}) interface{} {
+	t.Helper()
+	t.Setenv("AWS_AKID", testAKID)
+	t.Setenv("AWS_SECRET", testSecret)
+	secrets.ClearCache()
+	params := map[string]interface{}{
+		"region":            "us-east-1",
+		"service":           "service",
+		"access_key_id":     "env:AWS_AKID",
+		"secret_access_key": "env:AWS_SECRET",
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	cfg, err := (&AWSSigV4{}).ParseParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestSigV4OutgoingRoundTrip(t *testing.T) {
+	in, inCfg := newPlugin(t, map[string]interface{}{"region": "us-east-1", "service": "service"})
+	cfg := newOutgoing(t, nil)
+	body := `{"a":1}`
+	r := httptest.NewRequest(http.MethodPost, "http://svc.internal/path/a%20b?x=2&a=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if err := (&AWSSigV4{}).AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != hashHex([]byte(body)) {
+		t.Fatal("expected payload hash header")
+	}
+	if !strings.Contains(r.Header.Get("Authorization"), "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date,") {
+		t.Fatalf("unexpected signed headers in %q", r.Header.Get("Authorization"))
+	}
+	if !in.Authenticate(context.Background(), r, inCfg) {
+		t.Fatal("expected outgoing signature to verify")
+	}
+	r.Header.Set("Content-Type", "text/plain")
+	if in.Authenticate(context.Background(), r, inCfg) {
+		t.Fatal("expected signed header change to fail verification")
+	}
+}
+
+func TestSigV4OutgoingUnsignedPayload(t *testing.T) {
+	in, inCfg := newPlugin(t, map[string]interface{}{"allow_unsigned_payload": true})
+	cfg := newOutgoing(t, map[string]interface{}{"unsigned_payload": true, "session_token": "env:AWS_TOKEN"})
+	t.Setenv("AWS_TOKEN", "session")
+	r := httptest.NewRequest(http.MethodPut, "http://svc.internal/obj", strings.NewReader("data"))
+	if err := (&AWSSigV4{}).AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != unsignedPayload {
+		t.Fatal("expected unsigned payload marker")
+	}
+	if r.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Fatal("expected session token header")
+	}
+	if !in.Authenticate(context.Background(), r, inCfg) {
+		t.Fatal("expected unsigned payload signature to verify")
+	}
+}
+
+func TestSigV4OutgoingAssumeRoleChain(t *testing.T) {
+	var roles []string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sts/aws4_request") {
+			t.Errorf("unexpected STS authorization %q", r.Header.Get("Authorization"))
+		}
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRole" {
+			t.Errorf("unexpected action %q", r.Form.Get("Action"))
+		}
+		role := r.Form.Get("RoleArn")
+		if role == "arn:aws:iam::1:role/denied" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>nope</Message></Error></ErrorResponse>`)
+			return
+		}
+		roles = append(roles, role+"|"+r.Header.Get("X-Amz-Security-Token")+"|"+r.Form.Get("ExternalId"))
+		exp := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>ASIA%d</AccessKeyId><SecretAccessKey>%s</SecretAccessKey><SessionToken>tok%d</SessionToken><Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			len(roles), testSecret, len(roles), exp)
+	}))
+	defer sts.Close()
+
+	cfg := newOutgoing(t, map[string]interface{}{
+		"sts_endpoint": sts.URL,
+		"assume_roles": []interface{}{
+			map[string]interface{}{"role_arn": "arn:aws:iam::1:role/a"},
+			map[string]interface{}{"role_arn": "arn:aws:iam::2:role/b", "external_id": "ext"},
+		},
+	})
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://svc.internal/", nil)
+		if err := (&AWSSigV4{}).AddAuth(context.Background(), r, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=ASIA2/") || r.Header.Get("X-Amz-Security-Token") != "tok2" {
+			t.Fatalf("expected final role credentials, got %q", r.Header.Get("Authorization"))
+		}
+	}
+	want := []string{"arn:aws:iam::1:role/a||", "arn:aws:iam::2:role/b|tok1|ext"}
+	if strings.Join(roles, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected STS calls %v", roles)
+	}
+
+	cfg = newOutgoing(t, map[string]interface{}{
+		"sts_endpoint": sts.URL,
+		"assume_roles": []interface{}{map[string]interface{}{"role_arn": "arn:aws:iam::1:role/denied"}},
+	})
+	r := httptest.NewRequest(http.MethodGet, "http://svc.internal/", nil)
+	if err := (&AWSSigV4{}).AddAuth(context.Background(), r, cfg); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("expected STS error, got %v", err)
+	}
+}
+
+func TestSigV4OutgoingParseParams(t *testing.T) {
+	p := &AWSSigV4{}
+	base := func() map[string]interface{} {
+		return map[string]interface{}{"region": "us-east-1", "service": "s3", "access_key_id": "env:A", "secret_access_key": "env:B"}
+	}
+	cases := []func(m map[string]interface{}){
+		func(m map[string]interface{}) { delete(m, "region") },
+		func(m map[string]interface{}) { delete(m, "secret_access_key") },
+		func(m map[string]interface{}) { m["access_key_id"] = "bogus:x" },
+		func(m map[string]interface{}) {
+			m["assume_roles"] = []interface{}{map[string]interface{}{"role_arn": "role"}}
+		},
+		func(m map[string]interface{}) { m["sts_endpoint"] = "ftp://sts" },
+		func(m map[string]interface{}) { m["duration"] = -1 },
+		func(m map[string]interface{}) { m["unknown"] = true },
+	}
+	for i, mutate := range cases {
+		m := base()
+		mutate(m)
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(base())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*outParams); c.STSEndpoint != "https://sts.us-east-1.amazonaws.com" || c.Duration != 3600 {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
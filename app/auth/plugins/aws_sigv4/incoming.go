@@ -0,0 +1,307 @@
+package awssigv4
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// credential maps an access key ID to its secret and optional caller name.
+type credential struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	Name            string `json:"name"`
+}
+
+// inParams configures SigV4 verification. Tolerance bounds the clock skew
+// accepted for X-Amz-Date and MaxExpires caps presigned URL lifetimes, both in
+// seconds.
+type inParams struct {
+	Credentials          []credential `json:"credentials"`
+	Region               string       `json:"region"`
+	Service              string       `json:"service"`
+	Tolerance            int64        `json:"tolerance"`
+	MaxExpires           int64        `json:"max_expires"`
+	AllowUnsignedPayload bool         `json:"allow_unsigned_payload"`
+}
+
+// sigInfo holds the signature fields extracted from a request.
+type sigInfo struct {
+	accessKey     string
+	scope         string
+	region        string
+	service       string
+	amzDate       time.Time
+	rawDate       string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+	expires       int64
+}
+
+// AWSSigV4Auth verifies requests signed with AWS Signature Version 4 using
+// static access keys.
+type AWSSigV4Auth struct{}
+
+func (a *AWSSigV4Auth) Name() string             { return "aws_sigv4" }
+func (a *AWSSigV4Auth) RequiredParams() []string { return []string{"credentials"} }
+func (a *AWSSigV4Auth) OptionalParams() []string {
+	return []string{"region", "service", "tolerance", "max_expires", "allow_unsigned_payload"}
+}
+
+func (a *AWSSigV4Auth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[inParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Credentials) == 0 {
+		return nil, fmt.Errorf("missing credentials")
+	}
+	seen := make(map[string]bool)
+	for _, c := range p.Credentials {
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return nil, fmt.Errorf("credentials require access_key_id and secret_access_key")
+		}
+		if seen[c.AccessKeyID] {
+			return nil, fmt.Errorf("duplicate access_key_id %s", c.AccessKeyID)
+		}
+		seen[c.AccessKeyID] = true
+		if err := secrets.ValidateSecret(c.SecretAccessKey); err != nil {
+			return nil, err
+		}
+	}
+	if p.Tolerance < 0 || p.MaxExpires < 0 {
+		return nil, fmt.Errorf("tolerance and max_expires must not be negative")
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = 900
+	}
+	if p.MaxExpires == 0 {
+		p.MaxExpires = 604800
+	}
+	return p, nil
+}
+
+func (a *AWSSigV4Auth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return a.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the SigV4 signature and explains failures.
+func (a *AWSSigV4Auth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	if r.Header.Get("Authorization") == "" && r.URL.Query().Get("X-Amz-Algorithm") == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "no SigV4 signature")
+	}
+	info, ok := parseSignature(r)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "malformed SigV4 signature")
+	}
+	if (cfg.Region != "" && info.region != cfg.Region) || (cfg.Service != "" && info.service != cfg.Service) {
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "credential scope %s is not allowed", info.scope)
+	}
+	if !withinWindow(info, cfg, time.Now()) {
+		return authplugins.Fail(authplugins.ReasonExpired, "signature outside validity window")
+	}
+	cred := cfg.credential(info.accessKey)
+	if cred == nil {
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "unknown access key %s", info.accessKey)
+	}
+
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	payloadHash := info.payloadHash
+	switch {
+	case payloadHash == unsignedPayload:
+		if len(body) > 0 && !cfg.AllowUnsignedPayload {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "unsigned payload not allowed")
+		}
+	case payloadHash == "":
+		payloadHash = hashHex(body)
+	case payloadHash != hashHex(body):
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "payload hash does not match body")
+	}
+
+	secret, err := secrets.LoadSecret(ctx, cred.SecretAccessKey)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	skip := ""
+	if info.presigned {
+		skip = "X-Amz-Signature"
+	}
+	query := canonicalQuery(r.URL.Query(), skip)
+	for _, uri := range canonicalURIs(r.URL) {
+		canonical, ok := canonicalRequest(r, uri, query, info.signedHeaders, payloadHash)
+		if !ok {
+			return authplugins.Fail(authplugins.ReasonMalformedCredential, "signed header missing from request")
+		}
+		expected := signature(secret, info.rawDate, info.scope, canonical)
+		if hmac.Equal([]byte(expected), []byte(info.signature)) {
+			return nil
+		}
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+}
+
+// Identify returns the configured name for the signing access key, or the
+// access key ID itself when no name is set.
+func (a *AWSSigV4Auth) Identify(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	info, ok := parseSignature(r)
+	if !ok {
+		return "", false
+	}
+	cred := cfg.credential(info.accessKey)
+	if cred == nil {
+		return "", false
+	}
+	if cred.Name != "" {
+		return cred.Name, true
+	}
+	return cred.AccessKeyID, true
+}
+
+// presignParams are the query parameters carrying a presigned SigV4
+// signature. Other X-Amz-* parameters are meant for the upstream.
+var presignParams = []string{
+	"X-Amz-Algorithm",
+	"X-Amz-Credential",
+	"X-Amz-Date",
+	"X-Amz-Expires",
+	"X-Amz-SignedHeaders",
+	"X-Amz-Signature",
+	"X-Amz-Security-Token",
+}
+
+// StripAuth removes SigV4 headers and presigned query parameters.
+func (a *AWSSigV4Auth) StripAuth(r *http.Request, p interface{}) {
+	r.Header.Del("Authorization")
+	r.Header.Del("X-Amz-Date")
+	r.Header.Del("X-Amz-Content-Sha256")
+	r.Header.Del("X-Amz-Security-Token")
+	q := r.URL.Query()
+	changed := false
+	for _, k := range presignParams {
+		if q.Has(k) {
+			q.Del(k)
+			changed = true
+		}
+	}
+	if changed {
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
+func (c *inParams) credential(accessKey string) *credential {
+	for i := range c.Credentials {
+		if c.Credentials[i].AccessKeyID == accessKey {
+			return &c.Credentials[i]
+		}
+	}
+	return nil
+}
+
+func withinWindow(info *sigInfo, cfg *inParams, now time.Time) bool {
+	skew := time.Duration(cfg.Tolerance) * time.Second
+	if info.presigned {
+		if info.expires <= 0 || info.expires > cfg.MaxExpires {
+			return false
+		}
+		expiry := info.amzDate.Add(time.Duration(info.expires) * time.Second)
+		return !now.Before(info.amzDate.Add(-skew)) && !now.After(expiry)
+	}
+	d := now.Sub(info.amzDate)
+	return d <= skew && d >= -skew
+}
+
+// parseSignature extracts SigV4 fields from the Authorization header or, for
+// presigned URLs, from the query string.
+func parseSignature(r *http.Request) (*sigInfo, bool) {
+	info := &sigInfo{}
+	var credStr, signed string
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), algorithm+" "); ok {
+		for _, field := range strings.Split(auth, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch k {
+			case "Credential":
+				credStr = v
+			case "SignedHeaders":
+				signed = v
+			case "Signature":
+				info.signature = v
+			}
+		}
+		info.rawDate = r.Header.Get("X-Amz-Date")
+		info.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	} else {
+		q := r.URL.Query()
+		if q.Get("X-Amz-Algorithm") != algorithm {
+			return nil, false
+		}
+		credStr = q.Get("X-Amz-Credential")
+		signed = q.Get("X-Amz-SignedHeaders")
+		info.signature = q.Get("X-Amz-Signature")
+		info.rawDate = q.Get("X-Amz-Date")
+		info.payloadHash = q.Get("X-Amz-Content-Sha256")
+		if info.payloadHash == "" {
+			info.payloadHash = unsignedPayload
+		}
+		exp, err := strconv.ParseInt(q.Get("X-Amz-Expires"), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		info.presigned = true
+		info.expires = exp
+	}
+	if credStr == "" || signed == "" || info.signature == "" || info.rawDate == "" {
+		return nil, false
+	}
+	if strings.HasPrefix(info.payloadHash, "STREAMING-") {
+		return nil, false
+	}
+
+	parts := strings.Split(credStr, "/")
+	if len(parts) != 5 || parts[0] == "" || parts[4] != "aws4_request" {
+		return nil, false
+	}
+	info.accessKey = parts[0]
+	info.region = parts[2]
+	info.service = parts[3]
+	info.scope = strings.Join(parts[1:], "/")
+
+	t, err := time.Parse(amzDateFormat, info.rawDate)
+	if err != nil || t.Format("20060102") != parts[1] {
+		return nil, false
+	}
+	info.amzDate = t
+
+	info.signedHeaders = strings.Split(signed, ";")
+	if !slices.IsSorted(info.signedHeaders) || !slices.Contains(info.signedHeaders, "host") {
+		return nil, false
+	}
+	for _, h := range info.signedHeaders {
+		if h == "" || h != strings.ToLower(h) {
+			return nil, false
+		}
+	}
+	return info, true
+}
+
+func init() { authplugins.RegisterIncoming(&AWSSigV4Auth{}) }
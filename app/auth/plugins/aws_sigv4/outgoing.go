@@ -0,0 +1,177 @@
+package awssigv4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// outParams configures SigV4 signing of outgoing requests. The access key,
+// secret key and session token are secret references. AssumeRoles is a chain
+// of roles assumed in order through STSEndpoint, each hop signed with the
+// credentials of the previous one. UnsignedPayload leaves the body out of the
+// signature so it is streamed to the upstream without being buffered.
+type outParams struct {
+	Region          string       `json:"region"`
+	Service         string       `json:"service"`
+	AccessKeyID     string       `json:"access_key_id"`
+	SecretAccessKey string       `json:"secret_access_key"`
+	SessionToken    string       `json:"session_token"`
+	AssumeRoles     []assumeRole `json:"assume_roles"`
+	STSEndpoint     string       `json:"sts_endpoint"`
+	Duration        int64        `json:"duration"`
+	UnsignedPayload bool         `json:"unsigned_payload"`
+}
+
+// assumed caches temporary credentials from assume-role chains across
+// configuration reloads. Values are JSON encoded awsCredentials.
+var assumed = authplugins.NewTokenCache()
+
+// AWSSigV4 signs outgoing requests with AWS Signature Version 4.
+type AWSSigV4 struct{}
+
+func (a *AWSSigV4) Name() string { return "aws_sigv4" }
+func (a *AWSSigV4) RequiredParams() []string {
+	return []string{"region", "service", "access_key_id", "secret_access_key"}
+}
+func (a *AWSSigV4) OptionalParams() []string {
+	return []string{"session_token", "assume_roles", "sts_endpoint", "duration", "unsigned_payload"}
+}
+
+// SignsRequest reports that the signature must cover the request as left by
+// every other outgoing plugin.
+func (a *AWSSigV4) SignsRequest() bool { return true }
+
+func (a *AWSSigV4) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[outParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if p.Region == "" || p.Service == "" {
+		return nil, fmt.Errorf("missing region or service")
+	}
+	if p.AccessKeyID == "" || p.SecretAccessKey == "" {
+		return nil, fmt.Errorf("missing access_key_id or secret_access_key")
+	}
+	refs := []string{p.AccessKeyID, p.SecretAccessKey}
+	if p.SessionToken != "" {
+		refs = append(refs, p.SessionToken)
+	}
+	for _, ref := range refs {
+		if err := secrets.ValidateSecret(ref); err != nil {
+			return nil, err
+		}
+	}
+	for i := range p.AssumeRoles {
+		role := &p.AssumeRoles[i]
+		if !strings.HasPrefix(role.RoleARN, "arn:") {
+			return nil, fmt.Errorf("invalid role_arn %q", role.RoleARN)
+		}
+		if role.SessionName == "" {
+			role.SessionName = "authtranslator"
+		}
+	}
+	if p.STSEndpoint == "" {
+		p.STSEndpoint = "https://sts." + p.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(p.STSEndpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid sts_endpoint")
+	}
+	if p.Duration < 0 {
+		return nil, fmt.Errorf("duration must not be negative")
+	}
+	if p.Duration == 0 {
+		p.Duration = 3600
+	}
+	return p, nil
+}
+
+func (a *AWSSigV4) AddAuth(ctx context.Context, r *http.Request, params interface{}) error {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return fmt.Errorf("invalid config")
+	}
+	creds, err := cfg.credentials(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := unsignedPayload
+	if !cfg.UnsignedPayload {
+		body, err := authplugins.GetBody(r)
+		if err != nil {
+			return err
+		}
+		payloadHash = hashHex(body)
+	}
+	return signRequest(r, creds, cfg.Region, cfg.Service, payloadHash, time.Now())
+}
+
+// staticCredentials loads the configured keys.
+func (p *outParams) staticCredentials(ctx context.Context) (awsCredentials, error) {
+	var c awsCredentials
+	var err error
+	if c.AccessKeyID, err = secrets.LoadSecret(ctx, p.AccessKeyID); err != nil {
+		return c, err
+	}
+	if c.SecretAccessKey, err = secrets.LoadSecret(ctx, p.SecretAccessKey); err != nil {
+		return c, err
+	}
+	if p.SessionToken != "" {
+		if c.SessionToken, err = secrets.LoadSecret(ctx, p.SessionToken); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// credentials returns the keys to sign with: the static keys, or the
+// temporary credentials at the end of the assume-role chain.
+func (p *outParams) credentials(ctx context.Context) (awsCredentials, error) {
+	if len(p.AssumeRoles) == 0 {
+		return p.staticCredentials(ctx)
+	}
+	roles, _ := json.Marshal(p.AssumeRoles)
+	key := strings.Join([]string{p.STSEndpoint, p.Region, p.AccessKeyID, p.SessionToken, string(roles)}, "\x00")
+	tok, err := assumed.Get(ctx, key, func(ctx context.Context) (authplugins.Token, error) {
+		creds, err := p.staticCredentials(ctx)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		var exp time.Time
+		for _, role := range p.AssumeRoles {
+			if creds, exp, err = assume(ctx, p.STSEndpoint, p.Region, creds, role, p.Duration); err != nil {
+				return authplugins.Token{}, err
+			}
+		}
+		b, err := json.Marshal(creds)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		return authplugins.Token{Value: string(b), Expiry: exp}, nil
+	})
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var creds awsCredentials
+	err = json.Unmarshal([]byte(tok.Value), &creds)
+	return creds, err
+}
+
+// SecretRefs returns the secret references the credentials are loaded from.
+func (a *AWSSigV4) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken}
+}
+
+func init() { authplugins.RegisterOutgoing(&AWSSigV4{}) }
@@ -0,0 +1,91 @@
+package awssigv4
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPClient performs AssumeRole requests. It can be swapped in tests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// maxSTSResponse bounds how much of an STS response is read.
+const maxSTSResponse = 1 << 20
+
+// assumeRole configures one hop of an assume-role chain.
+type assumeRole struct {
+	RoleARN     string `json:"role_arn"`
+	SessionName string `json:"session_name"`
+	ExternalID  string `json:"external_id"`
+}
+
+type assumeRoleResponse struct {
+	Result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"Credentials"`
+	} `xml:"AssumeRoleResult"`
+}
+
+type stsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// assume calls STS AssumeRole at endpoint with creds and returns the
+// temporary credentials and their expiry.
+func assume(ctx context.Context, endpoint, region string, creds awsCredentials, role assumeRole, duration int64) (awsCredentials, time.Time, error) {
+	form := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {role.RoleARN},
+		"RoleSessionName": {role.SessionName},
+		"DurationSeconds": {strconv.FormatInt(duration, 10)},
+	}
+	if role.ExternalID != "" {
+		form.Set("ExternalId", role.ExternalID)
+	}
+	body := form.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if err := signRequest(req, creds, region, "sts", hashHex([]byte(body)), time.Now()); err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSTSResponse))
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e stsError
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return awsCredentials{}, time.Time{}, fmt.Errorf("assume role %s: %s: %s", role.RoleARN, e.Code, e.Message)
+		}
+		return awsCredentials{}, time.Time{}, fmt.Errorf("assume role %s: STS returned %s", role.RoleARN, resp.Status)
+	}
+	var out assumeRoleResponse
+	if err := xml.Unmarshal(data, &out); err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	c := out.Result.Credentials
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return awsCredentials{}, time.Time{}, fmt.Errorf("assume role %s: empty credentials", role.RoleARN)
+	}
+	return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken}, c.Expiration, nil
+}
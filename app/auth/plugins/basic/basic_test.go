@@ -4,9 +4,15 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
+	"golang.org/x/crypto/bcrypt"
+
 	"github.com/winhowes/AuthTranslator/app/secrets"
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
@@ -100,7 +106,7 @@ func TestBasicIdentify(t *testing.T) {
 func TestBasicPluginOptionalParams(t *testing.T) {
 	in := BasicAuth{}
 	out := BasicAuthOut{}
-	if got := in.OptionalParams(); len(got) != 2 || got[0] != "header" || got[1] != "prefix" {
+	if got := in.OptionalParams(); len(got) != 4 || got[0] != "secrets" || got[1] != "htpasswd" || got[2] != "header" || got[3] != "prefix" {
 		t.Fatalf("unexpected optional params: %v", got)
 	}
 	if got := out.OptionalParams(); len(got) != 2 || got[0] != "header" || got[1] != "prefix" {
@@ -296,7 +302,7 @@ func TestBasicPluginNamesAndRequiredParams(t *testing.T) {
 	if in.Name() != "basic" || out.Name() != "basic" {
 		t.Fatalf("unexpected names %s %s", in.Name(), out.Name())
 	}
-	if req := in.RequiredParams(); len(req) != 1 || req[0] != "secrets" {
+	if req := in.RequiredParams(); len(req) != 0 {
 		t.Fatalf("unexpected required params %v", req)
 	}
 	if req := out.RequiredParams(); len(req) != 1 || req[0] != "secrets" {
@@ -337,3 +343,183 @@ func TestBasicStripAuthInvalidParams(t *testing.T) {
 		t.Fatal("header should remain when params wrong type")
 	}
 }
+
+func basicRequest(user, pass string) *http.Request {
+	cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	return &http.Request{Header: http.Header{"Authorization": []string{"Basic " + cred}}}
+}
+
+func TestBasicHtpasswd(t *testing.T) {
+	bc, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256Hash, _ := shaCrypt([]byte("sha256-pw"), "$5$rounds=1000$abcdefgh")
+	sha512Hash, _ := shaCrypt([]byte("sha512-pw"), "$6$saltsalt")
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	data := "# users\nalice:" + string(bc) + "\nbob:" + sha256Hash + "\ncarol:" + sha512Hash + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secrets.ClearCache()
+
+	p := BasicAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"htpasswd": "file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, pass string
+		ok         bool
+	}{
+		{"alice", "bcrypt-pw", true},
+		{"bob", "sha256-pw", true},
+		{"carol", "sha512-pw", true},
+		{"alice", "wrong", false},
+		{"carol", "sha256-pw", false},
+		{"mallory", "bcrypt-pw", false},
+		{"", "bcrypt-pw", false},
+	}
+	for _, tt := range tests {
+		r := basicRequest(tt.user, tt.pass)
+		if got := p.Authenticate(context.Background(), r, cfg); got != tt.ok {
+			t.Fatalf("%s/%s: expected %v, got %v", tt.user, tt.pass, tt.ok, got)
+		}
+		// Cached verifications must give the same answer.
+		if got := p.Authenticate(context.Background(), r, cfg); got != tt.ok {
+			t.Fatalf("%s/%s: cached result %v", tt.user, tt.pass, got)
+		}
+		if tt.ok {
+			if id, ok := p.Identify(r, cfg); !ok || id != tt.user {
+				t.Fatalf("unexpected identity %q", id)
+			}
+		}
+	}
+
+	// Edits are picked up once the secret cache is cleared, as reload() does.
+	if err := os.WriteFile(path, []byte("bob:"+sha256Hash+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secrets.ClearCache()
+	if p.Authenticate(context.Background(), basicRequest("alice", "bcrypt-pw"), cfg) {
+		t.Fatal("expected removed user to fail")
+	}
+	if !p.Authenticate(context.Background(), basicRequest("bob", "sha256-pw"), cfg) {
+		t.Fatal("expected remaining user to succeed")
+	}
+}
+
+func TestHtpasswdDummyHash(t *testing.T) {
+	low, _ := bcrypt.GenerateFromPassword([]byte("a"), bcrypt.MinCost)
+	high, _ := bcrypt.GenerateFromPassword([]byte("b"), bcrypt.MinCost+1)
+	sha, _ := shaCrypt([]byte("c"), "$6$rounds=2000$salt")
+	fast := mustShaCrypt(t, "$6$rounds=1000$salt")
+	f, err := parseHtpasswd("low:" + string(low) + "\nhigh:" + string(high) + "\nsha:" + sha + "\nfast:" + fast + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.dummies) != 2 {
+		t.Fatalf("expected a dummy per scheme in a mixed file, got %q", f.dummies)
+	}
+	if cost, err := bcrypt.Cost([]byte(f.dummies[0])); err != nil || cost != bcrypt.MinCost+1 {
+		t.Fatalf("expected bcrypt dummy at the highest cost, got %q", f.dummies[0])
+	}
+	if f.dummies[1] != "$6$rounds=2000$"+dummySalt {
+		t.Fatalf("expected SHA-crypt dummy with the most rounds, got %q", f.dummies[1])
+	}
+	for user, hash := range f.users {
+		if slices.Contains(f.dummies, hash) {
+			t.Fatalf("dummy reuses the hash of %s", user)
+		}
+	}
+	// Unknown users are spread over the schemes so none of them stands out.
+	picked := map[string]bool{}
+	for i := 0; i < 64; i++ {
+		user := fmt.Sprintf("mallory%d", i)
+		picked[f.dummyFor(user)] = true
+		if f.dummyFor(user) != f.dummyFor(user) {
+			t.Fatal("expected the dummy for a user to be stable")
+		}
+	}
+	if len(picked) != 2 {
+		t.Fatalf("expected unknown users to hit every scheme, got %v", picked)
+	}
+	for _, pw := range []string{"a", "c", dummySalt} {
+		if f.verify("mallory", []byte(pw)) {
+			t.Fatal("expected unknown user to fail")
+		}
+	}
+}
+
+func mustShaCrypt(t *testing.T, settings string) string {
+	t.Helper()
+	h, err := shaCrypt([]byte("pw"), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestBasicHtpasswdWithSecrets(t *testing.T) {
+	t.Setenv("S", "svc:plain")
+	hash, _ := shaCrypt([]byte("pw"), "$6$salt")
+	t.Setenv("HTPASSWD", "user:"+hash)
+	secrets.ClearCache()
+	p := BasicAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:S"}, "htpasswd": "env:HTPASSWD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Authenticate(context.Background(), basicRequest("svc", "plain"), cfg) {
+		t.Fatal("expected plain secret to succeed")
+	}
+	if !p.Authenticate(context.Background(), basicRequest("user", "pw"), cfg) {
+		t.Fatal("expected htpasswd user to succeed")
+	}
+
+	t.Setenv("HTPASSWD", "user:$apr1$salt$hash")
+	secrets.ClearCache()
+	if p.Authenticate(context.Background(), basicRequest("user", "pw"), cfg) {
+		t.Fatal("expected unsupported hash file to fail closed")
+	}
+}
+
+func TestBasicHtpasswdParseErrors(t *testing.T) {
+	p := BasicAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{"htpasswd": "bogus"}); err == nil {
+		t.Fatal("expected invalid secret reference error")
+	}
+	cases := map[string]string{
+		"empty":       "# nothing\n",
+		"no colon":    "alice\n",
+		"unsupported": "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+		"bad bcrypt":  "alice:$2y$xx$short\n",
+		"duplicate":   "a:$5$s$h\na:$5$s$h\n",
+	}
+	for name, data := range cases {
+		if _, err := parseHtpasswd(data); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestShaCryptVectors(t *testing.T) {
+	vectors := []string{
+		"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+	}
+	for _, v := range vectors {
+		got, err := shaCrypt([]byte("Hello world!"), v)
+		if err != nil || got != v {
+			t.Fatalf("expected %s, got %s (%v)", v, got, err)
+		}
+	}
+	if _, err := shaCrypt([]byte("x"), "$1$salt$hash"); err == nil {
+		t.Fatal("expected error for unsupported prefix")
+	}
+	if _, err := shaCrypt([]byte("x"), "$5$rounds=abc$salt$hash"); err == nil {
+		t.Fatal("expected error for invalid rounds")
+	}
+}
@@ -0,0 +1,191 @@
+package basic
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// maxVerified bounds the cache of credentials that already passed hash
+// verification.
+const maxVerified = 10000
+
+// htpasswdFile is a parsed htpasswd file. Successful verifications are cached
+// by a digest of the credentials so bcrypt and SHA-crypt only run once per
+// distinct user and password.
+type htpasswdFile struct {
+	users map[string]string
+	// dummies holds one hash per scheme in the file, at the highest cost
+	// used with that scheme. An unknown user is verified against the one
+	// dummyKey selects for the name, so timing cannot tell it apart from a
+	// user of any scheme. The dummies never match a user's hash.
+	dummies  []string
+	dummyKey [sha256.Size]byte
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+// htpasswdState caches the parsed file and re-parses it whenever the secret
+// contents change.
+type htpasswdState struct {
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+	file   *htpasswdFile
+}
+
+func parseHtpasswd(data string) (*htpasswdFile, error) {
+	f := &htpasswdFile{users: make(map[string]string), verified: make(map[[sha256.Size]byte]struct{})}
+	sc := bufio.NewScanner(strings.NewReader(data))
+	line, bcryptCost := 0, 0
+	shaRounds := map[string]int{}
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("htpasswd line %d: expected user:hash", line)
+		}
+		if !supportedHash(hash) {
+			return nil, fmt.Errorf("htpasswd line %d: unsupported hash format for %s", line, user)
+		}
+		if _, dup := f.users[user]; dup {
+			return nil, fmt.Errorf("htpasswd line %d: duplicate user %s", line, user)
+		}
+		f.users[user] = hash
+		if cost, err := bcrypt.Cost([]byte(hash)); err == nil {
+			bcryptCost = max(bcryptCost, cost)
+		} else {
+			shaRounds[hash[:3]] = max(shaRounds[hash[:3]], shaCryptRounds(hash))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(f.users) == 0 {
+		return nil, fmt.Errorf("htpasswd file has no users")
+	}
+	if bcryptCost > 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte(dummySalt), bcryptCost)
+		if err != nil {
+			return nil, err
+		}
+		f.dummies = append(f.dummies, string(hash))
+	}
+	for _, magic := range []string{"$5$", "$6$"} {
+		if rounds, ok := shaRounds[magic]; ok {
+			f.dummies = append(f.dummies, fmt.Sprintf("%srounds=%d$%s", magic, rounds, dummySalt))
+		}
+	}
+	if _, err := rand.Read(f.dummyKey[:]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// dummyFor returns the dummy hash an unknown user is verified against.
+func (f *htpasswdFile) dummyFor(user string) string {
+	mac := hmac.New(sha256.New, f.dummyKey[:])
+	mac.Write([]byte(user))
+	return f.dummies[binary.BigEndian.Uint32(mac.Sum(nil))%uint32(len(f.dummies))]
+}
+
+// dummySalt seeds the dummy hash verified for unknown users.
+const dummySalt = "htpasswd-dummy"
+
+// shaCryptRounds returns the rounds a SHA-crypt hash is computed with.
+func shaCryptRounds(hash string) int {
+	r, ok := strings.CutPrefix(hash[3:], "rounds=")
+	if !ok {
+		return shaCryptDefaultRounds
+	}
+	r, _, _ = strings.Cut(r, "$")
+	n, err := strconv.Atoi(r)
+	if err != nil {
+		return shaCryptDefaultRounds
+	}
+	return min(max(n, shaCryptMinRounds), shaCryptMaxRounds)
+}
+
+func supportedHash(h string) bool {
+	switch {
+	case strings.HasPrefix(h, "$2a$"), strings.HasPrefix(h, "$2b$"), strings.HasPrefix(h, "$2y$"):
+		_, err := bcrypt.Cost([]byte(h))
+		return err == nil
+	case strings.HasPrefix(h, "$5$"), strings.HasPrefix(h, "$6$"):
+		return strings.Count(h, "$") >= 3
+	}
+	return false
+}
+
+func verifyHash(hash string, password []byte) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), password) == nil
+	}
+	got, err := shaCrypt(password, hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(hash)) == 1
+}
+
+// verify checks user and password. The hash comparison runs whether or not
+// the user exists so response times do not reveal valid usernames.
+func (f *htpasswdFile) verify(user string, password []byte) bool {
+	digest := sha256.Sum256([]byte(user + ":" + string(password)))
+	f.mu.Lock()
+	_, cached := f.verified[digest]
+	f.mu.Unlock()
+	if cached {
+		return true
+	}
+	hash, exists := f.users[user]
+	if !exists {
+		hash = f.dummyFor(user)
+	}
+	if !verifyHash(hash, password) || !exists {
+		return false
+	}
+	f.mu.Lock()
+	if len(f.verified) >= maxVerified {
+		f.verified = make(map[[sha256.Size]byte]struct{})
+	}
+	f.verified[digest] = struct{}{}
+	f.mu.Unlock()
+	return true
+}
+
+func (s *htpasswdState) load(ctx context.Context, ref string) (*htpasswdFile, error) {
+	raw, err := secrets.LoadSecret(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(raw))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && digest == s.digest {
+		return s.file, nil
+	}
+	f, err := parseHtpasswd(raw)
+	if err != nil {
+		return nil, err
+	}
+	s.digest = digest
+	s.file = f
+	return f, nil
+}
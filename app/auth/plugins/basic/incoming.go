@@ -13,25 +13,38 @@ import (
 )
 
 // BasicAuth validates HTTP Basic credentials from the request header.
+// Credentials are checked against plain user:pass secrets and, when
+// configured, an htpasswd file loaded through a secret reference.
 type inParams struct {
-	Secrets []string `json:"secrets"`
-	Header  string   `json:"header"`
-	Prefix  string   `json:"prefix"`
+	Secrets  []string `json:"secrets"`
+	Htpasswd string   `json:"htpasswd"`
+	Header   string   `json:"header"`
+	Prefix   string   `json:"prefix"`
+
+	htpasswd *htpasswdState
 }
 
 type BasicAuth struct{}
 
 func (b *BasicAuth) Name() string             { return "basic" }
-func (b *BasicAuth) RequiredParams() []string { return []string{"secrets"} }
-func (b *BasicAuth) OptionalParams() []string { return []string{"header", "prefix"} }
+func (b *BasicAuth) RequiredParams() []string { return []string{} }
+func (b *BasicAuth) OptionalParams() []string {
+	return []string{"secrets", "htpasswd", "header", "prefix"}
+}
 
 func (b *BasicAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 	p, err := authplugins.ParseParams[inParams](m)
 	if err != nil {
 		return nil, err
 	}
-	if len(p.Secrets) == 0 {
-		return nil, fmt.Errorf("missing secrets")
+	if len(p.Secrets) == 0 && p.Htpasswd == "" {
+		return nil, fmt.Errorf("missing secrets or htpasswd")
+	}
+	if p.Htpasswd != "" {
+		if err := secrets.ValidateSecret(p.Htpasswd); err != nil {
+			return nil, err
+		}
+		p.htpasswd = &htpasswdState{}
 	}
 	if p.Header == "" {
 		p.Header = "Authorization"
@@ -43,18 +56,26 @@ func (b *BasicAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 }
 
 func (b *BasicAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return b.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason checks the Basic credentials and explains failures.
+func (b *BasicAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*inParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
 	header := r.Header.Get(cfg.Header)
+	if header == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
 	if !strings.HasPrefix(header, cfg.Prefix) {
-		return false
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
 	}
 	enc := strings.TrimPrefix(header, cfg.Prefix)
 	dec, err := base64.StdEncoding.DecodeString(enc)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
 	}
 	creds := dec
 	for _, ref := range cfg.Secrets {
@@ -63,10 +84,24 @@ func (b *BasicAuth) Authenticate(ctx context.Context, r *http.Request, p interfa
 			continue
 		}
 		if subtle.ConstantTimeCompare(creds, []byte(sec)) == 1 {
-			return true
+			return nil
+		}
+	}
+	if cfg.htpasswd != nil {
+		user, pass, ok := strings.Cut(string(creds), ":")
+		if !ok || user == "" {
+			return authplugins.Fail(authplugins.ReasonMalformedCredential, "credentials are not user:password")
+		}
+		f, err := cfg.htpasswd.load(ctx, cfg.Htpasswd)
+		if err != nil {
+			authplugins.Logger().Error("basic: failed to load htpasswd", "error", err)
+			return &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+		}
+		if f.verify(user, []byte(pass)) {
+			return nil
 		}
 	}
-	return false
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "credentials do not match")
 }
 
 // Identify returns the username from the Basic auth header when present.
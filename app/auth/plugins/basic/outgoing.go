@@ -54,4 +54,13 @@ func (b *BasicAuthOut) AddAuth(ctx context.Context, r *http.Request, p interface
 	return nil
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (b *BasicAuthOut) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return cfg.Secrets
+}
+
 func init() { authplugins.RegisterOutgoing(&BasicAuthOut{}) }
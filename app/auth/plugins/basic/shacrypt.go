@@ -0,0 +1,169 @@
+package basic
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt ($5$ and $6$) as specified by Ulrich Drepper and used by glibc's
+// crypt(3) and htpasswd -2/-5.
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+	cryptAlphabet         = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// Byte order used when encoding the final digest, three bytes at a time.
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt hashes password using the settings ("$5$[rounds=N$]salt..." or
+// "$6$...") taken from an existing hash and returns the full crypt string.
+func shaCrypt(password []byte, settings string) (string, error) {
+	var newHash func() hash.Hash
+	var magic string
+	switch {
+	case strings.HasPrefix(settings, "$5$"):
+		newHash, magic = sha256.New, "$5$"
+	case strings.HasPrefix(settings, "$6$"):
+		newHash, magic = sha512.New, "$6$"
+	default:
+		return "", fmt.Errorf("unsupported crypt prefix")
+	}
+	rest := settings[len(magic):]
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if r, ok := strings.CutPrefix(rest, "rounds="); ok {
+		i := strings.IndexByte(r, '$')
+		if i < 0 {
+			return "", fmt.Errorf("invalid rounds")
+		}
+		n, err := strconv.Atoi(r[:i])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid rounds")
+		}
+		rounds = min(max(n, shaCryptMinRounds), shaCryptMaxRounds)
+		customRounds = true
+		rest = r[i+1:]
+	}
+	salt := rest
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	s := []byte(salt)
+
+	h := newHash()
+	h.Write(password)
+	h.Write(s)
+	h.Write(password)
+	b := h.Sum(nil)
+	size := len(b)
+
+	h.Reset()
+	h.Write(password)
+	h.Write(s)
+	for n := len(password); n > 0; n -= size {
+		h.Write(b[:min(n, size)])
+	}
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for range password {
+		h.Write(password)
+	}
+	dp := h.Sum(nil)
+	p := repeatTo(dp, len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := h.Sum(nil)
+	sp := repeatTo(ds, len(s))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sp)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(c[:0])
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	if customRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	order := sha256CryptOrder
+	if magic == "$6$" {
+		order = sha512CryptOrder
+	}
+	for _, o := range order {
+		encode24(&out, c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+	if magic == "$6$" {
+		encode24(&out, 0, 0, c[63], 2)
+	} else {
+		encode24(&out, 0, c[31], c[30], 3)
+	}
+	return out.String(), nil
+}
+
+func repeatTo(src []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, src[:min(n-len(out), len(src))]...)
+	}
+	return out
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -40,16 +40,33 @@ func (e *EnvoyXFCCAuth) ParseParams(m map[string]interface{}) (interface{}, erro
 }
 
 func (e *EnvoyXFCCAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return e.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason validates the XFCC identity and explains failures.
+func (e *EnvoyXFCCAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*inParams)
 	if !ok {
 		logAuthFailure(ctx, r, "", "invalid_params")
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
-	if _, ok := extractCallerIdentityFromValues(r.Header.Values(cfg.Header), cfg); !ok {
+	values := r.Header.Values(cfg.Header)
+	if _, ok := extractCallerIdentityFromValues(values, cfg); !ok {
 		logAuthFailure(ctx, r, cfg.Header, "authentication_failed")
-		return false
+		if len(values) == 0 {
+			return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+		}
+		// An empty prefix allows every URI, so success here means the header
+		// named a single identity that the allow lists rejected.
+		open := *cfg
+		open.AllowedURIs = nil
+		open.AllowedURIPrefix = []string{""}
+		if id, ok := extractCallerIdentityFromValues(values, &open); ok {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "identity %s is not allowed", id)
+		}
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "no unique caller identity in %s", cfg.Header)
 	}
-	return true
+	return nil
 }
 
 func (e *EnvoyXFCCAuth) Identify(r *http.Request, p interface{}) (string, bool) {
@@ -92,4 +92,13 @@ func (f *FindReplace) AddAuth(ctx context.Context, r *http.Request, params inter
 	return nil
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (f *FindReplace) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.FindSecret, cfg.ReplaceSecret}
+}
+
 func init() { authplugins.RegisterOutgoing(&FindReplace{}) }
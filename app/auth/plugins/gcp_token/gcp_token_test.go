@@ -10,6 +10,10 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth/google"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
 
 func TestGCPTokenAddAuth(t *testing.T) {
@@ -193,7 +197,7 @@ func TestGCPTokenRequiredOptional(t *testing.T) {
 		t.Fatalf("expected nil required params")
 	}
 	opts := p.OptionalParams()
-	if len(opts) != 2 || opts[0] != "header" || opts[1] != "prefix" {
+	if strings.Join(opts, ",") != "header,prefix,credentials,scopes,impersonate_service_account" {
 		t.Fatalf("unexpected optional params: %v", opts)
 	}
 }
@@ -250,3 +254,83 @@ func TestGCPTokenAddAuthPassesContext(t *testing.T) {
 		t.Fatalf("expected header to be set, got %s", got)
 	}
 }
+
+func TestGCPTokenImpersonation(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/token"):
+			if r.URL.Query().Get("scopes") != "" {
+				t.Errorf("impersonation should use the default metadata scopes")
+			}
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "base", "expires_in": 3600})
+		case strings.HasSuffix(r.URL.Path, ":generateAccessToken"):
+			if r.Header.Get("Authorization") != "Bearer base" {
+				t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+			}
+			var body struct {
+				Scope []string `json:"scope"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if strings.Join(body.Scope, " ") != "https://www.googleapis.com/auth/drive.readonly" {
+				t.Errorf("unexpected scopes %v", body.Scope)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"accessToken": "impersonated", "expireTime": time.Now().Add(time.Hour)})
+		}
+	}))
+	defer ts.Close()
+
+	oldHost, oldClient, oldIAM := MetadataHost, HTTPClient, google.IAMCredentialsURL
+	MetadataHost, HTTPClient, google.IAMCredentialsURL = ts.URL, ts.Client(), ts.URL
+	defer func() { MetadataHost, HTTPClient, google.IAMCredentialsURL = oldHost, oldClient, oldIAM }()
+
+	p := GCPToken{}
+	cfg, err := p.ParseParams(map[string]any{
+		"scopes":                      []string{"https://www.googleapis.com/auth/drive.readonly"},
+		"impersonate_service_account": "drive@proj.iam.gserviceaccount.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		r := &http.Request{Header: http.Header{}}
+		if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer impersonated" {
+			t.Fatalf("unexpected header %q", got)
+		}
+	}
+	if len(paths) != 2 || paths[1] != "/v1/projects/-/serviceAccounts/drive@proj.iam.gserviceaccount.com:generateAccessToken" {
+		t.Fatalf("unexpected requests %v", paths)
+	}
+
+	r := &http.Request{Header: http.Header{"Authorization": {"Bearer impersonated"}}}
+	p.Invalidate(r, cfg)
+	if err := p.AddAuth(context.Background(), &http.Request{Header: http.Header{}}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 4 {
+		t.Fatalf("expected refetch after invalidation, got %v", paths)
+	}
+}
+
+func TestGCPTokenCredentialsParams(t *testing.T) {
+	p := GCPToken{}
+	if _, err := p.ParseParams(map[string]any{"credentials": "bogus:ref"}); err == nil {
+		t.Fatal("expected invalid secret reference error")
+	}
+	if _, err := p.ParseParams(map[string]any{"scopes": []string{""}}); err == nil {
+		t.Fatal("expected empty scope error")
+	}
+	t.Setenv("GCP_CREDS", `{"type":"authorized_user"}`)
+	secrets.ClearCache()
+	cfg, err := p.ParseParams(map[string]any{"credentials": "env:GCP_CREDS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddAuth(context.Background(), &http.Request{Header: http.Header{}}, cfg); err == nil || !strings.Contains(err.Error(), "authorized_user") {
+		t.Fatalf("expected unsupported credentials error, got %v", err)
+	}
+}
@@ -2,24 +2,32 @@ package gcptoken
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/auth/google"
+	"github.com/winhowes/AuthTranslator/app/secrets"
 )
 
-// gcpTokenParams configures the GCP token plugin.
+// gcpTokenParams configures the GCP token plugin. Credentials is a secret
+// reference to a service account key or external account JSON file; without
+// it tokens come from the metadata server. ImpersonateServiceAccount
+// exchanges that token for one belonging to another service account.
 type gcpTokenParams struct {
-	Header string `json:"header"`
-	Prefix string `json:"prefix"`
+	Header                    string   `json:"header"`
+	Prefix                    string   `json:"prefix"`
+	Credentials               string   `json:"credentials"`
+	Scopes                    []string `json:"scopes"`
+	ImpersonateServiceAccount string   `json:"impersonate_service_account"`
 }
 
-// GCPToken obtains an OAuth access token from the GCP metadata server
-// and attaches it to outgoing requests.
+// GCPToken obtains an OAuth access token from the GCP metadata server, a
+// service account key or workload identity federation and attaches it to
+// outgoing requests.
 type GCPToken struct{}
 
 // MetadataHost is the base URL for metadata requests.
@@ -38,11 +46,17 @@ var (
 	cache cachedToken
 )
 
+// tokens caches tokens for configurations other than the plain metadata
+// default, keyed by cacheKey.
+var tokens = authplugins.NewTokenCache()
+
 func (g *GCPToken) Name() string { return "gcp_token" }
 
 func (g *GCPToken) RequiredParams() []string { return nil }
 
-func (g *GCPToken) OptionalParams() []string { return []string{"header", "prefix"} }
+func (g *GCPToken) OptionalParams() []string {
+	return []string{"header", "prefix", "credentials", "scopes", "impersonate_service_account"}
+}
 
 func (g *GCPToken) ParseParams(m map[string]interface{}) (interface{}, error) {
 	p, err := authplugins.ParseParams[gcpTokenParams](m)
@@ -55,6 +69,16 @@ func (g *GCPToken) ParseParams(m map[string]interface{}) (interface{}, error) {
 	if p.Prefix == "" {
 		p.Prefix = "Bearer "
 	}
+	if p.Credentials != "" {
+		if err := secrets.ValidateSecret(p.Credentials); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range p.Scopes {
+		if s == "" {
+			return nil, fmt.Errorf("empty scope")
+		}
+	}
 	return p, nil
 }
 
@@ -63,6 +87,14 @@ func (g *GCPToken) AddAuth(ctx context.Context, r *http.Request, params interfac
 	if !ok {
 		return fmt.Errorf("invalid config")
 	}
+	if cfg.custom() {
+		tok, err := tokens.Get(ctx, cfg.cacheKey(), cfg.fetch)
+		if err != nil {
+			return err
+		}
+		r.Header.Set(cfg.Header, cfg.Prefix+tok.Value)
+		return nil
+	}
 	tok, exp := getCachedToken()
 	if tok == "" || time.Now().After(exp.Add(-1*time.Minute)) {
 		var err error
@@ -76,29 +108,78 @@ func (g *GCPToken) AddAuth(ctx context.Context, r *http.Request, params interfac
 	return nil
 }
 
-func fetchToken(ctx context.Context) (string, time.Time, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", MetadataHost+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
-	if err != nil {
-		return "", time.Time{}, err
+// Invalidate drops the rejected token so the next request fetches a new one.
+func (g *GCPToken) Invalidate(r *http.Request, params interface{}) {
+	cfg, ok := params.(*gcpTokenParams)
+	if !ok {
+		return
 	}
-	req.Header.Set("Metadata-Flavor", "Google")
-	resp, err := HTTPClient.Do(req)
-	if err != nil {
-		return "", time.Time{}, err
+	tok, ok := strings.CutPrefix(r.Header.Get(cfg.Header), cfg.Prefix)
+	if !ok {
+		return
+	}
+	if !cfg.custom() {
+		mu.Lock()
+		if cache.token == tok {
+			cache = cachedToken{}
+		}
+		mu.Unlock()
+		return
+	}
+	tokens.Invalidate(cfg.cacheKey(), tok)
+}
+
+// custom reports whether cfg needs more than the default metadata token.
+func (p *gcpTokenParams) custom() bool {
+	return p.Credentials != "" || len(p.Scopes) > 0 || p.ImpersonateServiceAccount != ""
+}
+
+func (p *gcpTokenParams) cacheKey() string {
+	return strings.Join([]string{p.Credentials, p.ImpersonateServiceAccount, strings.Join(p.Scopes, " ")}, "\x00")
+}
+
+func (p *gcpTokenParams) scopes() []string {
+	if len(p.Scopes) == 0 {
+		return []string{google.CloudPlatformScope}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", time.Time{}, fmt.Errorf("status %s: %s", resp.Status, body)
+	return p.Scopes
+}
+
+// fetch obtains a token from the configured source, impersonating
+// ImpersonateServiceAccount when set.
+func (p *gcpTokenParams) fetch(ctx context.Context) (authplugins.Token, error) {
+	scopes := p.scopes()
+	if p.ImpersonateServiceAccount != "" {
+		scopes = []string{google.CloudPlatformScope}
 	}
-	var tr struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int64  `json:"expires_in"`
+	var tok authplugins.Token
+	var err error
+	if p.Credentials == "" {
+		var metaScopes []string
+		if p.ImpersonateServiceAccount == "" {
+			metaScopes = p.Scopes
+		}
+		tok, err = google.MetadataAccessToken(ctx, HTTPClient, MetadataHost, metaScopes)
+	} else {
+		var data string
+		if data, err = secrets.LoadSecret(ctx, p.Credentials); err != nil {
+			return authplugins.Token{}, err
+		}
+		var creds *google.Credentials
+		if creds, err = google.ParseCredentials(data); err != nil {
+			return authplugins.Token{}, err
+		}
+		tok, err = creds.AccessToken(ctx, HTTPClient, scopes)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
-		return "", time.Time{}, err
+	if err != nil || p.ImpersonateServiceAccount == "" {
+		return tok, err
 	}
-	return tr.AccessToken, time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second), nil
+	return google.GenerateAccessToken(ctx, HTTPClient, tok.Value, p.ImpersonateServiceAccount, p.scopes())
+}
+
+func fetchToken(ctx context.Context) (string, time.Time, error) {
+	tok, err := google.MetadataAccessToken(ctx, HTTPClient, MetadataHost, nil)
+	return tok.Value, tok.Expiry, err
 }
 
 func getCachedToken() (string, time.Time) {
@@ -114,4 +195,13 @@ func setCachedToken(tok string, exp time.Time) {
 	mu.Unlock()
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (g *GCPToken) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*gcpTokenParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.Credentials}
+}
+
 func init() { authplugins.RegisterOutgoing(&GCPToken{}) }
@@ -0,0 +1,262 @@
+package githubapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func reset() {
+	secrets.ClearCache()
+	tokens = authplugins.NewTokenCache()
+	installations.Lock()
+	installations.m = make(map[string]int64)
+	installations.Unlock()
+}
+
+// newGitHub fakes the app endpoints, verifying the app JWT with key.
+func newGitHub(t *testing.T, key *rsa.PrivateKey, issued *int32, lookups *int32) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"message": "A JSON web token could not be decoded"})
+			return
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if !strings.Contains(string(claims), `"iss":"42"`) {
+			t.Errorf("unexpected claims %s", claims)
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/octo/repo/installation":
+			atomic.AddInt32(lookups, 1)
+			json.NewEncoder(w).Encode(map[string]int64{"id": 7})
+		case r.Method == http.MethodGet && r.URL.Path == "/orgs/missing/installation":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Not Found"})
+		case r.Method == http.MethodPost && r.URL.Path == "/app/installations/7/access_tokens":
+			n := atomic.AddInt32(issued, 1)
+			var req struct {
+				Repositories []string          `json:"repositories"`
+				Permissions  map[string]string `json:"permissions"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			tok := fmt.Sprintf("ghs_%d", n)
+			if req.Permissions["contents"] == "read" {
+				tok += "_narrow"
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"token":      tok,
+				"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	oldClient := HTTPClient
+	HTTPClient = ts.Client()
+	t.Cleanup(func() {
+		HTTPClient = oldClient
+		ts.Close()
+	})
+	return ts
+}
+
+func appKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GH_APP_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})))
+	return key
+}
+
+func TestGitHubAppInstallationToken(t *testing.T) {
+	reset()
+	key := appKey(t)
+	var issued, lookups int32
+	ts := newGitHub(t, key, &issued, &lookups)
+	p := GitHubApp{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"app_id":          42,
+		"private_key":     "env:GH_APP_KEY",
+		"installation_id": "7",
+		"api_url":         ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://github/", nil)
+		if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Header.Get("Authorization"); got != "token ghs_1" {
+			t.Fatalf("unexpected header %q", got)
+		}
+	}
+	if issued != 1 {
+		t.Fatalf("expected token to be cached, got %d issues", issued)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://github/", nil)
+	r.Header.Set("Authorization", "token ghs_1")
+	p.Invalidate(r, cfg)
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header.Get("Authorization"); got != "token ghs_2" {
+		t.Fatalf("expected new token after invalidation, got %q", got)
+	}
+}
+
+func TestGitHubAppRepositoryLookupAndNarrowing(t *testing.T) {
+	reset()
+	key := appKey(t)
+	var issued, lookups int32
+	ts := newGitHub(t, key, &issued, &lookups)
+	p := GitHubApp{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"app_id":       "42",
+		"private_key":  "env:GH_APP_KEY",
+		"repository":   "octo/repo",
+		"api_url":      ts.URL + "/",
+		"permissions":  map[string]string{"contents": "read"},
+		"repositories": []string{"repo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://github/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header.Get("Authorization"); got != "token ghs_1_narrow" {
+		t.Fatalf("unexpected header %q", got)
+	}
+	tokens = authplugins.NewTokenCache()
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 1 {
+		t.Fatalf("expected installation lookup to be cached, got %d", lookups)
+	}
+}
+
+func TestGitHubAppReinstalledApp(t *testing.T) {
+	reset()
+	key := appKey(t)
+	var issued, lookups int32
+	ts := newGitHub(t, key, &issued, &lookups)
+	p := GitHubApp{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"app_id":      "42",
+		"private_key": "env:GH_APP_KEY",
+		"repository":  "octo/repo",
+		"api_url":     ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The app was uninstalled and reinstalled since installation 99 was cached.
+	installations.Lock()
+	installations.m[cfg.(*outParams).installationKey()] = 99
+	installations.Unlock()
+
+	r := httptest.NewRequest(http.MethodGet, "http://github/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header.Get("Authorization"); got != "token ghs_1" {
+		t.Fatalf("unexpected header %q", got)
+	}
+	if lookups != 1 {
+		t.Fatalf("expected the stale installation to be looked up again, got %d lookups", lookups)
+	}
+}
+
+func TestGitHubAppErrors(t *testing.T) {
+	reset()
+	key := appKey(t)
+	var issued, lookups int32
+	ts := newGitHub(t, key, &issued, &lookups)
+	p := GitHubApp{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"app_id":       42,
+		"private_key":  "env:GH_APP_KEY",
+		"organization": "missing",
+		"api_url":      ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://github/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err == nil || !strings.Contains(err.Error(), "Not Found") {
+		t.Fatalf("expected lookup failure, got %v", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	t.Setenv("GH_APP_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(other)})))
+	reset()
+	cfg, _ = p.ParseParams(map[string]interface{}{
+		"app_id":          42,
+		"private_key":     "env:GH_APP_KEY",
+		"installation_id": 7,
+		"api_url":         ts.URL,
+	})
+	if err := p.AddAuth(context.Background(), r, cfg); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected wrong key to be rejected, got %v", err)
+	}
+}
+
+func TestGitHubAppParseParams(t *testing.T) {
+	p := GitHubApp{}
+	cases := []map[string]interface{}{
+		{"private_key": "env:K", "installation_id": 1},
+		{"app_id": "abc", "private_key": "env:K", "installation_id": 1},
+		{"app_id": 1, "installation_id": 1},
+		{"app_id": 1, "private_key": "env:K"},
+		{"app_id": 1, "private_key": "env:K", "installation_id": 1, "organization": "o"},
+		{"app_id": 1, "private_key": "env:K", "repository": "noslash"},
+		{"app_id": 1, "private_key": "env:K", "installation_id": 1, "api_url": "ftp://x"},
+		{"app_id": 1, "private_key": "env:K", "installation_id": 1, "permissions": map[string]string{"contents": "all"}},
+	}
+	for i, m := range cases {
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"app_id": 1, "private_key": "env:K", "organization": "o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*outParams); c.APIURL != "https://api.github.com" || c.Header != "Authorization" || c.Prefix != "token " {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
@@ -0,0 +1,345 @@
+package githubapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// outParams configures GitHub App installation tokens. PrivateKey is a
+// secret reference to the app's PEM private key. The installation is
+// InstallationID when set, otherwise the one covering Repository
+// ("owner/repo") or Organization. Permissions and Repositories narrow the
+// token below what the installation grants.
+type outParams struct {
+	AppID          json.Number       `json:"app_id"`
+	PrivateKey     string            `json:"private_key"`
+	InstallationID json.Number       `json:"installation_id"`
+	Repository     string            `json:"repository"`
+	Organization   string            `json:"organization"`
+	APIURL         string            `json:"api_url"`
+	Permissions    map[string]string `json:"permissions"`
+	Repositories   []string          `json:"repositories"`
+	Header         string            `json:"header"`
+	Prefix         string            `json:"prefix"`
+}
+
+// HTTPClient performs GitHub API requests. It can be swapped in tests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// maxResponseBody bounds how much of a GitHub response is read.
+const maxResponseBody = 1 << 20
+
+// tokens caches installation tokens across configuration reloads.
+var tokens = authplugins.NewTokenCache()
+
+// installations caches installation IDs looked up by repository or
+// organization; they do not change while the app stays installed. An ID
+// GitHub no longer knows, because the app was reinstalled, is dropped and
+// looked up again.
+var installations = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: make(map[string]int64)}
+
+// GitHubApp attaches GitHub App installation access tokens to outgoing
+// requests.
+type GitHubApp struct{}
+
+func (g *GitHubApp) Name() string { return "github_app" }
+
+func (g *GitHubApp) RequiredParams() []string { return []string{"app_id", "private_key"} }
+
+func (g *GitHubApp) OptionalParams() []string {
+	return []string{"installation_id", "repository", "organization", "api_url", "permissions", "repositories", "header", "prefix"}
+}
+
+func (g *GitHubApp) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[outParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if id, err := p.AppID.Int64(); err != nil || id <= 0 {
+		return nil, fmt.Errorf("invalid app_id")
+	}
+	if p.PrivateKey == "" {
+		return nil, fmt.Errorf("missing private_key")
+	}
+	if err := secrets.ValidateSecret(p.PrivateKey); err != nil {
+		return nil, err
+	}
+	targets := 0
+	if p.InstallationID != "" {
+		if id, err := p.InstallationID.Int64(); err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid installation_id")
+		}
+		targets++
+	}
+	if p.Repository != "" {
+		if owner, repo, ok := strings.Cut(p.Repository, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("repository must be owner/repo")
+		}
+		targets++
+	}
+	if p.Organization != "" {
+		targets++
+	}
+	if targets != 1 {
+		return nil, fmt.Errorf("exactly one of installation_id, repository or organization is required")
+	}
+	if p.APIURL == "" {
+		p.APIURL = "https://api.github.com"
+	}
+	u, err := url.Parse(p.APIURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid api_url")
+	}
+	p.APIURL = strings.TrimSuffix(p.APIURL, "/")
+	for k, v := range p.Permissions {
+		if v != "read" && v != "write" && v != "admin" {
+			return nil, fmt.Errorf("invalid permission %s: %s", k, v)
+		}
+	}
+	if p.Header == "" {
+		p.Header = "Authorization"
+	}
+	if p.Prefix == "" {
+		p.Prefix = "token "
+	}
+	return p, nil
+}
+
+// cacheKey identifies the installation token a configuration would be
+// issued, including any narrowing so differently scoped tokens never mix.
+func (p *outParams) cacheKey() string {
+	perms := make([]string, 0, len(p.Permissions))
+	for k, v := range p.Permissions {
+		perms = append(perms, k+"="+v)
+	}
+	sort.Strings(perms)
+	repos := append([]string(nil), p.Repositories...)
+	sort.Strings(repos)
+	return strings.Join([]string{p.APIURL, p.AppID.String(), p.installationTarget(), strings.Join(perms, ","), strings.Join(repos, ",")}, "\x00")
+}
+
+// installationTarget names the configured installation.
+func (p *outParams) installationTarget() string {
+	switch {
+	case p.InstallationID != "":
+		return "id:" + p.InstallationID.String()
+	case p.Repository != "":
+		return "repo:" + strings.ToLower(p.Repository)
+	default:
+		return "org:" + strings.ToLower(p.Organization)
+	}
+}
+
+func (g *GitHubApp) AddAuth(ctx context.Context, r *http.Request, params interface{}) error {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return fmt.Errorf("invalid config")
+	}
+	tok, err := tokens.Get(ctx, cfg.cacheKey(), func(ctx context.Context) (authplugins.Token, error) {
+		return installationToken(ctx, cfg)
+	})
+	if err != nil {
+		return err
+	}
+	r.Header.Set(cfg.Header, cfg.Prefix+tok.Value)
+	return nil
+}
+
+// Invalidate drops the cached token carried by a request GitHub rejected,
+// for example after the installation's permissions changed.
+func (g *GitHubApp) Invalidate(r *http.Request, params interface{}) {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return
+	}
+	if v := r.Header.Get(cfg.Header); strings.HasPrefix(v, cfg.Prefix) {
+		tokens.Invalidate(cfg.cacheKey(), strings.TrimPrefix(v, cfg.Prefix))
+	}
+}
+
+// appJWT signs the short-lived JWT that authenticates as the app itself.
+// iat is backdated to tolerate clock drift as GitHub recommends.
+func appJWT(ctx context.Context, cfg *outParams) (string, error) {
+	pemKey, err := secrets.LoadSecret(ctx, cfg.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := authplugins.ParsePrivateKey(pemKey)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	return authplugins.SignJWT("RS256", key, "", map[string]interface{}{
+		"iat": now - 60,
+		"exp": now + 540,
+		"iss": cfg.AppID.String(),
+	})
+}
+
+func installationToken(ctx context.Context, cfg *outParams) (authplugins.Token, error) {
+	jwt, err := appJWT(ctx, cfg)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	id, err := installationID(ctx, cfg, jwt)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	var body []byte
+	if len(cfg.Permissions) > 0 || len(cfg.Repositories) > 0 {
+		req := struct {
+			Repositories []string          `json:"repositories,omitempty"`
+			Permissions  map[string]string `json:"permissions,omitempty"`
+		}{cfg.Repositories, cfg.Permissions}
+		if body, err = json.Marshal(req); err != nil {
+			return authplugins.Token{}, err
+		}
+	}
+	var resp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	path := "/app/installations/" + strconv.FormatInt(id, 10) + "/access_tokens"
+	err = call(ctx, cfg, http.MethodPost, path, jwt, body, http.StatusCreated, &resp)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound && cfg.InstallationID == "" {
+		forgetInstallation(cfg)
+		if id, err = installationID(ctx, cfg, jwt); err != nil {
+			return authplugins.Token{}, err
+		}
+		path = "/app/installations/" + strconv.FormatInt(id, 10) + "/access_tokens"
+		err = call(ctx, cfg, http.MethodPost, path, jwt, body, http.StatusCreated, &resp)
+	}
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	if resp.Token == "" {
+		return authplugins.Token{}, fmt.Errorf("empty installation token")
+	}
+	if resp.ExpiresAt.IsZero() {
+		resp.ExpiresAt = time.Now().Add(authplugins.DefaultTokenLifetime)
+	}
+	return authplugins.Token{Value: resp.Token, Expiry: resp.ExpiresAt}, nil
+}
+
+// installationID returns the configured installation or looks it up for the
+// repository or organization.
+func installationID(ctx context.Context, cfg *outParams, jwt string) (int64, error) {
+	if cfg.InstallationID != "" {
+		return cfg.InstallationID.Int64()
+	}
+	key := cfg.installationKey()
+	installations.Lock()
+	id, ok := installations.m[key]
+	installations.Unlock()
+	if ok {
+		return id, nil
+	}
+	path := "/orgs/" + url.PathEscape(cfg.Organization) + "/installation"
+	if cfg.Repository != "" {
+		owner, repo, _ := strings.Cut(cfg.Repository, "/")
+		path = "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/installation"
+	}
+	var resp struct {
+		ID int64 `json:"id"`
+	}
+	if err := call(ctx, cfg, http.MethodGet, path, jwt, nil, http.StatusOK, &resp); err != nil {
+		return 0, fmt.Errorf("looking up installation: %w", err)
+	}
+	if resp.ID == 0 {
+		return 0, fmt.Errorf("installation not found")
+	}
+	installations.Lock()
+	installations.m[key] = resp.ID
+	installations.Unlock()
+	return resp.ID, nil
+}
+
+func (p *outParams) installationKey() string {
+	return p.APIURL + "\x00" + p.AppID.String() + "\x00" + p.installationTarget()
+}
+
+// forgetInstallation drops the cached installation ID for cfg.
+func forgetInstallation(cfg *outParams) {
+	installations.Lock()
+	delete(installations.m, cfg.installationKey())
+	installations.Unlock()
+}
+
+// apiError is returned by call when GitHub answers with an unexpected status.
+type apiError struct {
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("github returned %s: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("github returned %s", e.Status)
+}
+
+// call performs an app-authenticated GitHub API request and decodes the
+// JSON response into out.
+func call(ctx context.Context, cfg *outParams, method, path, jwt string, body []byte, want int, out interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cfg.APIURL+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != want {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &e)
+		return &apiError{Code: resp.StatusCode, Status: resp.Status, Message: e.Message}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// SecretRefs returns the secret references the credentials are loaded from.
+func (g *GitHubApp) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.PrivateKey}
+}
+
+func init() { authplugins.RegisterOutgoing(&GitHubApp{}) }
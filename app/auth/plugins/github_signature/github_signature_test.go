@@ -11,6 +11,8 @@ import (
 	"strings"
 	"testing"
 
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
 
@@ -62,7 +64,7 @@ func TestGitHubSignatureAuthFail(t *testing.T) {
 
 func TestGitHubSignatureOptionalParams(t *testing.T) {
 	p := GitHubSignatureAuth{}
-	if got := p.OptionalParams(); len(got) != 2 || got[0] != "header" {
+	if got := p.OptionalParams(); len(got) != 5 || got[0] != "header" {
 		t.Fatalf("unexpected optional params: %v", got)
 	}
 }
@@ -190,3 +192,34 @@ func TestGitHubSignatureStripAuthInvalidParams(t *testing.T) {
 		t.Fatal("header should remain when params wrong type")
 	}
 }
+
+func TestGitHubSignatureReplayProtection(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("hello"))
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	newReq := func(delivery string) *http.Request {
+		return &http.Request{Header: http.Header{
+			"X-Hub-Signature-256": []string{sig},
+			"X-Github-Delivery":   []string{delivery},
+		}, Body: io.NopCloser(strings.NewReader("hello"))}
+	}
+	p := GitHubSignatureAuth{}
+	t.Setenv("SEC", "key")
+	prev := authplugins.SetReplayStore(nil)
+	defer authplugins.SetReplayStore(prev)
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:SEC"}, "replay_protection": true, "nonce_header": "X-GitHub-Delivery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Authenticate(context.Background(), newReq("d1"), cfg) {
+		t.Fatal("expected first delivery to pass")
+	}
+	// The delivery header is not signed, so a captured request resent with a
+	// new ID is still a replay.
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newReq("d2"), cfg)); got != authplugins.ReasonReplayed {
+		t.Fatalf("expected replay with a new delivery ID to fail, got %s", got)
+	}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newReq("d1"), cfg)); got != authplugins.ReasonReplayed {
+		t.Fatalf("expected replayed, got %s", got)
+	}
+}
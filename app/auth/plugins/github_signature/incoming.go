@@ -14,6 +14,7 @@ import (
 
 // githubSigParams configures GitHub webhook signature validation.
 type githubSigParams struct {
+	authplugins.ReplayParams
 	Secrets []string `json:"secrets"`
 	Header  string   `json:"header"`
 	Prefix  string   `json:"prefix"`
@@ -25,13 +26,18 @@ func (g *GitHubSignatureAuth) Name() string { return "github_signature" }
 
 func (g *GitHubSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
 
-func (g *GitHubSignatureAuth) OptionalParams() []string { return []string{"header", "prefix"} }
+func (g *GitHubSignatureAuth) OptionalParams() []string {
+	return append([]string{"header", "prefix"}, authplugins.ReplayParamNames...)
+}
 
 func (g *GitHubSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 	p, err := authplugins.ParseParams[githubSigParams](m)
 	if err != nil {
 		return nil, err
 	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
 	if len(p.Secrets) == 0 {
 		return nil, fmt.Errorf("missing secrets")
 	}
@@ -45,31 +51,41 @@ func (g *GitHubSignatureAuth) ParseParams(m map[string]interface{}) (interface{}
 }
 
 func (g *GitHubSignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return g.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the GitHub signature and explains failures.
+func (g *GitHubSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*githubSigParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
 	body, err := authplugins.GetBody(r)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
 	}
 	sig := r.Header.Get(cfg.Header)
 	if sig == "" {
-		return false
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
 	}
+	loaded := false
 	for _, ref := range cfg.Secrets {
 		secret, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
+		loaded = true
 		mac := hmac.New(sha256.New, []byte(secret))
 		mac.Write(body)
 		expected := cfg.Prefix + hex.EncodeToString(mac.Sum(nil))
 		if hmac.Equal([]byte(expected), []byte(sig)) {
-			return true
+			return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(g.Name(), cfg.Secrets), sig, "", 0)
 		}
 	}
-	return false
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
 }
 
 // StripAuth removes the GitHub signature header from the request.
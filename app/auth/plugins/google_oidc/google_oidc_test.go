@@ -6,8 +6,10 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,6 +18,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/winhowes/AuthTranslator/app/secrets"
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
 
@@ -659,14 +662,14 @@ func TestGoogleOIDCParamMethods(t *testing.T) {
 	if got := p.RequiredParams(); len(got) != 1 || got[0] != "audience" {
 		t.Fatalf("unexpected required params %v", got)
 	}
-	if got := p.OptionalParams(); len(got) != 2 || got[0] != "header" || got[1] != "prefix" {
+	if got := p.OptionalParams(); strings.Join(got, ",") != "header,prefix,credentials,impersonate_service_account" {
 		t.Fatalf("unexpected optional params %v", got)
 	}
 	a := GoogleOIDCAuth{}
 	if got := a.RequiredParams(); len(got) != 1 || got[0] != "audience" {
 		t.Fatalf("unexpected required params %v", got)
 	}
-	if got := a.OptionalParams(); len(got) != 2 || got[0] != "header" || got[1] != "prefix" {
+	if got := a.OptionalParams(); len(got) != 4 || got[0] != "header" || got[1] != "prefix" || got[3] != "identity_template" {
 		t.Fatalf("unexpected optional params %v", got)
 	}
 }
@@ -1043,3 +1046,110 @@ func TestGoogleOIDCAuthenticateNoExp(t *testing.T) {
 		t.Fatal("expected authentication without exp claim")
 	}
 }
+
+func TestGoogleOIDCIdentityClaim(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	kid := "idkid"
+	headerBytes, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payloadBytes, _ := json.Marshal(map[string]interface{}{"aud": "aud", "sub": "123", "email": "svc@example.iam.gserviceaccount.com", "exp": time.Now().Add(time.Hour).Unix()})
+	signingInput := base64.RawURLEncoding.EncodeToString(headerBytes) + "." + base64.RawURLEncoding.EncodeToString(payloadBytes)
+	h := sha256.Sum256([]byte(signingInput))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	tok := signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	keyCache.mu.Lock()
+	oldKeys, oldExp := keyCache.keys, keyCache.expiry
+	keyCache.keys = map[string]*rsa.PublicKey{kid: &key.PublicKey}
+	keyCache.expiry = time.Now().Add(time.Hour)
+	keyCache.mu.Unlock()
+	defer func() {
+		keyCache.mu.Lock()
+		keyCache.keys, keyCache.expiry = oldKeys, oldExp
+		keyCache.mu.Unlock()
+	}()
+
+	p := GoogleOIDCAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"audience": "aud", "identity_claim": "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + tok}}}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected authentication to succeed")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "svc@example.iam.gserviceaccount.com" {
+		t.Fatalf("unexpected id %q", id)
+	}
+
+	cfg, err = p.ParseParams(map[string]interface{}{"audience": "aud", "identity_template": "{{.hd}}/{{.email}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected missing hd claim to fail authentication")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"audience": "aud", "identity_claim": "email", "identity_template": "{{.sub}}"}); err == nil {
+		t.Fatal("expected mutually exclusive error")
+	}
+}
+
+func TestGoogleOIDCServiceAccountKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	exp := time.Now().Add(time.Hour).Unix()
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		r.ParseForm()
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Fatalf("malformed assertion")
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			t.Errorf("bad assertion signature: %v", err)
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims map[string]interface{}
+		json.Unmarshal(payload, &claims)
+		if claims["target_audience"] != "https://run.example" {
+			t.Errorf("unexpected target_audience %v", claims["target_audience"])
+		}
+		fmt.Fprintf(w, `{"id_token":%q}`, makeToken("https://run.example", "sa", exp, key, "k"))
+	}))
+	defer ts.Close()
+	oldClient := HTTPClient
+	HTTPClient = ts.Client()
+	defer func() { HTTPClient = oldClient }()
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sa@proj.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+		"token_uri":    ts.URL,
+	})
+	t.Setenv("GOOGLE_SA_KEY", string(creds))
+	secrets.ClearCache()
+
+	p := GoogleOIDC{}
+	cfg, err := p.ParseParams(map[string]interface{}{"audience": "https://run.example", "credentials": "env:GOOGLE_SA_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		r := &http.Request{Header: http.Header{}}
+		if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ey") {
+			t.Fatalf("unexpected header %q", r.Header.Get("Authorization"))
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached identity token, got %d calls", calls)
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"audience": "a", "credentials": "bogus:ref"}); err == nil {
+		t.Fatal("expected invalid secret reference error")
+	}
+}
@@ -20,9 +20,13 @@ import (
 
 // inParams configures validation of incoming Google OIDC tokens.
 type inParams struct {
-	Audience string `json:"audience"`
-	Header   string `json:"header"`
-	Prefix   string `json:"prefix"`
+	Audience         string `json:"audience"`
+	Header           string `json:"header"`
+	Prefix           string `json:"prefix"`
+	IdentityClaim    string `json:"identity_claim"`
+	IdentityTemplate string `json:"identity_template"`
+
+	identity *authplugins.IdentityTemplate
 }
 
 // CertsURL is the endpoint returning Google public signing keys. It can be overridden in tests.
@@ -46,7 +50,9 @@ func (g *GoogleOIDCAuth) Name() string { return "google_oidc" }
 
 func (g *GoogleOIDCAuth) RequiredParams() []string { return []string{"audience"} }
 
-func (g *GoogleOIDCAuth) OptionalParams() []string { return []string{"header", "prefix"} }
+func (g *GoogleOIDCAuth) OptionalParams() []string {
+	return []string{"header", "prefix", "identity_claim", "identity_template"}
+}
 
 func (g *GoogleOIDCAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 	p, err := authplugins.ParseParams[inParams](m)
@@ -56,6 +62,11 @@ func (g *GoogleOIDCAuth) ParseParams(m map[string]interface{}) (interface{}, err
 	if p.Audience == "" {
 		return nil, fmt.Errorf("missing audience")
 	}
+	identity, err := authplugins.ParseIdentityParams(p.IdentityClaim, p.IdentityTemplate)
+	if err != nil {
+		return nil, err
+	}
+	p.identity = identity
 	if p.Header == "" {
 		p.Header = "Authorization"
 	}
@@ -196,49 +207,69 @@ func verifyRS256(parts []string, key *rsa.PublicKey) bool {
 }
 
 func parseAndVerify(tok string) (map[string]interface{}, bool) {
+	claims, err := verifyToken(tok)
+	return claims, err == nil
+}
+
+// verifyToken checks the token's RS256 signature against Google's keys.
+func verifyToken(tok string) (map[string]interface{}, error) {
 	header, claims, parts, ok := parseToken(tok)
 	if !ok {
-		return nil, false
+		return nil, authplugins.Fail(authplugins.ReasonMalformedCredential, "malformed ID token")
 	}
 	alg, _ := header["alg"].(string)
 	kid, _ := header["kid"].(string)
 	if alg != "RS256" || kid == "" {
-		return nil, false
+		return nil, authplugins.Fail(authplugins.ReasonMalformedCredential, "unsupported token header")
 	}
 	key, err := getKey(kid)
 	if err != nil {
-		return nil, false
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
 	}
 	if !verifyRS256(parts, key) {
-		return nil, false
+		return nil, authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
 	}
-	return claims, true
+	return claims, nil
 }
 
 func (g *GoogleOIDCAuth) Authenticate(ctx context.Context, r *http.Request, params interface{}) bool {
+	return g.AuthenticateWithReason(ctx, r, params) == nil
+}
+
+// AuthenticateWithReason verifies the Google ID token and explains failures.
+func (g *GoogleOIDCAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, params interface{}) error {
 	cfg, ok := params.(*inParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", params)
 	}
 	header := r.Header.Get(cfg.Header)
+	if header == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
 	if !strings.HasPrefix(header, cfg.Prefix) {
-		return false
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
 	}
 	token := strings.TrimPrefix(header, cfg.Prefix)
-	claims, ok := parseAndVerify(token)
-	if !ok {
-		return false
+	claims, err := verifyToken(token)
+	if err != nil {
+		return err
 	}
 	if aud, ok := claims["aud"]; !ok || !matchAudience(aud, cfg.Audience) {
-		return false
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "audience mismatch")
 	}
 	if exp, ok := claims["exp"].(float64); ok && int64(exp) < time.Now().Unix() {
-		return false
+		return authplugins.Fail(authplugins.ReasonExpired, "token expired")
 	}
-	return true
+	if cfg.identity != nil {
+		if _, ok := cfg.identity.Execute(claims); !ok {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "identity could not be derived")
+		}
+	}
+	return nil
 }
 
-// Identify returns the token's subject claim when present.
+// Identify returns the caller ID built from identity_claim or
+// identity_template, or the token's subject claim when neither is set.
 func (g *GoogleOIDCAuth) Identify(r *http.Request, params interface{}) (string, bool) {
 	cfg, ok := params.(*inParams)
 	if !ok {
@@ -253,6 +284,9 @@ func (g *GoogleOIDCAuth) Identify(r *http.Request, params interface{}) (string,
 	if !ok {
 		return "", false
 	}
+	if cfg.identity != nil {
+		return cfg.identity.Execute(claims)
+	}
 	sub, ok := claims["sub"].(string)
 	if !ok || sub == "" {
 		return "", false
@@ -260,6 +294,17 @@ func (g *GoogleOIDCAuth) Identify(r *http.Request, params interface{}) (string,
 	return sub, true
 }
 
+// BearerToken returns the token from the configured header that
+// AuthenticateWithReason verified.
+func (g *GoogleOIDCAuth) BearerToken(r *http.Request, params interface{}) (string, bool) {
+	cfg, ok := params.(*inParams)
+	if !ok {
+		return "", false
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get(cfg.Header), cfg.Prefix)
+	return tok, ok && tok != ""
+}
+
 // StripAuth removes the Authorization header from the request.
 func (g *GoogleOIDCAuth) StripAuth(r *http.Request, params interface{}) {
 	cfg, ok := params.(*inParams)
@@ -13,17 +13,25 @@ import (
 	"time"
 
 	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/auth/google"
+	"github.com/winhowes/AuthTranslator/app/secrets"
 )
 
 // googleOIDCParams holds configuration for the Google OIDC plugin.
+// Credentials is a secret reference to a service account key or external
+// account JSON file used instead of the metadata server.
+// ImpersonateServiceAccount mints the token for another service account.
 type googleOIDCParams struct {
-	Audience string `json:"audience"`
-	Header   string `json:"header"`
-	Prefix   string `json:"prefix"`
+	Audience                  string `json:"audience"`
+	Header                    string `json:"header"`
+	Prefix                    string `json:"prefix"`
+	Credentials               string `json:"credentials"`
+	ImpersonateServiceAccount string `json:"impersonate_service_account"`
 }
 
-// GoogleOIDC obtains an identity token from the GCP metadata server and sets it
-// on outgoing requests.
+// GoogleOIDC obtains an identity token from the GCP metadata server, a
+// service account key or workload identity federation and sets it on
+// outgoing requests.
 type GoogleOIDC struct{}
 
 // MetadataHost is the base URL for the metadata server. It is overridden in tests.
@@ -42,13 +50,19 @@ var tokenCache = struct {
 	m map[string]cachedToken
 }{m: make(map[string]cachedToken)}
 
+// tokens caches identity tokens minted from credentials or impersonation,
+// keyed by cacheKey.
+var tokens = authplugins.NewTokenCache()
+
 func (g *GoogleOIDC) Name() string { return "google_oidc" }
 
 func (g *GoogleOIDC) RequiredParams() []string {
 	return []string{"audience"}
 }
 
-func (g *GoogleOIDC) OptionalParams() []string { return []string{"header", "prefix"} }
+func (g *GoogleOIDC) OptionalParams() []string {
+	return []string{"header", "prefix", "credentials", "impersonate_service_account"}
+}
 
 func (g *GoogleOIDC) ParseParams(m map[string]interface{}) (interface{}, error) {
 	p, err := authplugins.ParseParams[googleOIDCParams](m)
@@ -64,6 +78,11 @@ func (g *GoogleOIDC) ParseParams(m map[string]interface{}) (interface{}, error)
 	if p.Prefix == "" {
 		p.Prefix = "Bearer "
 	}
+	if p.Credentials != "" {
+		if err := secrets.ValidateSecret(p.Credentials); err != nil {
+			return nil, err
+		}
+	}
 	return p, nil
 }
 
@@ -72,6 +91,14 @@ func (g *GoogleOIDC) AddAuth(ctx context.Context, r *http.Request, params interf
 	if !ok {
 		return fmt.Errorf("invalid config")
 	}
+	if cfg.Credentials != "" || cfg.ImpersonateServiceAccount != "" {
+		tok, err := tokens.Get(ctx, cfg.cacheKey(), cfg.fetch)
+		if err != nil {
+			return err
+		}
+		r.Header.Set(cfg.Header, cfg.Prefix+tok.Value)
+		return nil
+	}
 	tok, exp := getCachedToken(cfg.Audience)
 	if tok == "" || time.Now().After(exp.Add(-1*time.Minute)) {
 		var err error
@@ -85,6 +112,60 @@ func (g *GoogleOIDC) AddAuth(ctx context.Context, r *http.Request, params interf
 	return nil
 }
 
+// Invalidate drops the rejected token so the next request fetches a new one.
+func (g *GoogleOIDC) Invalidate(r *http.Request, params interface{}) {
+	cfg, ok := params.(*googleOIDCParams)
+	if !ok {
+		return
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get(cfg.Header), cfg.Prefix)
+	if !ok {
+		return
+	}
+	if cfg.Credentials == "" && cfg.ImpersonateServiceAccount == "" {
+		tokenCache.Lock()
+		if tokenCache.m[cfg.Audience].token == tok {
+			delete(tokenCache.m, cfg.Audience)
+		}
+		tokenCache.Unlock()
+		return
+	}
+	tokens.Invalidate(cfg.cacheKey(), tok)
+}
+
+func (p *googleOIDCParams) cacheKey() string {
+	return strings.Join([]string{p.Credentials, p.ImpersonateServiceAccount, p.Audience}, "\x00")
+}
+
+// fetch mints an identity token from the configured credentials, or through
+// the IAM Credentials API when impersonating.
+func (p *googleOIDCParams) fetch(ctx context.Context) (authplugins.Token, error) {
+	var creds *google.Credentials
+	if p.Credentials != "" {
+		data, err := secrets.LoadSecret(ctx, p.Credentials)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		if creds, err = google.ParseCredentials(data); err != nil {
+			return authplugins.Token{}, err
+		}
+	}
+	if p.ImpersonateServiceAccount == "" {
+		return creds.IDToken(ctx, HTTPClient, p.Audience)
+	}
+	var base authplugins.Token
+	var err error
+	if creds != nil {
+		base, err = creds.AccessToken(ctx, HTTPClient, []string{google.CloudPlatformScope})
+	} else {
+		base, err = google.MetadataAccessToken(ctx, HTTPClient, MetadataHost, nil)
+	}
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	return google.GenerateIDToken(ctx, HTTPClient, base.Value, p.ImpersonateServiceAccount, p.Audience)
+}
+
 func fetchToken(aud string) (string, time.Time, error) {
 	metaURL := fmt.Sprintf("%s/computeMetadata/v1/instance/service-accounts/default/identity?audience=%s", MetadataHost, url.QueryEscape(aud))
 	req, err := http.NewRequest("GET", metaURL, nil)
@@ -142,4 +223,13 @@ func setCachedToken(aud, tok string, exp time.Time) {
 	tokenCache.Unlock()
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (g *GoogleOIDC) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*googleOIDCParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.Credentials}
+}
+
 func init() { authplugins.RegisterOutgoing(&GoogleOIDC{}) }
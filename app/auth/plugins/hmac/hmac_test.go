@@ -12,6 +12,8 @@ import (
 	"strings"
 	"testing"
 
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
 	"github.com/winhowes/AuthTranslator/app/secrets"
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
@@ -84,7 +86,7 @@ func TestHMACParseParamsInvalidAlgo(t *testing.T) {
 func TestHMACPluginOptionalParams(t *testing.T) {
 	in := HMACSignatureAuth{}
 	out := HMACSignature{}
-	if got := in.OptionalParams(); len(got) != 3 || got[0] != "header" {
+	if got := in.OptionalParams(); len(got) != 6 || got[0] != "header" {
 		t.Fatalf("unexpected optional params: %v", got)
 	}
 	if got := out.OptionalParams(); len(got) != 3 || got[0] != "header" {
@@ -330,3 +332,38 @@ func TestHMACOutgoingParseParamsBadAlgo(t *testing.T) {
 		t.Fatal("expected error for unsupported algo")
 	}
 }
+
+func TestHMACIncomingReplayProtection(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("replay"))
+	sig := hex.EncodeToString(mac.Sum(nil))
+	newReq := func() *http.Request {
+		return &http.Request{Header: http.Header{"X-Signature": []string{sig}}, Body: io.NopCloser(strings.NewReader("replay"))}
+	}
+	p := HMACSignatureAuth{}
+	t.Setenv("SECRET", "key")
+	prev := authplugins.SetReplayStore(nil)
+	defer authplugins.SetReplayStore(prev)
+
+	off, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:SECRET"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Authenticate(context.Background(), newReq(), off) || !p.Authenticate(context.Background(), newReq(), off) {
+		t.Fatal("expected repeated requests to pass without replay protection")
+	}
+
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:SECRET"}, "replay_protection": true, "replay_window": 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AuthenticateWithReason(context.Background(), newReq(), cfg); err != nil {
+		t.Fatalf("expected first request to pass: %v", err)
+	}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newReq(), cfg)); got != authplugins.ReasonReplayed {
+		t.Fatalf("expected replayed, got %s", got)
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:SECRET"}, "replay_window": -1}); err == nil {
+		t.Fatal("expected negative replay_window to fail")
+	}
+}
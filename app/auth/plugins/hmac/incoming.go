@@ -18,6 +18,7 @@ import (
 // inParams configures validation of generic HMAC signatures.
 // Algo may be one of sha1, sha256 or sha512.
 type inParams struct {
+	authplugins.ReplayParams
 	Secrets []string `json:"secrets"`
 	Header  string   `json:"header"`
 	Prefix  string   `json:"prefix"`
@@ -28,7 +29,9 @@ type HMACSignatureAuth struct{}
 
 func (h *HMACSignatureAuth) Name() string             { return "hmac_signature" }
 func (h *HMACSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
-func (h *HMACSignatureAuth) OptionalParams() []string { return []string{"header", "prefix", "algo"} }
+func (h *HMACSignatureAuth) OptionalParams() []string {
+	return append([]string{"header", "prefix", "algo"}, authplugins.ReplayParamNames...)
+}
 
 func hashFunc(algo string) (func() hash.Hash, error) {
 	switch algo {
@@ -48,6 +51,9 @@ func (h *HMACSignatureAuth) ParseParams(m map[string]interface{}) (interface{},
 	if err != nil {
 		return nil, err
 	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
 	if len(p.Secrets) == 0 {
 		return nil, fmt.Errorf("missing secrets")
 	}
@@ -64,35 +70,45 @@ func (h *HMACSignatureAuth) ParseParams(m map[string]interface{}) (interface{},
 }
 
 func (h *HMACSignatureAuth) Authenticate(ctx context.Context, r *http.Request, params interface{}) bool {
+	return h.AuthenticateWithReason(ctx, r, params) == nil
+}
+
+// AuthenticateWithReason verifies the HMAC signature and explains failures.
+func (h *HMACSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, params interface{}) error {
 	cfg, ok := params.(*inParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", params)
 	}
 	newHash, err := hashFunc(cfg.Algo)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
 	}
 	body, err := authplugins.GetBody(r)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
 	}
 	sig := r.Header.Get(cfg.Header)
 	if sig == "" {
-		return false
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
 	}
+	loaded := false
 	for _, ref := range cfg.Secrets {
 		secret, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
+		loaded = true
 		mac := hmac.New(newHash, []byte(secret))
 		mac.Write(body)
 		expected := cfg.Prefix + hex.EncodeToString(mac.Sum(nil))
 		if hmac.Equal([]byte(expected), []byte(sig)) {
-			return true
+			return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(h.Name(), cfg.Secrets), sig, "", 0)
 		}
 	}
-	return false
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
 }
 
 // StripAuth removes the signature header from the request.
@@ -87,4 +87,13 @@ func (h *HMACSignature) AddAuth(ctx context.Context, r *http.Request, params int
 	return nil
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (h *HMACSignature) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return cfg.Secrets
+}
+
 func init() { authplugins.RegisterOutgoing(&HMACSignature{}) }
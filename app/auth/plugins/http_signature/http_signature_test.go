@@ -0,0 +1,384 @@
+package httpsignature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const body = `{"hello": "world"}`
+
+// TestRFC9421HMACVector checks the signature base and HMAC signature against
+// the example in RFC 9421 appendix B.2.5.
+func TestRFC9421HMACVector(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(body))
+	r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	inputs, err := parseDictionary(`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`)
+	if err != nil || len(inputs) != 1 {
+		t.Fatalf("parse: %v", err)
+	}
+	base, err := signatureBase(r, &inputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\"date\": Tue, 20 Apr 2021 02:07:55 GMT\n" +
+		"\"@authority\": example.com\n" +
+		"\"content-type\": application/json\n" +
+		"\"@signature-params\": (\"date\" \"@authority\" \"content-type\");created=1618884473;keyid=\"test-shared-secret\""
+	if base != want {
+		t.Fatalf("signature base mismatch:\n%s", base)
+	}
+	secret, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	k := &signingKey{alg: algHMACSHA256, key: secret}
+	sig, _ := k.sign([]byte(base))
+	if got := base64.StdEncoding.EncodeToString(sig); got != "pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=" {
+		t.Fatalf("unexpected signature %s", got)
+	}
+}
+
+func TestContentDigestVector(t *testing.T) {
+	want := "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+	if got := contentDigest("sha-512", []byte(body)); got != want {
+		t.Fatalf("unexpected digest %s", got)
+	}
+	if err := verifyContentDigest(want, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyContentDigest(want, []byte("tampered")); err == nil {
+		t.Fatal("expected digest mismatch")
+	}
+	if err := verifyContentDigest("md5=:AAAA:", []byte(body)); err == nil {
+		t.Fatal("expected error without a supported digest")
+	}
+}
+
+func pemBlock(t *testing.T, typ string, der []byte) string {
+	t.Helper()
+	return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+}
+
+// keyPair returns PEM private and public keys for alg.
+func keyPair(t *testing.T, alg string) (string, string) {
+	t.Helper()
+	var priv, pub interface{}
+	switch alg {
+	case algHMACSHA256:
+		return "shared-secret", "shared-secret"
+	case algEd25519:
+		pk, sk, _ := ed25519.GenerateKey(rand.Reader)
+		priv, pub = sk, pk
+	case algECDSAP256SHA256, algECDSAP384SHA384:
+		curve := elliptic.P256()
+		if alg == algECDSAP384SHA384 {
+			curve = elliptic.P384()
+		}
+		sk, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, pub = sk, &sk.PublicKey
+	case algRSAPSSSHA512:
+		sk, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, pub = sk, &sk.PublicKey
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pemBlock(t, "PRIVATE KEY", privDER), pemBlock(t, "PUBLIC KEY", pubDER)
+}
+
+func setup(t *testing.T, alg string, in, out map[string]interface{}) (interface{}, interface{}) {
+	t.Helper()
+	priv, pub := keyPair(t, alg)
+	t.Setenv("SIG_PRIV", priv)
+	t.Setenv("SIG_PUB", pub)
+	secrets.ClearCache()
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	out["secrets"] = []interface{}{"env:SIG_PRIV"}
+	out["key_id"] = "client-a"
+	out["alg"] = alg
+	outCfg, err := (&HTTPSignature{}).ParseParams(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in == nil {
+		in = map[string]interface{}{}
+	}
+	in["keys"] = []interface{}{map[string]interface{}{"key_id": "client-a", "alg": alg, "secret": "env:SIG_PUB"}}
+	inCfg, err := (&HTTPSignatureAuth{}).ParseParams(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inCfg, outCfg
+}
+
+func signed(t *testing.T, outCfg interface{}) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "http://api.example.com/v1/items?x=1", strings.NewReader(body))
+	if err := (&HTTPSignature{}).AddAuth(context.Background(), r, outCfg); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestHTTPSignatureRoundTrip(t *testing.T) {
+	for _, alg := range []string{algHMACSHA256, algEd25519, algECDSAP256SHA256, algECDSAP384SHA384, algRSAPSSSHA512} {
+		t.Run(alg, func(t *testing.T) {
+			inCfg, outCfg := setup(t, alg, nil, nil)
+			r := signed(t, outCfg)
+			if r.Header.Get("Content-Digest") == "" {
+				t.Fatal("expected Content-Digest to be set")
+			}
+			p := &HTTPSignatureAuth{}
+			if err := p.AuthenticateWithReason(context.Background(), r, inCfg); err != nil {
+				t.Fatalf("expected signature to verify: %v", err)
+			}
+			if id, ok := p.Identify(r, inCfg); !ok || id != "client-a" {
+				t.Fatalf("unexpected identity %q %v", id, ok)
+			}
+			p.StripAuth(r, inCfg)
+			if r.Header.Get("Signature") != "" || r.Header.Get("Signature-Input") != "" {
+				t.Fatal("expected signature headers to be stripped")
+			}
+		})
+	}
+}
+
+func TestHTTPSignatureFailures(t *testing.T) {
+	inCfg, outCfg := setup(t, algEd25519, nil, nil)
+	p := &HTTPSignatureAuth{}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		mutate func(r *http.Request)
+		reason authplugins.Reason
+	}{
+		{"missing", func(r *http.Request) { r.Header.Del("Signature") }, authplugins.ReasonMissingCredential},
+		{"malformed", func(r *http.Request) { r.Header.Set("Signature", "sig1=nope") }, authplugins.ReasonMalformedCredential},
+		{"method", func(r *http.Request) { r.Method = http.MethodPut }, authplugins.ReasonInvalidCredential},
+		{"path", func(r *http.Request) { r.URL.Path = "/v1/other" }, authplugins.ReasonInvalidCredential},
+		{"body", func(r *http.Request) {
+			_ = authplugins.SetBody(r, []byte(`{"hello": "mallory"}`))
+		}, authplugins.ReasonInvalidCredential},
+		{"unknown key", func(r *http.Request) {
+			r.Header.Set("Signature-Input", strings.Replace(r.Header.Get("Signature-Input"), "client-a", "client-b", 1))
+		}, authplugins.ReasonInvalidCredential},
+		{"stale", func(r *http.Request) {
+			old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+			in := r.Header.Get("Signature-Input")
+			i := strings.Index(in, "created=") + len("created=")
+			r.Header.Set("Signature-Input", in[:i]+old+in[i+len(old):])
+		}, authplugins.ReasonExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := signed(t, outCfg)
+			tt.mutate(r)
+			err := p.AuthenticateWithReason(ctx, r, inCfg)
+			if got := authplugins.FailureReason(err); got != tt.reason {
+				t.Fatalf("expected %s, got %s (%v)", tt.reason, got, err)
+			}
+		})
+	}
+}
+
+func TestHTTPSignatureRequiresComponents(t *testing.T) {
+	inCfg, outCfg := setup(t, algHMACSHA256, map[string]interface{}{"components": []interface{}{"@method", "@path", "x-tenant"}}, nil)
+	r := signed(t, outCfg)
+	err := (&HTTPSignatureAuth{}).AuthenticateWithReason(context.Background(), r, inCfg)
+	if authplugins.FailureReason(err) != authplugins.ReasonNotAllowed {
+		t.Fatalf("expected not_allowed, got %v", err)
+	}
+
+	inCfg, outCfg = setup(t, algHMACSHA256, nil, map[string]interface{}{"components": []interface{}{"@method", "@path"}})
+	r = signed(t, outCfg)
+	err = (&HTTPSignatureAuth{}).AuthenticateWithReason(context.Background(), r, inCfg)
+	if authplugins.FailureReason(err) != authplugins.ReasonNotAllowed {
+		t.Fatalf("expected content-digest to be required, got %v", err)
+	}
+
+	// Bodyless requests need not cover content-digest.
+	r = httptest.NewRequest(http.MethodGet, "http://api.example.com/v1/items", nil)
+	if err := (&HTTPSignature{}).AddAuth(context.Background(), r, outCfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&HTTPSignatureAuth{}).AuthenticateWithReason(context.Background(), r, inCfg); err != nil {
+		t.Fatalf("expected bodyless request to verify: %v", err)
+	}
+}
+
+func TestHTTPSignatureTagAndExpires(t *testing.T) {
+	inCfg, outCfg := setup(t, algHMACSHA256, map[string]interface{}{"tag": "app-a"}, map[string]interface{}{"tag": "app-b", "expires": 60})
+	r := signed(t, outCfg)
+	if !strings.Contains(r.Header.Get("Signature-Input"), ";expires=") {
+		t.Fatalf("expected expires parameter, got %s", r.Header.Get("Signature-Input"))
+	}
+	err := (&HTTPSignatureAuth{}).AuthenticateWithReason(context.Background(), r, inCfg)
+	if authplugins.FailureReason(err) != authplugins.ReasonNotAllowed {
+		t.Fatalf("expected tag mismatch, got %v", err)
+	}
+}
+
+func TestHTTPSignatureReplayProtection(t *testing.T) {
+	prev := authplugins.SetReplayStore(nil)
+	defer authplugins.SetReplayStore(prev)
+	for _, nonce := range []bool{false, true} {
+		inCfg, outCfg := setup(t, algHMACSHA256, map[string]interface{}{"replay_protection": true}, map[string]interface{}{"nonce": nonce})
+		r := signed(t, outCfg)
+		p := &HTTPSignatureAuth{}
+		if err := p.AuthenticateWithReason(context.Background(), r, inCfg); err != nil {
+			t.Fatalf("nonce=%v: first delivery: %v", nonce, err)
+		}
+		err := p.AuthenticateWithReason(context.Background(), r, inCfg)
+		if authplugins.FailureReason(err) != authplugins.ReasonReplayed {
+			t.Fatalf("nonce=%v: expected replay, got %v", nonce, err)
+		}
+	}
+}
+
+func TestHTTPSignatureOutgoingOverwrites(t *testing.T) {
+	_, outCfg := setup(t, algHMACSHA256, nil, map[string]interface{}{"label": "proxy", "digest": "sha-512"})
+	r := httptest.NewRequest(http.MethodPost, "http://api.example.com/", strings.NewReader(body))
+	r.Header.Set("Signature", "sig1=:AAAA:")
+	r.Header.Set("Signature-Input", `sig1=("@method");created=1`)
+	if err := (&HTTPSignature{}).AddAuth(context.Background(), r, outCfg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(r.Header.Get("Signature"), "proxy=:") || !strings.HasPrefix(r.Header.Get("Signature-Input"), `proxy=("@method" "@authority" "@path" "content-digest");created=`) {
+		t.Fatalf("unexpected headers %v", r.Header)
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Digest"), "sha-512=:") {
+		t.Fatalf("unexpected Content-Digest %s", r.Header.Get("Content-Digest"))
+	}
+}
+
+func TestHTTPSignatureParams(t *testing.T) {
+	in := &HTTPSignatureAuth{}
+	out := &HTTPSignature{}
+	if in.Name() != "http_signature" || out.Name() != "http_signature" {
+		t.Fatal("unexpected names")
+	}
+	if !slices.Equal(in.RequiredParams(), []string{"keys"}) {
+		t.Fatalf("unexpected required params %v", in.RequiredParams())
+	}
+	if want := []string{"components", "tolerance", "label", "tag", "replay_protection", "nonce_header", "replay_window"}; !slices.Equal(in.OptionalParams(), want) {
+		t.Fatalf("unexpected optional params %v", in.OptionalParams())
+	}
+	if !slices.Equal(out.RequiredParams(), []string{"secrets", "key_id", "alg"}) {
+		t.Fatalf("unexpected required params %v", out.RequiredParams())
+	}
+	if !slices.Equal(out.OptionalParams(), []string{"components", "label", "tag", "expires", "digest", "nonce"}) {
+		t.Fatalf("unexpected optional params %v", out.OptionalParams())
+	}
+	if !out.SignsRequest() {
+		t.Fatal("expected outgoing plugin to sign last")
+	}
+
+	key := func(k map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"keys": []interface{}{k}}
+	}
+	badIn := []map[string]interface{}{
+		{},
+		key(map[string]interface{}{"key_id": "a", "alg": "hmac-sha256"}),
+		key(map[string]interface{}{"key_id": "a", "alg": "rsa-v1_5-sha256", "secret": "env:X"}),
+		key(map[string]interface{}{"key_id": "a", "alg": "hmac-sha256", "secret": "bogus:X"}),
+		{"keys": []interface{}{
+			map[string]interface{}{"key_id": "a", "alg": "hmac-sha256", "secret": "env:X"},
+			map[string]interface{}{"key_id": "a", "alg": "ed25519", "secret": "env:Y"},
+		}},
+		{"keys": []interface{}{map[string]interface{}{"key_id": "a", "alg": "hmac-sha256", "secret": "env:X"}}, "components": []interface{}{"Date"}},
+		{"keys": []interface{}{map[string]interface{}{"key_id": "a", "alg": "hmac-sha256", "secret": "env:X"}}, "tolerance": -1},
+	}
+	for i, m := range badIn {
+		if _, err := in.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected incoming params error", i)
+		}
+	}
+	base := func(extra map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{"secrets": []interface{}{"env:X"}, "key_id": "a", "alg": "hmac-sha256"}
+		for k, v := range extra {
+			m[k] = v
+		}
+		return m
+	}
+	badOut := []map[string]interface{}{
+		{"key_id": "a", "alg": "hmac-sha256"},
+		{"secrets": []interface{}{"env:X"}, "alg": "hmac-sha256"},
+		base(map[string]interface{}{"alg": "none"}),
+		base(map[string]interface{}{"components": []interface{}{"@method", "@method"}}),
+		base(map[string]interface{}{"label": "Sig"}),
+		base(map[string]interface{}{"digest": "md5"}),
+		base(map[string]interface{}{"expires": -1}),
+		base(map[string]interface{}{"tag": "café"}),
+	}
+	for i, m := range badOut {
+		if _, err := out.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected outgoing params error", i)
+		}
+	}
+}
+
+func TestHTTPSignatureWrongParamsType(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	err := (&HTTPSignatureAuth{}).AuthenticateWithReason(context.Background(), r, struct{}{})
+	if authplugins.FailureReason(err) != authplugins.ReasonInternal {
+		t.Fatalf("expected internal error, got %v", err)
+	}
+	if _, ok := (&HTTPSignatureAuth{}).Identify(r, struct{}{}); ok {
+		t.Fatal("expected no identity")
+	}
+	if err := (&HTTPSignature{}).AddAuth(context.Background(), r, struct{}{}); err == nil {
+		t.Fatal("expected invalid config error")
+	}
+}
+
+func TestParseDictionary(t *testing.T) {
+	m, err := parseDictionary(`a=("@method" "x-y");created=1;keyid="k\"1";flag, b=:AQID:`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || !slices.Equal(m[0].inner, []string{"@method", "x-y"}) || string(m[1].bytes) != "\x01\x02\x03" {
+		t.Fatalf("unexpected members %+v", m)
+	}
+	if id, _ := m[0].stringParam("keyid"); id != `k"1` {
+		t.Fatalf("unexpected keyid %q", id)
+	}
+	if got := m[0].serializeInner(); got != `("@method" "x-y");created=1;keyid="k\"1";flag` {
+		t.Fatalf("unexpected serialization %s", got)
+	}
+	for _, bad := range []string{`A=("x")`, `a`, `a=(x)`, `a=("x";p=1)`, `a=:AQID`, `a=("x"),`, `a="x"`, `a=("x") b=("y")`} {
+		if _, err := parseDictionary(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
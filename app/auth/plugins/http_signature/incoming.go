@@ -0,0 +1,238 @@
+package httpsignature
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// verifyKey maps a keyid to its algorithm and secret. Secret holds the HMAC
+// key for hmac-sha256 and a PEM public key or certificate otherwise.
+type verifyKey struct {
+	KeyID  string `json:"key_id"`
+	Alg    string `json:"alg"`
+	Secret string `json:"secret"`
+	Name   string `json:"name"`
+}
+
+// inParams configures RFC 9421 verification. Components lists the
+// components every accepted signature must cover, Tolerance bounds the age
+// of the created parameter in seconds and Label restricts verification to
+// one Signature-Input label. Tag, when set, must match the tag parameter.
+type inParams struct {
+	authplugins.ReplayParams
+	Keys       []verifyKey `json:"keys"`
+	Components []string    `json:"components"`
+	Tolerance  int64       `json:"tolerance"`
+	Label      string      `json:"label"`
+	Tag        string      `json:"tag"`
+}
+
+func (p *inParams) key(id string) *verifyKey {
+	for i := range p.Keys {
+		if p.Keys[i].KeyID == id {
+			return &p.Keys[i]
+		}
+	}
+	return nil
+}
+
+// defaultInComponents are required when no components are configured.
+var defaultInComponents = []string{"@method", "@path", "content-digest"}
+
+// HTTPSignatureAuth verifies RFC 9421 HTTP Message Signatures.
+type HTTPSignatureAuth struct{}
+
+func (h *HTTPSignatureAuth) Name() string             { return "http_signature" }
+func (h *HTTPSignatureAuth) RequiredParams() []string { return []string{"keys"} }
+func (h *HTTPSignatureAuth) OptionalParams() []string {
+	return append([]string{"components", "tolerance", "label", "tag"}, authplugins.ReplayParamNames...)
+}
+
+func (h *HTTPSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[inParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Keys) == 0 {
+		return nil, fmt.Errorf("missing keys")
+	}
+	seen := make(map[string]bool)
+	for _, k := range p.Keys {
+		if k.KeyID == "" || k.Secret == "" {
+			return nil, fmt.Errorf("keys require key_id and secret")
+		}
+		if !validAlg(k.Alg) {
+			return nil, fmt.Errorf("unsupported alg %q for key %s", k.Alg, k.KeyID)
+		}
+		if seen[k.KeyID] {
+			return nil, fmt.Errorf("duplicate key_id %s", k.KeyID)
+		}
+		seen[k.KeyID] = true
+		if err := secrets.ValidateSecret(k.Secret); err != nil {
+			return nil, err
+		}
+	}
+	if p.Components == nil {
+		p.Components = defaultInComponents
+	}
+	for _, c := range p.Components {
+		if !validComponent(c) {
+			return nil, fmt.Errorf("unsupported component %q", c)
+		}
+	}
+	if p.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = 300
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// selectSignature returns the Signature-Input member to verify: the first
+// one, restricted to Label when set, whose keyid is configured. It also
+// returns the matching signature bytes.
+func selectSignature(r *http.Request, cfg *inParams) (*sfMember, []byte, *verifyKey, error) {
+	inputs, err := parseDictionary(r.Header.Get("Signature-Input"))
+	if err != nil {
+		return nil, nil, nil, authplugins.Fail(authplugins.ReasonMalformedCredential, "malformed Signature-Input: %v", err)
+	}
+	sigs, err := parseDictionary(r.Header.Get("Signature"))
+	if err != nil {
+		return nil, nil, nil, authplugins.Fail(authplugins.ReasonMalformedCredential, "malformed Signature: %v", err)
+	}
+	for i := range inputs {
+		in := &inputs[i]
+		if !in.isInner || (cfg.Label != "" && in.key != cfg.Label) {
+			continue
+		}
+		keyID, _ := in.stringParam("keyid")
+		k := cfg.key(keyID)
+		if k == nil {
+			continue
+		}
+		for _, s := range sigs {
+			if s.key == in.key && s.bytes != nil {
+				return in, s.bytes, k, nil
+			}
+		}
+		return nil, nil, nil, authplugins.Fail(authplugins.ReasonMalformedCredential, "no Signature for label %s", in.key)
+	}
+	return nil, nil, nil, authplugins.Fail(authplugins.ReasonInvalidCredential, "no signature with a configured keyid")
+}
+
+func (h *HTTPSignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return h.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the message signature and explains
+// failures.
+func (h *HTTPSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	if r.Header.Get("Signature-Input") == "" || r.Header.Get("Signature") == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing Signature or Signature-Input header")
+	}
+	in, sig, k, err := selectSignature(r, cfg)
+	if err != nil {
+		return err
+	}
+	if alg, ok := in.stringParam("alg"); ok && alg != k.Alg {
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "alg %s does not match key %s", alg, k.KeyID)
+	}
+	if cfg.Tag != "" {
+		if tag, _ := in.stringParam("tag"); tag != cfg.Tag {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "signature tag does not match")
+		}
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	covered := make(map[string]bool, len(in.inner))
+	for _, c := range in.inner {
+		covered[c] = true
+	}
+	for _, c := range cfg.Components {
+		// Bodyless requests usually carry no Content-Digest to cover.
+		if c == "content-digest" && len(body) == 0 && r.Header.Get("Content-Digest") == "" {
+			continue
+		}
+		if !covered[c] {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "signature does not cover %s", c)
+		}
+	}
+	created, ok := in.intParam("created")
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "signature has no created parameter")
+	}
+	now := time.Now().Unix()
+	if age := now - created; age > cfg.Tolerance || age < -cfg.Tolerance {
+		return authplugins.Fail(authplugins.ReasonExpired, "created outside tolerance")
+	}
+	if expires, ok := in.intParam("expires"); ok && now >= expires {
+		return authplugins.Fail(authplugins.ReasonExpired, "signature expired")
+	}
+	secret, err := secrets.LoadSecret(ctx, k.Secret)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	key, err := parseVerifyKey(k.Alg, secret)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: fmt.Errorf("key %s: %w", k.KeyID, err)}
+	}
+	base, err := signatureBase(r, in)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if !key.verify([]byte(base), sig) {
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+	}
+	if covered["content-digest"] {
+		if err := verifyContentDigest(r.Header.Get("Content-Digest"), body); err != nil {
+			return &authplugins.AuthError{Reason: authplugins.ReasonInvalidCredential, Err: err}
+		}
+	}
+	scope := authplugins.ReplayScope(h.Name(), []string{k.KeyID, k.Secret})
+	if nonce, ok := in.stringParam("nonce"); ok && cfg.ReplayProtection && cfg.NonceHeader == "" {
+		return authplugins.CheckNonce(ctx, scope, nonce, authplugins.ToleranceTTL(created, cfg.Tolerance))
+	}
+	return cfg.CheckReplay(ctx, r, scope, string(sig), strconv.FormatInt(created, 10), authplugins.ToleranceTTL(created, cfg.Tolerance))
+}
+
+// Identify returns the configured name for the verified key, or its keyid
+// when no name is set.
+func (h *HTTPSignatureAuth) Identify(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	_, _, k, err := selectSignature(r, cfg)
+	if err != nil {
+		return "", false
+	}
+	if k.Name != "" {
+		return k.Name, true
+	}
+	return k.KeyID, true
+}
+
+// StripAuth removes the signature headers from the request. Content-Digest
+// is left in place as it describes the body rather than the caller.
+func (h *HTTPSignatureAuth) StripAuth(r *http.Request, p interface{}) {
+	r.Header.Del("Signature")
+	r.Header.Del("Signature-Input")
+}
+
+func init() { authplugins.RegisterIncoming(&HTTPSignatureAuth{}) }
@@ -0,0 +1,316 @@
+package httpsignature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// Supported RFC 9421 algorithm identifiers.
+const (
+	algHMACSHA256      = "hmac-sha256"
+	algEd25519         = "ed25519"
+	algECDSAP256SHA256 = "ecdsa-p256-sha256"
+	algECDSAP384SHA384 = "ecdsa-p384-sha384"
+	algRSAPSSSHA512    = "rsa-pss-sha512"
+)
+
+func validAlg(alg string) bool {
+	switch alg {
+	case algHMACSHA256, algEd25519, algECDSAP256SHA256, algECDSAP384SHA384, algRSAPSSSHA512:
+		return true
+	}
+	return false
+}
+
+// validComponent reports whether name is a supported component identifier:
+// a derived component or a lowercase header field name.
+func validComponent(name string) bool {
+	switch name {
+	case "@method", "@target-uri", "@authority", "@scheme", "@request-target", "@path", "@query":
+		return true
+	}
+	if name == "" || strings.HasPrefix(name, "@") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// componentValue returns the value of a covered component for r.
+func componentValue(r *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@authority":
+		return authority(r), nil
+	case "@scheme":
+		return scheme(r), nil
+	case "@target-uri":
+		return scheme(r) + "://" + authority(r) + r.URL.RequestURI(), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if p := r.URL.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	case "host":
+		return authority(r), nil
+	}
+	vals := r.Header.Values(name)
+	if len(vals) == 0 {
+		return "", fmt.Errorf("covered header %q is missing", name)
+	}
+	for i, v := range vals {
+		vals[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(vals, ", "), nil
+}
+
+func authority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return strings.ToLower(host)
+}
+
+func scheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// signatureBase builds the RFC 9421 signature base for the covered
+// components of sig.
+func signatureBase(r *http.Request, sig *sfMember) (string, error) {
+	var b strings.Builder
+	seen := make(map[string]bool, len(sig.inner))
+	for _, name := range sig.inner {
+		if !validComponent(name) {
+			return "", fmt.Errorf("unsupported component %q", name)
+		}
+		if seen[name] {
+			return "", fmt.Errorf("component %q covered twice", name)
+		}
+		seen[name] = true
+		v, err := componentValue(r, name)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(quote(name))
+		b.WriteString(": ")
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+	b.WriteString(`"@signature-params": `)
+	b.WriteString(sig.serializeInner())
+	return b.String(), nil
+}
+
+// contentDigest returns a Content-Digest header value for body.
+func contentDigest(alg string, body []byte) string {
+	if alg == "sha-512" {
+		sum := sha512.Sum512(body)
+		return "sha-512=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	}
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// verifyContentDigest checks that every supported digest in header matches
+// body and that at least one supported digest is present.
+func verifyContentDigest(header string, body []byte) error {
+	members, err := parseDictionary(header)
+	if err != nil {
+		return fmt.Errorf("malformed Content-Digest: %w", err)
+	}
+	checked := false
+	for _, m := range members {
+		var sum []byte
+		switch m.key {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		if !hmac.Equal(sum, m.bytes) {
+			return fmt.Errorf("%s digest does not match body", m.key)
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("no sha-256 or sha-512 digest in Content-Digest")
+	}
+	return nil
+}
+
+// signingKey holds key material for one algorithm. key is the HMAC secret,
+// or a crypto.PublicKey or crypto.Signer for asymmetric algorithms.
+type signingKey struct {
+	alg string
+	key interface{}
+}
+
+// parseVerifyKey converts secret into verification key material for alg.
+// Asymmetric keys are PEM public keys or certificates.
+func parseVerifyKey(alg, secret string) (*signingKey, error) {
+	if alg == algHMACSHA256 {
+		return &signingKey{alg: alg, key: []byte(secret)}, nil
+	}
+	block, _ := pem.Decode([]byte(secret))
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	default:
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	if !keyMatchesAlg(alg, pub) {
+		return nil, fmt.Errorf("key type %T does not match %s", pub, alg)
+	}
+	return &signingKey{alg: alg, key: pub}, nil
+}
+
+// parseSignKey converts secret into signing key material for alg.
+// Asymmetric keys are PEM private keys in the forms
+// authplugins.ParsePrivateKey accepts.
+func parseSignKey(alg, secret string) (*signingKey, error) {
+	if alg == algHMACSHA256 {
+		return &signingKey{alg: alg, key: []byte(secret)}, nil
+	}
+	signer, err := authplugins.ParsePrivateKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	if !keyMatchesAlg(alg, signer.Public()) {
+		return nil, fmt.Errorf("key type %T does not match %s", signer, alg)
+	}
+	return &signingKey{alg: alg, key: signer}, nil
+}
+
+func keyMatchesAlg(alg string, pub interface{}) bool {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return alg == algEd25519
+	case *ecdsa.PublicKey:
+		return (alg == algECDSAP256SHA256 && k.Curve == elliptic.P256()) ||
+			(alg == algECDSAP384SHA384 && k.Curve == elliptic.P384())
+	case *rsa.PublicKey:
+		return alg == algRSAPSSSHA512
+	}
+	return false
+}
+
+func digest(alg string, msg []byte) (crypto.Hash, []byte) {
+	switch alg {
+	case algECDSAP384SHA384:
+		sum := sha512.Sum384(msg)
+		return crypto.SHA384, sum[:]
+	case algRSAPSSSHA512:
+		sum := sha512.Sum512(msg)
+		return crypto.SHA512, sum[:]
+	default:
+		sum := sha256.Sum256(msg)
+		return crypto.SHA256, sum[:]
+	}
+}
+
+// sign signs base. ECDSA signatures use the fixed-size r||s encoding that
+// RFC 9421 requires.
+func (k *signingKey) sign(base []byte) ([]byte, error) {
+	switch k.alg {
+	case algHMACSHA256:
+		mac := hmac.New(sha256.New, k.key.([]byte))
+		mac.Write(base)
+		return mac.Sum(nil), nil
+	case algEd25519:
+		return k.key.(crypto.Signer).Sign(rand.Reader, base, crypto.Hash(0))
+	case algRSAPSSSHA512:
+		h, sum := digest(k.alg, base)
+		return k.key.(crypto.Signer).Sign(rand.Reader, sum, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h})
+	case algECDSAP256SHA256, algECDSAP384SHA384:
+		_, sum := digest(k.alg, base)
+		priv, ok := k.key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unexpected ECDSA key type %T", k.key)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, sum)
+		if err != nil {
+			return nil, err
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		r.FillBytes(out[:size])
+		s.FillBytes(out[size:])
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %s", k.alg)
+}
+
+// verify reports whether sig is a valid signature of base.
+func (k *signingKey) verify(base, sig []byte) bool {
+	switch k.alg {
+	case algHMACSHA256:
+		mac := hmac.New(sha256.New, k.key.([]byte))
+		mac.Write(base)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case algEd25519:
+		return ed25519.Verify(k.key.(ed25519.PublicKey), base, sig)
+	case algRSAPSSSHA512:
+		h, sum := digest(k.alg, base)
+		return rsa.VerifyPSS(k.key.(*rsa.PublicKey), h, sum, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: h}) == nil
+	case algECDSAP256SHA256, algECDSAP384SHA384:
+		pub := k.key.(*ecdsa.PublicKey)
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		_, sum := digest(k.alg, base)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, sum, r, s)
+	}
+	return false
+}
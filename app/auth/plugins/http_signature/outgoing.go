@@ -0,0 +1,177 @@
+package httpsignature
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// outParams configures RFC 9421 signing of outgoing requests. Secrets hold
+// the HMAC key for hmac-sha256 and a PEM private key otherwise. Expires is
+// the signature lifetime in seconds; zero omits the expires parameter.
+// Digest selects the Content-Digest algorithm used when content-digest is
+// covered.
+type outParams struct {
+	Secrets    []string `json:"secrets"`
+	KeyID      string   `json:"key_id"`
+	Alg        string   `json:"alg"`
+	Components []string `json:"components"`
+	Label      string   `json:"label"`
+	Tag        string   `json:"tag"`
+	Expires    int64    `json:"expires"`
+	Digest     string   `json:"digest"`
+	Nonce      bool     `json:"nonce"`
+}
+
+// defaultOutComponents are signed when no components are configured.
+var defaultOutComponents = []string{"@method", "@authority", "@path", "content-digest"}
+
+// HTTPSignature signs outgoing requests with RFC 9421 HTTP Message
+// Signatures.
+type HTTPSignature struct{}
+
+func (h *HTTPSignature) Name() string { return "http_signature" }
+func (h *HTTPSignature) RequiredParams() []string {
+	return []string{"secrets", "key_id", "alg"}
+}
+func (h *HTTPSignature) OptionalParams() []string {
+	return []string{"components", "label", "tag", "expires", "digest", "nonce"}
+}
+
+// SignsRequest reports that the signature must cover the request as left
+// by every other outgoing plugin.
+func (h *HTTPSignature) SignsRequest() bool { return true }
+
+func (h *HTTPSignature) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[outParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Secrets) == 0 {
+		return nil, fmt.Errorf("missing secrets")
+	}
+	if p.KeyID == "" {
+		return nil, fmt.Errorf("missing key_id")
+	}
+	if !validAlg(p.Alg) {
+		return nil, fmt.Errorf("unsupported alg %q", p.Alg)
+	}
+	if p.Components == nil {
+		p.Components = defaultOutComponents
+	}
+	seen := make(map[string]bool)
+	for _, c := range p.Components {
+		if !validComponent(c) {
+			return nil, fmt.Errorf("unsupported component %q", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("duplicate component %q", c)
+		}
+		seen[c] = true
+	}
+	if p.Label == "" {
+		p.Label = "sig1"
+	}
+	if !isKey(p.Label) {
+		return nil, fmt.Errorf("invalid label %q", p.Label)
+	}
+	if !isString(p.KeyID) || !isString(p.Tag) {
+		return nil, fmt.Errorf("key_id and tag must be printable ASCII")
+	}
+	if p.Expires < 0 {
+		return nil, fmt.Errorf("expires must not be negative")
+	}
+	switch p.Digest {
+	case "":
+		p.Digest = "sha-256"
+	case "sha-256", "sha-512":
+	default:
+		return nil, fmt.Errorf("unsupported digest %q", p.Digest)
+	}
+	return p, nil
+}
+
+// isKey reports whether s is a complete structured field key.
+func isKey(s string) bool {
+	p := &sfParser{s: s}
+	k, err := p.parseKey()
+	return err == nil && k == s
+}
+
+// isString reports whether s can be serialized as a structured field string.
+func isString(s string) bool {
+	_, err := (&sfParser{s: quote(s)}).parseString()
+	return err == nil
+}
+
+func (h *HTTPSignature) AddAuth(ctx context.Context, r *http.Request, params interface{}) error {
+	cfg, ok := params.(*outParams)
+	if !ok || len(cfg.Secrets) == 0 {
+		return fmt.Errorf("invalid config")
+	}
+	if slices.Contains(cfg.Components, "content-digest") {
+		body, err := authplugins.GetBody(r)
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Content-Digest", contentDigest(cfg.Digest, body))
+	}
+	secret, err := secrets.LoadRandomSecret(ctx, cfg.Secrets)
+	if err != nil {
+		return err
+	}
+	key, err := parseSignKey(cfg.Alg, secret)
+	if err != nil {
+		return err
+	}
+	created := time.Now().Unix()
+	in := &sfMember{key: cfg.Label, inner: cfg.Components, isInner: true}
+	in.params = append(in.params, sfParam{key: "created", raw: strconv.FormatInt(created, 10)})
+	if cfg.Expires > 0 {
+		in.params = append(in.params, sfParam{key: "expires", raw: strconv.FormatInt(created+cfg.Expires, 10)})
+	}
+	in.params = append(in.params,
+		sfParam{key: "keyid", raw: quote(cfg.KeyID)},
+		sfParam{key: "alg", raw: quote(cfg.Alg)},
+	)
+	if cfg.Nonce {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		in.params = append(in.params, sfParam{key: "nonce", raw: quote(base64.RawURLEncoding.EncodeToString(b))})
+	}
+	if cfg.Tag != "" {
+		in.params = append(in.params, sfParam{key: "tag", raw: quote(cfg.Tag)})
+	}
+	base, err := signatureBase(r, in)
+	if err != nil {
+		return err
+	}
+	sig, err := key.sign([]byte(base))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Signature-Input", cfg.Label+"="+in.serializeInner())
+	r.Header.Set("Signature", cfg.Label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+// SecretRefs returns the secret references the credentials are loaded from.
+func (h *HTTPSignature) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return cfg.Secrets
+}
+
+func init() { authplugins.RegisterOutgoing(&HTTPSignature{}) }
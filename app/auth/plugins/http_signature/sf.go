@@ -0,0 +1,280 @@
+package httpsignature
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file implements the subset of RFC 8941 structured fields needed for
+// Signature-Input, Signature and Content-Digest: dictionaries whose members
+// are inner lists of strings or byte sequences, with parameters.
+
+// sfParam is a parameter with its value kept in serialized form, which is
+// what the signature base needs.
+type sfParam struct {
+	key string
+	raw string
+}
+
+// sfMember is one dictionary member. Exactly one of inner or bytes is set
+// for the members this package accepts.
+type sfMember struct {
+	key     string
+	inner   []string
+	isInner bool
+	bytes   []byte
+	params  []sfParam
+}
+
+// param returns the serialized value of the named parameter.
+func (m *sfMember) param(key string) (string, bool) {
+	for _, p := range m.params {
+		if p.key == key {
+			return p.raw, true
+		}
+	}
+	return "", false
+}
+
+// stringParam returns the named string parameter without quotes.
+func (m *sfMember) stringParam(key string) (string, bool) {
+	raw, ok := m.param(key)
+	if !ok || len(raw) < 2 || raw[0] != '"' {
+		return "", false
+	}
+	s, err := (&sfParser{s: raw}).parseString()
+	if err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// intParam returns the named integer parameter.
+func (m *sfMember) intParam(key string) (int64, bool) {
+	raw, ok := m.param(key)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	return n, err == nil
+}
+
+// serializeInner renders an inner list member's value as used for the
+// @signature-params component.
+func (m *sfMember) serializeInner() string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, item := range m.inner {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(quote(item))
+	}
+	b.WriteByte(')')
+	for _, p := range m.params {
+		b.WriteByte(';')
+		b.WriteString(p.key)
+		if p.raw != "?1" {
+			b.WriteByte('=')
+			b.WriteString(p.raw)
+		}
+	}
+	return b.String()
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+type sfParser struct {
+	s   string
+	pos int
+}
+
+func (p *sfParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *sfParser) skipSP() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// parseDictionary parses a structured field dictionary.
+func parseDictionary(s string) ([]sfMember, error) {
+	p := &sfParser{s: strings.TrimSpace(s)}
+	var members []sfMember
+	for p.pos < len(p.s) {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		m := sfMember{key: key}
+		if p.peek() == '=' {
+			p.pos++
+			if err := p.parseMemberValue(&m); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("member %q has no value", key)
+		}
+		if m.params, err = p.parseParams(); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+		p.skipOWS()
+		if p.pos >= len(p.s) {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("expected ',' at offset %d", p.pos)
+		}
+		p.pos++
+		p.skipOWS()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("trailing comma")
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) parseMemberValue(m *sfMember) error {
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		m.isInner = true
+		for {
+			p.skipSP()
+			if p.peek() == ')' {
+				p.pos++
+				return nil
+			}
+			if p.peek() != '"' {
+				return fmt.Errorf("inner list items must be strings")
+			}
+			item, err := p.parseString()
+			if err != nil {
+				return err
+			}
+			if c := p.peek(); c == ';' {
+				return fmt.Errorf("component parameters are not supported for %q", item)
+			}
+			m.inner = append(m.inner, item)
+			if c := p.peek(); c != ' ' && c != ')' {
+				return fmt.Errorf("malformed inner list")
+			}
+		}
+	case c == ':':
+		p.pos++
+		end := strings.IndexByte(p.s[p.pos:], ':')
+		if end < 0 {
+			return fmt.Errorf("unterminated byte sequence")
+		}
+		b, err := base64.StdEncoding.DecodeString(p.s[p.pos : p.pos+end])
+		if err != nil {
+			return err
+		}
+		p.pos += end + 1
+		m.bytes = b
+		return nil
+	default:
+		return fmt.Errorf("unsupported member value at offset %d", p.pos)
+	}
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	if c := p.peek(); !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", fmt.Errorf("invalid key at offset %d", p.pos)
+	}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == '*' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *sfParser) parseString() (string, error) {
+	if p.peek() != '"' {
+		return "", fmt.Errorf("expected string")
+	}
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.pos >= len(p.s) || (p.s[p.pos] != '"' && p.s[p.pos] != '\\') {
+				return "", fmt.Errorf("invalid escape")
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", fmt.Errorf("invalid string character")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// parseParams parses ";key=value" parameters, keeping each value in its
+// serialized form. Boolean true parameters are stored as "?1".
+func (p *sfParser) parseParams() ([]sfParam, error) {
+	var params []sfParam
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		raw := "?1"
+		if p.peek() == '=' {
+			p.pos++
+			start := p.pos
+			switch c := p.peek(); {
+			case c == '"':
+				if _, err := p.parseString(); err != nil {
+					return nil, err
+				}
+			case c == '-' || (c >= '0' && c <= '9'):
+				p.pos++
+				for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+					p.pos++
+				}
+			case c == '?':
+				p.pos += 2
+			default:
+				for c := p.peek(); c != 0 && c != ';' && c != ',' && c != ' ' && c != ')'; c = p.peek() {
+					p.pos++
+				}
+			}
+			raw = p.s[start:p.pos]
+			if raw == "" {
+				return nil, fmt.Errorf("empty value for parameter %q", key)
+			}
+		}
+		params = append(params, sfParam{key: key, raw: raw})
+	}
+	return params, nil
+}
@@ -2,16 +2,13 @@ package jwt
 
 import (
 	"context"
-	"crypto"
-	"crypto/hmac"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -21,19 +18,29 @@ import (
 
 // inParams configures JWT validation.
 type inParams struct {
-	Secrets  []string `json:"secrets"`
-	Audience string   `json:"audience"`
-	Issuer   string   `json:"issuer"`
-	Header   string   `json:"header"`
-	Prefix   string   `json:"prefix"`
+	Secrets           []string               `json:"secrets"`
+	JWKSURL           string                 `json:"jwks_url"`
+	OIDCDiscovery     bool                   `json:"oidc_discovery"`
+	Audience          string                 `json:"audience"`
+	Issuer            string                 `json:"issuer"`
+	Header            string                 `json:"header"`
+	Prefix            string                 `json:"prefix"`
+	AllowedAlgorithms []string               `json:"allowed_algorithms"`
+	Leeway            int64                  `json:"leeway"`
+	MaxAge            int64                  `json:"max_age"`
+	RequiredClaims    map[string]interface{} `json:"required_claims"`
+	IdentityClaim     string                 `json:"identity_claim"`
+	IdentityTemplate  string                 `json:"identity_template"`
+
+	identity *authplugins.IdentityTemplate
 }
 
 type JWTAuth struct{}
 
 func (j *JWTAuth) Name() string             { return "jwt" }
-func (j *JWTAuth) RequiredParams() []string { return []string{"secrets"} }
+func (j *JWTAuth) RequiredParams() []string { return nil }
 func (j *JWTAuth) OptionalParams() []string {
-	return []string{"audience", "issuer", "header", "prefix"}
+	return []string{"secrets", "jwks_url", "oidc_discovery", "audience", "issuer", "header", "prefix", "allowed_algorithms", "leeway", "max_age", "required_claims", "identity_claim", "identity_template"}
 }
 
 func (j *JWTAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
@@ -41,9 +48,43 @@ func (j *JWTAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(p.Secrets) == 0 {
-		return nil, fmt.Errorf("missing secrets")
+	if len(p.Secrets) == 0 && p.JWKSURL == "" && !p.OIDCDiscovery {
+		return nil, fmt.Errorf("missing secrets or jwks_url")
+	}
+	if p.JWKSURL != "" {
+		u, err := url.Parse(p.JWKSURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid jwks_url")
+		}
+	}
+	if p.OIDCDiscovery {
+		if p.JWKSURL != "" {
+			return nil, fmt.Errorf("jwks_url and oidc_discovery are mutually exclusive")
+		}
+		u, err := url.Parse(p.Issuer)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("oidc_discovery requires an issuer URL")
+		}
+	}
+	if len(p.AllowedAlgorithms) == 0 {
+		p.AllowedAlgorithms = defaultAlgorithms
+	}
+	for _, alg := range p.AllowedAlgorithms {
+		if !supportedAlgorithms[alg] {
+			return nil, fmt.Errorf("unsupported algorithm %s", alg)
+		}
+	}
+	if p.Leeway < 0 {
+		return nil, fmt.Errorf("leeway must be >= 0")
+	}
+	if p.MaxAge < 0 {
+		return nil, fmt.Errorf("max_age must be >= 0")
+	}
+	identity, err := authplugins.ParseIdentityParams(p.IdentityClaim, p.IdentityTemplate)
+	if err != nil {
+		return nil, err
 	}
+	p.identity = identity
 	if p.Header == "" {
 		p.Header = "Authorization"
 	}
@@ -75,70 +116,6 @@ func parseHeaderPayload(tok string) (header map[string]interface{}, payload map[
 	return header, payload, parts, true
 }
 
-func verifyHS256(parts []string, key []byte) bool {
-	h := hmac.New(sha256.New, key)
-	h.Write([]byte(parts[0] + "." + parts[1]))
-	sig := h.Sum(nil)
-	expected := base64.RawURLEncoding.EncodeToString(sig)
-	return hmac.Equal([]byte(expected), []byte(parts[2]))
-}
-
-func verifyRS256(parts []string, pemData []byte) bool {
-	block, _ := pem.Decode(pemData)
-	if block == nil {
-		return false
-	}
-	var rsaPub *rsa.PublicKey
-	switch block.Type {
-	case "PUBLIC KEY":
-		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
-			return false
-		}
-		var ok bool
-		rsaPub, ok = pub.(*rsa.PublicKey)
-		if !ok {
-			return false
-		}
-	case "RSA PUBLIC KEY":
-		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
-		if err != nil {
-			return false
-		}
-		rsaPub = key
-	default:
-		return false
-	}
-	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
-	if err != nil {
-		return false
-	}
-	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
-	return rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hash[:], sig) == nil
-}
-
-func isRSAPublicPEM(data []byte) bool {
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return false
-	}
-
-	switch block.Type {
-	case "PUBLIC KEY":
-		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
-			return false
-		}
-		_, ok := pub.(*rsa.PublicKey)
-		return ok
-	case "RSA PUBLIC KEY":
-		_, err := x509.ParsePKCS1PublicKey(block.Bytes)
-		return err == nil
-	default:
-		return false
-	}
-}
-
 func matchAudience(claim interface{}, want string) bool {
 	switch v := claim.(type) {
 	case string:
@@ -154,64 +131,152 @@ func matchAudience(claim interface{}, want string) bool {
 }
 
 func (j *JWTAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return j.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the JWT and explains failures.
+func (j *JWTAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*inParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
 	headerVal := r.Header.Get(cfg.Header)
+	if headerVal == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
 	if !strings.HasPrefix(headerVal, cfg.Prefix) {
-		return false
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
 	}
 	token := strings.TrimPrefix(headerVal, cfg.Prefix)
 	header, claims, parts, ok := parseHeaderPayload(token)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "malformed JWT")
 	}
 	alg, _ := header["alg"].(string)
+	if !slices.Contains(cfg.AllowedAlgorithms, alg) {
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "algorithm %q is not allowed", alg)
+	}
 	verified := false
 	for _, ref := range cfg.Secrets {
 		key, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
-		switch alg {
-		case "HS256":
-			if isRSAPublicPEM([]byte(key)) {
-				continue
-			}
-			if verifyHS256(parts, []byte(key)) {
-				verified = true
-			}
-		case "RS256":
-			if verifyRS256(parts, []byte(key)) {
-				verified = true
-			}
-		default:
-			return false
-		}
-		if verified {
+		if verifySignature(alg, parts, secretKey(key)) {
+			verified = true
 			break
 		}
 	}
+	if !verified && (cfg.JWKSURL != "" || cfg.OIDCDiscovery) {
+		verified = verifyJWKS(ctx, cfg, alg, header, parts)
+	}
 	if !verified {
-		return false
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature could not be verified")
+	}
+	if err := validateClaims(cfg, claims, time.Now()); err != nil {
+		return err
 	}
+	if cfg.identity != nil {
+		if _, ok := cfg.identity.Execute(claims); !ok {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "identity_template could not be rendered")
+		}
+	}
+	return nil
+}
+
+// numericClaim returns the value of a NumericDate claim. Claims that are
+// missing or not numbers are reported as absent.
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	f, ok := claims[name].(float64)
+	return int64(f), ok
+}
+
+// validateClaims checks the registered time based claims, issuer, audience
+// and any configured required claims.
+func validateClaims(cfg *inParams, claims map[string]interface{}, now time.Time) error {
 	if aud := cfg.Audience; aud != "" {
 		if claim, ok := claims["aud"]; !ok || !matchAudience(claim, aud) {
-			return false
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "audience mismatch")
 		}
 	}
 	if iss := cfg.Issuer; iss != "" {
 		if claim, ok := claims["iss"].(string); !ok || claim != iss {
-			return false
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "issuer mismatch")
+		}
+	}
+	unix := now.Unix()
+	if exp, ok := numericClaim(claims, "exp"); ok && exp+cfg.Leeway < unix {
+		return authplugins.Fail(authplugins.ReasonExpired, "token expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && nbf > unix+cfg.Leeway {
+		return authplugins.Fail(authplugins.ReasonExpired, "token not yet valid")
+	}
+	iat, hasIat := numericClaim(claims, "iat")
+	if hasIat && iat > unix+cfg.Leeway {
+		return authplugins.Fail(authplugins.ReasonExpired, "token issued in the future")
+	}
+	if cfg.MaxAge > 0 && (!hasIat || unix-iat > cfg.MaxAge+cfg.Leeway) {
+		return authplugins.Fail(authplugins.ReasonExpired, "token older than max_age")
+	}
+	for name, want := range cfg.RequiredClaims {
+		got, ok := claims[name]
+		if !ok || !matchClaim(got, want) {
+			return authplugins.Fail(authplugins.ReasonNotAllowed, "required claim %s mismatch", name)
+		}
+	}
+	return nil
+}
+
+// matchClaim reports whether a claim satisfies an expected value. A nil
+// expectation only requires the claim to be present and array claims match
+// when any element equals the expected value.
+func matchClaim(got, want interface{}) bool {
+	if want == nil {
+		return true
+	}
+	if reflect.DeepEqual(got, want) {
+		return true
+	}
+	if arr, ok := got.([]interface{}); ok {
+		for _, elem := range arr {
+			if reflect.DeepEqual(elem, want) {
+				return true
+			}
 		}
 	}
-	if exp, ok := claims["exp"].(float64); ok {
-		if int64(exp) < time.Now().Unix() {
+	return false
+}
+
+// verifyJWKS checks the token against the keys published at the configured
+// JWKS endpoint, selecting candidates by the token's kid header.
+func verifyJWKS(ctx context.Context, cfg *inParams, alg string, header map[string]interface{}, parts []string) bool {
+	kid, _ := header["kid"].(string)
+	if strings.HasPrefix(alg, "HS") {
+		return false
+	}
+	var set *authplugins.JWKS
+	if cfg.OIDCDiscovery {
+		s, err := authplugins.DiscoverJWKS(ctx, cfg.Issuer)
+		if err != nil {
 			return false
 		}
+		set = s
+	} else {
+		set = authplugins.GetJWKS(cfg.JWKSURL)
+	}
+	keys, err := set.Lookup(ctx, kid)
+	if err != nil {
+		return false
 	}
-	return true
+	for _, k := range keys {
+		if k.Algorithm != "" && k.Algorithm != alg {
+			continue
+		}
+		if verifySignature(alg, parts, k.Key) {
+			return true
+		}
+	}
+	return false
 }
 
 func (j *JWTAuth) Identify(r *http.Request, p interface{}) (string, bool) {
@@ -228,6 +293,9 @@ func (j *JWTAuth) Identify(r *http.Request, p interface{}) (string, bool) {
 	if !ok {
 		return "", false
 	}
+	if cfg.identity != nil {
+		return cfg.identity.Execute(claims)
+	}
 	sub, ok := claims["sub"].(string)
 	if !ok || sub == "" {
 		return "", false
@@ -235,6 +303,17 @@ func (j *JWTAuth) Identify(r *http.Request, p interface{}) (string, bool) {
 	return sub, true
 }
 
+// BearerToken returns the token from the configured header that
+// AuthenticateWithReason verified.
+func (j *JWTAuth) BearerToken(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get(cfg.Header), cfg.Prefix)
+	return tok, ok && tok != ""
+}
+
 // StripAuth removes the JWT header from the request.
 func (j *JWTAuth) StripAuth(r *http.Request, p interface{}) {
 	cfg, ok := p.(*inParams)
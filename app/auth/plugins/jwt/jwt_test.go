@@ -4,21 +4,26 @@ import (
 	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
 	"github.com/winhowes/AuthTranslator/app/secrets"
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
@@ -58,6 +63,27 @@ func TestJWTAuth(t *testing.T) {
 	}
 }
 
+func TestJWTBearerTokenCustomHeader(t *testing.T) {
+	key := "secret"
+	tok := makeHS256Token("aud1", "user1", key, time.Now().Add(time.Hour).Unix())
+	r := &http.Request{Header: http.Header{"X-Token": []string{"JWT " + tok}, "Authorization": []string{"Bearer other"}}}
+	p := JWTAuth{}
+	t.Setenv("KEY", key)
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:KEY"}, "audience": "aud1", "header": "X-Token", "prefix": "JWT "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected authentication to succeed")
+	}
+	if got, ok := p.BearerToken(r, cfg); !ok || got != tok {
+		t.Fatalf("expected the verified token, got %q", got)
+	}
+	if _, ok := p.BearerToken(r, nil); ok {
+		t.Fatal("expected no token for invalid params")
+	}
+}
+
 func TestJWTAuthFail(t *testing.T) {
 	key := "secret"
 	tok := makeHS256Token("aud1", "user1", key, time.Now().Add(-time.Hour).Unix())
@@ -73,6 +99,36 @@ func TestJWTAuthFail(t *testing.T) {
 	}
 }
 
+func TestJWTAuthFailureReasons(t *testing.T) {
+	t.Setenv("KEY", "secret")
+	p := JWTAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:KEY"}, "audience": "aud1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		header string
+		want   authplugins.Reason
+	}{
+		{"", authplugins.ReasonMissingCredential},
+		{"Bearer not-a-jwt", authplugins.ReasonMalformedCredential},
+		{"Bearer " + makeHS256Token("aud1", "user1", "other", future), authplugins.ReasonInvalidCredential},
+		{"Bearer " + makeHS256Token("aud1", "user1", "secret", time.Now().Add(-time.Hour).Unix()), authplugins.ReasonExpired},
+		{"Bearer " + makeHS256Token("aud2", "user1", "secret", future), authplugins.ReasonNotAllowed},
+	}
+	for i, c := range cases {
+		r := &http.Request{Header: http.Header{}}
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		err := p.AuthenticateWithReason(context.Background(), r, cfg)
+		if got := authplugins.FailureReason(err); got != c.want {
+			t.Fatalf("case %d: expected %s, got %s (%v)", i, c.want, got, err)
+		}
+	}
+}
+
 func TestJWTOutgoingAddAuth(t *testing.T) {
 	r := &http.Request{Header: http.Header{}}
 	p := JWTAuthOut{}
@@ -604,3 +660,341 @@ func TestIsRSAPublicPEM(t *testing.T) {
 		t.Fatal("expected non-rsa public key to return false")
 	}
 }
+
+func makeRS256KidToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	header := base64.RawURLEncoding.EncodeToString(hdr)
+	payloadBytes, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(payloadBytes)
+	signingInput := header + "." + payload
+	hash := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newJWKSServer(t *testing.T, kid string, key *rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"jwks_uri":%q}`, ts.URL, ts.URL+"/jwks")
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	oldClient := authplugins.JWKSHTTPClient
+	authplugins.JWKSHTTPClient = ts.Client()
+	t.Cleanup(func() {
+		authplugins.JWKSHTTPClient = oldClient
+		ts.Close()
+	})
+	return ts
+}
+
+func TestJWTAuthJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJWKSServer(t, "kid1", &key.PublicKey)
+	p := JWTAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"jwks_url": ts.URL + "/jwks", "audience": "aud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"aud": "aud", "sub": "svc", "exp": time.Now().Add(time.Hour).Unix()}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeRS256KidToken(t, key, "kid1", claims)}}}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected jwks authentication to succeed")
+	}
+	r = &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeRS256KidToken(t, key, "other", claims)}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected unknown kid to fail")
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	r = &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeRS256KidToken(t, other, "kid1", claims)}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected wrong signing key to fail")
+	}
+}
+
+func TestJWTAuthOIDCDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJWKSServer(t, "kid1", &key.PublicKey)
+	p := JWTAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"oidc_discovery": true, "issuer": ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"iss": ts.URL, "sub": "svc", "exp": time.Now().Add(time.Hour).Unix()}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeRS256KidToken(t, key, "kid1", claims)}}}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected discovered jwks authentication to succeed")
+	}
+}
+
+func TestJWTParseParamsJWKSErrors(t *testing.T) {
+	p := JWTAuth{}
+	cases := []map[string]interface{}{
+		{"jwks_url": "ftp://example.com/keys"},
+		{"jwks_url": "://bad"},
+		{"oidc_discovery": true},
+		{"oidc_discovery": true, "issuer": "not a url"},
+		{"oidc_discovery": true, "issuer": "https://idp.example.com", "jwks_url": "https://idp.example.com/keys"},
+	}
+	for _, c := range cases {
+		if _, err := p.ParseParams(c); err == nil {
+			t.Fatalf("expected error for %v", c)
+		}
+	}
+}
+
+func signToken(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	hdr, _ := json.Marshal(map[string]string{"alg": alg})
+	payloadBytes, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(payloadBytes)
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		var digest []byte
+		if alg == "ES384" {
+			h := sha512.Sum384([]byte(signingInput))
+			digest = h[:]
+		} else {
+			h := sha256.Sum256([]byte(signingInput))
+			digest = h[:]
+		}
+		r, s, e := ecdsa.Sign(rand.Reader, k, digest)
+		if e != nil {
+			t.Fatal(e)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signingInput))
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signingInput))
+		sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, h[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func publicPEM(t *testing.T, pub interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestJWTAuthAsymmetricAlgorithms(t *testing.T) {
+	ec256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cases := []struct {
+		alg  string
+		priv interface{}
+		pub  interface{}
+	}{
+		{"ES256", ec256, &ec256.PublicKey},
+		{"ES384", ec384, &ec384.PublicKey},
+		{"EdDSA", edPriv, edPub},
+		{"PS256", rsaKey, &rsaKey.PublicKey},
+	}
+	claims := map[string]interface{}{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix()}
+	for _, c := range cases {
+		t.Run(c.alg, func(t *testing.T) {
+			secrets.ClearCache()
+			t.Setenv("PUBKEY", publicPEM(t, c.pub))
+			p := JWTAuth{}
+			tok := signToken(t, c.alg, c.priv, claims)
+			r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + tok}}}
+
+			cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:PUBKEY"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Authenticate(context.Background(), r, cfg) {
+				t.Fatalf("expected %s to be rejected by default algorithms", c.alg)
+			}
+
+			cfg, err = p.ParseParams(map[string]interface{}{"secrets": []string{"env:PUBKEY"}, "allowed_algorithms": []string{c.alg}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !p.Authenticate(context.Background(), r, cfg) {
+				t.Fatalf("expected %s authentication to succeed", c.alg)
+			}
+		})
+	}
+}
+
+func TestJWTAuthRejectsAlgorithmConfusion(t *testing.T) {
+	secrets.ClearCache()
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pemKey := publicPEM(t, &ec.PublicKey)
+	t.Setenv("ECPUB", pemKey)
+	claims := map[string]interface{}{"sub": "svc"}
+	p := JWTAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:ECPUB"}, "allowed_algorithms": []string{"HS256", "ES256", "ES384"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := makeHS256ClaimsToken(pemKey, claims)
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + hs}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected HS256 token signed with public key to fail")
+	}
+	es := signToken(t, "ES256", ec, claims)
+	parts := strings.Split(es, ".")
+	hdr := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES384"}`))
+	r = &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + hdr + "." + parts[1] + "." + parts[2]}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected curve mismatch to fail")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:ECPUB"}, "allowed_algorithms": []string{"none"}}); err == nil {
+		t.Fatal("expected unsupported algorithm error")
+	}
+}
+
+func TestJWTAuthTimeClaims(t *testing.T) {
+	t.Setenv("K", "key")
+	p := JWTAuth{}
+	now := time.Now().Unix()
+	cases := []struct {
+		name   string
+		params map[string]interface{}
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{"nbf future", nil, map[string]interface{}{"nbf": now + 60}, false},
+		{"nbf within leeway", map[string]interface{}{"leeway": 120}, map[string]interface{}{"nbf": now + 60}, true},
+		{"iat future", nil, map[string]interface{}{"iat": now + 60}, false},
+		{"exp within leeway", map[string]interface{}{"leeway": 120}, map[string]interface{}{"exp": now - 60}, true},
+		{"max age ok", map[string]interface{}{"max_age": 300}, map[string]interface{}{"iat": now - 60}, true},
+		{"max age exceeded", map[string]interface{}{"max_age": 30}, map[string]interface{}{"iat": now - 60}, false},
+		{"max age missing iat", map[string]interface{}{"max_age": 30}, map[string]interface{}{}, false},
+	}
+	for _, c := range cases {
+		params := map[string]interface{}{"secrets": []string{"env:K"}}
+		for k, v := range c.params {
+			params[k] = v
+		}
+		cfg, err := p.ParseParams(params)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeHS256ClaimsToken("key", c.claims)}}}
+		if got := p.Authenticate(context.Background(), r, cfg); got != c.ok {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.ok, got)
+		}
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:K"}, "leeway": -1}); err == nil {
+		t.Fatal("expected negative leeway error")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:K"}, "max_age": -1}); err == nil {
+		t.Fatal("expected negative max_age error")
+	}
+}
+
+func TestJWTAuthRequiredClaims(t *testing.T) {
+	t.Setenv("K", "key")
+	p := JWTAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"secrets": []string{"env:K"},
+		"required_claims": map[string]interface{}{
+			"repository": "org/repo",
+			"groups":     "admins",
+			"verified":   true,
+			"tenant":     nil,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := map[string]interface{}{"repository": "org/repo", "groups": []string{"dev", "admins"}, "verified": true, "tenant": "t1"}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeHS256ClaimsToken("key", good)}}}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected required claims to match")
+	}
+	for _, missing := range []string{"repository", "groups", "verified", "tenant"} {
+		claims := map[string]interface{}{}
+		for k, v := range good {
+			if k != missing {
+				claims[k] = v
+			}
+		}
+		r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeHS256ClaimsToken("key", claims)}}}
+		if p.Authenticate(context.Background(), r, cfg) {
+			t.Fatalf("expected failure without %s", missing)
+		}
+	}
+	bad := map[string]interface{}{"repository": "org/other", "groups": "admins", "verified": true, "tenant": "t1"}
+	r = &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + makeHS256ClaimsToken("key", bad)}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected mismatched claim to fail")
+	}
+}
+
+func TestJWTIdentityTemplate(t *testing.T) {
+	t.Setenv("K", "key")
+	p := JWTAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:K"}, "identity_template": "{{.repository}}@{{.ref}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := makeHS256ClaimsToken("key", map[string]interface{}{"sub": "x", "repository": "org/repo", "ref": "main"})
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + tok}}}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected authentication to succeed")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "org/repo@main" {
+		t.Fatalf("unexpected id %q", id)
+	}
+
+	tok = makeHS256ClaimsToken("key", map[string]interface{}{"sub": "x", "repository": "org/repo"})
+	r = &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + tok}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected token missing template claims to fail")
+	}
+
+	cfg, err = p.ParseParams(map[string]interface{}{"secrets": []string{"env:K"}, "identity_claim": "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok = makeHS256ClaimsToken("key", map[string]interface{}{"sub": "x", "email": "dev@example.com"})
+	r = &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + tok}}}
+	if id, ok := p.Identify(r, cfg); !ok || id != "dev@example.com" {
+		t.Fatalf("unexpected id %q", id)
+	}
+
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:K"}, "identity_template": "{{.a"}); err == nil {
+		t.Fatal("expected template parse error")
+	}
+}
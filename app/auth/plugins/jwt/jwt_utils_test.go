@@ -13,6 +13,19 @@ import (
 	"testing"
 )
 
+func verifyHS256(parts []string, key []byte) bool {
+	return verifySignature("HS256", parts, key)
+}
+
+func verifyRS256(parts []string, pemData []byte) bool {
+	return verifySignature("RS256", parts, parsePublicPEM(pemData))
+}
+
+func isRSAPublicPEM(data []byte) bool {
+	_, ok := parsePublicPEM(data).(*rsa.PublicKey)
+	return ok
+}
+
 func TestParseHeaderPayloadErrors(t *testing.T) {
 	// not enough parts
 	if _, _, _, ok := parseHeaderPayload("abc"); ok {
@@ -99,11 +112,11 @@ func TestMatchAudience(t *testing.T) {
 
 func TestJWTAuthParamsFuncs(t *testing.T) {
 	j := &JWTAuth{}
-	if len(j.RequiredParams()) != 1 || j.RequiredParams()[0] != "secrets" {
+	if len(j.RequiredParams()) != 0 {
 		t.Fatalf("unexpected required params: %v", j.RequiredParams())
 	}
 	opt := j.OptionalParams()
-	if len(opt) != 4 {
+	if len(opt) != 13 || opt[0] != "secrets" {
 		t.Fatalf("unexpected optional params: %v", opt)
 	}
 }
@@ -52,4 +52,13 @@ func (j *JWTAuthOut) AddAuth(ctx context.Context, r *http.Request, p interface{}
 	return nil
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (j *JWTAuthOut) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return cfg.Secrets
+}
+
 func init() { authplugins.RegisterOutgoing(&JWTAuthOut{}) }
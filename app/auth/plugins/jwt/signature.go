@@ -0,0 +1,151 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"hash"
+	"math/big"
+)
+
+// supportedAlgorithms lists the JWS algorithms understood by the jwt plugin.
+var supportedAlgorithms = map[string]bool{
+	"HS256": true, "HS384": true, "HS512": true,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// defaultAlgorithms is used when allowed_algorithms is not configured and
+// matches the algorithms accepted before the option existed.
+var defaultAlgorithms = []string{"HS256", "RS256"}
+
+func hashFor(alg string) (crypto.Hash, func() hash.Hash) {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384, sha512.New384
+	case "512":
+		return crypto.SHA512, sha512.New
+	default:
+		return crypto.SHA256, sha256.New
+	}
+}
+
+// parsePublicPEM decodes a PEM encoded public key or certificate. It returns
+// nil when data is not a PEM public key.
+func parsePublicPEM(data []byte) crypto.PublicKey {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return pub
+	case "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return pub
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return cert.PublicKey
+	default:
+		return nil
+	}
+}
+
+// secretKey converts a loaded secret into verification key material. PEM
+// encoded public keys are parsed while anything else is treated as an HMAC
+// secret.
+func secretKey(val string) interface{} {
+	if pub := parsePublicPEM([]byte(val)); pub != nil {
+		return pub
+	}
+	return []byte(val)
+}
+
+// verifySignature checks the signature of a split token for alg. HMAC
+// algorithms only accept []byte keys and asymmetric algorithms only accept a
+// public key of the matching type and curve, so a key can never be used with
+// an algorithm from a different family.
+func verifySignature(alg string, parts []string, key interface{}) bool {
+	if len(parts) != 3 || !supportedAlgorithms[alg] {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signingInput, sig)
+	}
+
+	ch, hf := hashFor(alg)
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hf, secret)
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		h := hf()
+		h.Write(signingInput)
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(pub, ch, h.Sum(nil), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(pub, ch, h.Sum(nil), sig) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != curveFor(alg) {
+			return false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		h := hf()
+		h.Write(signingInput)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, h.Sum(nil), r, s)
+	}
+	return false
+}
+
+func curveFor(alg string) elliptic.Curve {
+	switch alg {
+	case "ES384":
+		return elliptic.P384()
+	case "ES512":
+		return elliptic.P521()
+	default:
+		return elliptic.P256()
+	}
+}
@@ -0,0 +1,292 @@
+package k8stokenreview
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	k8s "github.com/winhowes/AuthTranslator/app/secrets/plugins/k8s"
+)
+
+// inParams configures Kubernetes TokenReview authentication.
+type inParams struct {
+	Audiences         []string `json:"audiences"`
+	AllowedNamespaces []string `json:"allowed_namespaces"`
+	Header            string   `json:"header"`
+	Prefix            string   `json:"prefix"`
+	CacheTTL          int64    `json:"cache_ttl"`
+}
+
+// inClusterConfig returns the API server location and credentials. It can be
+// swapped in tests to point at a fake API server.
+var inClusterConfig = k8s.LoadInClusterConfig
+
+// maxCacheEntries bounds the number of cached reviews.
+const maxCacheEntries = 10000
+
+// maxResponseBody bounds how much of a TokenReview response is read.
+const maxResponseBody = 1 << 20
+
+const serviceAccountPrefix = "system:serviceaccount:"
+
+type cachedReview struct {
+	username string
+	exp      time.Time
+}
+
+// reviewCache stores successful reviews keyed by a hash of the token and
+// requested audiences.
+var reviewCache = struct {
+	sync.Mutex
+	m map[string]cachedReview
+}{m: make(map[string]cachedReview)}
+
+// TokenReviewAuth authenticates Kubernetes service account tokens by
+// submitting them to the API server's TokenReview endpoint.
+type TokenReviewAuth struct{}
+
+func (k *TokenReviewAuth) Name() string             { return "k8s_tokenreview" }
+func (k *TokenReviewAuth) RequiredParams() []string { return []string{} }
+func (k *TokenReviewAuth) OptionalParams() []string {
+	return []string{"audiences", "allowed_namespaces", "header", "prefix", "cache_ttl"}
+}
+
+func (k *TokenReviewAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[inParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if p.CacheTTL < 0 {
+		return nil, fmt.Errorf("cache_ttl must be >= 0")
+	}
+	if p.CacheTTL == 0 {
+		p.CacheTTL = 30
+	}
+	if p.Header == "" {
+		p.Header = "Authorization"
+	}
+	if p.Prefix == "" {
+		p.Prefix = "Bearer "
+	}
+	return p, nil
+}
+
+func cacheKey(token string, audiences []string) string {
+	sum := sha256.Sum256([]byte(token + "\x00" + strings.Join(audiences, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func cacheGet(key string) (string, bool) {
+	reviewCache.Lock()
+	defer reviewCache.Unlock()
+	c, ok := reviewCache.m[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(c.exp) {
+		delete(reviewCache.m, key)
+		return "", false
+	}
+	return c.username, true
+}
+
+func cachePut(key, username string, exp time.Time) {
+	reviewCache.Lock()
+	defer reviewCache.Unlock()
+	if len(reviewCache.m) >= maxCacheEntries {
+		now := time.Now()
+		for k, c := range reviewCache.m {
+			if now.After(c.exp) {
+				delete(reviewCache.m, k)
+			}
+		}
+		if len(reviewCache.m) >= maxCacheEntries {
+			reviewCache.m = make(map[string]cachedReview)
+		}
+	}
+	reviewCache.m[key] = cachedReview{username: username, exp: exp}
+}
+
+type tokenReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Spec       tokenReviewSpec    `json:"spec"`
+	Status     *tokenReviewStatus `json:"status,omitempty"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool     `json:"authenticated"`
+	Audiences     []string `json:"audiences"`
+	Error         string   `json:"error"`
+	User          struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// review returns the service account username for token, consulting the
+// cache before calling the API server. Namespace restrictions are applied by
+// the caller so cached reviews can be shared between integrations.
+func review(ctx context.Context, cfg *inParams, token string) (string, error) {
+	key := cacheKey(token, cfg.Audiences)
+	if user, ok := cacheGet(key); ok {
+		return user, nil
+	}
+
+	cc, err := inClusterConfig()
+	if err != nil {
+		authplugins.Logger().Warn("k8s tokenreview: in-cluster config unavailable", "error", err)
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	body, err := json.Marshal(tokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: token, Audiences: cfg.Audiences},
+	})
+	if err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cc.BaseURL+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(body))
+	if err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cc.Token)
+	resp, err := cc.Client.Do(req)
+	if err != nil {
+		authplugins.Logger().Warn("k8s tokenreview request failed", "error", err)
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		authplugins.Logger().Warn("k8s tokenreview returned error", "status", resp.StatusCode)
+		return "", authplugins.Fail(authplugins.ReasonUnavailable, "tokenreview returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	var out tokenReview
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	st := out.Status
+	if st == nil || !st.Authenticated {
+		return "", authplugins.Fail(authplugins.ReasonInvalidCredential, "token not authenticated")
+	}
+	// An audience-aware API server echoes the requested audiences the token
+	// is valid for; an empty list means the audiences were not checked.
+	if len(cfg.Audiences) > 0 && !slices.ContainsFunc(st.Audiences, func(a string) bool {
+		return slices.Contains(cfg.Audiences, a)
+	}) {
+		return "", authplugins.Fail(authplugins.ReasonNotAllowed, "audience mismatch")
+	}
+	if _, _, ok := serviceAccount(st.User.Username); !ok {
+		return "", authplugins.Fail(authplugins.ReasonNotAllowed, "%s is not a service account", st.User.Username)
+	}
+	cachePut(key, st.User.Username, time.Now().Add(time.Duration(cfg.CacheTTL)*time.Second))
+	return st.User.Username, nil
+}
+
+// serviceAccount splits a "system:serviceaccount:<ns>:<name>" username.
+func serviceAccount(username string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(username, serviceAccountPrefix)
+	if !ok {
+		return "", "", false
+	}
+	ns, name, ok := strings.Cut(rest, ":")
+	if !ok || ns == "" || name == "" || strings.Contains(name, ":") {
+		return "", "", false
+	}
+	return ns, name, true
+}
+
+// authorizedUser reviews the request's token and applies allowed_namespaces.
+func authorizedUser(ctx context.Context, r *http.Request, cfg *inParams) (string, error) {
+	token, ok := bearerToken(r, cfg)
+	if !ok {
+		if r.Header.Get(cfg.Header) == "" {
+			return "", authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+		}
+		return "", authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
+	}
+	user, err := review(ctx, cfg, token)
+	if err != nil {
+		return "", err
+	}
+	ns, _, _ := serviceAccount(user)
+	if len(cfg.AllowedNamespaces) > 0 && !slices.Contains(cfg.AllowedNamespaces, ns) {
+		return "", authplugins.Fail(authplugins.ReasonNotAllowed, "namespace %s is not allowed", ns)
+	}
+	return user, nil
+}
+
+func bearerToken(r *http.Request, cfg *inParams) (string, bool) {
+	header := r.Header.Get(cfg.Header)
+	if !strings.HasPrefix(header, cfg.Prefix) {
+		return "", false
+	}
+	tok := strings.TrimPrefix(header, cfg.Prefix)
+	return tok, tok != ""
+}
+
+func (k *TokenReviewAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return k.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason reviews the token and explains failures.
+func (k *TokenReviewAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	_, err := authorizedUser(ctx, r, cfg)
+	return err
+}
+
+// Identify returns the service account as system:serviceaccount:<ns>:<name>.
+func (k *TokenReviewAuth) Identify(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	user, err := authorizedUser(r.Context(), r, cfg)
+	return user, err == nil
+}
+
+// BearerToken returns the token from the configured header that
+// AuthenticateWithReason verified.
+func (k *TokenReviewAuth) BearerToken(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get(cfg.Header), cfg.Prefix)
+	return tok, ok && tok != ""
+}
+
+// StripAuth removes the token header from the request.
+func (k *TokenReviewAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+}
+
+func init() { authplugins.RegisterIncoming(&TokenReviewAuth{}) }
@@ -0,0 +1,200 @@
+package k8stokenreview
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	k8s "github.com/winhowes/AuthTranslator/app/secrets/plugins/k8s"
+)
+
+// fakeAPIServer answers TokenReviews using the users map, keyed by token.
+func fakeAPIServer(t *testing.T, users map[string]string, audiences []string, status int) *int32 {
+	t.Helper()
+	var hits int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.Method != http.MethodPost || r.URL.Path != "/apis/authentication.k8s.io/v1/tokenreviews" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer reviewer" {
+			t.Errorf("unexpected reviewer credentials %q", r.Header.Get("Authorization"))
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		var tr tokenReview
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		st := &tokenReviewStatus{}
+		if user, ok := users[tr.Spec.Token]; ok {
+			st.Authenticated = true
+			st.User.Username = user
+			st.Audiences = audiences
+		}
+		tr.Status = st
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(tr)
+	}))
+	t.Cleanup(ts.Close)
+
+	old := inClusterConfig
+	inClusterConfig = func() (*k8s.InClusterConfig, error) {
+		return &k8s.InClusterConfig{BaseURL: ts.URL, Token: "reviewer", Client: ts.Client()}, nil
+	}
+	t.Cleanup(func() { inClusterConfig = old })
+	reviewCache.Lock()
+	reviewCache.m = make(map[string]cachedReview)
+	reviewCache.Unlock()
+	return &hits
+}
+
+func newRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func parse(t *testing.T, m map[string]interface{}) interface{} {
+	t.Helper()
+	cfg, err := (&TokenReviewAuth{}).ParseParams(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestTokenReviewAuthenticate(t *testing.T) {
+	hits := fakeAPIServer(t, map[string]string{
+		"good":  "system:serviceaccount:payments:api",
+		"human": "alice@example.com",
+	}, nil, 0)
+	p := &TokenReviewAuth{}
+	cfg := parse(t, map[string]interface{}{})
+
+	r := newRequest("good")
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected service account token to authenticate")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "system:serviceaccount:payments:api" {
+		t.Fatalf("unexpected identity %q", id)
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Fatalf("expected cached review, got %d API calls", n)
+	}
+
+	if p.Authenticate(context.Background(), newRequest("bad"), cfg) {
+		t.Fatal("expected unauthenticated token to fail")
+	}
+	if p.Authenticate(context.Background(), newRequest("human"), cfg) {
+		t.Fatal("expected non service account user to fail")
+	}
+	if p.Authenticate(context.Background(), newRequest(""), cfg) {
+		t.Fatal("expected missing token to fail")
+	}
+
+	p.StripAuth(r, cfg)
+	if r.Header.Get("Authorization") != "" {
+		t.Fatal("expected token header to be stripped")
+	}
+}
+
+func TestTokenReviewNamespaces(t *testing.T) {
+	fakeAPIServer(t, map[string]string{"good": "system:serviceaccount:payments:api"}, nil, 0)
+	p := &TokenReviewAuth{}
+	allowed := parse(t, map[string]interface{}{"allowed_namespaces": []interface{}{"payments"}})
+	denied := parse(t, map[string]interface{}{"allowed_namespaces": []interface{}{"billing"}})
+
+	if !p.Authenticate(context.Background(), newRequest("good"), allowed) {
+		t.Fatal("expected allowed namespace to authenticate")
+	}
+	// The cached review must not bypass another integration's namespace list.
+	if p.Authenticate(context.Background(), newRequest("good"), denied) {
+		t.Fatal("expected namespace outside allow list to fail")
+	}
+	if _, ok := p.Identify(newRequest("good"), denied); ok {
+		t.Fatal("expected no identity outside allow list")
+	}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newRequest("good"), denied)); got != authplugins.ReasonNotAllowed {
+		t.Fatalf("expected not_allowed, got %s", got)
+	}
+}
+
+func TestTokenReviewAudiences(t *testing.T) {
+	fakeAPIServer(t, map[string]string{"good": "system:serviceaccount:payments:api"}, []string{"vault"}, 0)
+	p := &TokenReviewAuth{}
+	if !p.Authenticate(context.Background(), newRequest("good"), parse(t, map[string]interface{}{"audiences": []interface{}{"authtranslator", "vault"}})) {
+		t.Fatal("expected matching audience to authenticate")
+	}
+	if p.Authenticate(context.Background(), newRequest("good"), parse(t, map[string]interface{}{"audiences": []interface{}{"authtranslator"}})) {
+		t.Fatal("expected audience mismatch to fail")
+	}
+}
+
+func TestTokenReviewAPIError(t *testing.T) {
+	fakeAPIServer(t, nil, nil, http.StatusForbidden)
+	p := &TokenReviewAuth{}
+	err := p.AuthenticateWithReason(context.Background(), newRequest("good"), parse(t, map[string]interface{}{}))
+	if got := authplugins.FailureReason(err); got != authplugins.ReasonUnavailable {
+		t.Fatalf("expected unavailable, got %s (%v)", got, err)
+	}
+}
+
+func TestTokenReviewCustomHeader(t *testing.T) {
+	fakeAPIServer(t, map[string]string{"good": "system:serviceaccount:ci:runner"}, nil, 0)
+	p := &TokenReviewAuth{}
+	cfg := parse(t, map[string]interface{}{"header": "X-K8s-Token", "prefix": "Token "})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-K8s-Token", "Token good")
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected custom header to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get("X-K8s-Token") != "" {
+		t.Fatal("expected custom header to be stripped")
+	}
+}
+
+func TestTokenReviewParams(t *testing.T) {
+	p := &TokenReviewAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{"cache_ttl": -1}); err == nil {
+		t.Fatal("expected negative cache_ttl to fail")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"unknown": 1}); err == nil {
+		t.Fatal("expected unknown field to fail")
+	}
+	cfg := parse(t, map[string]interface{}{}).(*inParams)
+	if cfg.CacheTTL != 30 || cfg.Header != "Authorization" || cfg.Prefix != "Bearer " {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	if len(p.RequiredParams()) != 0 {
+		t.Fatal("expected no required params")
+	}
+	want := []string{"audiences", "allowed_namespaces", "header", "prefix", "cache_ttl"}
+	if !slices.Equal(p.OptionalParams(), want) {
+		t.Fatalf("unexpected optional params %v", p.OptionalParams())
+	}
+	if p.Authenticate(context.Background(), newRequest("x"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
+
+func TestServiceAccount(t *testing.T) {
+	for _, u := range []string{"system:serviceaccount:ns", "system:serviceaccount::n", "system:serviceaccount:a:b:c", "system:node:x"} {
+		if _, _, ok := serviceAccount(u); ok {
+			t.Fatalf("expected %q to be rejected", u)
+		}
+	}
+	if ns, name, ok := serviceAccount("system:serviceaccount:a:b"); !ok || ns != "a" || name != "b" {
+		t.Fatal("expected valid service account to parse")
+	}
+}
@@ -0,0 +1,130 @@
+package linearsignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// linearSigParams configures Linear webhook signature validation. Tolerance
+// is the maximum age of the payload's webhookTimestamp in seconds.
+type linearSigParams struct {
+	authplugins.ReplayParams
+	Secrets   []string `json:"secrets"`
+	Header    string   `json:"header"`
+	Tolerance int64    `json:"tolerance"`
+}
+
+// LinearSignatureAuth verifies the hex HMAC-SHA256 of the body sent in
+// Linear-Signature. Linear signs no header timestamp, so replays are bounded
+// by the webhookTimestamp field inside the signed JSON payload.
+type LinearSignatureAuth struct{}
+
+func (l *LinearSignatureAuth) Name() string { return "linear_signature" }
+
+func (l *LinearSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
+
+func (l *LinearSignatureAuth) OptionalParams() []string {
+	return append([]string{"header", "tolerance"}, authplugins.ReplayParamNames...)
+}
+
+func (l *LinearSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[linearSigParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if len(p.Secrets) == 0 {
+		return nil, fmt.Errorf("missing secrets")
+	}
+	if p.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	if p.Header == "" {
+		p.Header = "Linear-Signature"
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = 60
+	}
+	return p, nil
+}
+
+func (l *LinearSignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return l.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the Linear signature and explains failures.
+func (l *LinearSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*linearSigParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	sig := r.Header.Get(cfg.Header)
+	if sig == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	loaded := false
+	matched := false
+	for _, ref := range cfg.Secrets {
+		secret, err := secrets.LoadSecret(ctx, ref)
+		if err != nil {
+			continue
+		}
+		loaded = true
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			matched = true
+			break
+		}
+	}
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	if !matched {
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+	}
+	// The timestamp is only trusted once the signature covering it is valid.
+	var payload struct {
+		WebhookTimestamp int64 `json:"webhookTimestamp"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if payload.WebhookTimestamp == 0 {
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "payload has no webhookTimestamp")
+	}
+	tolerance := cfg.Tolerance * 1000
+	if age := time.Now().UnixMilli() - payload.WebhookTimestamp; age > tolerance || age < -tolerance {
+		return authplugins.Fail(authplugins.ReasonExpired, "webhookTimestamp outside tolerance")
+	}
+	ts := strconv.FormatInt(payload.WebhookTimestamp, 10)
+	return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(l.Name(), cfg.Secrets), sig, ts, authplugins.ToleranceTTL(payload.WebhookTimestamp/1000, cfg.Tolerance))
+}
+
+// StripAuth removes the Linear signature header from the request.
+func (l *LinearSignatureAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*linearSigParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+}
+
+func init() { authplugins.RegisterIncoming(&LinearSignatureAuth{}) }
@@ -0,0 +1,91 @@
+package linearsignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func newRequest(secret, body string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Linear-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func payload(ts time.Time) string {
+	return fmt.Sprintf(`{"action":"create","webhookTimestamp":%d}`, ts.UnixMilli())
+}
+
+func TestLinearSignature(t *testing.T) {
+	p := &LinearSignatureAuth{}
+	t.Setenv("LINEAR_OLD", "old")
+	t.Setenv("LINEAR_NEW", "new")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:LINEAR_OLD", "env:LINEAR_NEW"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest("new", payload(time.Now()))
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected rotated secret to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get("Linear-Signature") != "" {
+		t.Fatal("expected header to be stripped")
+	}
+
+	missing := newRequest("old", payload(time.Now()))
+	missing.Header.Del("Linear-Signature")
+	cases := []struct {
+		name string
+		r    *http.Request
+		want authplugins.Reason
+	}{
+		{"missing", missing, authplugins.ReasonMissingCredential},
+		{"wrong secret", newRequest("nope", payload(time.Now())), authplugins.ReasonInvalidCredential},
+		{"stale", newRequest("old", payload(time.Now().Add(-2*time.Minute))), authplugins.ReasonExpired},
+		{"no timestamp", newRequest("old", `{"action":"create"}`), authplugins.ReasonMalformedCredential},
+		{"not json", newRequest("old", `nope`), authplugins.ReasonMalformedCredential},
+	}
+	for _, c := range cases {
+		if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), c.r, cfg)); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestLinearSignatureParams(t *testing.T) {
+	p := &LinearSignatureAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing secrets to fail")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}, "tolerance": -1}); err == nil {
+		t.Fatal("expected negative tolerance to fail")
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*linearSigParams); c.Header != "Linear-Signature" || c.Tolerance != 60 {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+	if !slices.Equal(p.RequiredParams(), []string{"secrets"}) || !slices.Equal(p.OptionalParams(), []string{"header", "tolerance", "replay_protection", "nonce_header", "replay_window"}) {
+		t.Fatal("unexpected params lists")
+	}
+	if p.Authenticate(context.Background(), newRequest("x", "{}"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
@@ -2,54 +2,180 @@ package mtls
 
 import (
 	"context"
+	"crypto/x509"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/winhowes/AuthTranslator/app/auth"
 )
 
-// mtlsParams defines allowed subject names for client certificates.
+// Identity sources selectable via the identity_source parameter.
+const (
+	identityCN       = "cn"
+	identityURI      = "uri"
+	identitySPIFFEID = "spiffe_id"
+)
+
+// mtlsParams defines which client certificates are accepted and how callers
+// are identified. A certificate is accepted when it matches any configured
+// allow list; with no lists configured every verified certificate is accepted.
 type mtlsParams struct {
-	Subjects []string `json:"subjects"`
+	Subjects           []string `json:"subjects"`
+	AllowedSPIFFEIDs   []string `json:"allowed_spiffe_ids"`
+	AllowedURIPrefixes []string `json:"allowed_uri_prefixes"`
+	AllowedDNSSANs     []string `json:"allowed_dns_sans"`
+	AllowedEmailSANs   []string `json:"allowed_email_sans"`
+	IdentitySource     string   `json:"identity_source"`
 }
 
 type MTLSAuth struct{}
 
 func (m *MTLSAuth) Name() string             { return "mtls" }
 func (m *MTLSAuth) RequiredParams() []string { return []string{} }
-func (m *MTLSAuth) OptionalParams() []string { return []string{"subjects"} }
+func (m *MTLSAuth) OptionalParams() []string {
+	return []string{"subjects", "allowed_spiffe_ids", "allowed_uri_prefixes", "allowed_dns_sans", "allowed_email_sans", "identity_source"}
+}
 
 func (m *MTLSAuth) ParseParams(data map[string]interface{}) (interface{}, error) {
-	return authplugins.ParseParams[mtlsParams](data)
+	cfg, err := authplugins.ParseParams[mtlsParams](data)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.IdentitySource {
+	case "":
+		cfg.IdentitySource = identityCN
+	case identityCN, identityURI, identitySPIFFEID:
+	default:
+		return nil, fmt.Errorf("invalid identity_source %q", cfg.IdentitySource)
+	}
+	for _, id := range cfg.AllowedSPIFFEIDs {
+		if !strings.HasPrefix(id, "spiffe://") {
+			return nil, fmt.Errorf("invalid SPIFFE ID %q", id)
+		}
+	}
+	return cfg, nil
 }
 
 func (m *MTLSAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return m.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason checks the verified client certificate and explains failures.
+func (m *MTLSAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*mtlsParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
 	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
-		return false
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "no verified client certificate")
 	}
-	if len(cfg.Subjects) == 0 {
-		return true
+	if !cfg.restricted() && cfg.IdentitySource == identityCN {
+		return nil
 	}
 	if len(r.TLS.PeerCertificates) == 0 {
-		return false
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "no client certificate")
 	}
-	subj := r.TLS.PeerCertificates[0].Subject.CommonName
-	for _, s := range cfg.Subjects {
-		if subj == s {
-			return true
+	cert := r.TLS.PeerCertificates[0]
+	if cfg.IdentitySource != identityCN {
+		if _, ok := identity(cert, cfg.IdentitySource); !ok {
+			return authplugins.Fail(authplugins.ReasonMalformedCredential, "certificate has no %s identity", cfg.IdentitySource)
 		}
 	}
-	return false
+	if cfg.restricted() && !cfg.allowed(cert) {
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "certificate %q is not allowed", cert.Subject.CommonName)
+	}
+	return nil
 }
 
 func (m *MTLSAuth) Identify(r *http.Request, p interface{}) (string, bool) {
 	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
 		return "", false
 	}
-	return r.TLS.PeerCertificates[0].Subject.CommonName, true
+	source := identityCN
+	if cfg, ok := p.(*mtlsParams); ok {
+		source = cfg.IdentitySource
+	}
+	return identity(r.TLS.PeerCertificates[0], source)
+}
+
+func (c *mtlsParams) restricted() bool {
+	return len(c.Subjects) > 0 || len(c.AllowedSPIFFEIDs) > 0 || len(c.AllowedURIPrefixes) > 0 ||
+		len(c.AllowedDNSSANs) > 0 || len(c.AllowedEmailSANs) > 0
+}
+
+func (c *mtlsParams) allowed(cert *x509.Certificate) bool {
+	for _, s := range c.Subjects {
+		if cert.Subject.CommonName == s {
+			return true
+		}
+	}
+	if id, ok := spiffeID(cert); ok {
+		for _, allowed := range c.AllowedSPIFFEIDs {
+			if id == allowed {
+				return true
+			}
+		}
+	}
+	for _, u := range cert.URIs {
+		for _, prefix := range c.AllowedURIPrefixes {
+			if uriHasPrefix(u.String(), prefix) {
+				return true
+			}
+		}
+	}
+	for _, name := range cert.DNSNames {
+		for _, allowed := range c.AllowedDNSSANs {
+			if strings.EqualFold(name, allowed) {
+				return true
+			}
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		for _, allowed := range c.AllowedEmailSANs {
+			if strings.EqualFold(email, allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// uriHasPrefix reports whether uri starts with prefix at a path boundary, so
+// "spiffe://example.org" does not match "spiffe://example.org.evil.com/x".
+func uriHasPrefix(uri, prefix string) bool {
+	rest, ok := strings.CutPrefix(uri, prefix)
+	if !ok {
+		return false
+	}
+	return rest == "" || strings.HasSuffix(prefix, "/") || strings.HasPrefix(rest, "/")
+}
+
+func identity(cert *x509.Certificate, source string) (string, bool) {
+	switch source {
+	case identityURI:
+		if len(cert.URIs) == 0 {
+			return "", false
+		}
+		return cert.URIs[0].String(), true
+	case identitySPIFFEID:
+		return spiffeID(cert)
+	default:
+		return cert.Subject.CommonName, true
+	}
+}
+
+// spiffeID returns the SPIFFE ID of an X.509 SVID. The SPIFFE spec requires
+// exactly one URI SAN, so certificates with several URIs have no SPIFFE ID.
+func spiffeID(cert *x509.Certificate) (string, bool) {
+	if len(cert.URIs) != 1 {
+		return "", false
+	}
+	u := cert.URIs[0]
+	if u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", false
+	}
+	return u.String(), true
 }
 
 func init() { authplugins.RegisterIncoming(&MTLSAuth{}) }
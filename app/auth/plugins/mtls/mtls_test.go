@@ -13,6 +13,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -451,7 +452,7 @@ func TestMTLSParamMethods(t *testing.T) {
 	if rp := in.RequiredParams(); len(rp) != 0 {
 		t.Fatalf("unexpected required params: %v", rp)
 	}
-	if op := in.OptionalParams(); len(op) != 1 || op[0] != "subjects" {
+	if op := in.OptionalParams(); len(op) != 6 || op[0] != "subjects" || op[5] != "identity_source" {
 		t.Fatalf("unexpected optional params: %v", op)
 	}
 	if rp := out.RequiredParams(); len(rp) != 2 || rp[0] != "cert" || rp[1] != "key" {
@@ -500,3 +501,119 @@ func TestMTLSOutgoingParseUnknownField(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func sanRequest(cert *x509.Certificate) *http.Request {
+	return &http.Request{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}, PeerCertificates: []*x509.Certificate{cert}}}
+}
+
+func mustURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestMTLSAuthSANMatching(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "cn"},
+		URIs:           []*url.URL{mustURL(t, "spiffe://example.org/ns/team/sa/caller")},
+		DNSNames:       []string{"svc.example.com"},
+		EmailAddresses: []string{"bot@example.com"},
+	}
+	p := MTLSAuth{}
+	tests := []struct {
+		params map[string]interface{}
+		want   bool
+	}{
+		{map[string]interface{}{"allowed_spiffe_ids": []string{"spiffe://example.org/ns/team/sa/caller"}}, true},
+		{map[string]interface{}{"allowed_spiffe_ids": []string{"spiffe://example.org/ns/team/sa/other"}}, false},
+		{map[string]interface{}{"allowed_uri_prefixes": []string{"spiffe://example.org/ns/team/"}}, true},
+		{map[string]interface{}{"allowed_uri_prefixes": []string{"spiffe://example.org/ns/other/"}}, false},
+		{map[string]interface{}{"allowed_uri_prefixes": []string{"spiffe://example.org"}}, true},
+		{map[string]interface{}{"allowed_uri_prefixes": []string{"spiffe://example.org/ns/te"}}, false},
+		{map[string]interface{}{"allowed_dns_sans": []string{"SVC.example.com"}}, true},
+		{map[string]interface{}{"allowed_dns_sans": []string{"other.example.com"}}, false},
+		{map[string]interface{}{"allowed_email_sans": []string{"bot@example.com"}}, true},
+		{map[string]interface{}{"allowed_email_sans": []string{"human@example.com"}}, false},
+		{map[string]interface{}{"subjects": []string{"nope"}, "allowed_dns_sans": []string{"svc.example.com"}}, true},
+		{map[string]interface{}{"subjects": []string{"nope"}, "allowed_spiffe_ids": []string{"spiffe://example.org/x"}}, false},
+	}
+	for i, tt := range tests {
+		cfg, err := p.ParseParams(tt.params)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if got := p.Authenticate(context.Background(), sanRequest(cert), cfg); got != tt.want {
+			t.Fatalf("case %d: expected %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+func TestMTLSAuthURIPrefixBoundary(t *testing.T) {
+	p := MTLSAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"allowed_uri_prefixes": []string{"spiffe://example.org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uri := range []string{"spiffe://example.org.evil.com/ns/x", "spiffe://example.orgfoo/ns/x"} {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "cn"}, URIs: []*url.URL{mustURL(t, uri)}}
+		if p.Authenticate(context.Background(), sanRequest(cert), cfg) {
+			t.Fatalf("expected %s to be rejected", uri)
+		}
+	}
+}
+
+func TestMTLSIdentitySource(t *testing.T) {
+	spiffe := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "cn"},
+		URIs:    []*url.URL{mustURL(t, "spiffe://example.org/workload")},
+	}
+	multi := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "cn"},
+		URIs:    []*url.URL{mustURL(t, "https://example.org/a"), mustURL(t, "spiffe://example.org/b")},
+	}
+	plain := &x509.Certificate{Subject: pkix.Name{CommonName: "cn"}}
+	p := MTLSAuth{}
+
+	tests := []struct {
+		source string
+		cert   *x509.Certificate
+		id     string
+		ok     bool
+	}{
+		{"", spiffe, "cn", true},
+		{"cn", multi, "cn", true},
+		{"uri", spiffe, "spiffe://example.org/workload", true},
+		{"uri", multi, "https://example.org/a", true},
+		{"uri", plain, "", false},
+		{"spiffe_id", spiffe, "spiffe://example.org/workload", true},
+		{"spiffe_id", multi, "", false},
+		{"spiffe_id", plain, "", false},
+	}
+	for i, tt := range tests {
+		cfg, err := p.ParseParams(map[string]interface{}{"identity_source": tt.source})
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		r := sanRequest(tt.cert)
+		if got := p.Authenticate(context.Background(), r, cfg); got != tt.ok {
+			t.Fatalf("case %d: expected authenticate %v, got %v", i, tt.ok, got)
+		}
+		id, ok := p.Identify(r, cfg)
+		if ok != tt.ok || id != tt.id {
+			t.Fatalf("case %d: expected %q/%v, got %q/%v", i, tt.id, tt.ok, id, ok)
+		}
+	}
+}
+
+func TestMTLSParseParamsSANErrors(t *testing.T) {
+	p := MTLSAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{"identity_source": "email"}); err == nil {
+		t.Fatal("expected error for unknown identity_source")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"allowed_spiffe_ids": []string{"https://example.org"}}); err == nil {
+		t.Fatal("expected error for non-SPIFFE ID")
+	}
+}
@@ -0,0 +1,254 @@
+package oauth2clientcredentials
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func reset() {
+	secrets.ClearCache()
+	tokens = authplugins.NewTokenCache()
+}
+
+// newTokenServer issues tok-1, tok-2, ... and records each form it receives.
+func newTokenServer(t *testing.T, hits *int32, check func(r *http.Request)) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(hits, 1)
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+			return
+		}
+		if check != nil {
+			check(r)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("tok-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	oldClient := HTTPClient
+	HTTPClient = ts.Client()
+	t.Cleanup(func() {
+		HTTPClient = oldClient
+		ts.Close()
+	})
+	return ts
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	reset()
+	t.Setenv("CC_ID", "client")
+	t.Setenv("CC_SECRET", "s3cret")
+	var hits int32
+	ts := newTokenServer(t, &hits, func(r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "client" || pass != "s3cret" {
+			t.Errorf("unexpected basic auth %q %q", user, pass)
+		}
+		if r.PostForm.Get("scope") != "read write" || r.PostForm.Get("audience") != "api" {
+			t.Errorf("unexpected form %v", r.PostForm)
+		}
+	})
+	p := ClientCredentials{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"token_url":     ts.URL,
+		"client_id":     "env:CC_ID",
+		"client_secret": "env:CC_SECRET",
+		"scopes":        []string{"read", "write"},
+		"audience":      "api",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+			if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+				t.Error(err)
+			}
+			if got := r.Header.Get("Authorization"); got != "Bearer tok-1" {
+				t.Errorf("unexpected header %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if hits != 1 {
+		t.Fatalf("expected one token request, got %d", hits)
+	}
+}
+
+func TestClientCredentialsShortLivedToken(t *testing.T) {
+	reset()
+	t.Setenv("CC_ID", "client")
+	t.Setenv("CC_SECRET", "s3cret")
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": fmt.Sprintf("tok-%d", n), "expires_in": 30})
+	}))
+	defer ts.Close()
+	oldClient := HTTPClient
+	HTTPClient = ts.Client()
+	defer func() { HTTPClient = oldClient }()
+
+	p := ClientCredentials{}
+	cfg, err := p.ParseParams(map[string]interface{}{"token_url": ts.URL, "client_id": "env:CC_ID", "client_secret": "env:CC_SECRET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+		if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("expected a 30s token to be reused, got %d token requests", hits)
+	}
+}
+
+func TestClientCredentialsInvalidate(t *testing.T) {
+	reset()
+	t.Setenv("CC_ID", "client")
+	t.Setenv("CC_SECRET", "s3cret")
+	var hits int32
+	ts := newTokenServer(t, &hits, nil)
+	p := ClientCredentials{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"token_url":     ts.URL,
+		"client_id":     "env:CC_ID",
+		"client_secret": "env:CC_SECRET",
+		"auth_method":   "client_secret_post",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	p.Invalidate(r, cfg)
+	p.Invalidate(r, cfg)
+	r2 := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	if err := p.AddAuth(context.Background(), r2, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := r2.Header.Get("Authorization"); got != "Bearer tok-2" {
+		t.Fatalf("expected refreshed token, got %q", got)
+	}
+	p.Invalidate(r, cfg)
+	if err := p.AddAuth(context.Background(), r2, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 {
+		t.Fatalf("expected stale invalidation to keep the new token, got %d requests", hits)
+	}
+}
+
+func TestClientCredentialsPrivateKeyJWT(t *testing.T) {
+	reset()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	t.Setenv("CC_ID", "client")
+	t.Setenv("CC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	var hits int32
+	ts := newTokenServer(t, &hits, func(r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Error("unexpected basic auth")
+		}
+		if r.PostForm.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" || r.PostForm.Get("client_assertion") == "" {
+			t.Errorf("missing client assertion: %v", r.PostForm)
+		}
+	})
+	p := ClientCredentials{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"token_url":   ts.URL,
+		"client_id":   "env:CC_ID",
+		"private_key": "env:CC_KEY",
+		"alg":         "ES256",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Get("Authorization") != "Bearer tok-1" {
+		t.Fatalf("unexpected header %q", r.Header.Get("Authorization"))
+	}
+}
+
+func TestClientCredentialsTokenError(t *testing.T) {
+	reset()
+	t.Setenv("CC_ID", "client")
+	t.Setenv("CC_SECRET", "s3cret")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+	}))
+	defer ts.Close()
+	p := ClientCredentials{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"token_url":     ts.URL,
+		"client_id":     "env:CC_ID",
+		"client_secret": "env:CC_SECRET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err == nil {
+		t.Fatal("expected token endpoint error")
+	}
+}
+
+func TestClientCredentialsParseParams(t *testing.T) {
+	p := ClientCredentials{}
+	cases := []map[string]interface{}{
+		{"client_id": "env:A", "client_secret": "env:B"},
+		{"token_url": "ftp://x", "client_id": "env:A", "client_secret": "env:B"},
+		{"token_url": "https://x/token", "client_secret": "env:B"},
+		{"token_url": "https://x/token", "client_id": "env:A"},
+		{"token_url": "https://x/token", "client_id": "env:A", "client_secret": "env:B", "private_key": "env:C"},
+		{"token_url": "https://x/token", "client_id": "env:A", "private_key": "env:C", "alg": "HS256"},
+		{"token_url": "https://x/token", "client_id": "env:A", "client_secret": "env:B", "auth_method": "magic"},
+		{"token_url": "https://x/token", "client_id": "bogus:A", "client_secret": "env:B"},
+	}
+	for i, m := range cases {
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"token_url": "https://x/token", "client_id": "env:A", "private_key": "env:C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*outParams); c.AuthMethod != authPrivateKey || c.Alg != "RS256" || c.Header != "Authorization" || c.Prefix != "Bearer " {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
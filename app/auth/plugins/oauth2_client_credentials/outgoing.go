@@ -0,0 +1,231 @@
+package oauth2clientcredentials
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// Client authentication methods accepted by auth_method.
+const (
+	authBasic      = "client_secret_basic"
+	authPost       = "client_secret_post"
+	authPrivateKey = "private_key_jwt"
+)
+
+// outParams configures the OAuth2 client credentials grant. ClientID,
+// ClientSecret and PrivateKey are secret references. PrivateKey signs a
+// private_key_jwt client assertion with Alg and KeyID.
+type outParams struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	AuthMethod   string   `json:"auth_method"`
+	PrivateKey   string   `json:"private_key"`
+	KeyID        string   `json:"key_id"`
+	Alg          string   `json:"alg"`
+	Scopes       []string `json:"scopes"`
+	Audience     string   `json:"audience"`
+	Resource     string   `json:"resource"`
+	Header       string   `json:"header"`
+	Prefix       string   `json:"prefix"`
+}
+
+// HTTPClient performs token requests. It can be swapped in tests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// tokens is shared across configuration reloads so a reload does not force
+// every integration to fetch a new token.
+var tokens = authplugins.NewTokenCache()
+
+// ClientCredentials attaches access tokens obtained with the OAuth2 client
+// credentials grant to outgoing requests.
+type ClientCredentials struct{}
+
+func (c *ClientCredentials) Name() string { return "oauth2_client_credentials" }
+
+func (c *ClientCredentials) RequiredParams() []string { return []string{"token_url", "client_id"} }
+
+func (c *ClientCredentials) OptionalParams() []string {
+	return []string{"client_secret", "auth_method", "private_key", "key_id", "alg", "scopes", "audience", "resource", "header", "prefix"}
+}
+
+func (c *ClientCredentials) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[outParams](m)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(p.TokenURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid token_url")
+	}
+	if p.ClientID == "" {
+		return nil, fmt.Errorf("missing client_id")
+	}
+	if p.AuthMethod == "" {
+		p.AuthMethod = authBasic
+		if p.PrivateKey != "" {
+			p.AuthMethod = authPrivateKey
+		}
+	}
+	refs := []string{p.ClientID}
+	switch p.AuthMethod {
+	case authBasic, authPost:
+		if p.ClientSecret == "" {
+			return nil, fmt.Errorf("%s requires client_secret", p.AuthMethod)
+		}
+		if p.PrivateKey != "" {
+			return nil, fmt.Errorf("private_key requires auth_method %s", authPrivateKey)
+		}
+		refs = append(refs, p.ClientSecret)
+	case authPrivateKey:
+		if p.PrivateKey == "" {
+			return nil, fmt.Errorf("%s requires private_key", authPrivateKey)
+		}
+		if p.ClientSecret != "" {
+			return nil, fmt.Errorf("client_secret cannot be used with %s", authPrivateKey)
+		}
+		if p.Alg == "" {
+			p.Alg = "RS256"
+		}
+		if !authplugins.ValidSigningAlg(p.Alg) {
+			return nil, fmt.Errorf("unsupported alg %q", p.Alg)
+		}
+		refs = append(refs, p.PrivateKey)
+	default:
+		return nil, fmt.Errorf("unsupported auth_method %q", p.AuthMethod)
+	}
+	for _, ref := range refs {
+		if err := secrets.ValidateSecret(ref); err != nil {
+			return nil, err
+		}
+	}
+	if p.Header == "" {
+		p.Header = "Authorization"
+	}
+	if p.Prefix == "" {
+		p.Prefix = "Bearer "
+	}
+	return p, nil
+}
+
+// cacheKey identifies the token a configuration would be issued.
+func (p *outParams) cacheKey() string {
+	return strings.Join([]string{p.TokenURL, p.ClientID, p.AuthMethod, strings.Join(p.Scopes, " "), p.Audience, p.Resource}, "\x00")
+}
+
+func (c *ClientCredentials) AddAuth(ctx context.Context, r *http.Request, params interface{}) error {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return fmt.Errorf("invalid config")
+	}
+	tok, err := tokens.Get(ctx, cfg.cacheKey(), func(ctx context.Context) (authplugins.Token, error) {
+		return fetchToken(ctx, cfg)
+	})
+	if err != nil {
+		return err
+	}
+	r.Header.Set(cfg.Header, cfg.Prefix+tok.Value)
+	return nil
+}
+
+// Invalidate drops the cached token carried by a request the upstream
+// rejected so the next request fetches a new one.
+func (c *ClientCredentials) Invalidate(r *http.Request, params interface{}) {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return
+	}
+	if v := r.Header.Get(cfg.Header); strings.HasPrefix(v, cfg.Prefix) {
+		tokens.Invalidate(cfg.cacheKey(), strings.TrimPrefix(v, cfg.Prefix))
+	}
+}
+
+func fetchToken(ctx context.Context, cfg *outParams) (authplugins.Token, error) {
+	clientID, err := secrets.LoadSecret(ctx, cfg.ClientID)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	if cfg.Audience != "" {
+		form.Set("audience", cfg.Audience)
+	}
+	if cfg.Resource != "" {
+		form.Set("resource", cfg.Resource)
+	}
+	var user, pass string
+	switch cfg.AuthMethod {
+	case authBasic, authPost:
+		secret, err := secrets.LoadSecret(ctx, cfg.ClientSecret)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		if cfg.AuthMethod == authBasic {
+			user, pass = clientID, secret
+		} else {
+			form.Set("client_id", clientID)
+			form.Set("client_secret", secret)
+		}
+	case authPrivateKey:
+		assertion, err := clientAssertion(ctx, cfg, clientID)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		form.Set("client_id", clientID)
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", assertion)
+	}
+
+	tr, err := authplugins.RequestToken(ctx, HTTPClient, cfg.TokenURL, form, user, pass)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	return tr.Token(time.Now()), nil
+}
+
+// clientAssertion builds the RFC 7523 client authentication JWT.
+func clientAssertion(ctx context.Context, cfg *outParams, clientID string) (string, error) {
+	pemKey, err := secrets.LoadSecret(ctx, cfg.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := authplugins.ParsePrivateKey(pemKey)
+	if err != nil {
+		return "", err
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	return authplugins.SignJWT(cfg.Alg, key, cfg.KeyID, map[string]interface{}{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": cfg.TokenURL,
+		"jti": hex.EncodeToString(jti),
+		"iat": now,
+		"exp": now + 300,
+	})
+}
+
+// SecretRefs returns the secret references the credentials are loaded from.
+func (c *ClientCredentials) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.ClientID, cfg.ClientSecret, cfg.PrivateKey}
+}
+
+func init() { authplugins.RegisterOutgoing(&ClientCredentials{}) }
@@ -0,0 +1,319 @@
+package oauth2introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// inParams configures RFC 7662 token introspection.
+type inParams struct {
+	Endpoint         string   `json:"endpoint"`
+	ClientID         string   `json:"client_id"`
+	ClientSecret     string   `json:"client_secret"`
+	Scopes           []string `json:"scopes"`
+	Audience         string   `json:"audience"`
+	Header           string   `json:"header"`
+	Prefix           string   `json:"prefix"`
+	CacheTTL         int64    `json:"cache_ttl"`
+	IdentityClaim    string   `json:"identity_claim"`
+	IdentityTemplate string   `json:"identity_template"`
+
+	identity *authplugins.IdentityTemplate
+}
+
+// HTTPClient performs introspection requests. It can be swapped in tests.
+var HTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// maxCacheEntries bounds the number of cached introspection results.
+const maxCacheEntries = 10000
+
+// maxResponseBody bounds how much of an introspection response is read.
+const maxResponseBody = 1 << 20
+
+type cachedResult struct {
+	claims map[string]interface{}
+	exp    time.Time
+}
+
+// resultCache stores active introspection results keyed by a hash of the
+// endpoint and token so raw tokens are never held in memory longer than the
+// request.
+var resultCache = struct {
+	sync.Mutex
+	m map[string]cachedResult
+}{m: make(map[string]cachedResult)}
+
+// IntrospectionAuth validates opaque access tokens with an OAuth2 token
+// introspection endpoint.
+type IntrospectionAuth struct{}
+
+func (o *IntrospectionAuth) Name() string { return "oauth2_introspection" }
+
+func (o *IntrospectionAuth) RequiredParams() []string {
+	return []string{"endpoint", "client_id", "client_secret"}
+}
+
+func (o *IntrospectionAuth) OptionalParams() []string {
+	return []string{"scopes", "audience", "header", "prefix", "cache_ttl", "identity_claim", "identity_template"}
+}
+
+func (o *IntrospectionAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[inParams](m)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint")
+	}
+	if p.ClientID == "" || p.ClientSecret == "" {
+		return nil, fmt.Errorf("missing client_id or client_secret")
+	}
+	for _, ref := range []string{p.ClientID, p.ClientSecret} {
+		if err := secrets.ValidateSecret(ref); err != nil {
+			return nil, err
+		}
+	}
+	if p.CacheTTL < 0 {
+		return nil, fmt.Errorf("cache_ttl must be >= 0")
+	}
+	if p.CacheTTL == 0 {
+		p.CacheTTL = 300
+	}
+	identity, err := authplugins.ParseIdentityParams(p.IdentityClaim, p.IdentityTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil {
+		identity, _ = authplugins.IdentityTemplateForClaim("sub")
+	}
+	p.identity = identity
+	if p.Header == "" {
+		p.Header = "Authorization"
+	}
+	if p.Prefix == "" {
+		p.Prefix = "Bearer "
+	}
+	return p, nil
+}
+
+func cacheKey(endpoint, token string) string {
+	sum := sha256.Sum256([]byte(endpoint + "\x00" + token))
+	return hex.EncodeToString(sum[:])
+}
+
+func cacheGet(key string) (map[string]interface{}, bool) {
+	resultCache.Lock()
+	defer resultCache.Unlock()
+	c, ok := resultCache.m[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(c.exp) {
+		delete(resultCache.m, key)
+		return nil, false
+	}
+	return c.claims, true
+}
+
+func cachePut(key string, claims map[string]interface{}, exp time.Time) {
+	resultCache.Lock()
+	defer resultCache.Unlock()
+	if len(resultCache.m) >= maxCacheEntries {
+		now := time.Now()
+		for k, c := range resultCache.m {
+			if now.After(c.exp) {
+				delete(resultCache.m, k)
+			}
+		}
+		if len(resultCache.m) >= maxCacheEntries {
+			resultCache.m = make(map[string]cachedResult)
+		}
+	}
+	resultCache.m[key] = cachedResult{claims: claims, exp: exp}
+}
+
+func bearerToken(r *http.Request, cfg *inParams) (string, bool) {
+	header := r.Header.Get(cfg.Header)
+	if !strings.HasPrefix(header, cfg.Prefix) {
+		return "", false
+	}
+	tok := strings.TrimPrefix(header, cfg.Prefix)
+	return tok, tok != ""
+}
+
+// introspect returns the claims of an active token, consulting the cache
+// before calling the introspection endpoint.
+func introspect(ctx context.Context, cfg *inParams, token string) (map[string]interface{}, error) {
+	key := cacheKey(cfg.Endpoint, token)
+	if claims, ok := cacheGet(key); ok {
+		return claims, nil
+	}
+
+	clientID, err := secrets.LoadSecret(ctx, cfg.ClientID)
+	if err != nil {
+		authplugins.Logger().Warn("oauth2 introspection client_id load failed", "error", err)
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	clientSecret, err := secrets.LoadSecret(ctx, cfg.ClientSecret)
+	if err != nil {
+		authplugins.Logger().Warn("oauth2 introspection client_secret load failed", "error", err)
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		authplugins.Logger().Warn("oauth2 introspection request failed", "error", err)
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		authplugins.Logger().Warn("oauth2 introspection returned error", "status", resp.StatusCode)
+		return nil, authplugins.Fail(authplugins.ReasonUnavailable, "introspection returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, authplugins.Fail(authplugins.ReasonInvalidCredential, "token is not active")
+	}
+
+	now := time.Now()
+	until := now.Add(time.Duration(cfg.CacheTTL) * time.Second)
+	if exp, ok := claims["exp"].(float64); ok {
+		expAt := time.Unix(int64(exp), 0)
+		if !expAt.After(now) {
+			return nil, authplugins.Fail(authplugins.ReasonExpired, "token expired")
+		}
+		if expAt.Before(until) {
+			until = expAt
+		}
+	}
+	cachePut(key, claims, until)
+	return claims, nil
+}
+
+func hasScopes(claim interface{}, want []string) bool {
+	s, _ := claim.(string)
+	granted := make(map[string]struct{})
+	for _, sc := range strings.Fields(s) {
+		granted[sc] = struct{}{}
+	}
+	for _, w := range want {
+		if _, ok := granted[w]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAudience(claim interface{}, want string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, elem := range v {
+			if s, ok := elem.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o *IntrospectionAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return o.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason introspects the bearer token and explains failures.
+func (o *IntrospectionAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	token, ok := bearerToken(r, cfg)
+	if !ok {
+		if r.Header.Get(cfg.Header) == "" {
+			return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+		}
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
+	}
+	claims, err := introspect(ctx, cfg, token)
+	if err != nil {
+		return err
+	}
+	if exp, ok := claims["exp"].(float64); ok && int64(exp) < time.Now().Unix() {
+		return authplugins.Fail(authplugins.ReasonExpired, "token expired")
+	}
+	if len(cfg.Scopes) > 0 && !hasScopes(claims["scope"], cfg.Scopes) {
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "token lacks required scopes")
+	}
+	if cfg.Audience != "" && !matchAudience(claims["aud"], cfg.Audience) {
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "audience mismatch")
+	}
+	return nil
+}
+
+// Identify returns the caller ID built from the introspection response, using
+// the sub field unless identity_claim or identity_template is configured.
+func (o *IntrospectionAuth) Identify(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	token, ok := bearerToken(r, cfg)
+	if !ok {
+		return "", false
+	}
+	claims, err := introspect(r.Context(), cfg, token)
+	if err != nil {
+		return "", false
+	}
+	return cfg.identity.Execute(claims)
+}
+
+// BearerToken returns the token from the configured header that
+// AuthenticateWithReason verified.
+func (o *IntrospectionAuth) BearerToken(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return "", false
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get(cfg.Header), cfg.Prefix)
+	return tok, ok && tok != ""
+}
+
+// StripAuth removes the token header from the request.
+func (o *IntrospectionAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+}
+
+func init() { authplugins.RegisterIncoming(&IntrospectionAuth{}) }
@@ -0,0 +1,196 @@
+package oauth2introspection
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func resetCache() {
+	secrets.ClearCache()
+	resultCache.Lock()
+	resultCache.m = make(map[string]cachedResult)
+	resultCache.Unlock()
+}
+
+func newIntrospectionServer(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "client" || pass != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		resp := map[string]interface{}{"active": false}
+		switch r.PostForm.Get("token") {
+		case "good":
+			resp = map[string]interface{}{
+				"active":   true,
+				"sub":      "user-1",
+				"username": "alice",
+				"scope":    "read write",
+				"aud":      []string{"api", "other"},
+				"exp":      time.Now().Add(time.Hour).Unix(),
+			}
+		case "expired":
+			resp = map[string]interface{}{"active": true, "sub": "user-2", "exp": time.Now().Add(-time.Minute).Unix()}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	oldClient := HTTPClient
+	HTTPClient = ts.Client()
+	t.Cleanup(func() {
+		HTTPClient = oldClient
+		ts.Close()
+	})
+	return ts
+}
+
+func TestIntrospectionAuth(t *testing.T) {
+	resetCache()
+	var hits int32
+	ts := newIntrospectionServer(t, &hits)
+	t.Setenv("CID", "client")
+	t.Setenv("CSEC", "s3cret")
+	p := IntrospectionAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"endpoint":      ts.URL,
+		"client_id":     "env:CID",
+		"client_secret": "env:CSEC",
+		"scopes":        []string{"read"},
+		"audience":      "api",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer good"}}}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected authentication to succeed")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "user-1" {
+		t.Fatalf("unexpected id %q", id)
+	}
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected cached authentication to succeed")
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("expected one introspection call, got %d", n)
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get("Authorization") != "" {
+		t.Fatal("expected header stripped")
+	}
+
+	for _, tok := range []string{"bad", "expired"} {
+		r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer " + tok}}}
+		if p.Authenticate(context.Background(), r, cfg) {
+			t.Fatalf("expected %s token to fail", tok)
+		}
+	}
+	r = &http.Request{Header: http.Header{}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected missing header to fail")
+	}
+}
+
+func TestIntrospectionScopeAudienceAndIdentity(t *testing.T) {
+	resetCache()
+	var hits int32
+	ts := newIntrospectionServer(t, &hits)
+	t.Setenv("CID", "client")
+	t.Setenv("CSEC", "s3cret")
+	p := IntrospectionAuth{}
+	base := map[string]interface{}{"endpoint": ts.URL, "client_id": "env:CID", "client_secret": "env:CSEC"}
+	with := func(k string, v interface{}) map[string]interface{} {
+		m := map[string]interface{}{}
+		for bk, bv := range base {
+			m[bk] = bv
+		}
+		m[k] = v
+		return m
+	}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer good"}}}
+
+	cfg, _ := p.ParseParams(with("scopes", []string{"read", "admin"}))
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected missing scope to fail")
+	}
+	cfg, _ = p.ParseParams(with("audience", "nope"))
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected audience mismatch to fail")
+	}
+	cfg, _ = p.ParseParams(with("identity_claim", "username"))
+	if id, ok := p.Identify(r, cfg); !ok || id != "alice" {
+		t.Fatalf("unexpected id %q", id)
+	}
+}
+
+func TestIntrospectionBadClientCredentials(t *testing.T) {
+	resetCache()
+	var hits int32
+	ts := newIntrospectionServer(t, &hits)
+	t.Setenv("CID", "client")
+	t.Setenv("CSEC", "wrong")
+	p := IntrospectionAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"endpoint": ts.URL, "client_id": "env:CID", "client_secret": "env:CSEC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{Header: http.Header{"Authorization": []string{"Bearer good"}}}
+	if p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected endpoint error to fail authentication")
+	}
+}
+
+func TestIntrospectionParseParamsErrors(t *testing.T) {
+	p := IntrospectionAuth{}
+	cases := []map[string]interface{}{
+		{"client_id": "env:A", "client_secret": "env:B"},
+		{"endpoint": "ftp://x", "client_id": "env:A", "client_secret": "env:B"},
+		{"endpoint": "https://idp.example.com/introspect", "client_secret": "env:B"},
+		{"endpoint": "https://idp.example.com/introspect", "client_id": "bogus:A", "client_secret": "env:B"},
+		{"endpoint": "https://idp.example.com/introspect", "client_id": "env:A", "client_secret": "env:B", "cache_ttl": -1},
+		{"endpoint": "https://idp.example.com/introspect", "client_id": "env:A", "client_secret": "env:B", "identity_template": "{{"},
+	}
+	for _, c := range cases {
+		if _, err := p.ParseParams(c); err == nil {
+			t.Fatalf("expected error for %v", c)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"endpoint": "https://idp.example.com/introspect", "client_id": "env:A", "client_secret": "env:B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := cfg.(*inParams)
+	if in.Header != "Authorization" || in.Prefix != "Bearer " || in.CacheTTL != 300 {
+		t.Fatalf("unexpected defaults %+v", in)
+	}
+}
+
+func TestIntrospectionCacheEviction(t *testing.T) {
+	resetCache()
+	for i := 0; i < maxCacheEntries; i++ {
+		cachePut(cacheKey("e", string(rune(i))), nil, time.Now().Add(-time.Second))
+	}
+	cachePut("fresh", map[string]interface{}{"sub": "x"}, time.Now().Add(time.Minute))
+	resultCache.Lock()
+	n := len(resultCache.m)
+	resultCache.Unlock()
+	if n != 1 {
+		t.Fatalf("expected expired entries evicted, got %d", n)
+	}
+	if _, ok := cacheGet("fresh"); !ok {
+		t.Fatal("expected fresh entry")
+	}
+}
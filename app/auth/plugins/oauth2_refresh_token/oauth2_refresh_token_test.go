@@ -0,0 +1,174 @@
+package oauth2refreshtoken
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func reset() {
+	secrets.ClearCache()
+	tokens = authplugins.NewTokenCache()
+}
+
+// rotatingServer accepts only the current refresh token and rotates it on
+// every use, as Asana and Zendesk do.
+type rotatingServer struct {
+	mu      sync.Mutex
+	current string
+	n       int
+}
+
+func (s *rotatingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != s.current {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	s.n++
+	s.current = fmt.Sprintf("rt-%d", s.n)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("at-%d", s.n),
+		"refresh_token": s.current,
+		"expires_in":    3600,
+	})
+}
+
+func setup(t *testing.T) (*rotatingServer, string, map[string]interface{}) {
+	t.Helper()
+	reset()
+	srv := &rotatingServer{current: "rt-0"}
+	ts := httptest.NewServer(srv)
+	oldClient := HTTPClient
+	HTTPClient = ts.Client()
+	t.Cleanup(func() {
+		HTTPClient = oldClient
+		ts.Close()
+	})
+	path := filepath.Join(t.TempDir(), "refresh_token")
+	if err := os.WriteFile(path, []byte("rt-0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RT_CLIENT_ID", "client")
+	t.Setenv("RT_CLIENT_SECRET", "s3cret")
+	return srv, path, map[string]interface{}{
+		"token_url":     ts.URL,
+		"client_id":     "env:RT_CLIENT_ID",
+		"client_secret": "env:RT_CLIENT_SECRET",
+		"refresh_token": "file:" + path,
+	}
+}
+
+func TestRefreshTokenRotatesAndPersists(t *testing.T) {
+	srv, path, params := setup(t)
+	p := RefreshToken{}
+	cfg, err := p.ParseParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+			if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+				t.Error(err)
+				return
+			}
+			if got := r.Header.Get("Authorization"); got != "Bearer at-1" {
+				t.Errorf("unexpected header %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if srv.n != 1 {
+		t.Fatalf("expected a single refresh, got %d", srv.n)
+	}
+	if b, _ := os.ReadFile(path); strings.TrimSpace(string(b)) != "rt-1" {
+		t.Fatalf("expected rotated refresh token to be persisted, got %q", b)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	r.Header.Set("Authorization", "Bearer at-1")
+	p.Invalidate(r, cfg)
+	reset()
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatalf("expected persisted refresh token to be usable: %v", err)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer at-2" {
+		t.Fatalf("unexpected header %q", got)
+	}
+}
+
+func TestRefreshTokenRereadsUnderLock(t *testing.T) {
+	srv, path, params := setup(t)
+	p := RefreshToken{}
+	cfg, err := p.ParseParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secrets.LoadSecret(context.Background(), "file:"+path); err != nil {
+		t.Fatal(err)
+	}
+	// Another replica rotates the token behind our cached copy.
+	srv.current = "rt-other"
+	if err := os.WriteFile(path, []byte("rt-other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err != nil {
+		t.Fatalf("expected refresh with the latest stored token: %v", err)
+	}
+}
+
+func TestRefreshTokenGrantError(t *testing.T) {
+	srv, _, params := setup(t)
+	srv.current = "something-else"
+	p := RefreshToken{}
+	cfg, err := p.ParseParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	if err := p.AddAuth(context.Background(), r, cfg); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("expected invalid_grant, got %v", err)
+	}
+}
+
+func TestRefreshTokenParseParams(t *testing.T) {
+	p := RefreshToken{}
+	cases := []map[string]interface{}{
+		{"client_id": "env:A", "refresh_token": "file:/tmp/rt"},
+		{"token_url": "https://x/token", "refresh_token": "file:/tmp/rt"},
+		{"token_url": "https://x/token", "client_id": "env:A"},
+		{"token_url": "https://x/token", "client_id": "env:A", "refresh_token": "env:RT"},
+		{"token_url": "https://x/token", "client_id": "env:A", "refresh_token": "file:/tmp/rt", "auth_method": "private_key_jwt"},
+	}
+	for i, m := range cases {
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"token_url": "https://x/token", "client_id": "env:A", "refresh_token": "file:/tmp/rt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*outParams); c.AuthMethod != "client_secret_basic" || c.Header != "Authorization" || c.Prefix != "Bearer " {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
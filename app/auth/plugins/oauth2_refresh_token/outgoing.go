@@ -0,0 +1,201 @@
+package oauth2refreshtoken
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// outParams configures the OAuth2 refresh token grant. ClientID and
+// ClientSecret are secret references; ClientSecret is omitted for public
+// clients. RefreshToken must reference a writable secret source so rotated
+// refresh tokens can be persisted.
+type outParams struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RefreshToken string   `json:"refresh_token"`
+	AuthMethod   string   `json:"auth_method"`
+	Scopes       []string `json:"scopes"`
+	Header       string   `json:"header"`
+	Prefix       string   `json:"prefix"`
+}
+
+// HTTPClient performs token requests. It can be swapped in tests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// lockTTL bounds how long a refresh may hold the shared lock, and lockWait
+// how long a request waits for another refresh to finish.
+const (
+	lockTTL  = 30 * time.Second
+	lockWait = 30 * time.Second
+)
+
+// tokens is shared across configuration reloads so a reload does not spend
+// a refresh token.
+var tokens = authplugins.NewTokenCache()
+
+// RefreshToken attaches access tokens obtained with the OAuth2 refresh token
+// grant and persists rotated refresh tokens.
+type RefreshToken struct{}
+
+func (o *RefreshToken) Name() string { return "oauth2_refresh_token" }
+
+func (o *RefreshToken) RequiredParams() []string {
+	return []string{"token_url", "client_id", "refresh_token"}
+}
+
+func (o *RefreshToken) OptionalParams() []string {
+	return []string{"client_secret", "auth_method", "scopes", "header", "prefix"}
+}
+
+func (o *RefreshToken) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[outParams](m)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(p.TokenURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid token_url")
+	}
+	if p.ClientID == "" {
+		return nil, fmt.Errorf("missing client_id")
+	}
+	if p.RefreshToken == "" {
+		return nil, fmt.Errorf("missing refresh_token")
+	}
+	if err := secrets.ValidateWritableSecret(p.RefreshToken); err != nil {
+		return nil, fmt.Errorf("refresh_token: %w", err)
+	}
+	refs := []string{p.ClientID}
+	if p.ClientSecret != "" {
+		refs = append(refs, p.ClientSecret)
+	}
+	for _, ref := range refs {
+		if err := secrets.ValidateSecret(ref); err != nil {
+			return nil, err
+		}
+	}
+	switch p.AuthMethod {
+	case "":
+		p.AuthMethod = "client_secret_basic"
+	case "client_secret_basic", "client_secret_post":
+	default:
+		return nil, fmt.Errorf("unsupported auth_method %q", p.AuthMethod)
+	}
+	if p.Header == "" {
+		p.Header = "Authorization"
+	}
+	if p.Prefix == "" {
+		p.Prefix = "Bearer "
+	}
+	return p, nil
+}
+
+// cacheKey identifies the access token. The refresh token reference is the
+// grant itself, so integrations sharing it share access tokens too.
+func (p *outParams) cacheKey() string {
+	return strings.Join([]string{p.TokenURL, p.RefreshToken, strings.Join(p.Scopes, " ")}, "\x00")
+}
+
+func (o *RefreshToken) AddAuth(ctx context.Context, r *http.Request, params interface{}) error {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return fmt.Errorf("invalid config")
+	}
+	tok, err := tokens.Get(ctx, cfg.cacheKey(), func(ctx context.Context) (authplugins.Token, error) {
+		return refresh(ctx, cfg)
+	})
+	if err != nil {
+		return err
+	}
+	r.Header.Set(cfg.Header, cfg.Prefix+tok.Value)
+	return nil
+}
+
+// Invalidate drops the cached access token carried by a request the
+// upstream rejected so the next request refreshes it.
+func (o *RefreshToken) Invalidate(r *http.Request, params interface{}) {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return
+	}
+	if v := r.Header.Get(cfg.Header); strings.HasPrefix(v, cfg.Prefix) {
+		tokens.Invalidate(cfg.cacheKey(), strings.TrimPrefix(v, cfg.Prefix))
+	}
+}
+
+// refresh redeems the stored refresh token while holding the shared lock for
+// it, so replicas never redeem the same single-use token twice. The token is
+// reread from its source under the lock because another replica may have
+// rotated it.
+func refresh(ctx context.Context, cfg *outParams) (authplugins.Token, error) {
+	lockCtx, cancel := context.WithTimeout(ctx, lockWait)
+	defer cancel()
+	unlock, err := authplugins.Lock(lockCtx, "oauth2_refresh_token:"+cfg.RefreshToken, lockTTL)
+	if err != nil {
+		return authplugins.Token{}, fmt.Errorf("waiting for refresh lock: %w", err)
+	}
+	defer unlock()
+
+	secrets.InvalidateSecret(cfg.RefreshToken)
+	rt, err := secrets.LoadSecret(ctx, cfg.RefreshToken)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	if rt == "" {
+		return authplugins.Token{}, fmt.Errorf("empty refresh token")
+	}
+	clientID, err := secrets.LoadSecret(ctx, cfg.ClientID)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	var clientSecret string
+	if cfg.ClientSecret != "" {
+		if clientSecret, err = secrets.LoadSecret(ctx, cfg.ClientSecret); err != nil {
+			return authplugins.Token{}, err
+		}
+	}
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt}}
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	var user, pass string
+	if cfg.AuthMethod == "client_secret_basic" && clientSecret != "" {
+		user, pass = clientID, clientSecret
+	} else {
+		form.Set("client_id", clientID)
+		if clientSecret != "" {
+			form.Set("client_secret", clientSecret)
+		}
+	}
+	tr, err := authplugins.RequestToken(ctx, HTTPClient, cfg.TokenURL, form, user, pass)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	if tr.RefreshToken != "" && tr.RefreshToken != rt {
+		// The old refresh token is already spent, so keep serving the new
+		// access token even if the rotated one cannot be saved.
+		if err := secrets.StoreSecret(ctx, cfg.RefreshToken, tr.RefreshToken); err != nil {
+			authplugins.Logger().Error("oauth2_refresh_token: failed to persist rotated refresh token", "error", err)
+		}
+	}
+	return tr.Token(time.Now()), nil
+}
+
+// SecretRefs returns the secret references the credentials are loaded from.
+func (o *RefreshToken) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.RefreshToken, cfg.ClientID, cfg.ClientSecret}
+}
+
+func init() { authplugins.RegisterOutgoing(&RefreshToken{}) }
@@ -0,0 +1,123 @@
+package pagerdutysignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// pagerDutySigParams configures PagerDuty v3 webhook signature validation.
+type pagerDutySigParams struct {
+	authplugins.ReplayParams
+	Secrets []string `json:"secrets"`
+	Header  string   `json:"header"`
+}
+
+// PagerDutySignatureAuth verifies the X-PagerDuty-Signature header sent with
+// v3 webhooks. PagerDuty lists one v1= signature per active secret while a
+// secret is being rotated, so any listed signature may match. The scheme
+// signs no timestamp, so with replay_protection a digest of the signed body,
+// which every listed signature covers, is used as a nonce and remembered for
+// replay_window seconds.
+type PagerDutySignatureAuth struct{}
+
+func (s *PagerDutySignatureAuth) Name() string { return "pagerduty_signature" }
+
+func (s *PagerDutySignatureAuth) RequiredParams() []string { return []string{"secrets"} }
+
+func (s *PagerDutySignatureAuth) OptionalParams() []string {
+	return append([]string{"header"}, authplugins.ReplayParamNames...)
+}
+
+func (s *PagerDutySignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[pagerDutySigParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if len(p.Secrets) == 0 {
+		return nil, fmt.Errorf("missing secrets")
+	}
+	if p.Header == "" {
+		p.Header = "X-PagerDuty-Signature"
+	}
+	return p, nil
+}
+
+// parseSignatures returns the v1 signatures from "v1=<hex>,v1=<hex>".
+func parseSignatures(h string) []string {
+	var sigs []string
+	for _, part := range strings.Split(h, ",") {
+		if sig, ok := strings.CutPrefix(strings.TrimSpace(part), "v1="); ok && sig != "" {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+func (s *PagerDutySignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return s.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the PagerDuty signature and explains
+// failures.
+func (s *PagerDutySignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*pagerDutySigParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	header := r.Header.Get(cfg.Header)
+	if header == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
+	sigs := parseSignatures(header)
+	if len(sigs) == 0 {
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header has no v1 signature", cfg.Header)
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	sum := sha256.Sum256(body)
+	nonce := hex.EncodeToString(sum[:])
+	loaded := false
+	for _, ref := range cfg.Secrets {
+		secret, err := secrets.LoadSecret(ctx, ref)
+		if err != nil {
+			continue
+		}
+		loaded = true
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		for _, sig := range sigs {
+			if hmac.Equal([]byte(expected), []byte(sig)) {
+				return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(s.Name(), cfg.Secrets), nonce, "", 0)
+			}
+		}
+	}
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+}
+
+// StripAuth removes the PagerDuty signature header from the request.
+func (s *PagerDutySignatureAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*pagerDutySigParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+}
+
+func init() { authplugins.RegisterIncoming(&PagerDutySignatureAuth{}) }
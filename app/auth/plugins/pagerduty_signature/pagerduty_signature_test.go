@@ -0,0 +1,99 @@
+package pagerdutysignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const body = `{"event":{"event_type":"incident.triggered"}}`
+
+func sign(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newRequest(header string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if header != "" {
+		r.Header.Set("X-PagerDuty-Signature", header)
+	}
+	return r
+}
+
+func TestPagerDutySignature(t *testing.T) {
+	p := &PagerDutySignatureAuth{}
+	t.Setenv("PD_SECRET", "secret")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:PD_SECRET"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// During rotation PagerDuty lists a signature per subscription secret.
+	r := newRequest(sign("retired") + ", " + sign("secret"))
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected one matching signature to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get("X-PagerDuty-Signature") != "" {
+		t.Fatal("expected header to be stripped")
+	}
+
+	cases := map[string]authplugins.Reason{
+		"":              authplugins.ReasonMissingCredential,
+		"v0=abc":        authplugins.ReasonMalformedCredential,
+		sign("retired"): authplugins.ReasonInvalidCredential,
+	}
+	for header, want := range cases {
+		if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newRequest(header), cfg)); got != want {
+			t.Errorf("%q: expected %s, got %s", header, want, got)
+		}
+	}
+}
+
+func TestPagerDutySignatureReplayWithoutMatchingSignature(t *testing.T) {
+	p := &PagerDutySignatureAuth{}
+	t.Setenv("PD_OLD", "old")
+	t.Setenv("PD_NEW", "new")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:PD_OLD", "env:PD_NEW"}, "replay_protection": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AuthenticateWithReason(context.Background(), newRequest(sign("old")+","+sign("new")), cfg); err != nil {
+		t.Fatal(err)
+	}
+	err = p.AuthenticateWithReason(context.Background(), newRequest(sign("new")), cfg)
+	if got := authplugins.FailureReason(err); got != authplugins.ReasonReplayed {
+		t.Fatalf("expected replay with the first v1 removed to be rejected, got %s (%v)", got, err)
+	}
+}
+
+func TestParseSignatures(t *testing.T) {
+	got := parseSignatures("v1=aa, v1=bb,v2=cc,v1=")
+	if !slices.Equal(got, []string{"aa", "bb"}) {
+		t.Fatalf("unexpected signatures %v", got)
+	}
+}
+
+func TestPagerDutySignatureParams(t *testing.T) {
+	p := &PagerDutySignatureAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing secrets to fail")
+	}
+	if !slices.Equal(p.RequiredParams(), []string{"secrets"}) || !slices.Equal(p.OptionalParams(), []string{"header", "replay_protection", "nonce_header", "replay_window"}) {
+		t.Fatal("unexpected params lists")
+	}
+	if p.Authenticate(context.Background(), newRequest("v1=x"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
@@ -27,4 +27,9 @@ func (p *PassThruAuth) Authenticate(ctx context.Context, r *http.Request, _ inte
 	return true
 }
 
+// AuthenticateWithReason always succeeds.
+func (p *PassThruAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, _ interface{}) error {
+	return nil
+}
+
 func init() { authplugins.RegisterIncoming(&PassThruAuth{}) }
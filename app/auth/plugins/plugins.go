@@ -1,19 +1,35 @@
 package plugins
 
 import (
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/api_key"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/aws_sigv4"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/azure_managed_identity"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/basic"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/envoy_xfcc"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/findreplace"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/gcp_token"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/github_app"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/github_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/google_oidc"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/hmac"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/http_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/jwt"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/k8s_tokenreview"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/linear_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/mtls"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/oauth2_client_credentials"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/oauth2_introspection"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/oauth2_refresh_token"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/pagerduty_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/passthrough"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/shopify_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/slack_signature"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/standard_webhooks"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/stripe_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/token"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/token_exchange"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/trusted_header"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/twilio_signature"
 	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/urlpath"
+	_ "github.com/winhowes/AuthTranslator/app/auth/plugins/zendesk_signature"
 )
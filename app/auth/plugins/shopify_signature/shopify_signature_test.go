@@ -0,0 +1,70 @@
+package shopifysignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const body = `{"id":1}`
+
+func sign(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newRequest(sig string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if sig != "" {
+		r.Header.Set("X-Shopify-Hmac-Sha256", sig)
+	}
+	return r
+}
+
+func TestShopifySignature(t *testing.T) {
+	p := &ShopifySignatureAuth{}
+	t.Setenv("SHOPIFY_OLD", "old")
+	t.Setenv("SHOPIFY_NEW", "new")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:SHOPIFY_OLD", "env:SHOPIFY_NEW"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest(sign("new"))
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected rotated secret to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get("X-Shopify-Hmac-Sha256") != "" {
+		t.Fatal("expected header to be stripped")
+	}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newRequest(sign("nope")), cfg)); got != authplugins.ReasonInvalidCredential {
+		t.Fatalf("expected invalid_credential, got %s", got)
+	}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newRequest(""), cfg)); got != authplugins.ReasonMissingCredential {
+		t.Fatalf("expected missing_credential, got %s", got)
+	}
+}
+
+func TestShopifySignatureParams(t *testing.T) {
+	p := &ShopifySignatureAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing secrets to fail")
+	}
+	if !slices.Equal(p.RequiredParams(), []string{"secrets"}) || !slices.Equal(p.OptionalParams(), []string{"header", "replay_protection", "nonce_header", "replay_window"}) {
+		t.Fatal("unexpected params lists")
+	}
+	if p.Authenticate(context.Background(), newRequest("x"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
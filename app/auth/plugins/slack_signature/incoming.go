@@ -17,6 +17,7 @@ import (
 
 // slackSigParams holds config for Slack signature validation.
 type slackSigParams struct {
+	authplugins.ReplayParams
 	Secrets         []string `json:"secrets"`
 	Version         string   `json:"version"`
 	SigHeader       string   `json:"sig_header"`
@@ -31,7 +32,7 @@ func (s *SlackSignatureAuth) Name() string { return "slack_signature" }
 func (s *SlackSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
 
 func (s *SlackSignatureAuth) OptionalParams() []string {
-	return []string{"version", "sig_header", "ts_header", "tolerance"}
+	return append([]string{"version", "sig_header", "ts_header", "tolerance"}, authplugins.ReplayParamNames...)
 }
 
 func (s *SlackSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
@@ -39,6 +40,9 @@ func (s *SlackSignatureAuth) ParseParams(m map[string]interface{}) (interface{},
 	if err != nil {
 		return nil, err
 	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
 	if len(p.Secrets) == 0 {
 		return nil, fmt.Errorf("missing secrets")
 	}
@@ -58,40 +62,53 @@ func (s *SlackSignatureAuth) ParseParams(m map[string]interface{}) (interface{},
 }
 
 func (s *SlackSignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return s.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the Slack signature and explains failures.
+func (s *SlackSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*slackSigParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
 	tsStr := r.Header.Get(cfg.TimestampHeader)
+	if tsStr == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.TimestampHeader)
+	}
 	ts, err := strconv.ParseInt(tsStr, 10, 64)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
 	}
 	if abs(time.Now().Unix()-ts) > cfg.Tolerance {
-		return false
+		return authplugins.Fail(authplugins.ReasonExpired, "timestamp outside tolerance")
 	}
 	body, err := authplugins.GetBody(r)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
 	}
 	base := fmt.Sprintf("%s:%s:%s", cfg.Version, tsStr, string(body))
 	sig := r.Header.Get(cfg.SigHeader)
 	if sig == "" {
-		return false
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.SigHeader)
 	}
+	loaded := false
 	for _, ref := range cfg.Secrets {
 		secret, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
+		loaded = true
 		mac := hmac.New(sha256.New, []byte(secret))
 		mac.Write([]byte(base))
 		expected := cfg.Version + "=" + hex.EncodeToString(mac.Sum(nil))
 		if hmac.Equal([]byte(expected), []byte(sig)) {
-			return true
+			return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(s.Name(), cfg.Secrets), sig, tsStr, authplugins.ToleranceTTL(ts, cfg.Tolerance))
 		}
 	}
-	return false
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
 }
 
 // StripAuth removes the Slack signature and timestamp headers from the request.
@@ -15,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
 	"github.com/winhowes/AuthTranslator/app/secrets"
 
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
@@ -110,7 +111,7 @@ func TestSlackSignatureDefaults(t *testing.T) {
 
 func TestSlackSignatureOptionalParams(t *testing.T) {
 	p := SlackSignatureAuth{}
-	if got := p.OptionalParams(); len(got) != 4 || got[0] != "version" {
+	if got := p.OptionalParams(); len(got) != 7 || got[0] != "version" {
 		t.Fatalf("unexpected optional params: %v", got)
 	}
 }
@@ -310,3 +311,30 @@ func TestSlackSignatureStripAuthInvalidParams(t *testing.T) {
 		t.Fatal("headers should remain when params wrong type")
 	}
 }
+
+func TestSlackSignatureReplayProtection(t *testing.T) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("v0:" + ts + ":hello"))
+	sig := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	newReq := func() *http.Request {
+		return &http.Request{Header: http.Header{
+			"X-Slack-Request-Timestamp": []string{ts},
+			"X-Slack-Signature":         []string{sig},
+		}, Body: io.NopCloser(strings.NewReader("hello"))}
+	}
+	p := SlackSignatureAuth{}
+	t.Setenv("SEC", "key")
+	prev := authplugins.SetReplayStore(nil)
+	defer authplugins.SetReplayStore(prev)
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:SEC"}, "replay_protection": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Authenticate(context.Background(), newReq(), cfg) {
+		t.Fatal("expected first request to pass")
+	}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), newReq(), cfg)); got != authplugins.ReasonReplayed {
+		t.Fatalf("expected replayed, got %s", got)
+	}
+}
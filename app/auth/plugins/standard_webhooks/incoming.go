@@ -0,0 +1,159 @@
+package standardwebhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// standardWebhooksParams configures Standard Webhooks verification. Tolerance
+// is the maximum age of webhook-timestamp in seconds and also bounds how long
+// a webhook-id is kept in the shared replay store. HeaderPrefix selects the
+// header family, e.g. "svix-" for vendors that send svix-id, svix-timestamp
+// and svix-signature.
+type standardWebhooksParams struct {
+	Secrets      []string `json:"secrets"`
+	Tolerance    int64    `json:"tolerance"`
+	HeaderPrefix string   `json:"header_prefix"`
+}
+
+// StandardWebhooksAuth verifies webhooks signed with the Standard Webhooks
+// scheme (https://www.standardwebhooks.com), as used by Svix and the vendors
+// built on it.
+type StandardWebhooksAuth struct{}
+
+func (s *StandardWebhooksAuth) Name() string { return "standard_webhooks" }
+
+func (s *StandardWebhooksAuth) RequiredParams() []string { return []string{"secrets"} }
+
+func (s *StandardWebhooksAuth) OptionalParams() []string {
+	return []string{"tolerance", "header_prefix"}
+}
+
+func (s *StandardWebhooksAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[standardWebhooksParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Secrets) == 0 {
+		return nil, fmt.Errorf("missing secrets")
+	}
+	if p.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = 300
+	}
+	if p.HeaderPrefix == "" {
+		p.HeaderPrefix = "webhook-"
+	}
+	return p, nil
+}
+
+// signingKey decodes a "whsec_<base64>" secret. The prefix is optional.
+func signingKey(secret string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(secret), "whsec_"))
+}
+
+// parseSignatures returns the v1 signatures from a space-delimited
+// "v1,<base64> v1,<base64>" header. Other versions are ignored.
+func parseSignatures(h string) []string {
+	var sigs []string
+	for _, part := range strings.Fields(h) {
+		if sig, ok := strings.CutPrefix(part, "v1,"); ok && sig != "" {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+func (s *StandardWebhooksAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return s.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the webhook signature, timestamp and ID
+// and explains failures.
+func (s *StandardWebhooksAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*standardWebhooksParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	id := r.Header.Get(cfg.HeaderPrefix + "id")
+	tsStr := r.Header.Get(cfg.HeaderPrefix + "timestamp")
+	sigHeader := r.Header.Get(cfg.HeaderPrefix + "signature")
+	if id == "" || tsStr == "" || sigHeader == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %sid, %stimestamp or %ssignature header", cfg.HeaderPrefix, cfg.HeaderPrefix, cfg.HeaderPrefix)
+	}
+	sigs := parseSignatures(sigHeader)
+	if len(sigs) == 0 {
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%ssignature has no v1 signature", cfg.HeaderPrefix)
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if age := time.Now().Unix() - ts; age > cfg.Tolerance || age < -cfg.Tolerance {
+		return authplugins.Fail(authplugins.ReasonExpired, "timestamp outside tolerance")
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	loaded := false
+	matched := false
+	for _, ref := range cfg.Secrets {
+		secret, err := secrets.LoadSecret(ctx, ref)
+		if err != nil {
+			continue
+		}
+		key, err := signingKey(secret)
+		if err != nil {
+			continue
+		}
+		loaded = true
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(id + "." + tsStr + "."))
+		mac.Write(body)
+		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		for _, sig := range sigs {
+			if hmac.Equal([]byte(expected), []byte(sig)) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			break
+		}
+	}
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no valid whsec_ secrets could be loaded")
+	}
+	if !matched {
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+	}
+	// IDs are only recorded once the signature covering them is valid, so
+	// forged requests cannot block genuine deliveries.
+	return authplugins.CheckNonce(ctx, authplugins.ReplayScope(s.Name(), cfg.Secrets), id, authplugins.ToleranceTTL(ts, cfg.Tolerance))
+}
+
+// StripAuth removes the Standard Webhooks headers from the request.
+func (s *StandardWebhooksAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*standardWebhooksParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.HeaderPrefix + "id")
+	r.Header.Del(cfg.HeaderPrefix + "timestamp")
+	r.Header.Del(cfg.HeaderPrefix + "signature")
+}
+
+func init() { authplugins.RegisterIncoming(&StandardWebhooksAuth{}) }
@@ -0,0 +1,154 @@
+package standardwebhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const (
+	oldSecret = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	newSecret = "whsec_c2VjcmV0LWtleS1mb3ItdGVzdHM="
+	body      = `{"type":"invoice.paid"}`
+)
+
+func sign(secret, id string, ts int64) string {
+	key, _ := signingKey(secret)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + strconv.FormatInt(ts, 10) + "." + body))
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newRequest(id string, ts int64, sig string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("webhook-id", id)
+	r.Header.Set("webhook-timestamp", strconv.FormatInt(ts, 10))
+	r.Header.Set("webhook-signature", sig)
+	return r
+}
+
+func parse(t *testing.T, m map[string]interface{}) interface{} {
+	t.Helper()
+	t.Setenv("SW_OLD", oldSecret)
+	t.Setenv("SW_NEW", newSecret)
+	if _, ok := m["secrets"]; !ok {
+		m["secrets"] = []interface{}{"env:SW_OLD", "env:SW_NEW"}
+	}
+	cfg, err := (&StandardWebhooksAuth{}).ParseParams(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestStandardWebhooksSpecVector(t *testing.T) {
+	p := &StandardWebhooksAuth{}
+	cfg := parse(t, map[string]interface{}{"tolerance": 1 << 40})
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"test": 2432232314}`))
+	r.Header.Set("webhook-id", "msg_p5jXN8AQM9LWM0D4loKWxJek")
+	r.Header.Set("webhook-timestamp", "1614265330")
+	r.Header.Set("webhook-signature", "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=")
+	if err := p.AuthenticateWithReason(context.Background(), r, cfg); err != nil {
+		t.Fatalf("expected spec vector to verify: %v", err)
+	}
+}
+
+func TestStandardWebhooks(t *testing.T) {
+	p := &StandardWebhooksAuth{}
+	cfg := parse(t, map[string]interface{}{})
+	now := time.Now().Unix()
+
+	// Several signatures per header, one of them from the rotated secret.
+	r := newRequest("msg_1", now, "v1a,ignored "+sign("whsec_b3RoZXI=", "msg_1", now)+" "+sign(newSecret, "msg_1", now))
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected rotated secret to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	for _, h := range []string{"webhook-id", "webhook-timestamp", "webhook-signature"} {
+		if r.Header.Get(h) != "" {
+			t.Fatalf("expected %s to be stripped", h)
+		}
+	}
+
+	stale := now - 600
+	cases := []struct {
+		name string
+		r    *http.Request
+		want authplugins.Reason
+	}{
+		{"replayed", newRequest("msg_1", now, sign(oldSecret, "msg_1", now)), authplugins.ReasonReplayed},
+		{"missing", newRequest("", now, sign(oldSecret, "", now)), authplugins.ReasonMissingCredential},
+		{"no v1", newRequest("msg_2", now, "v1a,abc"), authplugins.ReasonMalformedCredential},
+		{"stale", newRequest("msg_3", stale, sign(oldSecret, "msg_3", stale)), authplugins.ReasonExpired},
+		{"wrong secret", newRequest("msg_4", now, sign("whsec_b3RoZXI=", "msg_4", now)), authplugins.ReasonInvalidCredential},
+		{"tampered id", newRequest("msg_5", now, sign(oldSecret, "msg_6", now)), authplugins.ReasonInvalidCredential},
+	}
+	for _, c := range cases {
+		if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), c.r, cfg)); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+	// A forged delivery must not block the genuine one with the same ID.
+	if !p.Authenticate(context.Background(), newRequest("msg_4", now, sign(oldSecret, "msg_4", now)), cfg) {
+		t.Fatal("expected genuine delivery after forged attempt to authenticate")
+	}
+}
+
+func TestStandardWebhooksHeaderPrefix(t *testing.T) {
+	p := &StandardWebhooksAuth{}
+	cfg := parse(t, map[string]interface{}{"header_prefix": "svix-"})
+	now := time.Now().Unix()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("svix-id", "msg_svix")
+	r.Header.Set("svix-timestamp", strconv.FormatInt(now, 10))
+	r.Header.Set("svix-signature", sign(oldSecret, "msg_svix", now))
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected svix headers to authenticate")
+	}
+}
+
+func TestStandardWebhooksBadSecret(t *testing.T) {
+	p := &StandardWebhooksAuth{}
+	t.Setenv("SW_BAD", "whsec_not base64!")
+	cfg := parse(t, map[string]interface{}{"secrets": []interface{}{"env:SW_BAD"}})
+	now := time.Now().Unix()
+	err := p.AuthenticateWithReason(context.Background(), newRequest("msg_bad", now, sign(oldSecret, "msg_bad", now)), cfg)
+	if got := authplugins.FailureReason(err); got != authplugins.ReasonUnavailable {
+		t.Fatalf("expected unavailable, got %s", got)
+	}
+}
+
+func TestStandardWebhooksParams(t *testing.T) {
+	p := &StandardWebhooksAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing secrets to fail")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}, "tolerance": -1}); err == nil {
+		t.Fatal("expected negative tolerance to fail")
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*standardWebhooksParams); c.Tolerance != 300 || c.HeaderPrefix != "webhook-" {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+	if !slices.Equal(p.RequiredParams(), []string{"secrets"}) || !slices.Equal(p.OptionalParams(), []string{"tolerance", "header_prefix"}) {
+		t.Fatal("unexpected params lists")
+	}
+	if p.Authenticate(context.Background(), newRequest("x", 0, "v1,x"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
@@ -0,0 +1,149 @@
+package stripesignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// stripeSigParams configures Stripe webhook signature validation. Tolerance
+// is the maximum age of the signed timestamp in seconds.
+type stripeSigParams struct {
+	authplugins.ReplayParams
+	Secrets   []string `json:"secrets"`
+	Header    string   `json:"header"`
+	Tolerance int64    `json:"tolerance"`
+}
+
+// StripeSignatureAuth verifies the Stripe-Signature header sent with Stripe
+// webhooks.
+type StripeSignatureAuth struct{}
+
+func (s *StripeSignatureAuth) Name() string { return "stripe_signature" }
+
+func (s *StripeSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
+
+func (s *StripeSignatureAuth) OptionalParams() []string {
+	return append([]string{"header", "tolerance"}, authplugins.ReplayParamNames...)
+}
+
+func (s *StripeSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[stripeSigParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if len(p.Secrets) == 0 {
+		return nil, fmt.Errorf("missing secrets")
+	}
+	if p.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	if p.Header == "" {
+		p.Header = "Stripe-Signature"
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = 300
+	}
+	return p, nil
+}
+
+// parseHeader splits "t=<ts>,v1=<sig>,v1=<sig>,v0=<sig>" into the timestamp
+// and the v1 signatures. Other schemes are ignored as Stripe recommends.
+func parseHeader(h string) (string, []string) {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(h, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	return ts, sigs
+}
+
+func (s *StripeSignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return s.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the Stripe signature and explains failures.
+func (s *StripeSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*stripeSigParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	header := r.Header.Get(cfg.Header)
+	if header == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
+	tsStr, sigs := parseHeader(header)
+	if tsStr == "" || len(sigs) == 0 {
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header lacks t or v1", cfg.Header)
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if age := time.Now().Unix() - ts; age > cfg.Tolerance || age < -cfg.Tolerance {
+		return authplugins.Fail(authplugins.ReasonExpired, "timestamp outside tolerance")
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	// Every valid v1 signature covers the same timestamp and body, so the
+	// nonce is derived from those rather than from whichever one matched.
+	// Otherwise dropping the matching signature of a request signed with two
+	// secrets would let the other one through as a fresh nonce.
+	sum := sha256.Sum256(body)
+	nonce := hex.EncodeToString(sum[:])
+	loaded := false
+	for _, ref := range cfg.Secrets {
+		secret, err := secrets.LoadSecret(ctx, ref)
+		if err != nil {
+			continue
+		}
+		loaded = true
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(tsStr + "."))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		for _, sig := range sigs {
+			if hmac.Equal([]byte(expected), []byte(sig)) {
+				return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(s.Name(), cfg.Secrets), nonce, tsStr, authplugins.ToleranceTTL(ts, cfg.Tolerance))
+			}
+		}
+	}
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+}
+
+// StripAuth removes the Stripe signature header from the request.
+func (s *StripeSignatureAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*stripeSigParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+}
+
+func init() { authplugins.RegisterIncoming(&StripeSignatureAuth{}) }
@@ -0,0 +1,149 @@
+package stripesignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const body = `{"id":"evt_1"}`
+
+func sign(secret, ts string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newRequest(header string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if header != "" {
+		r.Header.Set("Stripe-Signature", header)
+	}
+	return r
+}
+
+func parse(t *testing.T) interface{} {
+	t.Helper()
+	t.Setenv("STRIPE_OLD", "whsec_old")
+	t.Setenv("STRIPE_NEW", "whsec_new")
+	cfg, err := (&StripeSignatureAuth{}).ParseParams(map[string]interface{}{"secrets": []interface{}{"env:STRIPE_OLD", "env:STRIPE_NEW"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestStripeSignature(t *testing.T) {
+	p := &StripeSignatureAuth{}
+	cfg := parse(t)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Stripe sends one v1 signature per active secret while rolling.
+	r := newRequest("t=" + ts + ",v1=" + sign("other", ts) + ",v1=" + sign("whsec_new", ts) + ",v0=ignored")
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected rotated secret to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get("Stripe-Signature") != "" {
+		t.Fatal("expected header to be stripped")
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	cases := []struct {
+		name   string
+		header string
+		want   authplugins.Reason
+	}{
+		{"missing", "", authplugins.ReasonMissingCredential},
+		{"no v1", "t=" + ts, authplugins.ReasonMalformedCredential},
+		{"bad timestamp", "t=abc,v1=" + sign("whsec_old", "abc"), authplugins.ReasonMalformedCredential},
+		{"wrong secret", "t=" + ts + ",v1=" + sign("nope", ts), authplugins.ReasonInvalidCredential},
+		{"stale", "t=" + old + ",v1=" + sign("whsec_old", old), authplugins.ReasonExpired},
+	}
+	for _, c := range cases {
+		err := p.AuthenticateWithReason(context.Background(), newRequest(c.header), cfg)
+		if got := authplugins.FailureReason(err); got != c.want {
+			t.Errorf("%s: expected %s, got %s (%v)", c.name, c.want, got, err)
+		}
+	}
+}
+
+func TestStripeSignatureReplayWithoutMatchingSignature(t *testing.T) {
+	p := &StripeSignatureAuth{}
+	t.Setenv("STRIPE_OLD", "whsec_old")
+	t.Setenv("STRIPE_NEW", "whsec_new")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:STRIPE_OLD", "env:STRIPE_NEW"}, "replay_protection": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	first, second := "v1="+sign("whsec_old", ts), "v1="+sign("whsec_new", ts)
+	if err := p.AuthenticateWithReason(context.Background(), newRequest("t="+ts+","+first+","+second), cfg); err != nil {
+		t.Fatal(err)
+	}
+	err = p.AuthenticateWithReason(context.Background(), newRequest("t="+ts+","+second), cfg)
+	if got := authplugins.FailureReason(err); got != authplugins.ReasonReplayed {
+		t.Fatalf("expected replay with the first v1 removed to be rejected, got %s (%v)", got, err)
+	}
+}
+
+func TestStripeSignatureTolerance(t *testing.T) {
+	p := &StripeSignatureAuth{}
+	t.Setenv("STRIPE_OLD", "whsec_old")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:STRIPE_OLD"}, "tolerance": 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	if !p.Authenticate(context.Background(), newRequest("t="+old+",v1="+sign("whsec_old", old)), cfg) {
+		t.Fatal("expected timestamp within custom tolerance to authenticate")
+	}
+}
+
+func TestStripeSignatureUnavailable(t *testing.T) {
+	p := &StripeSignatureAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:STRIPE_UNSET_SECRET"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	err = p.AuthenticateWithReason(context.Background(), newRequest("t="+ts+",v1="+sign("x", ts)), cfg)
+	if got := authplugins.FailureReason(err); got != authplugins.ReasonUnavailable {
+		t.Fatalf("expected unavailable, got %s", got)
+	}
+}
+
+func TestStripeSignatureParams(t *testing.T) {
+	p := &StripeSignatureAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing secrets to fail")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}, "tolerance": -1}); err == nil {
+		t.Fatal("expected negative tolerance to fail")
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*stripeSigParams); c.Header != "Stripe-Signature" || c.Tolerance != 300 {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+	if !slices.Equal(p.RequiredParams(), []string{"secrets"}) || !slices.Equal(p.OptionalParams(), []string{"header", "tolerance", "replay_protection", "nonce_header", "replay_window"}) {
+		t.Fatal("unexpected params lists")
+	}
+	if p.Authenticate(context.Background(), newRequest("t=1,v1=x"), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
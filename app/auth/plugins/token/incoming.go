@@ -38,25 +38,38 @@ func (t *TokenAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 }
 
 func (t *TokenAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return t.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason checks the token header and explains failures.
+func (t *TokenAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*inParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
 	headerValue := r.Header.Get(cfg.Header)
+	if headerValue == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
 	if cfg.Prefix != "" && !strings.HasPrefix(headerValue, cfg.Prefix) {
-		return false
+		return authplugins.Fail(authplugins.ReasonMalformedCredential, "%s header missing prefix", cfg.Header)
 	}
 	tokenValue := strings.TrimPrefix(headerValue, cfg.Prefix)
+	loaded := false
 	for _, ref := range cfg.Secrets {
 		token, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
+		loaded = true
 		if subtle.ConstantTimeCompare([]byte(tokenValue), []byte(token)) == 1 {
-			return true
+			return nil
 		}
 	}
-	return false
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no token secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "token does not match")
 }
 
 // StripAuth removes the token header from the request.
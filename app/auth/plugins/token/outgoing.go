@@ -48,4 +48,13 @@ func (t *TokenAuthOut) AddAuth(ctx context.Context, r *http.Request, p interface
 	return nil
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (t *TokenAuthOut) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return cfg.Secrets
+}
+
 func init() { authplugins.RegisterOutgoing(&TokenAuthOut{}) }
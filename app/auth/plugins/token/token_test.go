@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"testing"
 
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
 	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
 )
 
@@ -313,3 +314,39 @@ func TestTokenStripAuthInvalidParams(t *testing.T) {
 		t.Fatal("header should remain when params wrong type")
 	}
 }
+
+func TestTokenAuthenticateWithReason(t *testing.T) {
+	p := TokenAuth{}
+	t.Setenv("TOK", "secret")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:TOK"}, "header": "X-Auth", "prefix": "Bearer "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]authplugins.Reason{
+		"":             authplugins.ReasonMissingCredential,
+		"Token secret": authplugins.ReasonMalformedCredential,
+		"Bearer wrong": authplugins.ReasonInvalidCredential,
+	}
+	for header, want := range cases {
+		r := &http.Request{Header: http.Header{}}
+		if header != "" {
+			r.Header.Set("X-Auth", header)
+		}
+		if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), r, cfg)); got != want {
+			t.Fatalf("header %q: expected %s, got %s", header, want, got)
+		}
+	}
+	r := &http.Request{Header: http.Header{"X-Auth": []string{"Bearer secret"}}}
+	if err := p.AuthenticateWithReason(context.Background(), r, cfg); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+
+	missing, err := p.ParseParams(map[string]interface{}{"secrets": []string{"env:MISSING_TOK"}, "header": "X-Auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = &http.Request{Header: http.Header{"X-Auth": []string{"secret"}}}
+	if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), r, missing)); got != authplugins.ReasonUnavailable {
+		t.Fatalf("expected unavailable, got %s", got)
+	}
+}
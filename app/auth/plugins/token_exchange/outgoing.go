@@ -0,0 +1,211 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+const (
+	grantType       = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	authBasic       = "client_secret_basic"
+	authPost        = "client_secret_post"
+)
+
+// outParams configures an RFC 8693 token exchange. ClientID, ClientSecret and
+// ActorToken are secret references.
+type outParams struct {
+	TokenURL           string   `json:"token_url"`
+	Audience           string   `json:"audience"`
+	Resource           string   `json:"resource"`
+	Scopes             []string `json:"scopes"`
+	SubjectTokenType   string   `json:"subject_token_type"`
+	RequestedTokenType string   `json:"requested_token_type"`
+	ActorToken         string   `json:"actor_token"`
+	ActorTokenType     string   `json:"actor_token_type"`
+	ClientID           string   `json:"client_id"`
+	ClientSecret       string   `json:"client_secret"`
+	AuthMethod         string   `json:"auth_method"`
+	Header             string   `json:"header"`
+	Prefix             string   `json:"prefix"`
+}
+
+// HTTPClient performs token requests. It can be swapped in tests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// tokens caches exchanged tokens by configuration and subject token hash.
+var tokens = authplugins.NewTokenCache()
+
+// TokenExchange exchanges the caller's incoming bearer token for a token
+// scoped to the upstream and attaches it to outgoing requests.
+type TokenExchange struct{}
+
+func (t *TokenExchange) Name() string { return "token_exchange" }
+
+func (t *TokenExchange) RequiredParams() []string { return []string{"token_url"} }
+
+func (t *TokenExchange) OptionalParams() []string {
+	return []string{"audience", "resource", "scopes", "subject_token_type", "requested_token_type", "actor_token", "actor_token_type", "client_id", "client_secret", "auth_method", "header", "prefix"}
+}
+
+func (t *TokenExchange) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[outParams](m)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(p.TokenURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid token_url")
+	}
+	if p.SubjectTokenType == "" {
+		p.SubjectTokenType = accessTokenType
+	}
+	if p.RequestedTokenType == "" {
+		p.RequestedTokenType = accessTokenType
+	}
+	var refs []string
+	if p.ActorToken != "" {
+		if p.ActorTokenType == "" {
+			p.ActorTokenType = accessTokenType
+		}
+		refs = append(refs, p.ActorToken)
+	} else if p.ActorTokenType != "" {
+		return nil, fmt.Errorf("actor_token_type requires actor_token")
+	}
+	if p.ClientID != "" {
+		if p.ClientSecret == "" {
+			return nil, fmt.Errorf("client_id requires client_secret")
+		}
+		if p.AuthMethod == "" {
+			p.AuthMethod = authBasic
+		}
+		if p.AuthMethod != authBasic && p.AuthMethod != authPost {
+			return nil, fmt.Errorf("unsupported auth_method %q", p.AuthMethod)
+		}
+		refs = append(refs, p.ClientID, p.ClientSecret)
+	} else if p.ClientSecret != "" || p.AuthMethod != "" {
+		return nil, fmt.Errorf("client_secret and auth_method require client_id")
+	}
+	for _, ref := range refs {
+		if err := secrets.ValidateSecret(ref); err != nil {
+			return nil, err
+		}
+	}
+	if p.Header == "" {
+		p.Header = "Authorization"
+	}
+	if p.Prefix == "" {
+		p.Prefix = "Bearer "
+	}
+	return p, nil
+}
+
+// cacheKey identifies the token exchanged for subject. Only a hash of the
+// subject token is kept so cache keys never hold caller credentials.
+func (p *outParams) cacheKey(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return strings.Join([]string{p.TokenURL, p.Audience, p.Resource, strings.Join(p.Scopes, " "), p.RequestedTokenType, p.ActorToken, p.ClientID, hex.EncodeToString(sum[:])}, "\x00")
+}
+
+func (t *TokenExchange) AddAuth(ctx context.Context, r *http.Request, params interface{}) error {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return fmt.Errorf("invalid config")
+	}
+	subject, ok := authplugins.SubjectToken(ctx)
+	if !ok {
+		return fmt.Errorf("no verified caller token to exchange")
+	}
+	tok, err := tokens.Get(ctx, cfg.cacheKey(subject), func(ctx context.Context) (authplugins.Token, error) {
+		return exchange(ctx, cfg, subject)
+	})
+	if err != nil {
+		return err
+	}
+	r.Header.Set(cfg.Header, cfg.Prefix+tok.Value)
+	return nil
+}
+
+// Invalidate drops the exchanged token carried by a request the upstream
+// rejected so the caller's next request is exchanged again.
+func (t *TokenExchange) Invalidate(r *http.Request, params interface{}) {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return
+	}
+	subject, ok := authplugins.SubjectToken(r.Context())
+	if !ok {
+		return
+	}
+	if v := r.Header.Get(cfg.Header); strings.HasPrefix(v, cfg.Prefix) {
+		tokens.Invalidate(cfg.cacheKey(subject), strings.TrimPrefix(v, cfg.Prefix))
+	}
+}
+
+func exchange(ctx context.Context, cfg *outParams, subject string) (authplugins.Token, error) {
+	form := url.Values{
+		"grant_type":           {grantType},
+		"subject_token":        {subject},
+		"subject_token_type":   {cfg.SubjectTokenType},
+		"requested_token_type": {cfg.RequestedTokenType},
+	}
+	if cfg.Audience != "" {
+		form.Set("audience", cfg.Audience)
+	}
+	if cfg.Resource != "" {
+		form.Set("resource", cfg.Resource)
+	}
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	if cfg.ActorToken != "" {
+		actor, err := secrets.LoadSecret(ctx, cfg.ActorToken)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		form.Set("actor_token", actor)
+		form.Set("actor_token_type", cfg.ActorTokenType)
+	}
+	var user, pass string
+	if cfg.ClientID != "" {
+		clientID, err := secrets.LoadSecret(ctx, cfg.ClientID)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		secret, err := secrets.LoadSecret(ctx, cfg.ClientSecret)
+		if err != nil {
+			return authplugins.Token{}, err
+		}
+		if cfg.AuthMethod == authBasic {
+			user, pass = clientID, secret
+		} else {
+			form.Set("client_id", clientID)
+			form.Set("client_secret", secret)
+		}
+	}
+	tr, err := authplugins.RequestToken(ctx, HTTPClient, cfg.TokenURL, form, user, pass)
+	if err != nil {
+		return authplugins.Token{}, err
+	}
+	return tr.Token(time.Now()), nil
+}
+
+// SecretRefs returns the secret references the credentials are loaded from.
+func (t *TokenExchange) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return []string{cfg.ActorToken, cfg.ClientID, cfg.ClientSecret}
+}
+
+func init() { authplugins.RegisterOutgoing(&TokenExchange{}) }
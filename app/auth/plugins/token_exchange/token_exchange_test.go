@@ -0,0 +1,144 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func callerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://upstream/", nil)
+	r = r.WithContext(authplugins.WithSubjectToken(r.Context()))
+	authplugins.SetSubjectToken(r.Context(), token)
+	return r
+}
+
+func TestTokenExchangeAddAuth(t *testing.T) {
+	var calls atomic.Int32
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		r.ParseForm()
+		if r.Form.Get("grant_type") != grantType || r.Form.Get("subject_token_type") != accessTokenType ||
+			r.Form.Get("audience") != "billing" || r.Form.Get("scope") != "read write" {
+			t.Errorf("unexpected exchange %v", r.Form)
+		}
+		if r.Form.Get("actor_token") != "actor" {
+			t.Errorf("unexpected actor token %q", r.Form.Get("actor_token"))
+		}
+		if u, p, _ := r.BasicAuth(); u != "proxy" || p != "secret" {
+			t.Errorf("unexpected client auth %q %q", u, p)
+		}
+		fmt.Fprintf(w, `{"access_token":"exchanged-%s","issued_token_type":%q,"token_type":"Bearer","expires_in":300}`,
+			r.Form.Get("subject_token"), accessTokenType)
+	}))
+	defer sts.Close()
+	t.Setenv("TX_CLIENT_SECRET", "secret")
+	t.Setenv("TX_ACTOR", "actor")
+	secrets.ClearCache()
+
+	p := TokenExchange{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"token_url":     sts.URL,
+		"audience":      "billing",
+		"scopes":        []string{"read", "write"},
+		"client_id":     "env:TX_CLIENT_ID",
+		"client_secret": "env:TX_CLIENT_SECRET",
+		"actor_token":   "env:TX_ACTOR",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TX_CLIENT_ID", "proxy")
+
+	for _, subject := range []string{"alice", "alice", "bob"} {
+		r := callerRequest(subject)
+		if err := p.AddAuth(r.Context(), r, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer exchanged-"+subject {
+			t.Fatalf("unexpected header %q", got)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected one exchange per subject, got %d", calls.Load())
+	}
+
+	r := callerRequest("alice")
+	r.Header.Set("Authorization", "Bearer exchanged-alice")
+	p.Invalidate(r, cfg)
+	r = callerRequest("alice")
+	if err := p.AddAuth(r.Context(), r, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected invalidation to force a new exchange, got %d calls", calls.Load())
+	}
+}
+
+func TestTokenExchangeMissingSubject(t *testing.T) {
+	p := TokenExchange{}
+	cfg, err := p.ParseParams(map[string]interface{}{"token_url": "https://sts.example/token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := callerRequest("")
+	if err := p.AddAuth(r.Context(), r, cfg); err == nil {
+		t.Fatal("expected error without a caller token")
+	}
+}
+
+func TestTokenExchangeError(t *testing.T) {
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_target"}`)
+	}))
+	defer sts.Close()
+	p := TokenExchange{}
+	cfg, _ := p.ParseParams(map[string]interface{}{"token_url": sts.URL, "resource": "https://api.example"})
+	r := callerRequest("carol")
+	if err := p.AddAuth(context.Background(), r, cfg); err == nil {
+		t.Fatal("expected error without subject in context")
+	}
+	err := p.AddAuth(r.Context(), r, cfg)
+	if err == nil || !strings.Contains(err.Error(), "invalid_target") {
+		t.Fatalf("expected token endpoint error, got %v", err)
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Fatal("expected no header on failure")
+	}
+}
+
+func TestTokenExchangeParseParams(t *testing.T) {
+	p := TokenExchange{}
+	cases := []map[string]interface{}{
+		{},
+		{"token_url": "ftp://sts"},
+		{"token_url": "https://sts", "client_id": "env:ID"},
+		{"token_url": "https://sts", "client_secret": "env:SECRET"},
+		{"token_url": "https://sts", "client_id": "env:ID", "client_secret": "env:S", "auth_method": "private_key_jwt"},
+		{"token_url": "https://sts", "actor_token_type": "urn:ietf:params:oauth:token-type:jwt"},
+		{"token_url": "https://sts", "actor_token": "bogus:ref"},
+		{"token_url": "https://sts", "unknown": true},
+	}
+	for i, m := range cases {
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"token_url": "https://sts", "client_id": "env:ID", "client_secret": "env:S"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.(*outParams)
+	if c.AuthMethod != authBasic || c.SubjectTokenType != accessTokenType || c.RequestedTokenType != accessTokenType || c.Prefix != "Bearer " {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
@@ -0,0 +1,283 @@
+package trustedheader
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// Google IAP signs X-Goog-IAP-JWT-Assertion with ES256 keys published here.
+const (
+	iapHeader  = "X-Goog-IAP-JWT-Assertion"
+	iapIssuer  = "https://cloud.google.com/iap"
+	iapJWKSURL = "https://www.gstatic.com/iap/verify/public_key-jwk"
+)
+
+// inParams configures trusted header authentication. Header carries the
+// caller identity and is only believed when the connection comes from one of
+// TrustedCIDRs. StripPrefix is removed from the identity, for example
+// "accounts.google.com:" for IAP. StripHeaders lists extra headers the
+// fronting proxy sets that must not reach the upstream. When IAPAudience is
+// set the IAP JWT assertion is verified as well and its email claim must
+// match the identity header.
+type inParams struct {
+	TrustedCIDRs []string `json:"trusted_cidrs"`
+	Header       string   `json:"header"`
+	StripPrefix  string   `json:"strip_prefix"`
+	StripHeaders []string `json:"strip_headers"`
+	IAPAudience  string   `json:"iap_audience"`
+	IAPJWKSURL   string   `json:"iap_jwks_url"`
+	Leeway       int64    `json:"leeway"`
+
+	prefixes []netip.Prefix
+}
+
+// TrustedHeaderAuth accepts an identity set by a fronting authenticating
+// proxy such as oauth2-proxy, Google IAP or an Envoy ext_authz filter.
+type TrustedHeaderAuth struct{}
+
+func (t *TrustedHeaderAuth) Name() string             { return "trusted_header" }
+func (t *TrustedHeaderAuth) RequiredParams() []string { return []string{"trusted_cidrs"} }
+func (t *TrustedHeaderAuth) OptionalParams() []string {
+	return []string{"header", "strip_prefix", "strip_headers", "iap_audience", "iap_jwks_url", "leeway"}
+}
+
+func (t *TrustedHeaderAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[inParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.TrustedCIDRs) == 0 {
+		return nil, fmt.Errorf("missing trusted_cidrs")
+	}
+	for _, c := range p.TrustedCIDRs {
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted_cidrs entry %q: %w", c, err)
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	if p.Header == "" {
+		p.Header = "X-Forwarded-User"
+	}
+	if p.IAPJWKSURL != "" && p.IAPAudience == "" {
+		return nil, fmt.Errorf("iap_jwks_url requires iap_audience")
+	}
+	if p.IAPAudience != "" && p.IAPJWKSURL == "" {
+		p.IAPJWKSURL = iapJWKSURL
+	}
+	if p.IAPJWKSURL != "" {
+		u, err := url.Parse(p.IAPJWKSURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid iap_jwks_url")
+		}
+	}
+	if p.Leeway < 0 {
+		return nil, fmt.Errorf("leeway must not be negative")
+	}
+	if p.Leeway == 0 {
+		p.Leeway = 30
+	}
+	return p, nil
+}
+
+// trusted reports whether the direct peer of r is inside a trusted CIDR.
+// Forwarding headers are deliberately ignored: the proxy that sets the
+// identity header must connect directly.
+func (p *inParams) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// identity returns the identity header with StripPrefix removed.
+func (p *inParams) identity(r *http.Request) string {
+	return strings.TrimPrefix(strings.TrimSpace(r.Header.Get(p.Header)), p.StripPrefix)
+}
+
+func (t *TrustedHeaderAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return t.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason checks the peer address, the identity header and,
+// when configured, the IAP assertion, and explains failures.
+func (t *TrustedHeaderAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	if !cfg.trusted(r) {
+		return authplugins.Fail(authplugins.ReasonNotAllowed, "peer %s is not a trusted proxy", r.RemoteAddr)
+	}
+	id := cfg.identity(r)
+	if id == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
+	}
+	if cfg.IAPAudience == "" {
+		return nil
+	}
+	assertion := r.Header.Get(iapHeader)
+	if assertion == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", iapHeader)
+	}
+	email, err := verifyIAP(ctx, cfg, assertion, time.Now())
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(email, id) {
+		return authplugins.Fail(authplugins.ReasonInvalidCredential, "IAP assertion email does not match %s", cfg.Header)
+	}
+	return nil
+}
+
+// verifyIAP verifies an IAP JWT assertion and returns its email claim.
+func verifyIAP(ctx context.Context, cfg *inParams, tok string, now time.Time) (string, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return "", authplugins.Fail(authplugins.ReasonMalformedCredential, "malformed IAP assertion")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims struct {
+		Iss   string          `json:"iss"`
+		Aud   json.RawMessage `json:"aud"`
+		Exp   int64           `json:"exp"`
+		Iat   int64           `json:"iat"`
+		Email string          `json:"email"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if header.Alg != "ES256" {
+		return "", authplugins.Fail(authplugins.ReasonInvalidCredential, "unexpected IAP assertion alg %q", header.Alg)
+	}
+	keys, err := authplugins.GetJWKS(cfg.IAPJWKSURL).Lookup(ctx, header.Kid)
+	if errors.Is(err, authplugins.ErrKeyNotFound) {
+		return "", authplugins.Fail(authplugins.ReasonInvalidCredential, "unknown IAP key %q", header.Kid)
+	}
+	if err != nil {
+		return "", &authplugins.AuthError{Reason: authplugins.ReasonUnavailable, Err: err}
+	}
+	verified := false
+	for _, k := range keys {
+		if pub, ok := k.Key.(*ecdsa.PublicKey); ok && verifyES256(pub, parts) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", authplugins.Fail(authplugins.ReasonInvalidCredential, "IAP assertion signature does not match")
+	}
+	if claims.Iss != iapIssuer {
+		return "", authplugins.Fail(authplugins.ReasonNotAllowed, "unexpected IAP issuer %q", claims.Iss)
+	}
+	if !matchAudience(claims.Aud, cfg.IAPAudience) {
+		return "", authplugins.Fail(authplugins.ReasonNotAllowed, "IAP assertion audience mismatch")
+	}
+	if claims.Exp == 0 || now.Unix() > claims.Exp+cfg.Leeway {
+		return "", authplugins.Fail(authplugins.ReasonExpired, "IAP assertion expired")
+	}
+	if claims.Iat > now.Unix()+cfg.Leeway {
+		return "", authplugins.Fail(authplugins.ReasonExpired, "IAP assertion issued in the future")
+	}
+	if claims.Email == "" {
+		return "", authplugins.Fail(authplugins.ReasonMalformedCredential, "IAP assertion has no email")
+	}
+	return claims.Email, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func matchAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, a := range many {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifyES256(pub *ecdsa.PublicKey, parts []string) bool {
+	if pub.Curve != elliptic.P256() {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	return ecdsa.Verify(pub, sum[:], r, s)
+}
+
+// Identify returns the identity header value once the request came from a
+// trusted proxy.
+func (t *TrustedHeaderAuth) Identify(r *http.Request, p interface{}) (string, bool) {
+	cfg, ok := p.(*inParams)
+	if !ok || !cfg.trusted(r) {
+		return "", false
+	}
+	id := cfg.identity(r)
+	return id, id != ""
+}
+
+// StripAuth removes the identity header, the IAP assertion and any
+// configured extra headers so they never reach the upstream.
+func (t *TrustedHeaderAuth) StripAuth(r *http.Request, p interface{}) {
+	cfg, ok := p.(*inParams)
+	if !ok {
+		return
+	}
+	r.Header.Del(cfg.Header)
+	r.Header.Del(iapHeader)
+	for _, h := range cfg.StripHeaders {
+		r.Header.Del(h)
+	}
+}
+
+func init() { authplugins.RegisterIncoming(&TrustedHeaderAuth{}) }
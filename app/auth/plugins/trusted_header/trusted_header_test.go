@@ -0,0 +1,239 @@
+package trustedheader
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+)
+
+func newRequest(remote string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://internal.example/", nil)
+	r.RemoteAddr = remote
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestTrustedHeaderAuthenticate(t *testing.T) {
+	p := TrustedHeaderAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"trusted_cidrs": []string{"10.0.0.0/8", "::1/128"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest("10.1.2.3:4567", map[string]string{"X-Forwarded-User": "alice"})
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected trusted peer to authenticate")
+	}
+	if id, ok := p.Identify(r, cfg); !ok || id != "alice" {
+		t.Fatalf("unexpected identity %q %v", id, ok)
+	}
+	r = newRequest("[::1]:4567", map[string]string{"X-Forwarded-User": "bob"})
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected IPv6 loopback to authenticate")
+	}
+}
+
+func TestTrustedHeaderUntrustedPeer(t *testing.T) {
+	p := TrustedHeaderAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{"trusted_cidrs": []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest("192.0.2.1:1234", map[string]string{
+		"X-Forwarded-User": "alice",
+		"X-Forwarded-For":  "10.0.0.1",
+	})
+	err = p.AuthenticateWithReason(context.Background(), r, cfg)
+	var ae *authplugins.AuthError
+	if !errors.As(err, &ae) || ae.Reason != authplugins.ReasonNotAllowed {
+		t.Fatalf("expected not allowed, got %v", err)
+	}
+	if _, ok := p.Identify(r, cfg); ok {
+		t.Fatal("expected no identity from untrusted peer")
+	}
+}
+
+func TestTrustedHeaderMissingHeader(t *testing.T) {
+	p := TrustedHeaderAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"trusted_cidrs": []string{"127.0.0.1/32"},
+		"header":        "X-Goog-Authenticated-User-Email",
+		"strip_prefix":  "accounts.google.com:",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest("127.0.0.1:1", nil)
+	err = p.AuthenticateWithReason(context.Background(), r, cfg)
+	var ae *authplugins.AuthError
+	if !errors.As(err, &ae) || ae.Reason != authplugins.ReasonMissingCredential {
+		t.Fatalf("expected missing credential, got %v", err)
+	}
+	r = newRequest("127.0.0.1:1", map[string]string{"X-Goog-Authenticated-User-Email": "accounts.google.com:alice@example.com"})
+	if id, ok := p.Identify(r, cfg); !ok || id != "alice@example.com" {
+		t.Fatalf("unexpected identity %q %v", id, ok)
+	}
+}
+
+func TestTrustedHeaderStripAuth(t *testing.T) {
+	p := TrustedHeaderAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"trusted_cidrs": []string{"10.0.0.0/8"},
+		"strip_headers": []string{"X-Forwarded-Email"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest("10.0.0.1:1", map[string]string{
+		"X-Forwarded-User":  "alice",
+		"X-Forwarded-Email": "alice@example.com",
+		iapHeader:           "assertion",
+		"X-Other":           "keep",
+	})
+	p.StripAuth(r, cfg)
+	for _, h := range []string{"X-Forwarded-User", "X-Forwarded-Email", iapHeader} {
+		if r.Header.Get(h) != "" {
+			t.Fatalf("expected %s to be stripped", h)
+		}
+	}
+	if r.Header.Get("X-Other") != "keep" {
+		t.Fatal("unrelated header removed")
+	}
+}
+
+func TestTrustedHeaderParseParamsErrors(t *testing.T) {
+	p := TrustedHeaderAuth{}
+	cases := []map[string]interface{}{
+		{},
+		{"trusted_cidrs": []string{"not-a-cidr"}},
+		{"trusted_cidrs": []string{"10.0.0.0/8"}, "iap_jwks_url": "https://keys.example"},
+		{"trusted_cidrs": []string{"10.0.0.0/8"}, "iap_audience": "aud", "iap_jwks_url": "ftp://keys"},
+		{"trusted_cidrs": []string{"10.0.0.0/8"}, "leeway": -1},
+	}
+	for i, m := range cases {
+		if _, err := p.ParseParams(m); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+	cfg, err := p.ParseParams(map[string]interface{}{"trusted_cidrs": []string{"10.0.0.0/8"}, "iap_audience": "aud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.(*inParams); c.IAPJWKSURL != iapJWKSURL || c.Header != "X-Forwarded-User" || c.Leeway != 30 {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
+
+func b64(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signIAP(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	signing := b64(map[string]string{"alg": "ES256", "kid": kid}) + "." + b64(claims)
+	sum := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func iapServer(t *testing.T, key *ecdsa.PrivateKey, kid string) *httptest.Server {
+	t.Helper()
+	x := base64.RawURLEncoding.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32)))
+	y := base64.RawURLEncoding.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32)))
+	body := fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","alg":"ES256","kid":"%s","x":"%s","y":"%s"}]}`, kid, x, y)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTrustedHeaderIAPAssertion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := iapServer(t, key, "k1")
+	p := TrustedHeaderAuth{}
+	cfg, err := p.ParseParams(map[string]interface{}{
+		"trusted_cidrs": []string{"10.0.0.0/8"},
+		"header":        "X-Goog-Authenticated-User-Email",
+		"strip_prefix":  "accounts.google.com:",
+		"iap_audience":  "/projects/1/global/backendServices/2",
+		"iap_jwks_url":  srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	valid := map[string]interface{}{
+		"iss":   iapIssuer,
+		"aud":   "/projects/1/global/backendServices/2",
+		"iat":   now,
+		"exp":   now + 600,
+		"email": "alice@example.com",
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for ck, cv := range valid {
+			c[ck] = cv
+		}
+		c[k] = v
+		return c
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name      string
+		assertion string
+		reason    authplugins.Reason
+	}{
+		{"valid", signIAP(t, key, "k1", valid), ""},
+		{"missing", "", authplugins.ReasonMissingCredential},
+		{"malformed", "a.b", authplugins.ReasonMalformedCredential},
+		{"wrong key", signIAP(t, other, "k1", valid), authplugins.ReasonInvalidCredential},
+		{"issuer", signIAP(t, key, "k1", with("iss", "https://evil")), authplugins.ReasonNotAllowed},
+		{"audience", signIAP(t, key, "k1", with("aud", "other")), authplugins.ReasonNotAllowed},
+		{"expired", signIAP(t, key, "k1", with("exp", now-600)), authplugins.ReasonExpired},
+		{"email mismatch", signIAP(t, key, "k1", with("email", "mallory@example.com")), authplugins.ReasonInvalidCredential},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := map[string]string{"X-Goog-Authenticated-User-Email": "accounts.google.com:alice@example.com"}
+			if tt.assertion != "" {
+				h[iapHeader] = tt.assertion
+			}
+			err := p.AuthenticateWithReason(context.Background(), newRequest("10.0.0.5:443", h), cfg)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("expected success, got %v", err)
+				}
+				return
+			}
+			var ae *authplugins.AuthError
+			if !errors.As(err, &ae) || ae.Reason != tt.reason {
+				t.Fatalf("expected %s, got %v", tt.reason, err)
+			}
+		})
+	}
+}
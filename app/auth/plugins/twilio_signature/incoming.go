@@ -18,6 +18,7 @@ import (
 
 // twilioSigParams configures Twilio webhook signature validation.
 type twilioSigParams struct {
+	authplugins.ReplayParams
 	Secrets []string `json:"secrets"`
 	Header  string   `json:"header"`
 }
@@ -28,13 +29,18 @@ func (t *TwilioSignatureAuth) Name() string { return "twilio_signature" }
 
 func (t *TwilioSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
 
-func (t *TwilioSignatureAuth) OptionalParams() []string { return []string{"header"} }
+func (t *TwilioSignatureAuth) OptionalParams() []string {
+	return append([]string{"header"}, authplugins.ReplayParamNames...)
+}
 
 func (t *TwilioSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
 	p, err := authplugins.ParseParams[twilioSigParams](m)
 	if err != nil {
 		return nil, err
 	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
 	if len(p.Secrets) == 0 {
 		return nil, fmt.Errorf("missing secrets")
 	}
@@ -99,34 +105,44 @@ func parseCanonicalForm(r *http.Request, body []byte) (url.Values, bool) {
 }
 
 func (t *TwilioSignatureAuth) Authenticate(ctx context.Context, r *http.Request, params interface{}) bool {
+	return t.AuthenticateWithReason(ctx, r, params) == nil
+}
+
+// AuthenticateWithReason verifies the Twilio signature and explains failures.
+func (t *TwilioSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, params interface{}) error {
 	cfg, ok := params.(*twilioSigParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", params)
 	}
 	sig := r.Header.Get(cfg.Header)
 	if sig == "" {
-		return false
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s header", cfg.Header)
 	}
 	// Use the shared body cache so signature validation does not consume the
 	// request body before the proxy forwards it upstream.
 	body, err := authplugins.GetBody(r)
 	if err != nil {
-		return false
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
 	}
 	base := canonicalString(r, body)
+	loaded := false
 	for _, ref := range cfg.Secrets {
 		secret, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
+		loaded = true
 		mac := hmac.New(sha1.New, []byte(secret))
 		mac.Write([]byte(base))
 		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
 		if hmac.Equal([]byte(expected), []byte(sig)) {
-			return true
+			return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(t.Name(), cfg.Secrets), sig, "", 0)
 		}
 	}
-	return false
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
 }
 
 // StripAuth removes the Twilio signature header from the request.
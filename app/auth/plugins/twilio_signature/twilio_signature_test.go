@@ -217,7 +217,7 @@ func TestTwilioSignatureRequiredParams(t *testing.T) {
 
 func TestTwilioSignatureOptionalParams(t *testing.T) {
 	p := TwilioSignatureAuth{}
-	if got := p.OptionalParams(); len(got) != 1 || got[0] != "header" {
+	if got := p.OptionalParams(); len(got) != 4 || got[0] != "header" {
 		t.Fatalf("unexpected optional params %v", got)
 	}
 }
@@ -33,23 +33,33 @@ func (u *URLPathAuth) ParseParams(m map[string]interface{}) (interface{}, error)
 }
 
 func (u *URLPathAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return u.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason checks the path suffix and explains failures.
+func (u *URLPathAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
 	cfg, ok := p.(*inParams)
 	if !ok {
-		return false
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
 	}
+	loaded := false
 	for _, ref := range cfg.Secrets {
 		sec, err := secrets.LoadSecret(ctx, ref)
 		if err != nil {
 			continue
 		}
+		loaded = true
 		suffix := "/" + sec
 		if strings.HasSuffix(r.URL.Path, suffix) {
 			r.URL.Path = strings.TrimSuffix(r.URL.Path, suffix)
 			r.RequestURI = r.URL.RequestURI()
-			return true
+			return nil
 		}
 	}
-	return false
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no path secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "path does not end with a configured secret")
 }
 
 func init() { authplugins.RegisterIncoming(&URLPathAuth{}) }
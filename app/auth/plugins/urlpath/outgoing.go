@@ -50,4 +50,13 @@ func (u *URLPathAuthOut) AddAuth(ctx context.Context, r *http.Request, p interfa
 	return nil
 }
 
+// SecretRefs returns the secret references the credentials are loaded from.
+func (u *URLPathAuthOut) SecretRefs(params interface{}) []string {
+	cfg, ok := params.(*outParams)
+	if !ok {
+		return nil
+	}
+	return cfg.Secrets
+}
+
 func init() { authplugins.RegisterOutgoing(&URLPathAuthOut{}) }
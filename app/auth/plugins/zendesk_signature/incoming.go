@@ -0,0 +1,114 @@
+package zendesksignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+const (
+	signatureHeader = "X-Zendesk-Webhook-Signature"
+	timestampHeader = "X-Zendesk-Webhook-Signature-Timestamp"
+)
+
+// zendeskSigParams configures Zendesk webhook signature validation.
+// Tolerance is the maximum age of the signed timestamp in seconds.
+type zendeskSigParams struct {
+	authplugins.ReplayParams
+	Secrets   []string `json:"secrets"`
+	Tolerance int64    `json:"tolerance"`
+}
+
+// ZendeskSignatureAuth verifies the base64 HMAC-SHA256 of the signature
+// timestamp followed by the body, as sent with Zendesk webhooks.
+type ZendeskSignatureAuth struct{}
+
+func (z *ZendeskSignatureAuth) Name() string { return "zendesk_signature" }
+
+func (z *ZendeskSignatureAuth) RequiredParams() []string { return []string{"secrets"} }
+
+func (z *ZendeskSignatureAuth) OptionalParams() []string {
+	return append([]string{"tolerance"}, authplugins.ReplayParamNames...)
+}
+
+func (z *ZendeskSignatureAuth) ParseParams(m map[string]interface{}) (interface{}, error) {
+	p, err := authplugins.ParseParams[zendeskSigParams](m)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if len(p.Secrets) == 0 {
+		return nil, fmt.Errorf("missing secrets")
+	}
+	if p.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = 300
+	}
+	return p, nil
+}
+
+func (z *ZendeskSignatureAuth) Authenticate(ctx context.Context, r *http.Request, p interface{}) bool {
+	return z.AuthenticateWithReason(ctx, r, p) == nil
+}
+
+// AuthenticateWithReason verifies the Zendesk signature and explains failures.
+func (z *ZendeskSignatureAuth) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg, ok := p.(*zendeskSigParams)
+	if !ok {
+		return authplugins.Fail(authplugins.ReasonInternal, "unexpected params type %T", p)
+	}
+	sig := r.Header.Get(signatureHeader)
+	tsStr := r.Header.Get(timestampHeader)
+	if sig == "" || tsStr == "" {
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing signature or timestamp header")
+	}
+	ts, err := time.Parse(time.RFC3339, tsStr)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonMalformedCredential, Err: err}
+	}
+	if age := time.Since(ts); age > time.Duration(cfg.Tolerance)*time.Second || age < -time.Duration(cfg.Tolerance)*time.Second {
+		return authplugins.Fail(authplugins.ReasonExpired, "timestamp outside tolerance")
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return &authplugins.AuthError{Reason: authplugins.ReasonInternal, Err: err}
+	}
+	loaded := false
+	for _, ref := range cfg.Secrets {
+		secret, err := secrets.LoadSecret(ctx, ref)
+		if err != nil {
+			continue
+		}
+		loaded = true
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(tsStr))
+		mac.Write(body)
+		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			return cfg.CheckReplay(ctx, r, authplugins.ReplayScope(z.Name(), cfg.Secrets), sig, tsStr, authplugins.ToleranceTTL(ts.Unix(), cfg.Tolerance))
+		}
+	}
+	if !loaded {
+		return authplugins.Fail(authplugins.ReasonUnavailable, "no signing secrets could be loaded")
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "signature does not match")
+}
+
+// StripAuth removes the Zendesk signature headers from the request.
+func (z *ZendeskSignatureAuth) StripAuth(r *http.Request, p interface{}) {
+	r.Header.Del(signatureHeader)
+	r.Header.Del(timestampHeader)
+}
+
+func init() { authplugins.RegisterIncoming(&ZendeskSignatureAuth{}) }
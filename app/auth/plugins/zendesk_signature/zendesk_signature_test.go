@@ -0,0 +1,84 @@
+package zendesksignature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+const body = `{"ticket":1}`
+
+func newRequest(secret string, ts time.Time) *http.Request {
+	stamp := ts.UTC().Format(time.RFC3339)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stamp + body))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set(signatureHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	r.Header.Set(timestampHeader, stamp)
+	return r
+}
+
+func TestZendeskSignature(t *testing.T) {
+	p := &ZendeskSignatureAuth{}
+	t.Setenv("ZD_OLD", "old")
+	t.Setenv("ZD_NEW", "new")
+	cfg, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:ZD_OLD", "env:ZD_NEW"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRequest("new", time.Now())
+	if !p.Authenticate(context.Background(), r, cfg) {
+		t.Fatal("expected rotated secret to authenticate")
+	}
+	p.StripAuth(r, cfg)
+	if r.Header.Get(signatureHeader) != "" || r.Header.Get(timestampHeader) != "" {
+		t.Fatal("expected headers to be stripped")
+	}
+
+	bad := newRequest("old", time.Now())
+	bad.Header.Set(timestampHeader, "yesterday")
+	missing := newRequest("old", time.Now())
+	missing.Header.Del(signatureHeader)
+	cases := []struct {
+		name string
+		r    *http.Request
+		want authplugins.Reason
+	}{
+		{"missing", missing, authplugins.ReasonMissingCredential},
+		{"bad timestamp", bad, authplugins.ReasonMalformedCredential},
+		{"stale", newRequest("old", time.Now().Add(-10*time.Minute)), authplugins.ReasonExpired},
+		{"wrong secret", newRequest("nope", time.Now()), authplugins.ReasonInvalidCredential},
+	}
+	for _, c := range cases {
+		if got := authplugins.FailureReason(p.AuthenticateWithReason(context.Background(), c.r, cfg)); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestZendeskSignatureParams(t *testing.T) {
+	p := &ZendeskSignatureAuth{}
+	if _, err := p.ParseParams(map[string]interface{}{}); err == nil {
+		t.Fatal("expected missing secrets to fail")
+	}
+	if _, err := p.ParseParams(map[string]interface{}{"secrets": []interface{}{"env:X"}, "tolerance": -5}); err == nil {
+		t.Fatal("expected negative tolerance to fail")
+	}
+	if !slices.Equal(p.RequiredParams(), []string{"secrets"}) || !slices.Equal(p.OptionalParams(), []string{"tolerance", "replay_protection", "nonce_header", "replay_window"}) {
+		t.Fatal("unexpected params lists")
+	}
+	if p.Authenticate(context.Background(), newRequest("x", time.Now()), struct{}{}) {
+		t.Fatal("expected failure with wrong params type")
+	}
+}
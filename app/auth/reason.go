@@ -0,0 +1,106 @@
+package authplugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Reason is a coarse category describing why an incoming request failed
+// authentication. Reasons are used as metric labels so the set is fixed.
+type Reason string
+
+const (
+	// ReasonMissingCredential means the request carried no credential for
+	// the plugin.
+	ReasonMissingCredential Reason = "missing_credential"
+	// ReasonMalformedCredential means a credential was present but could not
+	// be parsed.
+	ReasonMalformedCredential Reason = "malformed_credential"
+	// ReasonInvalidCredential means the credential did not match any
+	// configured secret, key or signature.
+	ReasonInvalidCredential Reason = "invalid_credential"
+	// ReasonExpired means the credential or signed timestamp is outside its
+	// validity window.
+	ReasonExpired Reason = "expired"
+	// ReasonNotAllowed means the credential is valid but its identity,
+	// audience or issuer is not accepted by the configuration.
+	ReasonNotAllowed Reason = "not_allowed"
+	// ReasonReplayed means a validly signed request was already seen within
+	// its replay window.
+	ReasonReplayed Reason = "replayed"
+	// ReasonUnavailable means a secret, key set or remote verifier could not
+	// be reached.
+	ReasonUnavailable Reason = "unavailable"
+	// ReasonInternal means the plugin could not evaluate the request, for
+	// example because the body could not be read.
+	ReasonInternal Reason = "internal_error"
+	// ReasonUnknown is reported for plugins that do not explain failures.
+	ReasonUnknown Reason = "unknown"
+)
+
+var knownReasons = map[Reason]struct{}{
+	ReasonMissingCredential:   {},
+	ReasonMalformedCredential: {},
+	ReasonInvalidCredential:   {},
+	ReasonExpired:             {},
+	ReasonNotAllowed:          {},
+	ReasonReplayed:            {},
+	ReasonUnavailable:         {},
+	ReasonInternal:            {},
+	ReasonUnknown:             {},
+}
+
+// AuthError describes an authentication failure. Reason is safe to use as a
+// metric label; Err carries detail for logs and must not be returned to the
+// caller.
+type AuthError struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *AuthError) Error() string {
+	if e.Err == nil {
+		return string(e.Reason)
+	}
+	return string(e.Reason) + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// Fail returns an *AuthError with the given reason and formatted detail.
+func Fail(reason Reason, format string, args ...interface{}) error {
+	return &AuthError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// ReasonAuthenticator is optionally implemented by incoming auth plugins that
+// can explain why authentication failed. A nil error means the request is
+// authenticated.
+type ReasonAuthenticator interface {
+	AuthenticateWithReason(ctx context.Context, r *http.Request, params interface{}) error
+}
+
+// Authenticate runs p against r, preferring AuthenticateWithReason when the
+// plugin implements it. Plugins that only return a bool report ReasonUnknown.
+func Authenticate(ctx context.Context, p IncomingAuthPlugin, r *http.Request, params interface{}) error {
+	if ra, ok := p.(ReasonAuthenticator); ok {
+		return ra.AuthenticateWithReason(ctx, r, params)
+	}
+	if p.Authenticate(ctx, r, params) {
+		return nil
+	}
+	return &AuthError{Reason: ReasonUnknown, Err: errors.New("authentication failed")}
+}
+
+// FailureReason returns the bounded reason for err. Errors that are not an
+// *AuthError, or that carry an unrecognised reason, map to ReasonUnknown.
+func FailureReason(err error) Reason {
+	var ae *AuthError
+	if errors.As(err, &ae) {
+		if _, ok := knownReasons[ae.Reason]; ok {
+			return ae.Reason
+		}
+	}
+	return ReasonUnknown
+}
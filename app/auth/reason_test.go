@@ -0,0 +1,65 @@
+package authplugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type reasonIncoming struct {
+	testIncoming
+	err error
+}
+
+func (p reasonIncoming) AuthenticateWithReason(context.Context, *http.Request, interface{}) error {
+	return p.err
+}
+
+type rejectIncoming struct{ testIncoming }
+
+func (rejectIncoming) Authenticate(context.Context, *http.Request, interface{}) bool { return false }
+
+func TestAuthenticatePrefersReason(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	want := Fail(ReasonExpired, "token expired at %d", 1)
+	err := Authenticate(context.Background(), reasonIncoming{err: want}, r, nil)
+	if !errors.Is(err, want) || FailureReason(err) != ReasonExpired {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err.Error() != "expired: token expired at 1" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+	if err := Authenticate(context.Background(), reasonIncoming{}, r, nil); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+}
+
+func TestAuthenticateBoolFallback(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := Authenticate(context.Background(), testIncoming{}, r, nil); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	err := Authenticate(context.Background(), rejectIncoming{}, r, nil)
+	if FailureReason(err) != ReasonUnknown {
+		t.Fatalf("expected unknown reason, got %v", err)
+	}
+}
+
+func TestFailureReasonBounded(t *testing.T) {
+	if got := FailureReason(errors.New("plain")); got != ReasonUnknown {
+		t.Fatalf("expected unknown for plain error, got %s", got)
+	}
+	if got := FailureReason(&AuthError{Reason: "made_up"}); got != ReasonUnknown {
+		t.Fatalf("expected unknown for unrecognised reason, got %s", got)
+	}
+	wrapped := fmt.Errorf("plugin: %w", &AuthError{Reason: ReasonNotAllowed})
+	if got := FailureReason(wrapped); got != ReasonNotAllowed {
+		t.Fatalf("expected wrapped reason, got %s", got)
+	}
+	if (&AuthError{Reason: ReasonMissingCredential}).Error() != "missing_credential" {
+		t.Fatal("unexpected message without detail")
+	}
+}
@@ -22,6 +22,14 @@ type Identifier interface {
 	Identify(r *http.Request, params interface{}) (string, bool)
 }
 
+// BearerTokenSource is optionally implemented by incoming auth plugins that
+// verify a token the caller presents, such as a JWT or an OAuth access token.
+// BearerToken returns the token in r that the plugin checked; the proxy
+// records it for outgoing plugins that exchange the caller's credential.
+type BearerTokenSource interface {
+	BearerToken(r *http.Request, params interface{}) (string, bool)
+}
+
 // AuthStripper is optionally implemented by incoming auth plugins that wish to
 // remove authentication data from the request after it has been verified.
 // The proxy calls this after calling Identify.
@@ -38,6 +46,32 @@ type OutgoingAuthPlugin interface {
 	OptionalParams() []string
 }
 
+// RequestSigner is optionally implemented by outgoing auth plugins whose
+// credential covers the final request, such as message signatures. The proxy
+// runs plugins that report true after all other outgoing plugins so the
+// signature sees every header and body change they make.
+type RequestSigner interface {
+	SignsRequest() bool
+}
+
+// CredentialInvalidator is optionally implemented by outgoing auth plugins
+// that cache credentials. When the upstream answers 401, or 403 for
+// integrations that opt in, the proxy passes the rejected outgoing request to
+// Invalidate so the plugin can drop the credential it carried and fetch a
+// fresh one for the next request.
+type CredentialInvalidator interface {
+	Invalidate(r *http.Request, params interface{})
+}
+
+// SecretReferrer is optionally implemented by outgoing auth plugins that load
+// credentials from secret references. When the upstream rejects the
+// credentials the proxy reloads the references SecretRefs returns for params
+// so a rotated secret is picked up before -secret-refresh expires. Empty
+// strings are ignored.
+type SecretReferrer interface {
+	SecretRefs(params interface{}) []string
+}
+
 var incomingRegistry = map[string]IncomingAuthPlugin{}
 var outgoingRegistry = map[string]OutgoingAuthPlugin{}
 
@@ -0,0 +1,174 @@
+package authplugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayStore remembers nonces of verified requests so signature plugins can
+// reject replays.
+type ReplayStore interface {
+	// Seen records key for ttl and reports whether it was already recorded.
+	// Implementations must check and record atomically.
+	Seen(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// maxReplayEntries bounds the number of nonces held by a MemoryReplayStore.
+const maxReplayEntries = 100000
+
+// MemoryReplayStore is a process-local ReplayStore.
+type MemoryReplayStore struct {
+	mu sync.Mutex
+	m  map[string]time.Time
+}
+
+// NewMemoryReplayStore returns an empty in-memory replay store.
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{m: make(map[string]time.Time)}
+}
+
+// Seen implements ReplayStore.
+func (s *MemoryReplayStore) Seen(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if exp, ok := s.m[key]; ok && now.Before(exp) {
+		return true, nil
+	}
+	if len(s.m) >= maxReplayEntries {
+		for k, exp := range s.m {
+			if !now.Before(exp) {
+				delete(s.m, k)
+			}
+		}
+		if len(s.m) >= maxReplayEntries {
+			return false, fmt.Errorf("replay store full")
+		}
+	}
+	s.m[key] = now.Add(ttl)
+	return false, nil
+}
+
+var (
+	replayMu    sync.RWMutex
+	replayStore ReplayStore = NewMemoryReplayStore()
+)
+
+// SetReplayStore sets the store shared by plugins with replay protection and
+// returns the previous one. A nil store restores a fresh in-memory store.
+func SetReplayStore(s ReplayStore) ReplayStore {
+	if s == nil {
+		s = NewMemoryReplayStore()
+	}
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	prev := replayStore
+	replayStore = s
+	return prev
+}
+
+func currentReplayStore() ReplayStore {
+	replayMu.RLock()
+	defer replayMu.RUnlock()
+	return replayStore
+}
+
+// DefaultReplayWindow is how long nonces are remembered, in seconds, by
+// plugins whose signatures carry no timestamp.
+const DefaultReplayWindow = 300
+
+// ReplayParams is embedded in the params of signature plugins that support
+// replay protection. The nonce is derived from the verified signature and
+// timestamp; when NonceHeader is set its value must be unused too. The header
+// is usually not covered by the signature, so it can only add rejections,
+// never let a replayed signature through. ReplayWindow only applies to plugins
+// without a signed timestamp; the others remember nonces for as long as the
+// timestamp stays within tolerance.
+type ReplayParams struct {
+	ReplayProtection bool   `json:"replay_protection"`
+	NonceHeader      string `json:"nonce_header"`
+	ReplayWindow     int64  `json:"replay_window"`
+}
+
+// ReplayParamNames lists the optional params contributed by ReplayParams.
+var ReplayParamNames = []string{"replay_protection", "nonce_header", "replay_window"}
+
+// Validate checks the replay params and applies defaults.
+func (p *ReplayParams) Validate() error {
+	if p.ReplayWindow < 0 {
+		return fmt.Errorf("replay_window must not be negative")
+	}
+	if p.ReplayWindow == 0 {
+		p.ReplayWindow = DefaultReplayWindow
+	}
+	return nil
+}
+
+// CheckReplay rejects r with ReasonReplayed when its nonce was already seen
+// within ttl. It must only be called once the signature has been verified so
+// forged requests cannot burn genuine nonces. scope separates nonces of
+// different plugins and secret sets; sig and ts are the verified signature
+// and timestamp the nonce is derived from. Plugins accepting several
+// signatures per request pass something all of them cover instead of the
+// one that matched. When a nonce header is configured
+// its value is checked as well. A non-positive ttl falls back to
+// ReplayWindow.
+func (p *ReplayParams) CheckReplay(ctx context.Context, r *http.Request, scope, sig, ts string, ttl time.Duration) error {
+	if !p.ReplayProtection {
+		return nil
+	}
+	var header string
+	if p.NonceHeader != "" {
+		header = r.Header.Get(p.NonceHeader)
+		if header == "" {
+			return Fail(ReasonMissingCredential, "missing %s header", p.NonceHeader)
+		}
+	}
+	if ttl <= 0 {
+		ttl = time.Duration(p.ReplayWindow) * time.Second
+	}
+	if err := CheckNonce(ctx, scope, sig+"\x00"+ts, ttl); err != nil {
+		return err
+	}
+	if header == "" {
+		return nil
+	}
+	return CheckNonce(ctx, scope, http.CanonicalHeaderKey(p.NonceHeader)+"\x00"+header, ttl)
+}
+
+// CheckNonce records nonce within scope in the shared replay store for ttl
+// and fails with ReasonReplayed when it was already recorded.
+func CheckNonce(ctx context.Context, scope, nonce string, ttl time.Duration) error {
+	sum := sha256.Sum256([]byte(scope + "\x00" + nonce))
+	seen, err := currentReplayStore().Seen(ctx, hex.EncodeToString(sum[:]), ttl)
+	if err != nil {
+		return &AuthError{Reason: ReasonUnavailable, Err: fmt.Errorf("replay store: %w", err)}
+	}
+	if seen {
+		return Fail(ReasonReplayed, "nonce already used")
+	}
+	return nil
+}
+
+// ReplayScope builds a CheckReplay scope from a plugin name and its secret
+// references, so integrations sharing a secret also share nonces.
+func ReplayScope(plugin string, refs []string) string {
+	return plugin + "\x00" + strings.Join(refs, "\x00")
+}
+
+// ToleranceTTL returns how long a nonce for a request signed at unix time ts
+// must be remembered so it cannot be replayed while ts is within tolerance
+// seconds of the current time.
+func ToleranceTTL(ts, tolerance int64) time.Duration {
+	ttl := time.Until(time.Unix(ts+tolerance, 0)) + time.Second
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
@@ -0,0 +1,121 @@
+package authplugins
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type failingReplayStore struct{}
+
+func (failingReplayStore) Seen(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("down")
+}
+
+func TestMemoryReplayStore(t *testing.T) {
+	s := NewMemoryReplayStore()
+	ctx := context.Background()
+	if seen, err := s.Seen(ctx, "a", time.Minute); err != nil || seen {
+		t.Fatalf("expected first use to be unseen, got %v %v", seen, err)
+	}
+	if seen, _ := s.Seen(ctx, "a", time.Minute); !seen {
+		t.Fatal("expected second use to be seen")
+	}
+	if seen, _ := s.Seen(ctx, "b", -time.Second); seen {
+		t.Fatal("expected new key to be unseen")
+	}
+	if seen, _ := s.Seen(ctx, "b", time.Minute); seen {
+		t.Fatal("expected expired key to be unseen")
+	}
+}
+
+func TestMemoryReplayStoreFull(t *testing.T) {
+	s := NewMemoryReplayStore()
+	for i := 0; i < maxReplayEntries; i++ {
+		s.m[strconv.Itoa(i)] = time.Now().Add(time.Minute)
+	}
+	if _, err := s.Seen(context.Background(), "new", time.Minute); err == nil {
+		t.Fatal("expected full store to fail closed")
+	}
+	for k := range s.m {
+		s.m[k] = time.Now().Add(-time.Second)
+	}
+	if _, err := s.Seen(context.Background(), "new", time.Minute); err != nil {
+		t.Fatalf("expected expired entries to be evicted: %v", err)
+	}
+}
+
+func TestCheckReplay(t *testing.T) {
+	prev := SetReplayStore(nil)
+	defer SetReplayStore(prev)
+	ctx := context.Background()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	off := &ReplayParams{}
+	if err := off.CheckReplay(ctx, r, "s", "sig", "1", time.Minute); err != nil {
+		t.Fatalf("expected disabled protection to pass: %v", err)
+	}
+	if err := off.CheckReplay(ctx, r, "s", "sig", "1", time.Minute); err != nil {
+		t.Fatalf("expected disabled protection to pass twice: %v", err)
+	}
+
+	p := &ReplayParams{ReplayProtection: true}
+	if err := p.Validate(); err != nil || p.ReplayWindow != DefaultReplayWindow {
+		t.Fatalf("unexpected defaults %+v %v", p, err)
+	}
+	if err := p.CheckReplay(ctx, r, "s", "sig", "1", 0); err != nil {
+		t.Fatalf("expected first request to pass: %v", err)
+	}
+	if got := FailureReason(p.CheckReplay(ctx, r, "s", "sig", "1", 0)); got != ReasonReplayed {
+		t.Fatalf("expected replayed, got %s", got)
+	}
+	if err := p.CheckReplay(ctx, r, "other", "sig", "1", 0); err != nil {
+		t.Fatalf("expected other scope to pass: %v", err)
+	}
+	if err := p.CheckReplay(ctx, r, "s", "sig", "2", 0); err != nil {
+		t.Fatalf("expected new timestamp to pass: %v", err)
+	}
+
+	h := &ReplayParams{ReplayProtection: true, NonceHeader: "X-Delivery"}
+	if got := FailureReason(h.CheckReplay(ctx, r, "s", "sig", "", time.Minute)); got != ReasonMissingCredential {
+		t.Fatalf("expected missing nonce header to fail, got %s", got)
+	}
+	r.Header.Set("X-Delivery", "d1")
+	if err := h.CheckReplay(ctx, r, "s", "sig-a", "", time.Minute); err != nil {
+		t.Fatalf("expected first delivery to pass: %v", err)
+	}
+	if got := FailureReason(h.CheckReplay(ctx, r, "s", "sig-b", "", time.Minute)); got != ReasonReplayed {
+		t.Fatalf("expected same delivery ID to be replayed, got %s", got)
+	}
+	// The header is not signed: a captured request resent with a fresh
+	// delivery ID still carries a used signature.
+	r.Header.Set("X-Delivery", "d2")
+	if got := FailureReason(h.CheckReplay(ctx, r, "s", "sig-a", "", time.Minute)); got != ReasonReplayed {
+		t.Fatalf("expected replay with a changed header to fail, got %s", got)
+	}
+
+	SetReplayStore(failingReplayStore{})
+	if got := FailureReason(p.CheckReplay(ctx, r, "s", "sig", "3", 0)); got != ReasonUnavailable {
+		t.Fatalf("expected unavailable store error, got %s", got)
+	}
+}
+
+func TestReplayParamsValidate(t *testing.T) {
+	if err := (&ReplayParams{ReplayWindow: -1}).Validate(); err == nil {
+		t.Fatal("expected negative replay_window to fail")
+	}
+}
+
+func TestToleranceTTL(t *testing.T) {
+	now := time.Now().Unix()
+	if ttl := ToleranceTTL(now, 300); ttl < 299*time.Second || ttl > 302*time.Second {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+	if ttl := ToleranceTTL(now-1000, 300); ttl != time.Second {
+		t.Fatalf("expected minimum ttl, got %v", ttl)
+	}
+}
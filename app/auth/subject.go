@@ -0,0 +1,47 @@
+package authplugins
+
+import (
+	"context"
+	"sync"
+)
+
+type subjectTokenKey struct{}
+
+type subjectToken struct {
+	mu  sync.Mutex
+	tok string
+}
+
+// WithSubjectToken returns a context that can hold the caller's token. The
+// proxy calls it before incoming auth runs; the first plugin implementing
+// BearerTokenSource that verifies the request records the token it checked
+// with SetSubjectToken, so outgoing plugins can act on behalf of the caller
+// even after an AuthStripper removed the credential.
+func WithSubjectToken(ctx context.Context) context.Context {
+	return context.WithValue(ctx, subjectTokenKey{}, &subjectToken{})
+}
+
+// SetSubjectToken records tok as the caller's verified token unless one was
+// already recorded for ctx.
+func SetSubjectToken(ctx context.Context, tok string) {
+	s, _ := ctx.Value(subjectTokenKey{}).(*subjectToken)
+	if s == nil || tok == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok == "" {
+		s.tok = tok
+	}
+}
+
+// SubjectToken returns the caller's token recorded with SetSubjectToken.
+func SubjectToken(ctx context.Context) (string, bool) {
+	s, _ := ctx.Value(subjectTokenKey{}).(*subjectToken)
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tok, s.tok != ""
+}
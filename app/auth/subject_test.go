@@ -0,0 +1,24 @@
+package authplugins
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSubjectToken(t *testing.T) {
+	if _, ok := SubjectToken(context.Background()); ok {
+		t.Fatal("expected no token without WithSubjectToken")
+	}
+	SetSubjectToken(context.Background(), "ignored")
+
+	ctx := WithSubjectToken(context.Background())
+	if _, ok := SubjectToken(ctx); ok {
+		t.Fatal("expected no token before one is set")
+	}
+	SetSubjectToken(ctx, "")
+	SetSubjectToken(ctx, "first")
+	SetSubjectToken(ctx, "second")
+	if got, ok := SubjectToken(ctx); !ok || got != "first" {
+		t.Fatalf("expected the first verified token, got %q %v", got, ok)
+	}
+}
@@ -0,0 +1,126 @@
+package authplugins
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenRefreshSkew is how long before expiry a cached token is refreshed so
+// requests never carry a token that expires in flight. Tokens living less
+// than twice as long are refreshed halfway through their lifetime instead.
+var TokenRefreshSkew = time.Minute
+
+// Token is an access token fetched by an outgoing plugin.
+type Token struct {
+	Value  string
+	Expiry time.Time
+}
+
+// valid reports whether t, fetched at fetched, can still be used at now.
+func (t Token) valid(now, fetched time.Time) bool {
+	skew := min(TokenRefreshSkew, t.Expiry.Sub(fetched)/2)
+	return t.Value != "" && now.Before(t.Expiry.Add(-skew))
+}
+
+// tokenSweepInterval is how often a TokenCache drops expired entries.
+const tokenSweepInterval = time.Minute
+
+// TokenCache caches tokens by key for outgoing plugins. Concurrent callers
+// that miss the cache for the same key share a single fetch. Expired entries
+// are swept as new keys are added so caches keyed per caller stay bounded.
+type TokenCache struct {
+	mu      sync.Mutex
+	entries map[string]*tokenEntry
+	swept   time.Time
+}
+
+type tokenEntry struct {
+	fetchMu sync.Mutex
+	mu      sync.Mutex
+	tok     Token
+	fetched time.Time
+}
+
+// NewTokenCache returns an empty token cache.
+func NewTokenCache() *TokenCache {
+	return &TokenCache{entries: make(map[string]*tokenEntry)}
+}
+
+func (c *TokenCache) entry(key string) *tokenEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.sweep(time.Now())
+		e = &tokenEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// sweep drops entries whose token has expired and that no caller is
+// fetching. c.mu must be held.
+func (c *TokenCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < tokenSweepInterval {
+		return
+	}
+	c.swept = now
+	for k, e := range c.entries {
+		if !e.fetchMu.TryLock() {
+			continue
+		}
+		if tok, _ := e.current(); !now.Before(tok.Expiry) {
+			delete(c.entries, k)
+		}
+		e.fetchMu.Unlock()
+	}
+}
+
+// current returns the cached token and when its fetch started.
+func (e *tokenEntry) current() (Token, time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.tok, e.fetched
+}
+
+// Get returns the cached token for key, calling fetch when there is none or
+// it is about to expire. The fetch runs without the caller's cancellation so
+// one abandoned request does not fail every request waiting on it.
+func (c *TokenCache) Get(ctx context.Context, key string, fetch func(context.Context) (Token, error)) (Token, error) {
+	e := c.entry(key)
+	if tok, fetched := e.current(); tok.valid(time.Now(), fetched) {
+		return tok, nil
+	}
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+	if tok, fetched := e.current(); tok.valid(time.Now(), fetched) {
+		return tok, nil
+	}
+	start := time.Now()
+	tok, err := fetch(context.WithoutCancel(ctx))
+	if err != nil {
+		return Token{}, err
+	}
+	e.mu.Lock()
+	e.tok = tok
+	e.fetched = start
+	e.mu.Unlock()
+	return tok, nil
+}
+
+// Invalidate drops the cached token for key if it is still value, so a burst
+// of rejections for one token triggers a single refresh.
+func (c *TokenCache) Invalidate(key, value string) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	if e.tok.Value == value {
+		e.tok = Token{}
+	}
+	e.mu.Unlock()
+}
@@ -0,0 +1,122 @@
+package authplugins
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheGet(t *testing.T) {
+	c := NewTokenCache()
+	var calls atomic.Int32
+	fetch := func(context.Context) (Token, error) {
+		n := calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return Token{Value: "tok" + string(rune('0'+n)), Expiry: time.Now().Add(time.Hour)}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tok, err := c.Get(context.Background(), "k", fetch); err != nil || tok.Value != "tok1" {
+				t.Errorf("unexpected token %v %v", tok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("expected one fetch, got %d", calls.Load())
+	}
+}
+
+func TestTokenCacheRefreshesNearExpiry(t *testing.T) {
+	c := NewTokenCache()
+	n := 0
+	fetch := func(context.Context) (Token, error) {
+		n++
+		return Token{Value: "tok", Expiry: time.Now().Add(100 * time.Millisecond)}, nil
+	}
+	c.Get(context.Background(), "k", fetch)
+	time.Sleep(60 * time.Millisecond)
+	c.Get(context.Background(), "k", fetch)
+	if n != 2 {
+		t.Fatalf("expected token past half its lifetime to be refetched, got %d fetches", n)
+	}
+}
+
+func TestTokenCacheShortLivedToken(t *testing.T) {
+	c := NewTokenCache()
+	n := 0
+	fetch := func(context.Context) (Token, error) {
+		n++
+		return Token{Value: "tok", Expiry: time.Now().Add(30 * time.Second)}, nil
+	}
+	for i := 0; i < 3; i++ {
+		c.Get(context.Background(), "k", fetch)
+	}
+	if n != 1 {
+		t.Fatalf("expected token shorter than the skew to be cached, got %d fetches", n)
+	}
+}
+
+func TestTokenCacheInvalidate(t *testing.T) {
+	c := NewTokenCache()
+	n := 0
+	fetch := func(context.Context) (Token, error) {
+		n++
+		return Token{Value: "tok" + string(rune('0'+n)), Expiry: time.Now().Add(time.Hour)}, nil
+	}
+	c.Get(context.Background(), "k", fetch)
+	c.Invalidate("k", "other")
+	if tok, _ := c.Get(context.Background(), "k", fetch); tok.Value != "tok1" {
+		t.Fatalf("expected stale invalidation to be ignored, got %s", tok.Value)
+	}
+	c.Invalidate("k", "tok1")
+	if tok, _ := c.Get(context.Background(), "k", fetch); tok.Value != "tok2" {
+		t.Fatalf("expected refetch after invalidation, got %s", tok.Value)
+	}
+	c.Invalidate("missing", "x")
+}
+
+func TestTokenCacheFetchError(t *testing.T) {
+	c := NewTokenCache()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Get(ctx, "k", func(ctx context.Context) (Token, error) {
+		if ctx.Err() != nil {
+			t.Fatal("fetch should not inherit caller cancellation")
+		}
+		return Token{}, errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected fetch error")
+	}
+}
+
+func TestTokenCacheSweepsExpiredEntries(t *testing.T) {
+	c := NewTokenCache()
+	expired := func(context.Context) (Token, error) {
+		return Token{Value: "old", Expiry: time.Now().Add(-time.Second)}, nil
+	}
+	fresh := func(context.Context) (Token, error) {
+		return Token{Value: "new", Expiry: time.Now().Add(time.Hour)}, nil
+	}
+	c.Get(context.Background(), "expired", expired)
+	c.Get(context.Background(), "fresh", fresh)
+	c.mu.Lock()
+	c.swept = time.Time{}
+	c.mu.Unlock()
+	c.Get(context.Background(), "another", fresh)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries["expired"]; ok {
+		t.Fatal("expected expired entry to be swept")
+	}
+	if _, ok := c.entries["fresh"]; !ok {
+		t.Fatal("expected fresh entry to be kept")
+	}
+}
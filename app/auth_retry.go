@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/metrics"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// authRetry holds a request as it was before outgoing auth ran so it can be
+// sent again with fresh credentials.
+type authRetry struct {
+	req  *http.Request
+	body []byte
+
+	// handled is set once the transport has processed a rejection of the
+	// first attempt so ModifyResponse does not process it twice.
+	handled bool
+}
+
+type authRetryKey struct{}
+
+// authRetryHandled reports whether the rejection of the request carrying ctx
+// was already processed by authRetryTransport.
+func authRetryHandled(ctx context.Context) bool {
+	retry, _ := ctx.Value(authRetryKey{}).(*authRetry)
+	return retry != nil && retry.handled
+}
+
+// retryableMethod reports whether requests with method may be sent twice.
+func retryableMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// withAuthRetry records r, which has not had outgoing auth applied yet, so
+// authRetryTransport can resend it once if the upstream rejects the
+// credentials. Requests that are not idempotent, upgrade the connection or
+// have a body too large to buffer are returned unchanged.
+func withAuthRetry(integ *Integration, r *http.Request) *http.Request {
+	if !integ.RetryOnAuthFailure || !retryableMethod(r.Method) || r.Header.Get("Upgrade") != "" {
+		return r
+	}
+	body, err := authplugins.GetBody(r)
+	if err != nil {
+		return r
+	}
+	retry := &authRetry{req: r.Clone(r.Context()), body: body}
+	return r.WithContext(context.WithValue(r.Context(), authRetryKey{}, retry))
+}
+
+// hopHeaders are removed by httputil.ReverseProxy before forwarding, so they
+// are removed from retried requests too.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// authRetryTransport resends requests recorded by withAuthRetry once when the
+// upstream rejects their credentials, after dropping them.
+type authRetryTransport struct {
+	integ *Integration
+	base  http.RoundTripper
+}
+
+func (t *authRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !t.integ.rejectsAuth(resp.StatusCode) {
+		return resp, err
+	}
+	retry, _ := req.Context().Value(authRetryKey{}).(*authRetry)
+	if retry == nil {
+		return resp, nil
+	}
+	retry.handled = true
+	invalidateOutgoingAuth(t.integ, req)
+
+	next, err := t.retryRequest(req, retry)
+	if err != nil {
+		logger.Warn("outgoing auth retry failed", "integration", t.integ.Name, "error", err)
+		return resp, nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	metrics.IncAuthRetry(t.integ.Name)
+	return t.base.RoundTrip(next)
+}
+
+// retryRequest rebuilds the rejected request sent from the recorded original
+// and applies outgoing auth to it again.
+func (t *authRetryTransport) retryRequest(sent *http.Request, retry *authRetry) (*http.Request, error) {
+	ctx := context.WithValue(sent.Context(), authRetryKey{}, (*authRetry)(nil))
+	ctx = secrets.WithUsage(ctx)
+	next := retry.req.Clone(ctx)
+	if err := authplugins.SetBody(next, retry.body); err != nil {
+		return nil, err
+	}
+	if _, err := applyOutgoingAuth(t.integ, next); err != nil {
+		return nil, err
+	}
+	if next.ContentLength == 0 {
+		next.Body = nil
+	}
+
+	// Mirror the header changes ReverseProxy made to the first attempt.
+	for _, f := range next.Header.Values("Connection") {
+		for _, h := range strings.Split(f, ",") {
+			if h = textproto.TrimString(h); h != "" {
+				next.Header.Del(h)
+			}
+		}
+	}
+	for _, h := range append(hopHeaders, "X-Forwarded-For") {
+		if v, ok := sent.Header[h]; ok {
+			next.Header[h] = v
+		} else {
+			next.Header.Del(h)
+		}
+	}
+	return next, nil
+}
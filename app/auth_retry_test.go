@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/metrics"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// tokenBackend accepts requests whose Authorization is "Bearer " + valid,
+// rejects the others with status (401 when unset) and records every attempt.
+type tokenBackend struct {
+	mu       sync.Mutex
+	valid    string
+	status   int
+	attempts []string
+	body     string
+}
+
+func (b *tokenBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempts = append(b.attempts, r.Header.Get("Authorization"))
+	if r.Header.Get("Authorization") != "Bearer "+b.valid {
+		if b.status == 0 {
+			b.status = http.StatusUnauthorized
+		}
+		w.WriteHeader(b.status)
+		return
+	}
+	b.body = string(body)
+}
+
+func (b *tokenBackend) reset() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	got := b.attempts
+	b.attempts = nil
+	return got
+}
+
+func addTokenIntegration(t *testing.T, name, url string, refs []string, retry bool) {
+	t.Helper()
+	authplugins.RegisterIncoming(headerAuthPlugin{})
+	integ := Integration{
+		Name:               name,
+		Destination:        url,
+		InRateLimit:        100,
+		OutRateLimit:       100,
+		RetryOnAuthFailure: retry,
+		IncomingAuth: []AuthPluginConfig{
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Team", "value": "a", "id": "team-a"}},
+		},
+		OutgoingAuth: []AuthPluginConfig{{
+			Type:   "token",
+			Params: map[string]interface{}{"secrets": refs, "header": "Authorization", "prefix": "Bearer "},
+		}},
+	}
+	if err := AddIntegration(&integ); err != nil {
+		t.Fatalf("failed to add integration: %v", err)
+	}
+	t.Cleanup(func() { DeleteIntegration(name) })
+}
+
+func sendTo(host, method, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://"+host+"/", strings.NewReader(body))
+	req.Host = host
+	req.Header.Set("X-Team", "a")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	return rr
+}
+
+func TestUpstreamRejectionReloadsSecrets(t *testing.T) {
+	secrets.ClearCache()
+	t.Cleanup(secrets.ClearCache)
+	backend := &tokenBackend{valid: "new"}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	addTokenIntegration(t, "reload-secret", srv.URL, []string{"env:RELOAD_TOKEN"}, false)
+
+	t.Setenv("RELOAD_TOKEN", "old")
+	if rr := sendTo("reload-secret", http.MethodGet, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	t.Setenv("RELOAD_TOKEN", "new")
+	if rr := sendTo("reload-secret", http.MethodGet, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected rotated secret to be reloaded, got %d", rr.Code)
+	}
+	if got := backend.reset(); len(got) != 2 {
+		t.Fatalf("expected no retries, got %v", got)
+	}
+}
+
+func TestUpstreamForbiddenKeepsSecrets(t *testing.T) {
+	secrets.ClearCache()
+	t.Cleanup(secrets.ClearCache)
+	backend := &tokenBackend{valid: "never", status: http.StatusForbidden}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	addTokenIntegration(t, "forbidden-secret", srv.URL, []string{"env:FORBIDDEN_TOKEN"}, true)
+
+	t.Setenv("FORBIDDEN_TOKEN", "cached")
+	for i := 0; i < 3; i++ {
+		if rr := sendTo("forbidden-secret", http.MethodGet, ""); rr.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rr.Code)
+		}
+		t.Setenv("FORBIDDEN_TOKEN", "reloaded")
+	}
+	for _, got := range backend.reset() {
+		if got != "Bearer cached" {
+			t.Fatalf("expected 403s to neither reload nor retry, got %q", got)
+		}
+	}
+}
+
+func TestUpstreamRejectionReloadsSecretOncePerInterval(t *testing.T) {
+	secrets.ClearCache()
+	t.Cleanup(secrets.ClearCache)
+	backend := &tokenBackend{valid: "never"}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	addTokenIntegration(t, "debounce-secret", srv.URL, []string{"env:DEBOUNCE_TOKEN"}, false)
+
+	for _, v := range []string{"a", "b", "c"} {
+		t.Setenv("DEBOUNCE_TOKEN", v)
+		sendTo("debounce-secret", http.MethodGet, "")
+	}
+	got := backend.reset()
+	if len(got) != 3 || got[0] != "Bearer a" || got[1] != "Bearer b" || got[2] != "Bearer b" {
+		t.Fatalf("expected a single reload within the interval, got %v", got)
+	}
+}
+
+func TestUpstreamRejectionMarksSecretUnhealthy(t *testing.T) {
+	secrets.ClearCache()
+	t.Cleanup(secrets.ClearCache)
+	t.Setenv("HEALTH_GOOD", "good")
+	t.Setenv("HEALTH_BAD", "bad")
+	backend := &tokenBackend{valid: "good"}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	addTokenIntegration(t, "unhealthy-secret", srv.URL, []string{"env:HEALTH_GOOD", "env:HEALTH_BAD"}, false)
+
+	rejected := false
+	for i := 0; i < 64 && !rejected; i++ {
+		rejected = sendTo("unhealthy-secret", http.MethodGet, "").Code == http.StatusUnauthorized
+	}
+	if !rejected {
+		t.Fatal("expected the bad secret to be picked")
+	}
+	for i := 0; i < 20; i++ {
+		if rr := sendTo("unhealthy-secret", http.MethodGet, ""); rr.Code != http.StatusOK {
+			t.Fatalf("expected rejected secret to be skipped, got %d", rr.Code)
+		}
+	}
+}
+
+func TestRetryOnAuthFailure(t *testing.T) {
+	secrets.ClearCache()
+	t.Cleanup(secrets.ClearCache)
+	old := secrets.RejectInterval
+	secrets.RejectInterval = 0
+	t.Cleanup(func() { secrets.RejectInterval = old })
+	metrics.Reset()
+	backend := &tokenBackend{valid: "new"}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	addTokenIntegration(t, "auth-retry", srv.URL, []string{"env:RETRY_TOKEN"}, true)
+
+	// The retry reloads the secret, which is still stale.
+	t.Setenv("RETRY_TOKEN", "old")
+	if rr := sendTo("auth-retry", http.MethodGet, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	if got := backend.reset(); len(got) != 2 {
+		t.Fatalf("expected one retry, got %v", got)
+	}
+
+	// Cache the stale value again, then rotate the source.
+	if _, err := secrets.LoadSecret(context.Background(), "env:RETRY_TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RETRY_TOKEN", "new")
+	rr := sendTo("auth-retry", http.MethodPut, "payload")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected retry with the rotated secret to succeed, got %d", rr.Code)
+	}
+	if got := backend.reset(); len(got) != 2 || got[0] != "Bearer old" || got[1] != "Bearer new" {
+		t.Fatalf("unexpected attempts %v", got)
+	}
+	if backend.body != "payload" {
+		t.Fatalf("expected body to be resent, got %q", backend.body)
+	}
+
+	// Requests that are not idempotent are never retried.
+	t.Setenv("RETRY_TOKEN", "other")
+	secrets.ClearCache()
+	if rr := sendTo("auth-retry", http.MethodPost, "payload"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	if got := backend.reset(); len(got) != 1 {
+		t.Fatalf("expected POST not to be retried, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.WriteProm(w)
+	for _, line := range []string{
+		`authtranslator_auth_retries_total{integration="auth-retry"} 2`,
+		`authtranslator_secret_invalidations_total{integration="auth-retry"} 4`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Fatalf("missing %q in %s", line, w.Body.String())
+		}
+	}
+}
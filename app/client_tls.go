@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// clientCertVerifier holds the CA bundle and revocation lists used to verify
+// client certificates. Both files are re-read on SIGHUP so they can be rotated
+// without restarting the server.
+type clientCertVerifier struct {
+	caFile  string
+	crlFile string
+	mode    tls.ClientAuthType
+	cert    tls.Certificate
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+	crls []*x509.RevocationList
+}
+
+func parseClientAuthMode(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "", "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("invalid -client-auth %q", s)
+	}
+}
+
+// setupClientCertVerification builds a verifier from the -client-ca,
+// -client-auth and -client-crl flags. It returns nil when client certificate
+// verification is not configured.
+func setupClientCertVerification() (*clientCertVerifier, error) {
+	if *clientCA == "" {
+		if *clientAuth != "" || *clientCRL != "" {
+			return nil, errors.New("-client-auth and -client-crl require -client-ca")
+		}
+		return nil, nil
+	}
+	if *tlsCert == "" || *tlsKey == "" {
+		return nil, errors.New("-client-ca requires -tls-cert and -tls-key")
+	}
+	mode, err := parseClientAuthMode(*clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	v := &clientCertVerifier{caFile: *clientCA, crlFile: *clientCRL, mode: mode, cert: cert}
+	if err := v.reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// reload re-reads the CA bundle and CRL file. The previous state is kept when
+// either file fails to load.
+func (v *clientCertVerifier) reload() error {
+	caData, err := os.ReadFile(v.caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return fmt.Errorf("failed to load client CA file")
+	}
+	var crls []*x509.RevocationList
+	if v.crlFile != "" {
+		data, err := os.ReadFile(v.crlFile)
+		if err != nil {
+			return err
+		}
+		if crls, err = parseCRLs(data); err != nil {
+			return err
+		}
+	}
+	v.mu.Lock()
+	v.pool = pool
+	v.crls = crls
+	v.mu.Unlock()
+	return nil
+}
+
+func parseCRLs(data []byte) ([]*x509.RevocationList, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRL file: %w", err)
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRL file: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, fmt.Errorf("no CRLs found in CRL file")
+	}
+	return crls, nil
+}
+
+func (v *clientCertVerifier) currentPool() *x509.CertPool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.pool
+}
+
+// verifyConnection rejects connections whose verified chain contains a
+// certificate revoked by a CRL signed by its issuer.
+func (v *clientCertVerifier) verifyConnection(cs tls.ConnectionState) error {
+	v.mu.RLock()
+	crls := v.crls
+	v.mu.RUnlock()
+	if len(crls) == 0 {
+		return nil
+	}
+	for _, chain := range cs.VerifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			if isRevoked(chain[i], chain[i+1], crls) {
+				return fmt.Errorf("client certificate %s has been revoked", chain[i].SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+func isRevoked(cert, issuer *x509.Certificate, crls []*x509.RevocationList) bool {
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		for _, rc := range crl.RevokedCertificateEntries {
+			if rc.SerialNumber != nil && rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serverTLSConfig returns a TLS config that verifies client certificates
+// against the CA bundle current at handshake time. nextProtos sets the ALPN
+// protocols offered on each connection.
+func (v *clientCertVerifier) serverTLSConfig(nextProtos []string) *tls.Config {
+	base := &tls.Config{
+		Certificates:     []tls.Certificate{v.cert},
+		ClientAuth:       v.mode,
+		NextProtos:       nextProtos,
+		VerifyConnection: v.verifyConnection,
+	}
+	cfg := base.Clone()
+	cfg.ClientCAs = v.currentPool()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.ClientCAs = v.currentPool()
+		return c, nil
+	}
+	return cfg
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) crl(t *testing.T, serials ...int64) []byte {
+	t.Helper()
+	var revoked []x509.RevocationListEntry
+	for _, s := range serials {
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func setClientTLSFlags(t *testing.T, cert, key, ca, mode, crl string) {
+	t.Helper()
+	oldCert, oldKey, oldCA, oldMode, oldCRL := *tlsCert, *tlsKey, *clientCA, *clientAuth, *clientCRL
+	t.Cleanup(func() {
+		*tlsCert, *tlsKey, *clientCA, *clientAuth, *clientCRL = oldCert, oldKey, oldCA, oldMode, oldCRL
+	})
+	*tlsCert, *tlsKey, *clientCA, *clientAuth, *clientCRL = cert, key, ca, mode, crl
+}
+
+func newClientTLSServer(t *testing.T, v *clientCertVerifier) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = v.serverTLSConfig([]string{"http/1.1"})
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func clientCertGet(url string, cert *tls.Certificate) error {
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if cert != nil {
+		// Always present the certificate, even when its issuer is not in the
+		// server's acceptable CA list.
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return cert, nil }
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}, Timeout: 5 * time.Second}
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestParseClientAuthMode(t *testing.T) {
+	tests := map[string]tls.ClientAuthType{
+		"":                tls.VerifyClientCertIfGiven,
+		"verify-if-given": tls.VerifyClientCertIfGiven,
+		"require":         tls.RequireAndVerifyClientCert,
+		"REQUEST":         tls.RequestClientCert,
+	}
+	for in, want := range tests {
+		got, err := parseClientAuthMode(in)
+		if err != nil || got != want {
+			t.Fatalf("%q: got %v, %v", in, got, err)
+		}
+	}
+	if _, err := parseClientAuthMode("always"); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestSetupClientCertVerificationErrors(t *testing.T) {
+	cert, key := generateTLSFiles(t)
+	ca := newTestCA(t, "ca")
+	caFile := writeTempFile(t, string(ca.pem))
+	defer os.Remove(caFile)
+	bad := writeTempFile(t, "not a cert")
+	defer os.Remove(bad)
+
+	cases := []struct {
+		name                     string
+		cert, key, ca, mode, crl string
+	}{
+		{"auth without ca", cert, key, "", "require", ""},
+		{"crl without ca", cert, key, "", "", bad},
+		{"ca without tls", "", "", caFile, "", ""},
+		{"bad mode", cert, key, caFile, "sometimes", ""},
+		{"bad ca", cert, key, bad, "", ""},
+		{"missing ca", cert, key, "/nonexistent/ca.pem", "", ""},
+		{"bad crl", cert, key, caFile, "", bad},
+	}
+	for _, c := range cases {
+		setClientTLSFlags(t, c.cert, c.key, c.ca, c.mode, c.crl)
+		if _, err := setupClientCertVerification(); err == nil {
+			t.Fatalf("%s: expected error", c.name)
+		}
+	}
+
+	setClientTLSFlags(t, cert, key, "", "", "")
+	v, err := setupClientCertVerification()
+	if err != nil || v != nil {
+		t.Fatalf("expected verification disabled, got %v, %v", v, err)
+	}
+}
+
+func TestClientCertVerification(t *testing.T) {
+	cert, key := generateTLSFiles(t)
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	caFile := writeTempFile(t, string(ca.pem))
+	defer os.Remove(caFile)
+	crlFile := writeTempFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: ca.crl(t, 3)})))
+	defer os.Remove(crlFile)
+
+	setClientTLSFlags(t, cert, key, caFile, "require", crlFile)
+	v, err := setupClientCertVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newClientTLSServer(t, v)
+
+	good := ca.issue(t, 2)
+	if err := clientCertGet(srv.URL, &good); err != nil {
+		t.Fatalf("trusted cert rejected: %v", err)
+	}
+	if err := clientCertGet(srv.URL, nil); err == nil {
+		t.Fatal("expected request without cert to fail")
+	}
+	untrusted := other.issue(t, 2)
+	if err := clientCertGet(srv.URL, &untrusted); err == nil {
+		t.Fatal("expected untrusted cert to fail")
+	}
+	revoked := ca.issue(t, 3)
+	if err := clientCertGet(srv.URL, &revoked); err == nil {
+		t.Fatal("expected revoked cert to fail")
+	}
+}
+
+func TestClientCertVerifyIfGiven(t *testing.T) {
+	cert, key := generateTLSFiles(t)
+	ca := newTestCA(t, "ca")
+	caFile := writeTempFile(t, string(ca.pem))
+	defer os.Remove(caFile)
+
+	setClientTLSFlags(t, cert, key, caFile, "", "")
+	v, err := setupClientCertVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newClientTLSServer(t, v)
+	if err := clientCertGet(srv.URL, nil); err != nil {
+		t.Fatalf("request without cert failed: %v", err)
+	}
+	untrusted := newTestCA(t, "other").issue(t, 2)
+	if err := clientCertGet(srv.URL, &untrusted); err == nil {
+		t.Fatal("expected untrusted cert to fail")
+	}
+}
+
+func TestClientCertReload(t *testing.T) {
+	cert, key := generateTLSFiles(t)
+	oldCA := newTestCA(t, "old")
+	newCA := newTestCA(t, "new")
+	caFile := writeTempFile(t, string(oldCA.pem))
+	defer os.Remove(caFile)
+	crlFile := writeTempFile(t, string(oldCA.crl(t)))
+	defer os.Remove(crlFile)
+
+	setClientTLSFlags(t, cert, key, caFile, "require", crlFile)
+	v, err := setupClientCertVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newClientTLSServer(t, v)
+	oldCert := oldCA.issue(t, 2)
+	newCert := newCA.issue(t, 2)
+	if err := clientCertGet(srv.URL, &newCert); err == nil {
+		t.Fatal("expected cert from new CA to fail before reload")
+	}
+
+	// Trust both CAs and revoke the old client certificate via a DER CRL.
+	if err := os.WriteFile(caFile, append(oldCA.pem, newCA.pem...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(crlFile, oldCA.crl(t, 2), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientCertGet(srv.URL, &newCert); err != nil {
+		t.Fatalf("cert from new CA rejected after reload: %v", err)
+	}
+	if err := clientCertGet(srv.URL, &oldCert); err == nil {
+		t.Fatal("expected revoked cert to fail after reload")
+	}
+
+	// A broken bundle keeps the previous state.
+	if err := os.WriteFile(caFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if err := clientCertGet(srv.URL, &newCert); err != nil {
+		t.Fatalf("previous bundle not kept: %v", err)
+	}
+}
+
+func TestCRLIgnoredForOtherIssuer(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	crls, err := parseCRLs(other.crl(t, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(ca.issue(t, 2).Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isRevoked(leaf, ca.cert, crls) {
+		t.Fatal("CRL from another issuer should not revoke certificate")
+	}
+	if _, err := parseCRLs([]byte("-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n")); err == nil {
+		t.Fatal("expected error for PEM without CRL blocks")
+	}
+}
+
+func TestMainHTTP3ClientCA(t *testing.T) {
+	cfg := writeTempFile(t, `{"integrations":[{"name":"test","destination":"http://example.com"}]}`)
+	defer os.Remove(cfg)
+	al := writeTempFile(t, `[]`)
+	defer os.Remove(al)
+	cert, key := generateTLSFiles(t)
+	ca := newTestCA(t, "ca")
+	caFile := writeTempFile(t, string(ca.pem))
+	defer os.Remove(caFile)
+
+	addr := freeAddr(t)
+	cmd := runMainCmd("-config", cfg, "-allowlist", al, "-addr", addr, "-tls-cert", cert, "-tls-key", key, "-enable-http3", "-client-ca", caFile, "-client-auth", "require")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	defer func() {
+		cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			cmd.Process.Kill()
+			<-done
+		}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	get := func(c *tls.Certificate) error {
+		cfg := &tls.Config{InsecureSkipVerify: true}
+		if c != nil {
+			cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return c, nil }
+		}
+		rt := &http3.Transport{TLSClientConfig: cfg}
+		defer rt.Close()
+		client := &http.Client{Transport: rt, Timeout: 2 * time.Second}
+		resp, err := client.Get("https://" + addr + "/_at_internal/healthz")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	clientCert := ca.issue(t, 2)
+	if err := get(&clientCert); err != nil {
+		t.Fatalf("http3 request with client cert failed: %v", err)
+	}
+	if err := get(nil); err == nil {
+		t.Fatal("expected http3 request without client cert to fail")
+	}
+	if err := clientCertGet("https://"+addr+"/_at_internal/healthz", &clientCert); err != nil {
+		t.Fatalf("https request with client cert failed: %v", err)
+	}
+}
+
+func TestMainClientCAWithoutTLS(t *testing.T) {
+	cfg := writeTempFile(t, `{"integrations":[{"name":"test","destination":"http://example.com"}]}`)
+	defer os.Remove(cfg)
+	al := writeTempFile(t, `[]`)
+	defer os.Remove(al)
+
+	cmd := runMainCmd("-config", cfg, "-allowlist", al, "-addr", freeAddr(t), "-client-ca", "ca.pem")
+	err := cmd.Run()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() == 0 {
+		t.Fatalf("expected non-zero exit, got %v", err)
+	}
+}
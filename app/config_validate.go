@@ -54,6 +54,14 @@ func validateConfig(c *Config) error {
 				return fmt.Errorf("integration %s has invalid rate_limit_strategy", i.Name)
 			}
 		}
+		if i.IncomingAuthMode != "" {
+			if !validIncomingAuthMode(i.IncomingAuthMode) {
+				return fmt.Errorf("integration %s has invalid incoming_auth_mode", i.Name)
+			}
+			if i.IncomingAuthMode != incomingAuthAll && len(i.IncomingAuth) == 0 {
+				return fmt.Errorf("integration %s sets incoming_auth_mode %s without incoming_auth", i.Name, i.IncomingAuthMode)
+			}
+		}
 		if i.IdleConnTimeout != "" {
 			d, err := time.ParseDuration(i.IdleConnTimeout)
 			if err != nil || d < 0 {
@@ -152,3 +152,21 @@ func TestValidateConfigRejectsInvalidWildcardDestinations(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateConfigIncomingAuthMode(t *testing.T) {
+	auth := []AuthPluginConfig{{Type: "token", Params: map[string]interface{}{}}}
+	for _, mode := range []string{"all", "any", "first_match"} {
+		c := Config{Integrations: []Integration{{Name: "a", Destination: "http://ex", IncomingAuthMode: mode, IncomingAuth: auth}}}
+		if err := validateConfig(&c); err != nil {
+			t.Fatalf("mode %s: unexpected error: %v", mode, err)
+		}
+	}
+	bad := Config{Integrations: []Integration{{Name: "a", Destination: "http://ex", IncomingAuthMode: "either", IncomingAuth: auth}}}
+	if err := validateConfig(&bad); err == nil {
+		t.Fatal("expected error for invalid incoming_auth_mode")
+	}
+	empty := Config{Integrations: []Integration{{Name: "a", Destination: "http://ex", IncomingAuthMode: "any"}}}
+	if err := validateConfig(&empty); err == nil {
+		t.Fatal("expected error for any mode without incoming_auth")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// Incoming auth modes control how an integration combines its incoming_auth
+// plugins.
+const (
+	// incomingAuthAll requires every plugin to pass. The last plugin that
+	// identifies the caller supplies the caller ID and every stripper runs
+	// after its plugin authenticates.
+	incomingAuthAll = "all"
+	// incomingAuthAny evaluates every plugin and passes when at least one
+	// does. The first passing plugin that identifies the caller supplies the
+	// caller ID. Strippers for all plugins run once evaluation finishes so no
+	// configured credential reaches the upstream.
+	incomingAuthAny = "any"
+	// incomingAuthFirstMatch stops at the first plugin that passes. Only that
+	// plugin may supply the caller ID. Strippers for all plugins run so no
+	// configured credential reaches the upstream.
+	incomingAuthFirstMatch = "first_match"
+)
+
+func validIncomingAuthMode(mode string) bool {
+	switch mode {
+	case incomingAuthAll, incomingAuthAny, incomingAuthFirstMatch:
+		return true
+	}
+	return false
+}
+
+// incomingAuthError records which plugin rejected a request and why. The
+// detail is only logged; callers receive a generic 401.
+type incomingAuthError struct {
+	plugin string
+	err    error
+}
+
+func (e *incomingAuthError) Error() string { return e.plugin + ": " + e.err.Error() }
+
+func (e *incomingAuthError) Unwrap() error { return e.err }
+
+// reason returns the bounded failure reason used for metrics and logs.
+func (e *incomingAuthError) reason() string { return string(authplugins.FailureReason(e.err)) }
+
+// authenticateIncoming runs the integration's incoming auth plugins against r
+// according to its incoming_auth_mode. It returns the caller ID, or "" when
+// no plugin identified the caller, and an error describing the rejecting
+// plugin when the request is not authorised.
+func authenticateIncoming(integ *Integration, r *http.Request) (string, *incomingAuthError) {
+	if integ.IncomingAuthMode == incomingAuthAny || integ.IncomingAuthMode == incomingAuthFirstMatch {
+		return authenticateIncomingAnyOf(integ, r)
+	}
+
+	callerID := ""
+	for _, cfg := range integ.IncomingAuth {
+		p := authplugins.GetIncoming(cfg.Type)
+		if p == nil {
+			continue
+		}
+		if err := authplugins.Authenticate(r.Context(), p, r, cfg.parsed); err != nil {
+			return "", &incomingAuthError{plugin: cfg.Type, err: err}
+		}
+		recordSubjectToken(p, r, cfg.parsed)
+		if idp, ok := p.(authplugins.Identifier); ok {
+			if id, ok := idp.Identify(r, cfg.parsed); ok {
+				callerID = id
+			}
+		}
+		if stripper, ok := p.(authplugins.AuthStripper); ok {
+			stripper.StripAuth(r, cfg.parsed)
+		}
+	}
+	return callerID, nil
+}
+
+// recordSubjectToken stores the token p verified in r, if p exposes it, so
+// outgoing plugins can exchange the caller's credential.
+func recordSubjectToken(p authplugins.IncomingAuthPlugin, r *http.Request, params interface{}) {
+	if src, ok := p.(authplugins.BearerTokenSource); ok {
+		if tok, ok := src.BearerToken(r, params); ok {
+			authplugins.SetSubjectToken(r.Context(), tok)
+		}
+	}
+}
+
+// authenticateIncomingAnyOf implements the any and first_match modes. No
+// stripper runs until every candidate has seen the original request. When
+// every plugin fails, the reported failure is the first one from a plugin
+// that found a credential, since that best explains a rejected caller.
+func authenticateIncomingAnyOf(integ *Integration, r *http.Request) (string, *incomingAuthError) {
+	firstMatch := integ.IncomingAuthMode == incomingAuthFirstMatch
+	callerID := ""
+	passed := false
+	var failure *incomingAuthError
+	for _, cfg := range integ.IncomingAuth {
+		p := authplugins.GetIncoming(cfg.Type)
+		if p == nil {
+			continue
+		}
+		if err := authplugins.Authenticate(r.Context(), p, r, cfg.parsed); err != nil {
+			if failure == nil || (failure.reason() == string(authplugins.ReasonMissingCredential) &&
+				authplugins.FailureReason(err) != authplugins.ReasonMissingCredential) {
+				failure = &incomingAuthError{plugin: cfg.Type, err: err}
+			}
+			continue
+		}
+		recordSubjectToken(p, r, cfg.parsed)
+		if idp, ok := p.(authplugins.Identifier); ok && callerID == "" {
+			if id, ok := idp.Identify(r, cfg.parsed); ok {
+				callerID = id
+			}
+		}
+		passed = true
+		if firstMatch {
+			break
+		}
+	}
+	if !passed {
+		if failure == nil {
+			failure = &incomingAuthError{err: errors.New("no incoming auth plugin available")}
+		}
+		return "", failure
+	}
+	for _, cfg := range integ.IncomingAuth {
+		if stripper, ok := authplugins.GetIncoming(cfg.Type).(authplugins.AuthStripper); ok {
+			stripper.StripAuth(r, cfg.parsed)
+		}
+	}
+	return callerID, nil
+}
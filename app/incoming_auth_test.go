@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// headerAuthPlugin passes when Header equals Value, identifies the caller as
+// ID when set and strips Header.
+type headerAuthPlugin struct{}
+
+type headerAuthParams struct {
+	Header string `json:"header"`
+	Value  string `json:"value"`
+	ID     string `json:"id"`
+}
+
+func (headerAuthPlugin) Name() string { return "header_mode_test" }
+
+func (headerAuthPlugin) ParseParams(m map[string]interface{}) (interface{}, error) {
+	return authplugins.ParseParams[headerAuthParams](m)
+}
+
+func (headerAuthPlugin) Authenticate(_ context.Context, r *http.Request, p interface{}) bool {
+	cfg := p.(*headerAuthParams)
+	return r.Header.Get(cfg.Header) == cfg.Value
+}
+
+func (h headerAuthPlugin) AuthenticateWithReason(ctx context.Context, r *http.Request, p interface{}) error {
+	cfg := p.(*headerAuthParams)
+	switch r.Header.Get(cfg.Header) {
+	case "":
+		return authplugins.Fail(authplugins.ReasonMissingCredential, "missing %s", cfg.Header)
+	case cfg.Value:
+		return nil
+	}
+	return authplugins.Fail(authplugins.ReasonInvalidCredential, "wrong %s", cfg.Header)
+}
+
+func (headerAuthPlugin) Identify(r *http.Request, p interface{}) (string, bool) {
+	cfg := p.(*headerAuthParams)
+	return cfg.ID, cfg.ID != ""
+}
+
+func (headerAuthPlugin) StripAuth(r *http.Request, p interface{}) {
+	r.Header.Del(p.(*headerAuthParams).Header)
+}
+
+func (headerAuthPlugin) RequiredParams() []string { return []string{"header", "value"} }
+
+func (headerAuthPlugin) OptionalParams() []string { return []string{"id"} }
+
+func modeIntegration(t *testing.T, mode string) *Integration {
+	t.Helper()
+	authplugins.RegisterIncoming(headerAuthPlugin{})
+	integ := &Integration{
+		Name:             "mode",
+		Destination:      "http://example.com",
+		IncomingAuthMode: mode,
+		IncomingAuth: []AuthPluginConfig{
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Token", "value": "shared"}},
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Jwt", "value": "jwt", "id": "jwt-caller"}},
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Key", "value": "key", "id": "key-caller"}},
+		},
+	}
+	if err := prepareIntegration(integ); err != nil {
+		t.Fatal(err)
+	}
+	return integ
+}
+
+func modeRequest(headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://mode/", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestIncomingAuthModeAll(t *testing.T) {
+	integ := modeIntegration(t, "")
+	if integ.IncomingAuthMode != incomingAuthAll {
+		t.Fatalf("expected default mode all, got %q", integ.IncomingAuthMode)
+	}
+	r := modeRequest(map[string]string{"X-Token": "shared", "X-Jwt": "jwt", "X-Key": "key"})
+	id, err := authenticateIncoming(integ, r)
+	if err != nil || id != "key-caller" {
+		t.Fatalf("expected last identifier to win, got %q %v", id, err)
+	}
+	_, authErr := authenticateIncoming(integ, modeRequest(map[string]string{"X-Token": "shared", "X-Jwt": "jwt"}))
+	if authErr == nil {
+		t.Fatal("expected all mode to require every plugin")
+	}
+	if authErr.reason() != "missing_credential" {
+		t.Fatalf("unexpected reason %s", authErr.reason())
+	}
+}
+
+func TestIncomingAuthModeAny(t *testing.T) {
+	integ := modeIntegration(t, incomingAuthAny)
+
+	r := modeRequest(map[string]string{"X-Token": "shared", "X-Key": "key", "X-Other": "keep"})
+	id, err := authenticateIncoming(integ, r)
+	if err != nil || id != "key-caller" {
+		t.Fatalf("expected later identifying plugin to supply caller ID, got %q %v", id, err)
+	}
+	for _, h := range []string{"X-Token", "X-Jwt", "X-Key"} {
+		if r.Header.Get(h) != "" {
+			t.Fatalf("expected %s to be stripped", h)
+		}
+	}
+	if r.Header.Get("X-Other") != "keep" {
+		t.Fatal("expected unrelated header to be kept")
+	}
+
+	r = modeRequest(map[string]string{"X-Jwt": "jwt", "X-Key": "key"})
+	if id, err := authenticateIncoming(integ, r); err != nil || id != "jwt-caller" {
+		t.Fatalf("expected first identifying plugin to win, got %q %v", id, err)
+	}
+
+	r = modeRequest(map[string]string{"X-Token": "shared"})
+	if id, err := authenticateIncoming(integ, r); err != nil || id != "" {
+		t.Fatalf("expected anonymous success, got %q %v", id, err)
+	}
+
+	// The key plugin found a credential, so its failure is reported rather
+	// than the missing headers of the earlier plugins.
+	r = modeRequest(map[string]string{"X-Key": "wrong"})
+	_, authErr := authenticateIncoming(integ, r)
+	if authErr == nil {
+		t.Fatal("expected failure when no plugin passes")
+	}
+	if authErr.reason() != "invalid_credential" || authErr.plugin != "header_mode_test" {
+		t.Fatalf("unexpected failure %v", authErr)
+	}
+	if r.Header.Get("X-Key") != "wrong" {
+		t.Fatal("expected no stripping on failure")
+	}
+}
+
+func TestIncomingAuthModeFirstMatch(t *testing.T) {
+	integ := modeIntegration(t, incomingAuthFirstMatch)
+
+	r := modeRequest(map[string]string{"X-Token": "shared", "X-Key": "key"})
+	id, err := authenticateIncoming(integ, r)
+	if err != nil || id != "" {
+		t.Fatalf("expected only the matching plugin to supply the caller ID, got %q %v", id, err)
+	}
+	if r.Header.Get("X-Token") != "" || r.Header.Get("X-Key") != "" {
+		t.Fatal("expected all credentials to be stripped")
+	}
+
+	r = modeRequest(map[string]string{"X-Token": "nope", "X-Key": "key"})
+	if id, err := authenticateIncoming(integ, r); err != nil || id != "key-caller" {
+		t.Fatalf("expected key plugin to match, got %q %v", id, err)
+	}
+}
+
+func TestIncomingAuthModeInvalid(t *testing.T) {
+	integ := &Integration{Name: "badmode", Destination: "http://example.com", IncomingAuthMode: "some"}
+	if err := prepareIntegration(integ); err == nil {
+		t.Fatal("expected invalid mode to fail")
+	}
+	integ = &Integration{Name: "emptyany", Destination: "http://example.com", IncomingAuthMode: incomingAuthAny}
+	if err := prepareIntegration(integ); err == nil {
+		t.Fatal("expected any mode without plugins to fail")
+	}
+}
+
+func TestProxyHandlerIncomingAuthModeAny(t *testing.T) {
+	authplugins.RegisterIncoming(headerAuthPlugin{})
+	var gotToken, gotJwt string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotToken, gotJwt = r.Header.Get("X-Token"), r.Header.Get("X-Jwt")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	integ := Integration{
+		Name:             "anymode",
+		Destination:      backend.URL,
+		InRateLimit:      10,
+		OutRateLimit:     10,
+		IncomingAuthMode: incomingAuthAny,
+		IncomingAuth: []AuthPluginConfig{
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Token", "value": "shared", "id": "legacy"}},
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Jwt", "value": "jwt", "id": "migrated"}},
+		},
+	}
+	if err := AddIntegration(&integ); err != nil {
+		t.Fatalf("failed to add integration: %v", err)
+	}
+	t.Cleanup(func() { DeleteIntegration(integ.Name) })
+	callers := []CallerConfig{{ID: "migrated", Rules: []CallRule{{Path: "/", Methods: map[string]RequestConstraint{"GET": {}}}}}}
+	if err := SetAllowlist(integ.Name, callers); err != nil {
+		t.Fatalf("failed to set allowlist: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://anymode/", nil)
+	req.Host = "anymode"
+	req.Header.Set("X-Token", "stale")
+	req.Header.Set("X-Jwt", "jwt")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if gotToken != "" || gotJwt != "" {
+		t.Fatalf("expected credentials stripped, got %q %q", gotToken, gotJwt)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://anymode/", nil)
+	req.Host = "anymode"
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
@@ -117,11 +117,48 @@ func collectSecretRefs(cfg interface{}) []string {
 // AuthPluginConfig ties an auth plugin type to its parameters. The Params field
 // holds the raw configuration from the YAML config while parsed is used at
 // runtime after being validated by the plugin's ParseParams function.
+//
+// Outgoing plugins may set CallerParams to give each caller ID its own
+// parameters, typically its own secret references, layered over Params.
+// Callers without an entry use DefaultParams layered over Params, or are
+// rejected when DefaultParams is unset.
 type AuthPluginConfig struct {
-	Type   string                 `json:"type" yaml:"type"`
-	Params map[string]interface{} `json:"params" yaml:"params"`
+	Type          string                            `json:"type" yaml:"type"`
+	Params        map[string]interface{}            `json:"params" yaml:"params"`
+	CallerParams  map[string]map[string]interface{} `json:"caller_params,omitempty" yaml:"caller_params,omitempty"`
+	DefaultParams map[string]interface{}            `json:"default_params,omitempty" yaml:"default_params,omitempty"`
 
-	parsed interface{}
+	parsed       interface{}
+	callerParsed map[string]interface{}
+}
+
+// mergeParams returns base with the keys of override replaced.
+func mergeParams(base, override map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		out[k] = v
+	}
+	return out
+}
+
+// parseOutgoingParams parses and validates params for outgoing plugin p.
+func parseOutgoingParams(p authplugins.OutgoingAuthPlugin, params map[string]interface{}) (interface{}, error) {
+	cfg, err := p.ParseParams(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid params for auth %s: %v", p.Name(), err)
+	}
+	if err := validateRequired(cfg, p); err != nil {
+		return nil, fmt.Errorf("invalid params for auth %s: %w", p.Name(), err)
+	}
+	for _, ref := range collectSecretRefs(cfg) {
+		if err := secrets.ValidateSecret(ref); err != nil {
+			return nil, fmt.Errorf("invalid params for auth %s: %w", p.Name(), err)
+		}
+	}
+	return cfg, nil
 }
 
 // CallerConfig defines allowed paths and methods for a specific caller
@@ -137,6 +174,7 @@ type Integration struct {
 	InRateLimit       int                `json:"in_rate_limit" yaml:"in_rate_limit"`
 	OutRateLimit      int                `json:"out_rate_limit" yaml:"out_rate_limit"`
 	IncomingAuth      []AuthPluginConfig `json:"incoming_auth" yaml:"incoming_auth"`
+	IncomingAuthMode  string             `json:"incoming_auth_mode,omitempty" yaml:"incoming_auth_mode,omitempty"`
 	OutgoingAuth      []AuthPluginConfig `json:"outgoing_auth" yaml:"outgoing_auth"`
 	RateLimitWindow   string             `json:"rate_limit_window" yaml:"rate_limit_window,omitempty"`
 	RateLimitStrategy string             `json:"rate_limit_strategy,omitempty" yaml:"rate_limit_strategy,omitempty"`
@@ -151,6 +189,14 @@ type Integration struct {
 	MaxIdleConns          int    `json:"max_idle_conns,omitempty" yaml:"max_idle_conns,omitempty"`
 	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host,omitempty" yaml:"max_idle_conns_per_host,omitempty"`
 
+	// InvalidateOnForbidden treats upstream 403 responses like 401: cached
+	// credentials are dropped and their secrets reloaded. Leave it unset
+	// for upstreams that answer 403 for missing permissions or rate limits.
+	InvalidateOnForbidden bool `json:"invalidate_on_forbidden,omitempty" yaml:"invalidate_on_forbidden,omitempty"`
+	// RetryOnAuthFailure resends idempotent requests once with freshly
+	// loaded credentials when the upstream rejects them.
+	RetryOnAuthFailure bool `json:"retry_on_auth_failure,omitempty" yaml:"retry_on_auth_failure,omitempty"`
+
 	inLimiter  *RateLimiter
 	outLimiter *RateLimiter
 
@@ -235,6 +281,9 @@ func prepareIntegration(i *Integration) error {
 		if resp.StatusCode < http.StatusOK || resp.StatusCode >= 300 {
 			resp.Header.Set("X-AT-Upstream-Error", "true")
 		}
+		if i.rejectsAuth(resp.StatusCode) && !authRetryHandled(resp.Request.Context()) {
+			invalidateOutgoingAuth(i, resp.Request)
+		}
 		metrics.RecordResponseProcessingDuration(i.Name, time.Since(start))
 		return nil
 	}
@@ -261,11 +310,24 @@ func prepareIntegration(i *Integration) error {
 		return fmt.Errorf("invalid rate_limit_strategy %s", i.RateLimitStrategy)
 	}
 
+	if i.IncomingAuthMode == "" {
+		i.IncomingAuthMode = incomingAuthAll
+	}
+	if !validIncomingAuthMode(i.IncomingAuthMode) {
+		return fmt.Errorf("invalid incoming_auth_mode %s", i.IncomingAuthMode)
+	}
+	if i.IncomingAuthMode != incomingAuthAll && len(i.IncomingAuth) == 0 {
+		return fmt.Errorf("incoming_auth_mode %s requires incoming_auth", i.IncomingAuthMode)
+	}
+
 	for idx, a := range i.IncomingAuth {
 		p := authplugins.GetIncoming(a.Type)
 		if p == nil {
 			return fmt.Errorf("unknown incoming auth type %s", a.Type)
 		}
+		if a.CallerParams != nil || a.DefaultParams != nil {
+			return fmt.Errorf("caller_params and default_params are only supported for outgoing auth")
+		}
 		cfg, err := p.ParseParams(a.Params)
 		if err != nil {
 			return fmt.Errorf("invalid params for auth %s: %v", a.Type, err)
@@ -286,26 +348,48 @@ func prepareIntegration(i *Integration) error {
 		if p == nil {
 			return fmt.Errorf("unknown outgoing auth type %s", a.Type)
 		}
-		cfg, err := p.ParseParams(a.Params)
-		if err != nil {
-			return fmt.Errorf("invalid params for auth %s: %v", a.Type, err)
-		}
-		if err := validateRequired(cfg, p); err != nil {
-			return fmt.Errorf("invalid params for auth %s: %w", a.Type, err)
-		}
-		for _, ref := range collectSecretRefs(cfg) {
-			if err := secrets.ValidateSecret(ref); err != nil {
-				return fmt.Errorf("invalid params for auth %s: %w", a.Type, err)
+		tp, hasTransport := p.(interface {
+			Transport(interface{}) *http.Transport
+		})
+		if len(a.CallerParams) == 0 {
+			if a.DefaultParams != nil {
+				return fmt.Errorf("invalid params for auth %s: default_params requires caller_params", a.Type)
+			}
+			cfg, err := parseOutgoingParams(p, a.Params)
+			if err != nil {
+				return err
+			}
+			i.OutgoingAuth[idx].parsed = cfg
+			i.OutgoingAuth[idx].callerParsed = nil
+			if hasTransport {
+				if t := tp.Transport(cfg); t != nil {
+					i.proxy.Transport = t
+				}
 			}
+			continue
 		}
-		i.OutgoingAuth[idx].parsed = cfg
 
-		if tp, ok := p.(interface {
-			Transport(interface{}) *http.Transport
-		}); ok {
-			if t := tp.Transport(cfg); t != nil {
-				i.proxy.Transport = t
+		// The transport is shared by every caller, so plugins that
+		// provide one cannot vary their params per caller.
+		if hasTransport {
+			return fmt.Errorf("invalid params for auth %s: caller_params is not supported by this plugin", a.Type)
+		}
+		callerParsed := make(map[string]interface{}, len(a.CallerParams))
+		for caller, override := range a.CallerParams {
+			cfg, err := parseOutgoingParams(p, mergeParams(a.Params, override))
+			if err != nil {
+				return fmt.Errorf("caller %s: %w", caller, err)
 			}
+			callerParsed[caller] = cfg
+		}
+		i.OutgoingAuth[idx].callerParsed = callerParsed
+		i.OutgoingAuth[idx].parsed = nil
+		if a.DefaultParams != nil {
+			cfg, err := parseOutgoingParams(p, mergeParams(a.Params, a.DefaultParams))
+			if err != nil {
+				return fmt.Errorf("default_params: %w", err)
+			}
+			i.OutgoingAuth[idx].parsed = cfg
 		}
 	}
 
@@ -352,6 +436,9 @@ func prepareIntegration(i *Integration) error {
 	}
 
 	i.proxy.Transport = tr
+	if i.RetryOnAuthFailure {
+		i.proxy.Transport = &authRetryTransport{integ: i, base: tr}
+	}
 
 	return nil
 }
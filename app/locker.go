@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// lockPollInterval is how often a held Redis lock is retried.
+var lockPollInterval = 50 * time.Millisecond
+
+// releaseLockScript deletes a lock only while it still holds our token, so
+// an expired lock taken over by another instance is left alone.
+const releaseLockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// redisLocker serializes credential refreshes across instances through
+// -redis-addr. When Redis is unavailable it falls back to a process-local
+// lock, which still serializes refreshes within this instance.
+type redisLocker struct {
+	conns    chan net.Conn
+	fallback *authplugins.MemoryLocker
+}
+
+func newRedisLocker() *redisLocker {
+	return &redisLocker{
+		conns:    make(chan net.Conn, 4),
+		fallback: authplugins.NewMemoryLocker(),
+	}
+}
+
+// Lock implements authplugins.Locker.
+func (l *redisLocker) Lock(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+	key = "lock:" + key
+	for {
+		ok, err := l.try(key, token, ttl)
+		if err != nil {
+			logger.Error("redis lock failed, falling back to memory", "error", err)
+			return l.fallback.Lock(ctx, key, ttl)
+		}
+		if ok {
+			return func() { l.release(key, token) }, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func (l *redisLocker) conn() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	default:
+	}
+	return dialRedis()
+}
+
+func (l *redisLocker) put(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (l *redisLocker) try(key, token string, ttl time.Duration) (bool, error) {
+	conn, err := l.conn()
+	if err != nil {
+		return false, err
+	}
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	reply, err := redisCmdString(conn, "SET", key, token, "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	l.put(conn)
+	return reply == "OK", nil
+}
+
+func (l *redisLocker) release(key, token string) {
+	conn, err := l.conn()
+	if err != nil {
+		logger.Error("redis unlock failed", "error", err)
+		return
+	}
+	if _, err := redisCmdInt(conn, "EVAL", releaseLockScript, "1", key, token); err != nil {
+		logger.Error("redis unlock failed", "error", err)
+		conn.Close()
+		return
+	}
+	l.put(conn)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisLock serves SET NX and the release script from memory.
+func fakeRedisLock(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	keys := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					args, err := readRedisArgs(br)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					switch {
+					case args[0] == "SET" && len(args) == 6:
+						if _, held := keys[args[1]]; held {
+							conn.Write([]byte("$-1\r\n"))
+						} else {
+							keys[args[1]] = args[2]
+							conn.Write([]byte("+OK\r\n"))
+						}
+					case args[0] == "EVAL" && len(args) == 5:
+						if keys[args[3]] == args[4] {
+							delete(keys, args[3])
+							conn.Write([]byte(":1\r\n"))
+						} else {
+							conn.Write([]byte(":0\r\n"))
+						}
+					default:
+						conn.Write([]byte("-ERR unknown\r\n"))
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedisLocker(t *testing.T) {
+	addr := fakeRedisLock(t)
+	old := *redisAddr
+	*redisAddr = addr
+	t.Cleanup(func() { *redisAddr = old })
+
+	a, b := newRedisLocker(), newRedisLocker()
+	unlock, err := a.Lock(context.Background(), "k", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+	defer cancel()
+	if _, err := b.Lock(ctx, "k", time.Minute); err == nil {
+		t.Fatal("expected lock held by another instance to block")
+	}
+	unlock()
+	unlockB, err := b.Lock(context.Background(), "k", time.Minute)
+	if err != nil {
+		t.Fatalf("expected released lock to be acquired: %v", err)
+	}
+	unlockB()
+}
+
+func TestRedisLockerFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	old := *redisAddr
+	*redisAddr = addr
+	t.Cleanup(func() { *redisAddr = old })
+
+	l := newRedisLocker()
+	unlock, err := l.Lock(context.Background(), "k", time.Minute)
+	if err != nil {
+		t.Fatalf("expected fallback lock, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(ctx, "k", time.Minute); err == nil {
+		t.Fatal("expected fallback lock to serialize")
+	}
+	unlock()
+}
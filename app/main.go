@@ -115,13 +115,17 @@ var denylistURL = flag.String("denylist-url", "", "URL to remote denylist file")
 var configURL = flag.String("config-url", "", "URL to remote configuration file")
 var tlsCert = flag.String("tls-cert", "", "path to TLS certificate")
 var tlsKey = flag.String("tls-key", "", "path to TLS key")
+var clientCA = flag.String("client-ca", "", "path to CA bundle used to verify client certificates (reloaded on SIGHUP)")
+var clientAuth = flag.String("client-auth", "", "client certificate policy when -client-ca is set: request, require, or verify-if-given (default)")
+var clientCRL = flag.String("client-crl", "", "path to PEM or DER CRL used to reject revoked client certificates (reloaded on SIGHUP)")
 var logLevel = flag.String("log-level", "INFO", "log level: DEBUG, INFO, WARN, ERROR")
 var logFormat = flag.String("log-format", "text", "log output format: text or json")
-var redisAddr = flag.String("redis-addr", "", "redis address for rate limits (host:port or redis:// URL)")
+var redisAddr = flag.String("redis-addr", "", "redis address for rate limits, replay protection and credential refresh locks (host:port or redis:// URL)")
 var redisTimeout = flag.Duration("redis-timeout", 5*time.Second, "dial timeout for redis")
 var redisCA = flag.String("redis-ca", "", "path to CA certificate for Redis TLS")
 var maxBodySizeFlag = flag.Int64("max_body_size", authplugins.MaxBodySize, "maximum bytes buffered from request bodies (0 to disable)")
 var secretRefresh = flag.Duration("secret-refresh", 0, "refresh interval for cached secrets (0 disables)")
+var secretUnhealthyCooldown = flag.Duration("secret-unhealthy-cooldown", secrets.UnhealthyCooldown, "how long a secret rejected by an upstream is skipped when others are configured")
 var readTimeout = flag.Duration("read-timeout", 0, "HTTP server read timeout")
 var writeTimeout = flag.Duration("write-timeout", 0, "HTTP server write timeout")
 var showVersion = flag.Bool("version", false, "print version and exit")
@@ -716,63 +720,11 @@ func (rl *RateLimiter) allowRedis(key string) (bool, error) {
 		}
 	}
 	var err error
-	var username, password string
 	if conn == nil {
-		addr := *redisAddr
-		useTLS := false
-		if strings.Contains(addr, "://") {
-			u, err := url.Parse(addr)
-			if err != nil {
-				return false, err
-			}
-			if u.Host != "" {
-				addr = u.Host
-			}
-			switch u.Scheme {
-			case "rediss":
-				useTLS = true
-			case "", "redis":
-			default:
-				return false, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
-			}
-			if u.User != nil {
-				username = u.User.Username()
-				password, _ = u.User.Password()
-			}
-		}
-		d := net.Dialer{Timeout: *redisTimeout}
-		if useTLS {
-			tlsConf := &tls.Config{}
-			if *redisCA != "" {
-				caData, err := os.ReadFile(*redisCA)
-				if err != nil {
-					return false, err
-				}
-				pool := x509.NewCertPool()
-				if !pool.AppendCertsFromPEM(caData) {
-					return false, fmt.Errorf("failed to load CA file")
-				}
-				tlsConf.RootCAs = pool
-			}
-			conn, err = tls.DialWithDialer(&d, "tcp", addr, tlsConf)
-		} else {
-			conn, err = d.Dial("tcp", addr)
-		}
+		conn, err = dialRedis()
 		if err != nil {
 			return false, err
 		}
-		if username != "" || password != "" {
-			args := []string{"AUTH"}
-			if username != "" {
-				args = append(args, username, password)
-			} else {
-				args = append(args, password)
-			}
-			if err := redisCmd(conn, args...); err != nil {
-				conn.Close()
-				return false, err
-			}
-		}
 	}
 	bad := false
 	var allowed bool
@@ -952,64 +904,12 @@ func (rl *RateLimiter) retryAfterRedis(key string) (time.Duration, error) {
 		default:
 		}
 	}
-	var err error
-	var username, password string
 	if conn == nil {
-		addr := *redisAddr
-		useTLS := false
-		if strings.Contains(addr, "://") {
-			u, err := url.Parse(addr)
-			if err != nil {
-				return 0, err
-			}
-			if u.Host != "" {
-				addr = u.Host
-			}
-			switch u.Scheme {
-			case "rediss":
-				useTLS = true
-			case "", "redis":
-			default:
-				return 0, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
-			}
-			if u.User != nil {
-				username = u.User.Username()
-				password, _ = u.User.Password()
-			}
-		}
-		d := net.Dialer{Timeout: *redisTimeout}
-		if useTLS {
-			tlsConf := &tls.Config{}
-			if *redisCA != "" {
-				caData, err := os.ReadFile(*redisCA)
-				if err != nil {
-					return 0, err
-				}
-				pool := x509.NewCertPool()
-				if !pool.AppendCertsFromPEM(caData) {
-					return 0, fmt.Errorf("failed to load CA file")
-				}
-				tlsConf.RootCAs = pool
-			}
-			conn, err = tls.DialWithDialer(&d, "tcp", addr, tlsConf)
-		} else {
-			conn, err = d.Dial("tcp", addr)
-		}
+		var err error
+		conn, err = dialRedis()
 		if err != nil {
 			return 0, err
 		}
-		if username != "" || password != "" {
-			args := []string{"AUTH"}
-			if username != "" {
-				args = append(args, username, password)
-			} else {
-				args = append(args, password)
-			}
-			if err := redisCmd(conn, args...); err != nil {
-				conn.Close()
-				return 0, err
-			}
-		}
 	}
 
 	ttlMS, err := redisCmdInt(conn, "PTTL", key)
@@ -1036,6 +936,70 @@ func (rl *RateLimiter) retryAfterRedis(key string) (time.Duration, error) {
 	return time.Duration(ttlMS) * time.Millisecond, nil
 }
 
+// dialRedis connects to -redis-addr, using TLS for rediss:// URLs and
+// authenticating with any credentials in the URL.
+func dialRedis() (net.Conn, error) {
+	addr := *redisAddr
+	useTLS := false
+	var username, password string
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host != "" {
+			addr = u.Host
+		}
+		switch u.Scheme {
+		case "rediss":
+			useTLS = true
+		case "", "redis":
+		default:
+			return nil, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
+		}
+		if u.User != nil {
+			username = u.User.Username()
+			password, _ = u.User.Password()
+		}
+	}
+	d := net.Dialer{Timeout: *redisTimeout}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		tlsConf := &tls.Config{}
+		if *redisCA != "" {
+			caData, err := os.ReadFile(*redisCA)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caData) {
+				return nil, fmt.Errorf("failed to load CA file")
+			}
+			tlsConf.RootCAs = pool
+		}
+		conn, err = tls.DialWithDialer(&d, "tcp", addr, tlsConf)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if username != "" || password != "" {
+		args := []string{"AUTH"}
+		if username != "" {
+			args = append(args, username, password)
+		} else {
+			args = append(args, password)
+		}
+		if err := redisCmd(conn, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
 func redisCmdInt(conn net.Conn, args ...string) (int, error) {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "*%d\r\n", len(args))
@@ -1286,9 +1250,14 @@ const (
 	internalReasonConstraintFailure      = "constraint_failure"
 	internalReasonInvalidDestination     = "invalid_destination"
 	internalReasonOutgoingAuthFailure    = "outgoing_auth_failure"
+	internalReasonNoCallerCredential     = "no_caller_credential"
 	internalReasonNoProxyConfigured      = "no_proxy_configured"
 )
 
+// authFailureReasonOutgoing labels outgoing plugin failures in the auth
+// failure metric, alongside the reasons reported by incoming plugins.
+const authFailureReasonOutgoing = "outgoing_auth"
+
 // proxyHandler handles incoming requests and proxies them according to the integration.
 func proxyHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
@@ -1329,29 +1298,21 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	rateKey := clientIP
 	callerID := "*"
-	for _, cfg := range integ.IncomingAuth {
-		p := authplugins.GetIncoming(cfg.Type)
-		if p != nil {
-			if !p.Authenticate(r.Context(), r, cfg.parsed) {
-				logger.Warn("authentication failed", "host", host, "remote", r.RemoteAddr)
-				metrics.IncAuthFailure(integ.Name)
-				metrics.IncInternalResponse(integ.Name, http.StatusUnauthorized, internalReasonIncomingAuthFailure)
-				w.Header().Set("X-AT-Upstream-Error", "false")
-				w.Header().Set("X-AT-Error-Reason", "authentication failed")
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				http.Error(w, fmt.Sprintf("Unauthorized: authentication failed for integration %s", integ.Name), http.StatusUnauthorized)
-				return
-			}
-			if idp, ok := p.(authplugins.Identifier); ok {
-				if id, ok := idp.Identify(r, cfg.parsed); ok {
-					callerID = id
-					rateKey = id
-				}
-			}
-			if stripper, ok := p.(authplugins.AuthStripper); ok {
-				stripper.StripAuth(r, cfg.parsed)
-			}
-		}
+	r = r.WithContext(authplugins.WithSubjectToken(r.Context()))
+	id, authErr := authenticateIncoming(integ, r)
+	if authErr != nil {
+		logger.Warn("authentication failed", "host", host, "remote", r.RemoteAddr, "plugin", authErr.plugin, "reason", authErr.reason(), "error", authErr.err)
+		metrics.IncAuthFailure(integ.Name, authErr.reason())
+		metrics.IncInternalResponse(integ.Name, http.StatusUnauthorized, internalReasonIncomingAuthFailure)
+		w.Header().Set("X-AT-Upstream-Error", "false")
+		w.Header().Set("X-AT-Error-Reason", "authentication failed")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.Error(w, fmt.Sprintf("Unauthorized: authentication failed for integration %s", integ.Name), http.StatusUnauthorized)
+		return
+	}
+	if id != "" {
+		callerID = id
+		rateKey = id
 	}
 
 	logger.Info("incoming request", "method", r.Method, "integration", integ.Name, "path", r.URL.Path, "caller_id", callerID)
@@ -1442,20 +1403,25 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 		r.Header.Del("X-AT-Destination")
 	}
 
-	for _, cfg := range integ.OutgoingAuth {
-		p := authplugins.GetOutgoing(cfg.Type)
-		if p != nil {
-			if err := p.AddAuth(r.Context(), r, cfg.parsed); err != nil {
-				logger.Warn("outgoing auth failed", "integration", integ.Name, "plugin", cfg.Type, "error", err)
-				metrics.IncAuthFailure(integ.Name)
-				metrics.IncInternalResponse(integ.Name, http.StatusUnauthorized, internalReasonOutgoingAuthFailure)
-				w.Header().Set("X-AT-Upstream-Error", "false")
-				w.Header().Set("X-AT-Error-Reason", "authentication failed")
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				http.Error(w, fmt.Sprintf("Unauthorized: authentication failed for integration %s", integ.Name), http.StatusUnauthorized)
-				return
-			}
+	r = withAuthRetry(integ, r.WithContext(secrets.WithUsage(r.Context())))
+	if plugin, err := applyOutgoingAuth(integ, r); err != nil {
+		if errors.Is(err, errNoCallerCredential) {
+			logger.Warn("no outgoing credential for caller", "integration", integ.Name, "plugin", plugin, "caller_id", callerID)
+			metrics.IncInternalResponse(integ.Name, http.StatusForbidden, internalReasonNoCallerCredential)
+			w.Header().Set("X-AT-Upstream-Error", "false")
+			w.Header().Set("X-AT-Error-Reason", "no outgoing credential for caller")
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			http.Error(w, fmt.Sprintf("Forbidden: no outgoing credential configured for caller %s", callerID), http.StatusForbidden)
+			return
 		}
+		logger.Warn("outgoing auth failed", "integration", integ.Name, "plugin", plugin, "error", err)
+		metrics.IncAuthFailure(integ.Name, authFailureReasonOutgoing)
+		metrics.IncInternalResponse(integ.Name, http.StatusUnauthorized, internalReasonOutgoingAuthFailure)
+		w.Header().Set("X-AT-Upstream-Error", "false")
+		w.Header().Set("X-AT-Error-Reason", "authentication failed")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.Error(w, fmt.Sprintf("Unauthorized: authentication failed for integration %s", integ.Name), http.StatusUnauthorized)
+		return
 	}
 
 	if integ.proxy == nil {
@@ -1519,6 +1485,16 @@ func serve(s server, cert, key string) error {
 	}
 }
 
+// serveHTTP3 starts s using its TLSConfig when one is set. quic-go's
+// ListenAndServeTLS ignores TLSConfig, so client certificate settings would
+// otherwise be dropped.
+func serveHTTP3(s *http3.Server, cert, key string) error {
+	if s.TLSConfig != nil {
+		return s.ListenAndServe()
+	}
+	return s.ListenAndServeTLS(cert, key)
+}
+
 func shutdownHTTPServer(ctx context.Context, srv shutdowner) {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("server shutdown", "error", err)
@@ -1551,6 +1527,7 @@ func main() {
 
 	authplugins.MaxBodySize = *maxBodySizeFlag
 	secrets.CacheTTL = *secretRefresh
+	secrets.UnhealthyCooldown = *secretUnhealthyCooldown
 
 	if *showVersion {
 		fmt.Println(version)
@@ -1565,6 +1542,10 @@ func main() {
 	}
 	logger = slog.New(handler)
 	authplugins.SetLogger(logger)
+	if *redisAddr != "" {
+		authplugins.SetReplayStore(newRedisReplayStore())
+		authplugins.SetLocker(newRedisLocker())
+	}
 
 	if err := reload(); err != nil {
 		log.Fatal(err)
@@ -1577,7 +1558,15 @@ func main() {
 
 	http.HandleFunc("/", proxyHandler)
 
+	clientCerts, err := setupClientCertVerification()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	srv := newHTTPServer(*addr)
+	if clientCerts != nil {
+		srv.TLSConfig = clientCerts.serverTLSConfig([]string{"h2", "http/1.1"})
+	}
 	var h3srv *http3.Server
 
 	go func() {
@@ -1588,8 +1577,11 @@ func main() {
 
 	if *enableHTTP3 && *tlsCert != "" && *tlsKey != "" {
 		h3srv = &http3.Server{Addr: *addr, Handler: http.DefaultServeMux}
+		if clientCerts != nil {
+			h3srv.TLSConfig = clientCerts.serverTLSConfig(nil)
+		}
 		go func() {
-			if err := h3srv.ListenAndServeTLS(*tlsCert, *tlsKey); err != nil && err != http.ErrServerClosed {
+			if err := serveHTTP3(h3srv, *tlsCert, *tlsKey); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("listen http3: %v", err)
 			}
 		}()
@@ -1628,6 +1620,13 @@ func main() {
 			} else {
 				logger.Info("reloaded configuration")
 			}
+			if clientCerts != nil {
+				if err := clientCerts.reload(); err != nil {
+					logger.Error("client CA reload failed; keeping existing bundle", "error", err)
+				} else {
+					logger.Info("reloaded client CA bundle")
+				}
+			}
 		case <-watchSig:
 			if err := reload(); err != nil {
 				logger.Error("reload failed; keeping existing configuration", "source", redactConfigSource(configSource()), "error", err)
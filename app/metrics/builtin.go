@@ -25,6 +25,9 @@ var (
 	authFailureCounts           = expvar.NewMap("authtranslator_auth_failures_total")
 	internalResponseCounts      = expvar.NewMap("authtranslator_internal_responses_total")
 	upstreamStatusCounts        = expvar.NewMap("authtranslator_upstream_responses_total")
+	secretInvalidationCounts    = expvar.NewMap("authtranslator_secret_invalidations_total")
+	secretUnhealthyCounts       = expvar.NewMap("authtranslator_secret_unhealthy_total")
+	authRetryCounts             = expvar.NewMap("authtranslator_auth_retries_total")
 	upstreamRoundtripDurations  = newDurationMetric("authtranslator_upstream_roundtrip_duration_seconds")
 	endToEndDurations           = newDurationMetric("authtranslator_end_to_end_duration_seconds")
 	preProxyDurations           = newDurationMetric("authtranslator_pre_proxy_duration_seconds")
@@ -169,7 +172,11 @@ func IncRequest(integration string) { requestCounts.Add(integration, 1) }
 func IncRateLimit(integration string) { rateLimitCounts.Add(integration, 1) }
 
 // IncAuthFailure increments the auth failure counter for the integration.
-func IncAuthFailure(integration string) { authFailureCounts.Add(integration, 1) }
+// reason must come from a small fixed set, such as the reasons reported by
+// incoming auth plugins, to keep cardinality bounded.
+func IncAuthFailure(integration, reason string) {
+	authFailureCounts.Add(integration+metricKeySeparator+reason, 1)
+}
 
 // IncInternalResponse increments the counter for proxy-generated responses with
 // a coarse reason label to keep cardinality bounded.
@@ -184,6 +191,18 @@ func RecordStatus(integration string, status int) {
 	upstreamStatusCounts.Add(key, 1)
 }
 
+// IncSecretInvalidation increments the counter for cached secret references
+// dropped after the upstream rejected the integration's credentials.
+func IncSecretInvalidation(integration string) { secretInvalidationCounts.Add(integration, 1) }
+
+// IncSecretUnhealthy increments the counter for secret references marked
+// unhealthy after the upstream rejected the credential they held.
+func IncSecretUnhealthy(integration string) { secretUnhealthyCounts.Add(integration, 1) }
+
+// IncAuthRetry increments the counter for requests retried with fresh
+// credentials after the upstream rejected them.
+func IncAuthRetry(integration string) { authRetryCounts.Add(integration, 1) }
+
 // RecordUpstreamRoundtripDuration records the duration from proxy handoff until
 // AuthTranslator receives the upstream response.
 func RecordUpstreamRoundtripDuration(integration string, d time.Duration) {
@@ -230,7 +249,12 @@ func WriteProm(w http.ResponseWriter) {
 	})
 	writePromType(w, "authtranslator_auth_failures_total", "counter")
 	authFailureCounts.Do(func(kv expvar.KeyValue) {
-		fmt.Fprintf(w, "authtranslator_auth_failures_total{integration=%q} %s\n", kv.Key, kv.Value.String())
+		parts := strings.SplitN(kv.Key, metricKeySeparator, 2)
+		if len(parts) != 2 {
+			return
+		}
+		integ, reason := parts[0], parts[1]
+		fmt.Fprintf(w, "authtranslator_auth_failures_total{integration=%q,reason=%q} %s\n", integ, reason, kv.Value.String())
 	})
 	writePromType(w, "authtranslator_internal_responses_total", "counter")
 	internalResponseCounts.Do(func(kv expvar.KeyValue) {
@@ -250,6 +274,18 @@ func WriteProm(w http.ResponseWriter) {
 		integ, code := parts[0], parts[1]
 		fmt.Fprintf(w, "authtranslator_upstream_responses_total{integration=%q,code=%q} %s\n", integ, code, kv.Value.String())
 	})
+	writePromType(w, "authtranslator_secret_invalidations_total", "counter")
+	secretInvalidationCounts.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "authtranslator_secret_invalidations_total{integration=%q} %s\n", kv.Key, kv.Value.String())
+	})
+	writePromType(w, "authtranslator_secret_unhealthy_total", "counter")
+	secretUnhealthyCounts.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "authtranslator_secret_unhealthy_total{integration=%q} %s\n", kv.Key, kv.Value.String())
+	})
+	writePromType(w, "authtranslator_auth_retries_total", "counter")
+	authRetryCounts.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "authtranslator_auth_retries_total{integration=%q} %s\n", kv.Key, kv.Value.String())
+	})
 
 	mu.RLock()
 	ps := append([]Plugin(nil), plugins...)
@@ -57,6 +57,9 @@ func TestMetricsHandlerEmpty(t *testing.T) {
 		"# TYPE authtranslator_auth_failures_total counter",
 		"# TYPE authtranslator_internal_responses_total counter",
 		"# TYPE authtranslator_upstream_responses_total counter",
+		"# TYPE authtranslator_secret_invalidations_total counter",
+		"# TYPE authtranslator_secret_unhealthy_total counter",
+		"# TYPE authtranslator_auth_retries_total counter",
 	} {
 		if !strings.Contains(body, line) {
 			t.Fatalf("missing metric type line %q in %q", line, body)
@@ -72,7 +75,7 @@ func TestMetricsHandlerOutput(t *testing.T) {
 	IncRequest("foo")
 	IncRequest("foo")
 	IncRateLimit("foo")
-	IncAuthFailure("foo")
+	IncAuthFailure("foo", "expired")
 	IncInternalResponse("foo", http.StatusUnauthorized, "incoming_auth_failure")
 	RecordStatus("foo", http.StatusOK)
 	RecordStatus("bar", http.StatusTeapot)
@@ -86,6 +89,9 @@ func TestMetricsHandlerOutput(t *testing.T) {
 	RecordPreProxyDuration("bar", 10*time.Millisecond)
 	RecordResponseProcessingDuration("foo", 15*time.Millisecond)
 	RecordResponseProcessingDuration("bar", 5*time.Millisecond)
+	IncSecretInvalidation("foo")
+	IncSecretUnhealthy("foo")
+	IncAuthRetry("foo")
 
 	req := httptest.NewRequest(http.MethodGet, "/_at_internal/metrics", nil)
 	rr := httptest.NewRecorder()
@@ -106,6 +112,9 @@ func TestMetricsHandlerOutput(t *testing.T) {
 		"# TYPE authtranslator_auth_failures_total counter",
 		"# TYPE authtranslator_internal_responses_total counter",
 		"# TYPE authtranslator_upstream_responses_total counter",
+		"# TYPE authtranslator_secret_invalidations_total counter",
+		"# TYPE authtranslator_secret_unhealthy_total counter",
+		"# TYPE authtranslator_auth_retries_total counter",
 	} {
 		if !strings.Contains(body, line) {
 			t.Fatalf("missing metric type line %q in %q", line, body)
@@ -120,7 +129,7 @@ func TestMetricsHandlerOutput(t *testing.T) {
 	if !strings.Contains(body, `authtranslator_requests_total{integration="bar"} 1`) {
 		t.Fatal("missing bar request metric")
 	}
-	if !strings.Contains(body, `authtranslator_auth_failures_total{integration="foo"} 1`) {
+	if !strings.Contains(body, `authtranslator_auth_failures_total{integration="foo",reason="expired"} 1`) {
 		t.Fatal("missing foo auth failure metric")
 	}
 	if !strings.Contains(body, `authtranslator_internal_responses_total{integration="foo",code="401",reason="incoming_auth_failure"} 1`) {
@@ -132,6 +141,15 @@ func TestMetricsHandlerOutput(t *testing.T) {
 	if !strings.Contains(body, `authtranslator_upstream_responses_total{integration="bar",code="418"} 1`) {
 		t.Fatal("missing bar status metric")
 	}
+	for _, line := range []string{
+		`authtranslator_secret_invalidations_total{integration="foo"} 1`,
+		`authtranslator_secret_unhealthy_total{integration="foo"} 1`,
+		`authtranslator_auth_retries_total{integration="foo"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("missing %q", line)
+		}
+	}
 	if !strings.Contains(body, `authtranslator_upstream_roundtrip_duration_seconds_sum{integration="foo"}`) {
 		t.Fatal("missing foo upstream duration histogram")
 	}
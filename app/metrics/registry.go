@@ -40,6 +40,9 @@ func Reset() {
 	authFailureCounts.Init()
 	internalResponseCounts.Init()
 	upstreamStatusCounts.Init()
+	secretInvalidationCounts.Init()
+	secretUnhealthyCounts.Init()
+	authRetryCounts.Init()
 	upstreamRoundtripDurations.Reset()
 	endToEndDurations.Reset()
 	preProxyDurations.Reset()
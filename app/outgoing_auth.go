@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/metrics"
+	"github.com/winhowes/AuthTranslator/app/secrets"
+)
+
+// errNoCallerCredential is returned by applyOutgoingAuth when a plugin has
+// caller_params but none for the caller and no default_params.
+var errNoCallerCredential = errors.New("no outgoing credential configured for caller")
+
+// paramsFor returns the parsed params to use for caller. It returns false
+// when per-caller params are configured and none apply to caller.
+func (a *AuthPluginConfig) paramsFor(caller string) (interface{}, bool) {
+	if a.callerParsed == nil {
+		return a.parsed, true
+	}
+	if cfg, ok := a.callerParsed[caller]; ok {
+		return cfg, true
+	}
+	return a.parsed, a.parsed != nil
+}
+
+// applyOutgoingAuth runs the integration's outgoing auth plugins against r in
+// configuration order, except that request signers run last so they sign the
+// request as modified by every other plugin. Each plugin uses the params for
+// the caller recorded in r's context. On failure it returns the type of the
+// failing plugin alongside the error, which wraps errNoCallerCredential when
+// the caller has no params.
+func applyOutgoingAuth(integ *Integration, r *http.Request) (string, error) {
+	caller := metrics.Caller(r.Context())
+	type signer struct {
+		typ    string
+		plugin authplugins.OutgoingAuthPlugin
+		params interface{}
+	}
+	var signers []signer
+	for _, cfg := range integ.OutgoingAuth {
+		p := authplugins.GetOutgoing(cfg.Type)
+		if p == nil {
+			continue
+		}
+		params, ok := cfg.paramsFor(caller)
+		if !ok {
+			return cfg.Type, fmt.Errorf("%w %s", errNoCallerCredential, caller)
+		}
+		if s, ok := p.(authplugins.RequestSigner); ok && s.SignsRequest() {
+			signers = append(signers, signer{cfg.Type, p, params})
+			continue
+		}
+		if err := p.AddAuth(r.Context(), r, params); err != nil {
+			return cfg.Type, err
+		}
+	}
+	for _, s := range signers {
+		if err := s.plugin.AddAuth(r.Context(), r, s.params); err != nil {
+			return s.typ, err
+		}
+	}
+	return "", nil
+}
+
+// rejectsAuth reports whether an upstream status means the integration's
+// outgoing credentials were rejected: 401, or 403 when InvalidateOnForbidden
+// is set.
+func (i *Integration) rejectsAuth(status int) bool {
+	return status == http.StatusUnauthorized || (status == http.StatusForbidden && i.InvalidateOnForbidden)
+}
+
+// invalidateOutgoingAuth handles the upstream rejecting r, the request as
+// sent. Plugins that cache credentials drop them, the secret refs in the
+// caller's params are reloaded on next use, at most once per
+// secrets.RejectInterval, and the refs r loaded are marked unhealthy so
+// LoadRandomSecret prefers the others.
+func invalidateOutgoingAuth(integ *Integration, r *http.Request) {
+	caller := metrics.Caller(r.Context())
+	for _, cfg := range integ.OutgoingAuth {
+		params, ok := cfg.paramsFor(caller)
+		if !ok {
+			continue
+		}
+		p := authplugins.GetOutgoing(cfg.Type)
+		if inv, ok := p.(authplugins.CredentialInvalidator); ok {
+			inv.Invalidate(r, params)
+		}
+		sr, ok := p.(authplugins.SecretReferrer)
+		if !ok {
+			continue
+		}
+		for _, ref := range sr.SecretRefs(params) {
+			if ref != "" && secrets.RejectSecret(ref) {
+				metrics.IncSecretInvalidation(integ.Name)
+			}
+		}
+	}
+	for _, ref := range secrets.UsedSecrets(r.Context()) {
+		secrets.MarkUnhealthy(ref)
+		metrics.IncSecretUnhealthy(integ.Name)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+	"github.com/winhowes/AuthTranslator/app/metrics"
+)
+
+// appendHeaderPlugin appends Value to the X-Order header and fails when Fail
+// is set. With signer set it reports itself as a request signer.
+type appendHeaderPlugin struct {
+	name   string
+	signer bool
+}
+
+type appendHeaderParams struct {
+	Value string `json:"value"`
+	Fail  bool   `json:"fail"`
+}
+
+func (a appendHeaderPlugin) Name() string { return a.name }
+
+func (appendHeaderPlugin) ParseParams(m map[string]interface{}) (interface{}, error) {
+	return authplugins.ParseParams[appendHeaderParams](m)
+}
+
+func (appendHeaderPlugin) AddAuth(_ context.Context, r *http.Request, p interface{}) error {
+	cfg := p.(*appendHeaderParams)
+	if cfg.Fail {
+		return errors.New("boom")
+	}
+	r.Header.Add("X-Order", cfg.Value)
+	return nil
+}
+
+func (appendHeaderPlugin) RequiredParams() []string { return []string{"value"} }
+
+func (appendHeaderPlugin) OptionalParams() []string { return []string{"fail"} }
+
+func (a appendHeaderPlugin) SignsRequest() bool { return a.signer }
+
+func TestApplyOutgoingAuthRunsSignersLast(t *testing.T) {
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_test"})
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_signer_test", signer: true})
+	integ := &Integration{
+		Name:        "order",
+		Destination: "http://example.com",
+		OutgoingAuth: []AuthPluginConfig{
+			{Type: "append_signer_test", Params: map[string]interface{}{"value": "sig"}},
+			{Type: "append_test", Params: map[string]interface{}{"value": "a"}},
+			{Type: "append_test", Params: map[string]interface{}{"value": "b"}},
+		},
+	}
+	if err := prepareIntegration(integ); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://order/", nil)
+	if plugin, err := applyOutgoingAuth(integ, r); err != nil {
+		t.Fatalf("unexpected error from %s: %v", plugin, err)
+	}
+	if got := strings.Join(r.Header.Values("X-Order"), ","); got != "a,b,sig" {
+		t.Fatalf("expected signer to run last, got %q", got)
+	}
+}
+
+func TestApplyOutgoingAuthReportsFailingPlugin(t *testing.T) {
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_test"})
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_signer_test", signer: true})
+	integ := &Integration{
+		Name:        "order-fail",
+		Destination: "http://example.com",
+		OutgoingAuth: []AuthPluginConfig{
+			{Type: "append_signer_test", Params: map[string]interface{}{"value": "sig"}},
+			{Type: "append_test", Params: map[string]interface{}{"value": "a", "fail": true}},
+		},
+	}
+	if err := prepareIntegration(integ); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://order/", nil)
+	plugin, err := applyOutgoingAuth(integ, r)
+	if err == nil || plugin != "append_test" {
+		t.Fatalf("expected append_test failure, got %q %v", plugin, err)
+	}
+	if r.Header.Get("X-Order") != "" {
+		t.Fatal("signer should not run after a failure")
+	}
+}
+
+// invalidatingPlugin records the X-Order header of requests passed to
+// Invalidate.
+type invalidatingPlugin struct {
+	appendHeaderPlugin
+	got *[]string
+}
+
+func (p invalidatingPlugin) Invalidate(r *http.Request, _ interface{}) {
+	*p.got = append(*p.got, r.Header.Get("X-Order"))
+}
+
+func TestUpstreamRejectionInvalidatesOutgoingAuth(t *testing.T) {
+	var got []string
+	authplugins.RegisterOutgoing(invalidatingPlugin{appendHeaderPlugin{name: "invalidate_test"}, &got})
+	status := http.StatusUnauthorized
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+	integ := &Integration{
+		Name:         "invalidate",
+		Destination:  upstream.URL,
+		OutgoingAuth: []AuthPluginConfig{{Type: "invalidate_test", Params: map[string]interface{}{"value": "cred"}}},
+	}
+	if err := prepareIntegration(integ); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusOK} {
+		status = s
+		r := httptest.NewRequest(http.MethodGet, "http://invalidate/", nil)
+		if _, err := applyOutgoingAuth(integ, r); err != nil {
+			t.Fatal(err)
+		}
+		integ.proxy.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if len(got) != 1 || got[0] != "cred" {
+		t.Fatalf("expected only the 401 to invalidate the sent credential, got %v", got)
+	}
+
+	integ.InvalidateOnForbidden = true
+	status = http.StatusForbidden
+	r := httptest.NewRequest(http.MethodGet, "http://invalidate/", nil)
+	if _, err := applyOutgoingAuth(integ, r); err != nil {
+		t.Fatal(err)
+	}
+	integ.proxy.ServeHTTP(httptest.NewRecorder(), r)
+	if len(got) != 2 {
+		t.Fatalf("expected opted-in 403 to invalidate, got %v", got)
+	}
+}
+
+// subjectPlugin copies the captured caller token into X-Subject.
+type subjectPlugin struct{ appendHeaderPlugin }
+
+func (subjectPlugin) AddAuth(ctx context.Context, r *http.Request, _ interface{}) error {
+	tok, _ := authplugins.SubjectToken(ctx)
+	r.Header.Set("X-Subject", tok)
+	return nil
+}
+
+// bearerAuthPlugin is headerAuthPlugin exposing the checked header value as
+// the caller's token.
+type bearerAuthPlugin struct{ headerAuthPlugin }
+
+func (bearerAuthPlugin) Name() string { return "bearer_mode_test" }
+
+func (bearerAuthPlugin) BearerToken(r *http.Request, p interface{}) (string, bool) {
+	v := r.Header.Get(p.(*headerAuthParams).Header)
+	return v, v != ""
+}
+
+func TestProxyHandlerPassesSubjectTokenToOutgoingAuth(t *testing.T) {
+	authplugins.RegisterIncoming(headerAuthPlugin{})
+	authplugins.RegisterIncoming(bearerAuthPlugin{})
+	authplugins.RegisterOutgoing(subjectPlugin{appendHeaderPlugin{name: "subject_test"}})
+	var gotAuth, gotSubject string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotSubject = r.Header.Get("Authorization"), r.Header.Get("X-Subject")
+	}))
+	defer backend.Close()
+
+	integ := Integration{
+		Name:         "subject",
+		Destination:  backend.URL,
+		InRateLimit:  10,
+		OutRateLimit: 10,
+		IncomingAuth: []AuthPluginConfig{
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "Authorization", "value": "Bearer unverified", "id": "caller"}},
+			{Type: "bearer_mode_test", Params: map[string]interface{}{"header": "X-Caller-Token", "value": "caller-token"}},
+		},
+		OutgoingAuth: []AuthPluginConfig{{Type: "subject_test", Params: map[string]interface{}{"value": "x"}}},
+	}
+	if err := AddIntegration(&integ); err != nil {
+		t.Fatalf("failed to add integration: %v", err)
+	}
+	t.Cleanup(func() { DeleteIntegration(integ.Name) })
+	callers := []CallerConfig{{ID: "caller", Rules: []CallRule{{Path: "/", Methods: map[string]RequestConstraint{"GET": {}}}}}}
+	if err := SetAllowlist(integ.Name, callers); err != nil {
+		t.Fatalf("failed to set allowlist: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://subject/", nil)
+	req.Host = "subject"
+	req.Header.Set("Authorization", "Bearer unverified")
+	req.Header.Set("X-Caller-Token", "caller-token")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	// Only the token a plugin verified is captured, from that plugin's header.
+	if gotAuth != "" || gotSubject != "caller-token" {
+		t.Fatalf("expected stripped header and verified subject, got %q %q", gotAuth, gotSubject)
+	}
+}
+
+func TestApplyOutgoingAuthCallerParams(t *testing.T) {
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_test"})
+	integ := &Integration{
+		Name:        "per-caller",
+		Destination: "http://example.com",
+		OutgoingAuth: []AuthPluginConfig{{
+			Type:   "append_test",
+			Params: map[string]interface{}{"value": "shared"},
+			CallerParams: map[string]map[string]interface{}{
+				"team-a": {"value": "a"},
+				"team-b": {"value": "b"},
+			},
+		}},
+	}
+	if err := prepareIntegration(integ); err != nil {
+		t.Fatal(err)
+	}
+	for caller, want := range map[string]string{"team-a": "a", "team-b": "b"} {
+		r := httptest.NewRequest(http.MethodGet, "http://per-caller/", nil)
+		r = r.WithContext(metrics.WithCaller(r.Context(), caller))
+		if _, err := applyOutgoingAuth(integ, r); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Header.Get("X-Order"); got != want {
+			t.Fatalf("caller %s: expected %q, got %q", caller, want, got)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://per-caller/", nil)
+	r = r.WithContext(metrics.WithCaller(r.Context(), "team-c"))
+	plugin, err := applyOutgoingAuth(integ, r)
+	if !errors.Is(err, errNoCallerCredential) || plugin != "append_test" {
+		t.Fatalf("expected missing caller credential, got %q %v", plugin, err)
+	}
+	if r.Header.Get("X-Order") != "" {
+		t.Fatal("shared params must not be used without default_params")
+	}
+
+	integ.OutgoingAuth[0].DefaultParams = map[string]interface{}{"value": "default"}
+	if err := prepareIntegration(integ); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyOutgoingAuth(integ, r); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header.Get("X-Order"); got != "default" {
+		t.Fatalf("expected default params, got %q", got)
+	}
+}
+
+func TestPrepareIntegrationCallerParamsErrors(t *testing.T) {
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_test"})
+	authplugins.RegisterIncoming(headerAuthPlugin{})
+	cases := []*Integration{
+		{OutgoingAuth: []AuthPluginConfig{{Type: "append_test", Params: map[string]interface{}{"value": "x"}, DefaultParams: map[string]interface{}{"value": "y"}}}},
+		{OutgoingAuth: []AuthPluginConfig{{Type: "append_test", CallerParams: map[string]map[string]interface{}{"a": {"unknown": true}}}}},
+		{OutgoingAuth: []AuthPluginConfig{{Type: "append_test", CallerParams: map[string]map[string]interface{}{"a": {"value": "a"}}, DefaultParams: map[string]interface{}{}}}},
+		{IncomingAuth: []AuthPluginConfig{{Type: "header_mode_test", Params: map[string]interface{}{"header": "X", "value": "v"}, CallerParams: map[string]map[string]interface{}{"a": {}}}}},
+	}
+	for i, integ := range cases {
+		integ.Name = "caller-params"
+		integ.Destination = "http://example.com"
+		if err := prepareIntegration(integ); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestProxyHandlerNoCallerCredential(t *testing.T) {
+	authplugins.RegisterIncoming(headerAuthPlugin{})
+	authplugins.RegisterOutgoing(appendHeaderPlugin{name: "append_test"})
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Order")
+	}))
+	defer backend.Close()
+
+	integ := Integration{
+		Name:         "caller-creds",
+		Destination:  backend.URL,
+		InRateLimit:  10,
+		OutRateLimit: 10,
+		IncomingAuth: []AuthPluginConfig{
+			{Type: "header_mode_test", Params: map[string]interface{}{"header": "X-Team", "value": "a", "id": "team-a"}},
+		},
+		OutgoingAuth: []AuthPluginConfig{{
+			Type:         "append_test",
+			CallerParams: map[string]map[string]interface{}{"team-b": {"value": "b"}},
+		}},
+	}
+	if err := AddIntegration(&integ); err != nil {
+		t.Fatalf("failed to add integration: %v", err)
+	}
+	t.Cleanup(func() { DeleteIntegration(integ.Name) })
+
+	req := httptest.NewRequest(http.MethodGet, "http://caller-creds/", nil)
+	req.Host = "caller-creds"
+	req.Header.Set("X-Team", "a")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+	if rr.Header().Get("X-AT-Error-Reason") != "no outgoing credential for caller" || got != "" {
+		t.Fatalf("unexpected response %q, upstream saw %q", rr.Header().Get("X-AT-Error-Reason"), got)
+	}
+}
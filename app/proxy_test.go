@@ -896,7 +896,7 @@ func TestProxyHandlerAuthFailure(t *testing.T) {
 	req.Header.Set("X-Auth", "wrong")
 	rr := httptest.NewRecorder()
 	beforeRequests := promCounterValue(t, `authtranslator_requests_total{integration="authfail"}`)
-	beforeAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="authfail"}`)
+	beforeAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="authfail",reason="invalid_credential"}`)
 	beforeInternal := promCounterValue(t, `authtranslator_internal_responses_total{integration="authfail",code="401",reason="incoming_auth_failure"}`)
 	beforeUpstream := promCounterValue(t, `authtranslator_upstream_roundtrip_duration_seconds_count{integration="authfail"}`)
 	beforeTotal := promCounterValue(t, `authtranslator_end_to_end_duration_seconds_count{integration="authfail"}`)
@@ -912,10 +912,13 @@ func TestProxyHandlerAuthFailure(t *testing.T) {
 	if rr.Header().Get("X-AT-Error-Reason") != "authentication failed" {
 		t.Fatalf("unexpected error reason: %s", rr.Header().Get("X-AT-Error-Reason"))
 	}
+	if strings.Contains(rr.Body.String(), "invalid_credential") || strings.Contains(rr.Body.String(), "does not match") {
+		t.Fatalf("failure detail leaked to caller: %q", rr.Body.String())
+	}
 	if ct := rr.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
 		t.Fatalf("unexpected content type %s", ct)
 	}
-	if afterAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="authfail"}`); afterAuthFailures != beforeAuthFailures+1 {
+	if afterAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="authfail",reason="invalid_credential"}`); afterAuthFailures != beforeAuthFailures+1 {
 		t.Fatalf("expected auth failure metric to increment by 1, got before=%v after=%v", beforeAuthFailures, afterAuthFailures)
 	}
 	if afterInternal := promCounterValue(t, `authtranslator_internal_responses_total{integration="authfail",code="401",reason="incoming_auth_failure"}`); afterInternal != beforeInternal+1 {
@@ -1318,7 +1321,7 @@ func TestProxyHandlerOutgoingAuthError(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "http://fail-auth/", nil)
 	req.Host = "fail-auth"
 	rr := httptest.NewRecorder()
-	beforeAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="fail-auth"}`)
+	beforeAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="fail-auth",reason="outgoing_auth"}`)
 	beforeInternal := promCounterValue(t, `authtranslator_internal_responses_total{integration="fail-auth",code="401",reason="outgoing_auth_failure"}`)
 
 	proxyHandler(rr, req)
@@ -1329,7 +1332,7 @@ func TestProxyHandlerOutgoingAuthError(t *testing.T) {
 	if rr.Header().Get("X-AT-Error-Reason") != "authentication failed" {
 		t.Fatalf("unexpected error reason %q", rr.Header().Get("X-AT-Error-Reason"))
 	}
-	if afterAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="fail-auth"}`); afterAuthFailures != beforeAuthFailures+1 {
+	if afterAuthFailures := promCounterValue(t, `authtranslator_auth_failures_total{integration="fail-auth",reason="outgoing_auth"}`); afterAuthFailures != beforeAuthFailures+1 {
 		t.Fatalf("expected auth failure metric to increment by 1, got before=%v after=%v", beforeAuthFailures, afterAuthFailures)
 	}
 	if afterInternal := promCounterValue(t, `authtranslator_internal_responses_total{integration="fail-auth",code="401",reason="outgoing_auth_failure"}`); afterInternal != beforeInternal+1 {
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	authplugins "github.com/winhowes/AuthTranslator/app/auth"
+)
+
+// redisReplayStore shares replay protection nonces across instances through
+// -redis-addr. Like the rate limiter it falls back to process memory when
+// Redis is unavailable, so replays are still caught per instance.
+type redisReplayStore struct {
+	conns    chan net.Conn
+	fallback *authplugins.MemoryReplayStore
+}
+
+func newRedisReplayStore() *redisReplayStore {
+	return &redisReplayStore{
+		conns:    make(chan net.Conn, 4),
+		fallback: authplugins.NewMemoryReplayStore(),
+	}
+}
+
+// Seen implements authplugins.ReplayStore.
+func (s *redisReplayStore) Seen(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	seen, err := s.seenRedis(key, ttl)
+	if err == nil {
+		return seen, nil
+	}
+	logger.Error("redis replay store failed, falling back to memory", "error", err)
+	return s.fallback.Seen(ctx, key, ttl)
+}
+
+// seenRedis records key with SET NX so the check and insert are atomic
+// across instances.
+func (s *redisReplayStore) seenRedis(key string, ttl time.Duration) (bool, error) {
+	var conn net.Conn
+	select {
+	case conn = <-s.conns:
+	default:
+	}
+	if conn == nil {
+		var err error
+		conn, err = dialRedis()
+		if err != nil {
+			return false, err
+		}
+	}
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	reply, err := redisCmdString(conn, "SET", "replay:"+key, "1", "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	select {
+	case s.conns <- conn:
+	default:
+		conn.Close()
+	}
+	return reply != "OK", nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisSetNX serves SET key value NX PX ms commands from memory.
+func fakeRedisSetNX(t *testing.T) (string, *[]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	keys := map[string]bool{}
+	var calls []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					args, err := readRedisArgs(br)
+					if err != nil || len(args) != 6 {
+						return
+					}
+					mu.Lock()
+					calls = append(calls, strings.Join([]string{args[0], args[1], args[3], args[4]}, " "))
+					if args[0] != "SET" || keys[args[1]] {
+						mu.Unlock()
+						conn.Write([]byte("$-1\r\n"))
+						continue
+					}
+					keys[args[1]] = true
+					mu.Unlock()
+					conn.Write([]byte("+OK\r\n"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), &calls
+}
+
+// readRedisArgs reads one RESP array of bulk strings from br.
+func readRedisArgs(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		lenLine, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sz, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(lenLine, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, sz+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:sz]))
+	}
+	return args, nil
+}
+
+func TestRedisReplayStore(t *testing.T) {
+	addr, calls := fakeRedisSetNX(t)
+	old := *redisAddr
+	*redisAddr = addr
+	t.Cleanup(func() { *redisAddr = old })
+
+	s := newRedisReplayStore()
+	ctx := context.Background()
+	if seen, err := s.Seen(ctx, "abc", time.Minute); err != nil || seen {
+		t.Fatalf("expected first nonce to be unseen, got %v %v", seen, err)
+	}
+	if seen, err := s.Seen(ctx, "abc", time.Minute); err != nil || !seen {
+		t.Fatalf("expected repeated nonce to be seen, got %v %v", seen, err)
+	}
+	if (*calls)[0] != "SET replay:abc NX PX" {
+		t.Fatalf("unexpected command %q", (*calls)[0])
+	}
+	if len(s.conns) != 1 {
+		t.Fatalf("expected connection to be pooled, have %d", len(s.conns))
+	}
+	// A second store sharing the same Redis sees the nonce too.
+	if seen, _ := newRedisReplayStore().Seen(ctx, "abc", time.Minute); !seen {
+		t.Fatal("expected nonce to be shared through redis")
+	}
+}
+
+func TestRedisReplayStoreFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	old := *redisAddr
+	*redisAddr = addr
+	t.Cleanup(func() { *redisAddr = old })
+
+	s := newRedisReplayStore()
+	ctx := context.Background()
+	if seen, err := s.Seen(ctx, "abc", time.Minute); err != nil || seen {
+		t.Fatalf("expected fallback to accept first nonce, got %v %v", seen, err)
+	}
+	if seen, err := s.Seen(ctx, "abc", time.Minute); err != nil || !seen {
+		t.Fatalf("expected fallback to reject repeated nonce, got %v %v", seen, err)
+	}
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// UnhealthyCooldown is how long LoadRandomSecret avoids a reference after
+// MarkUnhealthy reports it was rejected.
+var UnhealthyCooldown = 5 * time.Minute
+
+// RejectInterval is the minimum time between two cache drops of the same
+// reference by RejectSecret, so a stream of rejections cannot turn into a
+// stream of backend reads.
+var RejectInterval = 30 * time.Second
+
+var rejected = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// RejectSecret drops ref from the cache after an upstream rejected the
+// credential it held, unless it already did so within RejectInterval. It
+// reports whether ref was dropped.
+func RejectSecret(ref string) bool {
+	now := time.Now()
+	rejected.Lock()
+	if last, ok := rejected.m[ref]; ok && now.Sub(last) < RejectInterval {
+		rejected.Unlock()
+		return false
+	}
+	rejected.m[ref] = now
+	rejected.Unlock()
+	InvalidateSecret(ref)
+	return true
+}
+
+var unhealthy = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// MarkUnhealthy records that the credential behind ref was rejected so
+// LoadRandomSecret prefers the other references it is given until
+// UnhealthyCooldown passes.
+func MarkUnhealthy(ref string) {
+	unhealthy.Lock()
+	unhealthy.m[ref] = time.Now().Add(UnhealthyCooldown)
+	unhealthy.Unlock()
+}
+
+// healthyRefs returns the refs not marked unhealthy, or all of refs when
+// every one is so a rejected set still yields a credential.
+func healthyRefs(refs []string) []string {
+	now := time.Now()
+	unhealthy.Lock()
+	defer unhealthy.Unlock()
+	var ok []string
+	for _, ref := range refs {
+		until, marked := unhealthy.m[ref]
+		if marked && now.After(until) {
+			delete(unhealthy.m, ref)
+			marked = false
+		}
+		if !marked {
+			ok = append(ok, ref)
+		}
+	}
+	if len(ok) == 0 {
+		return refs
+	}
+	return ok
+}
+
+type usage struct {
+	mu   sync.Mutex
+	refs []string
+}
+
+type usageKey struct{}
+
+// WithUsage returns a context that records the references loaded through it
+// for UsedSecrets.
+func WithUsage(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usageKey{}, &usage{})
+}
+
+// UsedSecrets returns the references loaded with ctx, or derived contexts,
+// since WithUsage.
+func UsedSecrets(ctx context.Context) []string {
+	u, _ := ctx.Value(usageKey{}).(*usage)
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.refs...)
+}
+
+func recordUsage(ctx context.Context, ref string) {
+	u, _ := ctx.Value(usageKey{}).(*usage)
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, r := range u.refs {
+		if r == ref {
+			return
+		}
+	}
+	u.refs = append(u.refs, ref)
+}
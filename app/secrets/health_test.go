@@ -0,0 +1,77 @@
+package secrets_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/winhowes/AuthTranslator/app/secrets"
+	_ "github.com/winhowes/AuthTranslator/app/secrets/plugins"
+)
+
+func TestLoadRandomSecretSkipsUnhealthy(t *testing.T) {
+	defer secrets.ClearCache()
+	t.Setenv("A", "first")
+	t.Setenv("B", "second")
+	refs := []string{"env:A", "env:B"}
+
+	secrets.MarkUnhealthy("env:A")
+	for i := 0; i < 20; i++ {
+		val, err := secrets.LoadRandomSecret(context.Background(), refs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != "second" {
+			t.Fatalf("expected healthy secret, got %s", val)
+		}
+	}
+
+	// With every reference unhealthy the full set is used again.
+	secrets.MarkUnhealthy("env:B")
+	if _, err := secrets.LoadRandomSecret(context.Background(), refs); err != nil {
+		t.Fatalf("expected fallback to all refs, got %v", err)
+	}
+}
+
+func TestUnhealthyCooldown(t *testing.T) {
+	defer secrets.ClearCache()
+	old := secrets.UnhealthyCooldown
+	secrets.UnhealthyCooldown = 10 * time.Millisecond
+	defer func() { secrets.UnhealthyCooldown = old }()
+	t.Setenv("A", "first")
+	t.Setenv("B", "second")
+
+	secrets.MarkUnhealthy("env:A")
+	time.Sleep(20 * time.Millisecond)
+	seen := map[string]bool{}
+	for i := 0; i < 100 && !seen["first"]; i++ {
+		val, err := secrets.LoadRandomSecret(context.Background(), []string{"env:A", "env:B"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[val] = true
+	}
+	if !seen["first"] {
+		t.Fatal("expected ref to be used again after cooldown")
+	}
+}
+
+func TestUsedSecrets(t *testing.T) {
+	defer secrets.ClearCache()
+	t.Setenv("A", "first")
+	t.Setenv("B", "second")
+
+	if refs := secrets.UsedSecrets(context.Background()); refs != nil {
+		t.Fatalf("expected no usage without WithUsage, got %v", refs)
+	}
+	ctx := secrets.WithUsage(context.Background())
+	for _, ref := range []string{"env:A", "env:B", "env:A"} {
+		if _, err := secrets.LoadSecret(ctx, ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refs := secrets.UsedSecrets(context.WithoutCancel(ctx))
+	if len(refs) != 2 || refs[0] != "env:A" || refs[1] != "env:B" {
+		t.Fatalf("unexpected used refs %v", refs)
+	}
+}
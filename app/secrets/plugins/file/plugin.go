@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/winhowes/AuthTranslator/app/secrets"
@@ -50,6 +51,70 @@ func (filePlugin) Load(ctx context.Context, id string) (string, error) {
 	return "", fmt.Errorf("secret %q not found in %s", key, path)
 }
 
+// Store writes val to the file, or replaces the key=value line for key and
+// appends one when it is missing. The file is replaced atomically so readers
+// never observe a partial write.
+func (filePlugin) Store(ctx context.Context, id, val string) error {
+	if strings.ContainsAny(val, "\r\n") {
+		return fmt.Errorf("secret value must be a single line")
+	}
+	path, key := splitPathAndKey(id)
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if key == "" {
+		return writeAtomic(path, []byte(val+"\n"), mode)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var out bytes.Buffer
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if parts := strings.SplitN(strings.TrimSpace(line), "=", 2); !found && len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			line = key + "=" + val
+			found = true
+		}
+		out.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !found {
+		out.WriteString(key + "=" + val + "\n")
+	}
+	return writeAtomic(path, out.Bytes(), mode)
+}
+
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 func splitPathAndKey(id string) (path, key string) {
 	idx := strings.LastIndex(id, ":")
 	if idx == -1 || idx+1 >= len(id) {
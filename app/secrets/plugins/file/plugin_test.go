@@ -122,3 +122,43 @@ func TestSplitPathAndKey(t *testing.T) {
 		})
 	}
 }
+
+func TestFilePluginStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	p := filePlugin{}
+	if err := p.Store(context.Background(), path, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := p.Load(context.Background(), path); err != nil || val != "new" {
+		t.Fatalf("expected new, got %q %v", val, err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0640 {
+		t.Fatalf("expected mode to be preserved, got %v", fi.Mode())
+	}
+	if err := p.Store(context.Background(), path, "a\nb"); err == nil {
+		t.Fatal("expected multi-line value to be rejected")
+	}
+}
+
+func TestFilePluginStoreKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens.env")
+	if err := os.WriteFile(path, []byte("# tokens\nA=1\nB=2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := filePlugin{}
+	if err := p.Store(context.Background(), path+":B", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Store(context.Background(), path+":C", "4"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "# tokens\nA=1\nB=3\nC=4\n" {
+		t.Fatalf("unexpected file contents %q", b)
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/winhowes/AuthTranslator/app/secrets"
@@ -30,53 +32,95 @@ var (
 
 	readFile   = os.ReadFile
 	newRequest = http.NewRequestWithContext
-)
-
-func (k8sPlugin) Prefix() string { return "k8s" }
 
-func (k8sPlugin) Load(ctx context.Context, id string) (string, error) {
-	parts := strings.SplitN(id, "#", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid k8s id: %s", id)
+	// caClient caches the client built for the last CA bundle so its
+	// transport and connection pool are reused across calls.
+	caClient struct {
+		sync.Mutex
+		ca     string
+		base   *http.Client
+		client *http.Client
 	}
-	secretRef, key := parts[0], parts[1]
-	nsName := strings.SplitN(secretRef, "/", 2)
-	if len(nsName) != 2 || nsName[0] == "" || nsName[1] == "" || key == "" {
-		return "", fmt.Errorf("invalid k8s id: %s", id)
-	}
-	namespace, name := nsName[0], nsName[1]
+)
 
+// InClusterConfig holds what is needed to call the Kubernetes API server
+// from inside a pod.
+type InClusterConfig struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+// LoadInClusterConfig reads the pod's service account token and cluster CA.
+// The token is re-read on every call so projected token rotation is honoured;
+// the client is rebuilt only when the CA bundle changes.
+func LoadInClusterConfig() (*InClusterConfig, error) {
 	host := os.Getenv("KUBERNETES_SERVICE_HOST")
 	port := os.Getenv("KUBERNETES_SERVICE_PORT")
 	if host == "" || port == "" {
-		return "", fmt.Errorf("not running in a cluster")
+		return nil, fmt.Errorf("not running in a cluster")
 	}
-
 	token, err := readFile(tokenPath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	caData, err := readFile(caPath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return &InClusterConfig{
+		BaseURL: "https://" + net.JoinHostPort(host, port),
+		Token:   strings.TrimSpace(string(token)),
+		Client:  clientFor(caData),
+	}, nil
+}
 
+// clientFor returns httpClient, or when it has no custom transport a client
+// trusting caData. The client is cached until the CA bundle changes.
+func clientFor(caData []byte) *http.Client {
+	base := httpClient
+	if base.Transport != nil {
+		return base
+	}
+	caClient.Lock()
+	defer caClient.Unlock()
+	if caClient.client != nil && caClient.base == base && caClient.ca == string(caData) {
+		return caClient.client
+	}
 	pool := x509.NewCertPool()
 	pool.AppendCertsFromPEM(caData)
+	caClient.ca = string(caData)
+	caClient.base = base
+	caClient.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}, Timeout: base.Timeout}
+	return caClient.client
+}
+
+func (k8sPlugin) Prefix() string { return "k8s" }
 
-	client := httpClient
-	if client.Transport == nil {
-		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}, Timeout: client.Timeout}
+func (k8sPlugin) Load(ctx context.Context, id string) (string, error) {
+	parts := strings.SplitN(id, "#", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid k8s id: %s", id)
 	}
+	secretRef, key := parts[0], parts[1]
+	nsName := strings.SplitN(secretRef, "/", 2)
+	if len(nsName) != 2 || nsName[0] == "" || nsName[1] == "" || key == "" {
+		return "", fmt.Errorf("invalid k8s id: %s", id)
+	}
+	namespace, name := nsName[0], nsName[1]
 
-	apiURL := fmt.Sprintf("https://%s:%s/api/v1/namespaces/%s/secrets/%s", host, port, namespace, name)
+	cfg, err := LoadInClusterConfig()
+	if err != nil {
+		return "", err
+	}
+	apiURL := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", cfg.BaseURL, namespace, name)
 	req, err := newRequest(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
 
-	resp, err := client.Do(req)
+	resp, err := cfg.Client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -246,3 +246,42 @@ func TestK8sDoError(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestK8sClientCachedPerCA(t *testing.T) {
+	oldClient := httpClient
+	httpClient = &http.Client{}
+	defer func() { httpClient = oldClient }()
+	restoreFiles := writeFiles(t, "tok", "ca-one")
+	defer restoreFiles()
+	t.Setenv("KUBERNETES_SERVICE_HOST", "k8s")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	first, err := LoadInClusterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenPath, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadInClusterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Client != first.Client {
+		t.Fatal("expected the client to be reused for the same CA bundle")
+	}
+	if second.Token != "rotated" {
+		t.Fatalf("expected the token to be re-read, got %q", second.Token)
+	}
+
+	if err := os.WriteFile(caPath, []byte("ca-two"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	third, err := LoadInClusterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Client == first.Client {
+		t.Fatal("expected a new client after the CA bundle changed")
+	}
+}
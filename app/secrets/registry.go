@@ -16,6 +16,12 @@ type Plugin interface {
 	Load(ctx context.Context, id string) (string, error)
 }
 
+// Writer is optionally implemented by plugins that can store a new value for
+// an identifier, for example to persist rotated refresh tokens.
+type Writer interface {
+	Store(ctx context.Context, id, val string) error
+}
+
 var registry = make(map[string]Plugin)
 
 // CacheTTL controls how long resolved secrets remain valid. A zero duration
@@ -32,11 +38,26 @@ var secretCache = struct {
 	m map[string]cachedSecret
 }{m: make(map[string]cachedSecret)}
 
-// ClearCache empties the cached secret values.
+// ClearCache empties the cached secret values and forgets references marked
+// unhealthy or rejected.
 func ClearCache() {
 	secretCache.Lock()
 	secretCache.m = make(map[string]cachedSecret)
 	secretCache.Unlock()
+	unhealthy.Lock()
+	unhealthy.m = make(map[string]time.Time)
+	unhealthy.Unlock()
+	rejected.Lock()
+	rejected.m = make(map[string]time.Time)
+	rejected.Unlock()
+}
+
+// InvalidateSecret drops ref from the cache so the next LoadSecret reads it
+// from its source.
+func InvalidateSecret(ref string) {
+	secretCache.Lock()
+	delete(secretCache.m, ref)
+	secretCache.Unlock()
 }
 
 // Register adds a secret plugin for a prefix.
@@ -55,8 +76,50 @@ func ValidateSecret(ref string) error {
 	return nil
 }
 
+// ValidateWritableSecret checks that the reference uses a known prefix whose
+// plugin can store values.
+func ValidateWritableSecret(ref string) error {
+	if err := ValidateSecret(ref); err != nil {
+		return err
+	}
+	prefix, _, _ := strings.Cut(ref, ":")
+	if _, ok := registry[prefix].(Writer); !ok {
+		return fmt.Errorf("secret source %s is not writable", prefix)
+	}
+	return nil
+}
+
+// StoreSecret writes val to the source of ref and caches it.
+func StoreSecret(ctx context.Context, ref, val string) error {
+	prefix, id, ok := strings.Cut(ref, ":")
+	if !ok {
+		return fmt.Errorf("invalid secret reference: %s", ref)
+	}
+	p, ok := registry[prefix]
+	if !ok {
+		return fmt.Errorf("unknown secret source: %s", prefix)
+	}
+	w, ok := p.(Writer)
+	if !ok {
+		return fmt.Errorf("secret source %s is not writable", prefix)
+	}
+	if err := w.Store(ctx, id, val); err != nil {
+		InvalidateSecret(ref)
+		return err
+	}
+	exp := time.Time{}
+	if CacheTTL > 0 {
+		exp = time.Now().Add(CacheTTL)
+	}
+	secretCache.Lock()
+	secretCache.m[ref] = cachedSecret{val: val, expiry: exp}
+	secretCache.Unlock()
+	return nil
+}
+
 // LoadSecret resolves a secret reference using the registered plugins.
 func LoadSecret(ctx context.Context, ref string) (string, error) {
+	recordUsage(ctx, ref)
 	secretCache.RLock()
 	if c, ok := secretCache.m[ref]; ok {
 		if CacheTTL <= 0 || time.Now().Before(c.expiry) {
@@ -92,11 +155,13 @@ func LoadSecret(ctx context.Context, ref string) (string, error) {
 // LoadRandomSecret selects one of the provided secret references at random and
 // resolves it via LoadSecret. When multiple references are given a unique seed
 // is used for the random generator to ensure a different selection on each
-// invocation.
+// invocation. References marked unhealthy are skipped unless every reference
+// is.
 func LoadRandomSecret(ctx context.Context, refs []string) (string, error) {
 	if len(refs) == 0 {
 		return "", fmt.Errorf("no secrets provided")
 	}
+	refs = healthyRefs(refs)
 
 	var idx int
 	if len(refs) == 1 {
@@ -2,6 +2,8 @@ package secrets_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -73,3 +75,38 @@ func TestCacheTTL(t *testing.T) {
 		t.Fatalf("expected 'second' after ttl, got %s", val)
 	}
 }
+
+func TestStoreSecret(t *testing.T) {
+	defer secrets.ClearCache()
+	path := filepath.Join(t.TempDir(), "rt")
+	if err := os.WriteFile(path, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ref := "file:" + path
+	ctx := context.Background()
+	if err := secrets.ValidateWritableSecret(ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.ValidateWritableSecret("env:X"); err == nil {
+		t.Fatal("expected env secrets to be read-only")
+	}
+	if _, err := secrets.LoadSecret(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.StoreSecret(ctx, ref, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := secrets.LoadSecret(ctx, ref); val != "second" {
+		t.Fatalf("expected cache to hold stored value, got %q", val)
+	}
+	if err := os.WriteFile(path, []byte("third"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secrets.InvalidateSecret(ref)
+	if val, _ := secrets.LoadSecret(ctx, ref); val != "third" {
+		t.Fatalf("expected invalidated secret to be reloaded, got %q", val)
+	}
+	if err := secrets.StoreSecret(ctx, "env:X", "v"); err == nil {
+		t.Fatal("expected store to read-only source to fail")
+	}
+}
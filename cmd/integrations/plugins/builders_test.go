@@ -14,6 +14,8 @@ func TestBuilders(t *testing.T) {
 		{"asana", []string{"-name", "a", "-token", "tok"}, Asana("a", "tok")},
 		{"github", []string{"-name", "gh", "-token", "tok", "-webhook-secret", "sec"}, GitHub("gh", "tok", "sec")},
 		{"ghe", []string{"-name", "ghe1", "-domain", "corp.example.com", "-token", "tok", "-webhook-secret", "sec"}, GitHubEnterprise("ghe1", "corp.example.com", "tok", "sec")},
+		{"github", []string{"-name", "gha", "-app-id", "42", "-app-private-key", "key", "-installation-id", "7", "-webhook-secret", "sec"}, GitHubApp("gha", "42", "key", "7", "sec")},
+		{"ghe", []string{"-name", "ghea", "-domain", "corp.example.com", "-app-id", "42", "-app-private-key", "key", "-installation-id", "7", "-webhook-secret", "sec"}, GitHubEnterpriseApp("ghea", "corp.example.com", "42", "key", "7", "sec")},
 		{"gitlab", []string{"-name", "gl", "-token", "tok"}, GitLab("gl", "tok")},
 		{"jira", []string{"-name", "j1", "-token", "tok"}, Jira("j1", "tok", "api.atlassian.com")},
 		{"jira", []string{"-name", "j2", "-domain", "jira.example.com", "-token", "tok"}, Jira("j2", "tok", "jira.example.com")},
@@ -64,6 +66,9 @@ func TestBuilderErrors(t *testing.T) {
 		{"twilio", []string{}},
 		{"workday", []string{"-token", "t"}},
 		{"github", []string{"-token", "t"}},
+		{"github", []string{"-app-id", "1", "-webhook-secret", "s"}},
+		{"github", []string{"-token", "t", "-app-id", "1", "-app-private-key", "k", "-installation-id", "2", "-webhook-secret", "s"}},
+		{"ghe", []string{"-domain", "d", "-app-id", "1", "-app-private-key", "k", "-webhook-secret", "s"}},
 		{"gitlab", []string{}},
 		{"openai", []string{}},
 		{"sendgrid", []string{}},
@@ -122,3 +127,19 @@ func TestBuilderParseError(t *testing.T) {
 		}
 	}
 }
+
+func TestGitHubAppBuilderOutgoingAuth(t *testing.T) {
+	got := GitHubEnterpriseApp("ghea", "corp.example.com", "42", "file:/key.pem", "7", "sec")
+	want := AuthPluginConfig{Type: "github_app", Params: map[string]interface{}{
+		"app_id":          "42",
+		"private_key":     "file:/key.pem",
+		"installation_id": "7",
+		"api_url":         "https://corp.example.com/api/v3",
+	}}
+	if len(got.OutgoingAuth) != 1 || !reflect.DeepEqual(got.OutgoingAuth[0], want) {
+		t.Fatalf("unexpected outgoing auth %#v", got.OutgoingAuth)
+	}
+	if _, ok := GitHubApp("gha", "42", "k", "7", "sec").OutgoingAuth[0].Params["api_url"]; ok {
+		t.Fatal("github.com app should use the plugin's default api_url")
+	}
+}
@@ -7,26 +7,14 @@ import (
 
 // GitHubEnterprise returns an Integration configured for a GitHub Enterprise instance.
 func GitHubEnterprise(name, domain, tokenRef, webhookSecretRef string) Integration {
-	return Integration{
-		Name:         name,
-		Destination:  fmt.Sprintf("https://%s/api/v3", domain),
-		InRateLimit:  100,
-		OutRateLimit: 100,
-		IncomingAuth: []AuthPluginConfig{{
-			Type: "github_signature",
-			Params: map[string]interface{}{
-				"secrets": []string{webhookSecretRef},
-			},
-		}},
-		OutgoingAuth: []AuthPluginConfig{{
-			Type: "token",
-			Params: map[string]interface{}{
-				"secrets": []string{tokenRef},
-				"header":  "Authorization",
-				"prefix":  "token ",
-			},
-		}},
-	}
+	return githubIntegration(name, fmt.Sprintf("https://%s/api/v3", domain), webhookSecretRef, githubTokenAuth(tokenRef))
+}
+
+// GitHubEnterpriseApp returns an Integration for a GitHub Enterprise
+// instance that authenticates as a GitHub App installation.
+func GitHubEnterpriseApp(name, domain, appID, privateKeyRef, installationID, webhookSecretRef string) Integration {
+	api := fmt.Sprintf("https://%s/api/v3", domain)
+	return githubIntegration(name, api, webhookSecretRef, githubAppAuth(api, appID, privateKeyRef, installationID))
 }
 
 func init() { Register("ghe", gheBuilder) }
@@ -37,11 +25,19 @@ func gheBuilder(args []string) (Integration, error) {
 	domain := fs.String("domain", "", "GitHub Enterprise domain")
 	token := fs.String("token", "", "secret reference for API token")
 	secret := fs.String("webhook-secret", "", "secret reference for webhook secret")
+	app := newGitHubAppFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return Integration{}, err
 	}
-	if *domain == "" || *token == "" || *secret == "" {
-		return Integration{}, fmt.Errorf("-domain, -token and -webhook-secret are required")
+	useApp, err := app.check(*token)
+	if err != nil {
+		return Integration{}, err
+	}
+	if *domain == "" || (*token == "" && !useApp) || *secret == "" {
+		return Integration{}, fmt.Errorf("-domain, -token (or GitHub App flags) and -webhook-secret are required")
+	}
+	if useApp {
+		return GitHubEnterpriseApp(*name, *domain, *app.appID, *app.privateKey, *app.installationID, *secret), nil
 	}
 	return GitHubEnterprise(*name, *domain, *token, *secret), nil
 }
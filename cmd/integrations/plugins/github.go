@@ -7,9 +7,19 @@ import (
 
 // GitHub returns an Integration configured for the GitHub API.
 func GitHub(name, tokenRef, webhookSecretRef string) Integration {
+	return githubIntegration(name, "https://api.github.com", webhookSecretRef, githubTokenAuth(tokenRef))
+}
+
+// GitHubApp returns an Integration for the GitHub API that authenticates as
+// a GitHub App installation instead of with a personal access token.
+func GitHubApp(name, appID, privateKeyRef, installationID, webhookSecretRef string) Integration {
+	return githubIntegration(name, "https://api.github.com", webhookSecretRef, githubAppAuth("", appID, privateKeyRef, installationID))
+}
+
+func githubIntegration(name, dest, webhookSecretRef string, out AuthPluginConfig) Integration {
 	return Integration{
 		Name:         name,
-		Destination:  "https://api.github.com",
+		Destination:  dest,
 		InRateLimit:  100,
 		OutRateLimit: 100,
 		IncomingAuth: []AuthPluginConfig{{
@@ -18,15 +28,62 @@ func GitHub(name, tokenRef, webhookSecretRef string) Integration {
 				"secrets": []string{webhookSecretRef},
 			},
 		}},
-		OutgoingAuth: []AuthPluginConfig{{
-			Type: "token",
-			Params: map[string]interface{}{
-				"secrets": []string{tokenRef},
-				"header":  "Authorization",
-				"prefix":  "token ",
-			},
-		}},
+		OutgoingAuth: []AuthPluginConfig{out},
+	}
+}
+
+func githubTokenAuth(tokenRef string) AuthPluginConfig {
+	return AuthPluginConfig{
+		Type: "token",
+		Params: map[string]interface{}{
+			"secrets": []string{tokenRef},
+			"header":  "Authorization",
+			"prefix":  "token ",
+		},
+	}
+}
+
+// githubAppAuth configures the github_app plugin. An empty apiURL uses the
+// plugin's default of https://api.github.com.
+func githubAppAuth(apiURL, appID, privateKeyRef, installationID string) AuthPluginConfig {
+	params := map[string]interface{}{
+		"app_id":          appID,
+		"private_key":     privateKeyRef,
+		"installation_id": installationID,
+	}
+	if apiURL != "" {
+		params["api_url"] = apiURL
 	}
+	return AuthPluginConfig{Type: "github_app", Params: params}
+}
+
+// githubAppFlags registers the GitHub App flags shared by the github and ghe
+// builders.
+type githubAppFlags struct {
+	appID, privateKey, installationID *string
+}
+
+func newGitHubAppFlags(fs *flag.FlagSet) githubAppFlags {
+	return githubAppFlags{
+		appID:          fs.String("app-id", "", "GitHub App ID (instead of -token)"),
+		privateKey:     fs.String("app-private-key", "", "secret reference for the GitHub App private key"),
+		installationID: fs.String("installation-id", "", "GitHub App installation ID"),
+	}
+}
+
+// check reports whether the app flags are in use and that they were given
+// together and not alongside a token.
+func (f githubAppFlags) check(token string) (bool, error) {
+	if *f.appID == "" && *f.privateKey == "" && *f.installationID == "" {
+		return false, nil
+	}
+	if token != "" {
+		return false, fmt.Errorf("-token cannot be combined with GitHub App flags")
+	}
+	if *f.appID == "" || *f.privateKey == "" || *f.installationID == "" {
+		return false, fmt.Errorf("-app-id, -app-private-key and -installation-id must be set together")
+	}
+	return true, nil
 }
 
 func init() { Register("github", githubBuilder) }
@@ -36,11 +93,19 @@ func githubBuilder(args []string) (Integration, error) {
 	name := fs.String("name", "github", "integration name")
 	token := fs.String("token", "", "secret reference for API token")
 	secret := fs.String("webhook-secret", "", "secret reference for webhook secret")
+	app := newGitHubAppFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return Integration{}, err
 	}
-	if *token == "" || *secret == "" {
-		return Integration{}, fmt.Errorf("-token and -webhook-secret are required")
+	useApp, err := app.check(*token)
+	if err != nil {
+		return Integration{}, err
+	}
+	if (*token == "" && !useApp) || *secret == "" {
+		return Integration{}, fmt.Errorf("-token (or GitHub App flags) and -webhook-secret are required")
+	}
+	if useApp {
+		return GitHubApp(*name, *app.appID, *app.privateKey, *app.installationID, *secret), nil
 	}
 	return GitHub(*name, *token, *secret), nil
 }
@@ -7,12 +7,14 @@ require (
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/quic-go/quic-go v0.56.0
+require (
+	github.com/quic-go/quic-go v0.56.0
+	golang.org/x/crypto v0.41.0
+)
 
 require (
 	github.com/kr/text v0.2.0 // indirect
 	github.com/quic-go/qpack v0.5.1 // indirect
-	golang.org/x/crypto v0.41.0 // indirect
 	golang.org/x/net v0.43.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
 	golang.org/x/text v0.28.0 // indirect